@@ -0,0 +1,294 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"bufio"
+	"encoding/binary"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/crc"
+	"github.com/cockroachdb/pebble/sstable"
+	"github.com/cockroachdb/pebble/vfs"
+)
+
+// A blob file stores the values of BLOBSET keys, which are separated from
+// their keys when a memtable is flushed (see Options.MinBlobSize). The file is
+// a sequence of values, each followed by a 4-byte little-endian checksum of
+// the value. The value of a BLOBSET key is an encoded blobHandle pointing into
+// a blob file.
+//
+// Blob files are immutable, and are never rewritten. A blob file is live as
+// long as an sstable in a live version references it through
+// FileMetadata.BlobFiles, which is counted by versionSet.blobRefs.
+
+// blobTrailerLen is the length of the checksum that follows every value in a
+// blob file.
+const blobTrailerLen = 4
+
+// blobHandle locates a value in a blob file.
+type blobHandle struct {
+	fileNum FileNum
+	offset  uint64
+	length  uint64
+}
+
+// encode appends the encoding of the handle to dst.
+func (h blobHandle) encode(dst []byte) []byte {
+	var buf [3 * binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], uint64(h.fileNum))
+	n += binary.PutUvarint(buf[n:], h.offset)
+	n += binary.PutUvarint(buf[n:], h.length)
+	return append(dst, buf[:n]...)
+}
+
+func decodeBlobHandle(b []byte) (blobHandle, error) {
+	var h blobHandle
+	var vals [3]uint64
+	for i := range vals {
+		v, n := binary.Uvarint(b)
+		if n <= 0 {
+			return blobHandle{}, base.CorruptionErrorf("pebble: invalid blob handle")
+		}
+		vals[i] = v
+		b = b[n:]
+	}
+	if len(b) != 0 {
+		return blobHandle{}, base.CorruptionErrorf("pebble: invalid blob handle")
+	}
+	h.fileNum, h.offset, h.length = FileNum(vals[0]), vals[1], vals[2]
+	return h, nil
+}
+
+// blobWriter writes the values separated from their keys by a flush to a new
+// blob file.
+type blobWriter struct {
+	fileNum   FileNum
+	file      vfs.File
+	w         *bufio.Writer
+	offset    uint64
+	handleBuf []byte
+}
+
+func newBlobWriter(fileNum FileNum, file vfs.File) *blobWriter {
+	return &blobWriter{
+		fileNum: fileNum,
+		file:    file,
+		w:       bufio.NewWriter(file),
+	}
+}
+
+// add appends value to the blob file, returning the encoded handle of the
+// value. The handle is only valid until the next call to add.
+func (w *blobWriter) add(value []byte) ([]byte, error) {
+	var trailer [blobTrailerLen]byte
+	binary.LittleEndian.PutUint32(trailer[:], crc.New(value).Value())
+	if _, err := w.w.Write(value); err != nil {
+		return nil, err
+	}
+	if _, err := w.w.Write(trailer[:]); err != nil {
+		return nil, err
+	}
+	h := blobHandle{fileNum: w.fileNum, offset: w.offset, length: uint64(len(value))}
+	w.offset += uint64(len(value)) + blobTrailerLen
+	w.handleBuf = h.encode(w.handleBuf[:0])
+	return w.handleBuf, nil
+}
+
+// size returns the number of bytes written to the blob file.
+func (w *blobWriter) size() uint64 {
+	return w.offset
+}
+
+// close flushes, syncs and closes the blob file.
+func (w *blobWriter) close() error {
+	err := w.w.Flush()
+	if err == nil {
+		err = w.file.Sync()
+	}
+	return firstError(err, w.file.Close())
+}
+
+// blobFileCache holds the open blob files of a DB, and reads values from
+// them. Blob files are opened when a value is first read from them, and are
+// closed when they become obsolete.
+type blobFileCache struct {
+	fs      vfs.FS
+	dirname string
+	mu      struct {
+		sync.Mutex
+		files map[FileNum]vfs.File
+	}
+}
+
+func newBlobFileCache(fs vfs.FS, dirname string) *blobFileCache {
+	c := &blobFileCache{fs: fs, dirname: dirname}
+	c.mu.files = make(map[FileNum]vfs.File)
+	return c
+}
+
+// read reads the value referenced by the encoded blob handle into buf, which
+// is grown as necessary, and returns the value.
+func (c *blobFileCache) read(handle []byte, buf []byte) ([]byte, error) {
+	h, err := decodeBlobHandle(handle)
+	if err != nil {
+		return nil, err
+	}
+	f, err := c.open(h.fileNum)
+	if err != nil {
+		return nil, err
+	}
+	n := int(h.length) + blobTrailerLen
+	if cap(buf) < n {
+		buf = make([]byte, n)
+	}
+	buf = buf[:n]
+	if _, err := f.ReadAt(buf, int64(h.offset)); err != nil {
+		return nil, errors.Wrapf(err, "pebble: reading blob file %s", h.fileNum)
+	}
+	value := buf[:h.length]
+	if crc.New(value).Value() != binary.LittleEndian.Uint32(buf[h.length:]) {
+		return nil, base.CorruptionErrorf("pebble: blob file %s: checksum mismatch at offset %d",
+			errors.Safe(h.fileNum), errors.Safe(h.offset))
+	}
+	return value, nil
+}
+
+func (c *blobFileCache) open(fileNum FileNum) (vfs.File, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if f, ok := c.mu.files[fileNum]; ok {
+		return f, nil
+	}
+	f, err := c.fs.Open(base.MakeFilepath(c.fs, c.dirname, fileTypeBlob, fileNum))
+	if err != nil {
+		return nil, err
+	}
+	c.mu.files[fileNum] = f
+	return f, nil
+}
+
+// evict closes the blob file, if it is open.
+func (c *blobFileCache) evict(fileNum FileNum) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if f, ok := c.mu.files[fileNum]; ok {
+		_ = f.Close()
+		delete(c.mu.files, fileNum)
+	}
+}
+
+// close closes all open blob files.
+func (c *blobFileCache) close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var err error
+	for fileNum, f := range c.mu.files {
+		err = firstError(err, f.Close())
+		delete(c.mu.files, fileNum)
+	}
+	return err
+}
+
+// blobFileSet accumulates the blob files referenced by the BLOBSET keys
+// written to an sstable.
+type blobFileSet map[FileNum]struct{}
+
+// add adds the blob file referenced by the encoded blob handle to the set.
+func (s blobFileSet) add(handle []byte) error {
+	h, err := decodeBlobHandle(handle)
+	if err != nil {
+		return err
+	}
+	s[h.fileNum] = struct{}{}
+	return nil
+}
+
+// sorted returns the file numbers in the set in increasing order, or nil if
+// the set is empty.
+func (s blobFileSet) sorted() []FileNum {
+	if len(s) == 0 {
+		return nil
+	}
+	fileNums := make([]FileNum, 0, len(s))
+	for fileNum := range s {
+		fileNums = append(fileNums, fileNum)
+	}
+	sort.Slice(fileNums, func(i, j int) bool { return fileNums[i] < fileNums[j] })
+	return fileNums
+}
+
+// The user properties of an sstable holding the total length of the values
+// its BLOBSET keys point to, and the total length of their encoded handles,
+// which the RawValueSize property of the sstable counts instead of the values.
+const (
+	blobValueSizeProperty  = "pebble.blob.value-size"
+	blobHandleSizeProperty = "pebble.blob.handle-size"
+)
+
+// blobValueSizeCollector is a TablePropertyCollector that records the
+// blobValueSizeProperty and blobHandleSizeProperty of an sstable.
+type blobValueSizeCollector struct {
+	valueSize  uint64
+	handleSize uint64
+}
+
+func newBlobValueSizeCollector() TablePropertyCollector {
+	return &blobValueSizeCollector{}
+}
+
+// Add implements TablePropertyCollector.
+func (c *blobValueSizeCollector) Add(key InternalKey, value []byte) error {
+	if key.Kind() != InternalKeyKindBlobSet {
+		return nil
+	}
+	h, err := decodeBlobHandle(value)
+	if err != nil {
+		return err
+	}
+	c.valueSize += h.length
+	c.handleSize += uint64(len(value))
+	return nil
+}
+
+// Finish implements TablePropertyCollector.
+func (c *blobValueSizeCollector) Finish(userProps map[string]string) error {
+	if c.handleSize > 0 {
+		userProps[blobValueSizeProperty] = strconv.FormatUint(c.valueSize, 10)
+		userProps[blobHandleSizeProperty] = strconv.FormatUint(c.handleSize, 10)
+	}
+	return nil
+}
+
+// Name implements TablePropertyCollector.
+func (c *blobValueSizeCollector) Name() string {
+	return blobValueSizeProperty
+}
+
+// rawValueSize returns the total length of the values of an sstable's point
+// keys, counting the values of BLOBSET keys by their length in blob files.
+func rawValueSize(props *sstable.Properties) uint64 {
+	valueSize, _ := strconv.ParseUint(props.UserProperties[blobValueSizeProperty], 10, 64)
+	handleSize, _ := strconv.ParseUint(props.UserProperties[blobHandleSizeProperty], 10, 64)
+	return props.RawValueSize - handleSize + valueSize
+}
+
+// referencesBlobFiles returns true if an input of the compaction references
+// a blob file, in which case its outputs may hold BLOBSET keys.
+func (c *compaction) referencesBlobFiles() bool {
+	for _, cl := range c.inputs {
+		iter := cl.files.Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			if len(f.BlobFiles) > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
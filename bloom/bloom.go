@@ -8,6 +8,7 @@ package bloom // import "github.com/cockroachdb/pebble/bloom"
 import (
 	"encoding/binary"
 	"fmt"
+	"sync/atomic"
 
 	"github.com/cockroachdb/pebble/internal/base"
 )
@@ -207,3 +208,72 @@ func (p FilterPolicy) NewWriter(ftype base.FilterType) base.FilterWriter {
 		panic(fmt.Sprintf("unknown filter type: %v", ftype))
 	}
 }
+
+// DynamicFilter is a Bloom filter of a fixed size to which keys are added
+// after it is created, such as a filter over the keys of a memtable. Keys may
+// be added concurrently with calls to MayContain and other calls to Add.
+//
+// The false positive rate of a DynamicFilter grows with the number of keys
+// added to it. It is ~1% when bitsPerKey bits of the filter are used for
+// each key, for the bitsPerKey passed to NewDynamicFilter.
+type DynamicFilter struct {
+	words   []uint32
+	nLines  uint32
+	nProbes uint32
+}
+
+// NewDynamicFilter returns an empty DynamicFilter using about size bytes. The
+// number of bits set for each key is chosen for a filter holding a key for
+// every bitsPerKey bits.
+func NewDynamicFilter(size int, bitsPerKey int) *DynamicFilter {
+	nLines := (size + cacheLineSize - 1) / cacheLineSize
+	// Make nLines an odd number to make sure more bits are involved when
+	// determining which block.
+	if nLines%2 == 0 {
+		nLines++
+	}
+	return &DynamicFilter{
+		words:   make([]uint32, nLines*cacheLineSize/4),
+		nLines:  uint32(nLines),
+		nProbes: calculateProbes(bitsPerKey),
+	}
+}
+
+// Add adds a key to the filter.
+func (f *DynamicFilter) Add(key []byte) {
+	h := hash(key)
+	delta := h>>17 | h<<15 // rotate right 17 bits
+	b := (h % f.nLines) * cacheLineBits
+	for i := uint32(0); i < f.nProbes; i++ {
+		bitPos := b + (h % cacheLineBits)
+		word, mask := &f.words[bitPos/32], uint32(1)<<(bitPos%32)
+		for {
+			old := atomic.LoadUint32(word)
+			if old&mask != 0 || atomic.CompareAndSwapUint32(word, old, old|mask) {
+				break
+			}
+		}
+		h += delta
+	}
+}
+
+// MayContain returns whether the key may have been added to the filter. It
+// returns false only if the key was definitely not added.
+func (f *DynamicFilter) MayContain(key []byte) bool {
+	h := hash(key)
+	delta := h>>17 | h<<15
+	b := (h % f.nLines) * cacheLineBits
+	for i := uint32(0); i < f.nProbes; i++ {
+		bitPos := b + (h % cacheLineBits)
+		if atomic.LoadUint32(&f.words[bitPos/32])&(1<<(bitPos%32)) == 0 {
+			return false
+		}
+		h += delta
+	}
+	return true
+}
+
+// Size returns the size of the filter in bytes.
+func (f *DynamicFilter) Size() int {
+	return len(f.words) * 4
+}
@@ -5,7 +5,9 @@
 package bloom
 
 import (
+	"encoding/binary"
 	"strings"
+	"sync"
 	"testing"
 
 	"github.com/cockroachdb/pebble/internal/base"
@@ -143,6 +145,42 @@ loop:
 	}
 }
 
+func TestDynamicFilter(t *testing.T) {
+	le32 := func(i int) []byte {
+		b := make([]byte, 4)
+		binary.LittleEndian.PutUint32(b, uint32(i))
+		return b
+	}
+	const numKeys = 10000
+	f := NewDynamicFilter(numKeys*10/8, 10)
+	require.LessOrEqual(t, numKeys*10/8, f.Size())
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < numKeys; i += 4 {
+				f.Add(le32(i))
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	// All added keys must match.
+	for i := 0; i < numKeys; i++ {
+		require.True(t, f.MayContain(le32(i)), "key %d", i)
+	}
+	// Check false positive rate.
+	nFalsePositive := 0
+	for i := 0; i < 10000; i++ {
+		if f.MayContain(le32(1e9 + i)) {
+			nFalsePositive++
+		}
+	}
+	require.LessOrEqual(t, nFalsePositive, 200)
+}
+
 func TestHash(t *testing.T) {
 	testCases := []struct {
 		s        string
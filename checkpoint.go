@@ -248,16 +248,36 @@ func (d *DB) Checkpoint(
 		}
 	}
 
-	// Link or copy the sstables.
+	// Link or copy the sstables, and the blob files they reference. Blob files
+	// are immutable, so linking them is safe.
+	blobFiles := make(map[FileNum]struct{})
 	for l := range current.Levels {
 		iter := current.Levels[l].Iter()
 		for f := iter.First(); f != nil; f = iter.Next() {
+			if f.IsShared {
+				// Shared sstables are never removed from shared storage, so the
+				// checkpoint references them in place. Opening the checkpoint
+				// requires the same Options.SharedFS.
+				continue
+			}
 			srcPath := base.MakeFilepath(fs, d.dirname, fileTypeTable, f.FileNum)
 			destPath := fs.PathJoin(destDir, fs.PathBase(srcPath))
 			ckErr = vfs.LinkOrCopy(fs, srcPath, destPath)
 			if ckErr != nil {
 				return ckErr
 			}
+			for _, blobFileNum := range f.BlobFiles {
+				if _, ok := blobFiles[blobFileNum]; ok {
+					continue
+				}
+				blobFiles[blobFileNum] = struct{}{}
+				srcPath := base.MakeFilepath(fs, d.dirname, fileTypeBlob, blobFileNum)
+				destPath := fs.PathJoin(destDir, fs.PathBase(srcPath))
+				ckErr = vfs.LinkOrCopy(fs, srcPath, destPath)
+				if ckErr != nil {
+					return ckErr
+				}
+			}
 		}
 	}
 
@@ -261,16 +261,21 @@ func (u *userKeyChangeSplitter) onNewOutput(key *InternalKey) []byte {
 
 // compactionFile is a vfs.File wrapper that, on every write, updates a metric
 // in `versions` on bytes written by in-progress compactions so far. It also
-// increments a per-compaction `written` int.
+// increments a per-compaction `written` int, and paces the writes through the
+// compaction's pacer.
 type compactionFile struct {
 	vfs.File
 
 	versions *versionSet
 	written  *int64
+	pacer    pacer
 }
 
 // Write implements the io.Writer interface.
 func (c *compactionFile) Write(p []byte) (n int, err error) {
+	if err := c.pacer.maybeThrottle(uint64(len(p))); err != nil {
+		return 0, err
+	}
 	n, err = c.File.Write(p)
 	if err != nil {
 		return n, err
@@ -415,9 +420,16 @@ type compaction struct {
 	// by tests to allow range tombstones or range keys to be added to tables where
 	// they would otherwise be elided.
 	disableSpanElision bool
+	// isManual is set for compactions requested through DB.Compact. These are
+	// parallelized by splitManualCompaction rather than by sub-compactions.
+	isManual bool
 
 	// flushing contains the flushables (aka memtables) that are being flushed.
 	flushing flushableList
+	// cancel is used to cancel the compaction. It is shared with the
+	// compaction's sub-compactions, and is nil for compactions that cannot be
+	// cancelled, such as flushes.
+	cancel *compactionCancel
 	// bytesIterated contains the number of bytes that have been flushed/compacted.
 	bytesIterated uint64
 	// bytesWritten contains the number of bytes that have been written to outputs.
@@ -475,6 +487,11 @@ type compaction struct {
 	allowedZeroSeqNum bool
 
 	metrics map[int]*LevelMetrics
+
+	// outputWriterMetas, if non-nil, is populated with the writer metadata of
+	// each output sstable. It is used by remote compactions to report the
+	// bounds of the outputs.
+	outputWriterMetas map[base.FileNum]*sstable.WriterMetadata
 }
 
 func (c *compaction) makeInfo(jobID int) CompactionInfo {
@@ -509,6 +526,32 @@ func (c *compaction) makeInfo(jobID int) CompactionInfo {
 	return info
 }
 
+// cancelled returns whether the compaction has been cancelled.
+func (c *compaction) cancelled() bool {
+	return c.cancel != nil && atomic.LoadInt32(&c.cancel.cancelled) == 1
+}
+
+// compactionCancel is the cancellation state of a compaction. A cancelled
+// compaction stops at its next key, and a remote compaction is cancelled
+// through the context passed to the CompactionExecutor.
+type compactionCancel struct {
+	// cancelled is set to 1, atomically, once the compaction is cancelled.
+	cancelled int32
+	// done is closed once the compaction is cancelled.
+	done chan struct{}
+}
+
+func newCompactionCancel() *compactionCancel {
+	return &compactionCancel{done: make(chan struct{})}
+}
+
+// cancel cancels the compaction. It may be called more than once.
+func (cc *compactionCancel) cancel() {
+	if atomic.CompareAndSwapInt32(&cc.cancelled, 0, 1) {
+		close(cc.done)
+	}
+}
+
 func newCompaction(pc *pickedCompaction, opts *Options) *compaction {
 	c := &compaction{
 		kind:              compactionKindDefault,
@@ -523,6 +566,7 @@ func newCompaction(pc *pickedCompaction, opts *Options) *compaction {
 		version:           pc.version,
 		maxOutputFileSize: pc.maxOutputFileSize,
 		maxOverlapBytes:   pc.maxOverlapBytes,
+		cancel:            newCompactionCancel(),
 		l0SublevelInfo:    pc.l0SublevelInfo,
 	}
 	c.startLevel = &c.inputs[0]
@@ -722,16 +766,16 @@ func newFlush(opts *Options, cur *version, baseLevel int, flushing flushableList
 }
 
 func (c *compaction) hasExtraLevelData() bool {
-	if len(c.extraLevels) == 0 {
-		// not a multi level compaction
-		return false
-	} else if c.extraLevels[0].files.Empty() {
-		// a multi level compaction without data in the intermediate input level;
-		// e.g. for a multi level compaction with levels 4,5, and 6, this could
-		// occur if there is no files to compact in 5, or in 5 and 6 (i.e. a move).
-		return false
+	// A multi level compaction may have no data in the intermediate input
+	// levels; e.g. for a multi level compaction with levels 4,5, and 6, this
+	// could occur if there is no files to compact in 5, or in 5 and 6 (i.e. a
+	// move).
+	for _, cl := range c.extraLevels {
+		if !cl.files.Empty() {
+			return true
+		}
 	}
-	return true
+	return false
 }
 
 func (c *compaction) setupInuseKeyRanges() {
@@ -1078,11 +1122,7 @@ func (c *compaction) newInputIter(
 		}
 	}
 
-	if len(c.extraLevels) > 0 {
-		if len(c.extraLevels) > 1 {
-			panic("n>2 multi level compaction not implemented yet")
-		}
-		interLevel := c.extraLevels[0]
+	for _, interLevel := range c.extraLevels {
 		err := manifest.CheckOrdering(c.cmp, c.formatKey,
 			manifest.Level(interLevel.level), interLevel.files.Iter())
 		if err != nil {
@@ -1230,8 +1270,8 @@ func (c *compaction) newInputIter(
 			}
 		}
 	}
-	if len(c.extraLevels) > 0 {
-		if err = addItersForLevel(c.extraLevels[0], manifest.Level(c.extraLevels[0].level)); err != nil {
+	for _, interLevel := range c.extraLevels {
+		if err = addItersForLevel(interLevel, manifest.Level(interLevel.level)); err != nil {
 			return nil, err
 		}
 	}
@@ -1267,9 +1307,11 @@ func (c *compaction) String() string {
 	}
 
 	var buf bytes.Buffer
-	for level := c.startLevel.level; level <= c.outputLevel.level; level++ {
-		i := level - c.startLevel.level
-		fmt.Fprintf(&buf, "%d:", level)
+	for i := range c.inputs {
+		if i > 0 && c.inputs[i].level == c.inputs[i-1].level {
+			continue
+		}
+		fmt.Fprintf(&buf, "%d:", c.inputs[i].level)
 		iter := c.inputs[i].files.Iter()
 		for f := iter.First(); f != nil; f = iter.Next() {
 			fmt.Fprintf(&buf, " %s:%s-%s", f.FileNum, f.Smallest, f.Largest)
@@ -1289,6 +1331,9 @@ type manualCompaction struct {
 	start       []byte
 	end         []byte
 	split       bool
+	// c is the compaction running the manual compaction, once it has been
+	// scheduled. It is used to cancel the compaction.
+	c *compaction
 }
 
 type readCompaction struct {
@@ -1683,9 +1728,14 @@ func pickElisionOnly(picker compactionPicker, env compactionEnv) *pickedCompacti
 func (d *DB) maybeScheduleCompactionPicker(
 	pickFunc func(compactionPicker, compactionEnv) *pickedCompaction,
 ) {
-	if d.closed.Load() != nil || d.opts.ReadOnly {
+	if d.closed.Load() != nil || d.opts.ReadOnly || d.mu.compact.paused > 0 {
 		return
 	}
+	// The compaction debt changes with every flush and compaction, all of
+	// which are followed by an attempt to schedule a compaction.
+	if d.writeLimiter != nil {
+		d.writeLimiter.tune(d.mu.versions.picker.estimatedCompactionDebt(0))
+	}
 	if d.mu.compact.compactingCount >= d.opts.MaxConcurrentCompactions {
 		if len(d.mu.compact.manual) > 0 {
 			// Inability to run head blocks later manual compactions.
@@ -1730,12 +1780,29 @@ func (d *DB) maybeScheduleCompactionPicker(
 		}
 	}
 
+	// Drop the tables that have outlived Options.TTL, which is also cheap.
+	if d.opts.TTL > 0 &&
+		d.mu.compact.compactingCount < d.opts.MaxConcurrentCompactions &&
+		!d.opts.DisableAutomaticCompactions {
+		v := d.mu.versions.currentVersion()
+		snapshots := d.mu.snapshots.toSlice()
+		inputs := checkExpiredTables(d.cmp, v, d.ttlCutoff().Unix(), snapshots)
+		if len(inputs) > 0 {
+			c := newDeleteOnlyCompaction(d.opts, v, inputs)
+			d.mu.compact.compactingCount++
+			d.addInProgressCompaction(c)
+			go d.compact(c, nil)
+		}
+	}
+
 	for len(d.mu.compact.manual) > 0 && d.mu.compact.compactingCount < d.opts.MaxConcurrentCompactions {
 		manual := d.mu.compact.manual[0]
 		env.inProgressCompactions = d.getInProgressCompactionInfoLocked(nil)
 		pc, retryLater := d.mu.versions.picker.pickManual(env, manual)
 		if pc != nil {
 			c := newCompaction(pc, d.opts)
+			c.isManual = true
+			manual.c = c
 			d.mu.compact.manual = d.mu.compact.manual[1:]
 			d.mu.compact.compactingCount++
 			d.addInProgressCompaction(c)
@@ -1751,6 +1818,13 @@ func (d *DB) maybeScheduleCompactionPicker(
 		}
 	}
 
+	// Mark the tables that have outlived Options.PeriodicCompactionPeriod for
+	// compaction. They are compacted when there is nothing else to do.
+	if d.opts.PeriodicCompactionPeriod > 0 && !d.opts.DisableAutomaticCompactions {
+		env.periodicCompactionCutoff = d.periodicCompactionCutoff()
+		d.maybeMarkStaleFilesLocked(env.periodicCompactionCutoff)
+	}
+
 	for !d.opts.DisableAutomaticCompactions && d.mu.compact.compactingCount < d.opts.MaxConcurrentCompactions {
 		env.inProgressCompactions = d.getInProgressCompactionInfoLocked(nil)
 		env.readCompactionEnv = readCompactionEnv{
@@ -2047,7 +2121,7 @@ func (d *DB) compact(c *compaction, errChannel chan error) {
 	pprof.Do(context.Background(), compactLabels, func(context.Context) {
 		d.mu.Lock()
 		defer d.mu.Unlock()
-		if err := d.compact1(c, errChannel); err != nil {
+		if err := d.compact1(c, errChannel); err != nil && !errors.Is(err, ErrCancelledCompaction) {
 			// TODO(peter): count consecutive compaction errors and backoff.
 			d.opts.EventListener.BackgroundError(err)
 		}
@@ -2121,7 +2195,16 @@ func (d *DB) compact1(c *compaction, errChannel chan error) (err error) {
 	return err
 }
 
-func moveFileToSharedFS(filepath string, fs vfs.FS, sharedPath string, sharedFS vfs.FS) error {
+// removeSharedOutput removes an output table of a failed compaction that was
+// moved to shared storage.
+func (d *DB) removeSharedOutput(meta *fileMetadata) {
+	_ = d.opts.SharedFS.Remove(base.MakeSharedSSTPath(
+		d.opts.SharedFS, d.opts.SharedDir, meta.CreatorUniqueID, meta.PhysicalFileNum))
+}
+
+func moveFileToSharedFS(
+	filepath string, fs vfs.FS, sharedPath string, sharedFS vfs.FS, pacer pacer,
+) error {
 	file, err := fs.Open(filepath, vfs.SequentialReadsOption)
 	if err != nil {
 		return err
@@ -2136,11 +2219,25 @@ func moveFileToSharedFS(filepath string, fs vfs.FS, sharedPath string, sharedFS
 		return err
 	}
 	defer func() {
-		_ = destFile.Close()
+		if destFile != nil {
+			_ = destFile.Close()
+		}
 	}()
 
-	_, err = io.Copy(destFile, file)
+	// The upload is only complete once it has been synced and closed. Shared
+	// storage may report a failure even if the object was persisted, in which
+	// case the table stays local and the orphaned object is removed on a best
+	// effort basis.
+	_, err = io.Copy(&pacedWriter{Writer: destFile, pacer: pacer}, file)
+	if err == nil {
+		err = destFile.Sync()
+	}
+	if err == nil {
+		err = destFile.Close()
+		destFile = nil
+	}
 	if err != nil {
+		_ = sharedFS.Remove(sharedPath)
 		return err
 	}
 
@@ -2239,15 +2336,109 @@ func (d *DB) runCompaction(
 
 	snapshots := d.mu.snapshots.toSlice()
 	formatVers := d.mu.formatVers.vers
-	// The table is written at the maximum allowable format implied by the current
-	// format major version of the DB.
-	tableFormat := formatVers.MaxTableFormat()
+
+	ve = &versionEdit{
+		DeletedFiles: map[deletedFileEntry]*fileMetadata{},
+	}
+
+	outputMetrics := &LevelMetrics{
+		BytesIn:   c.startLevel.files.SizeSum(),
+		BytesRead: c.outputLevel.files.SizeSum(),
+	}
+	for _, interLevel := range c.extraLevels {
+		outputMetrics.BytesIn += interLevel.files.SizeSum()
+	}
+	outputMetrics.BytesRead += outputMetrics.BytesIn
+
+	c.metrics = map[int]*LevelMetrics{
+		c.outputLevel.level: outputMetrics,
+	}
+	if len(c.flushing) == 0 && c.metrics[c.startLevel.level] == nil {
+		c.metrics[c.startLevel.level] = &LevelMetrics{}
+	}
+	for _, interLevel := range c.extraLevels {
+		c.metrics[interLevel.level] = &LevelMetrics{}
+	}
+
+	// Hand the compaction to the CompactionExecutor if possible. Otherwise,
+	// split the compaction into sub-compactions if possible. Every additional
+	// sub-compaction occupies a compaction slot until the compaction completes.
+	var remoteDesc *RemoteCompactionDesc
+	subcompactions := []*compaction{c}
+	if d.remoteCompactionAllowedLocked(c) {
+		remoteDesc = d.makeRemoteCompactionDescLocked(jobID, c, snapshots, formatVers)
+	} else {
+		subcompactions = d.splitCompactionLocked(c)
+	}
+	d.mu.compact.compactingCount += len(subcompactions) - 1
+	defer func() {
+		d.mu.compact.compactingCount -= len(subcompactions) - 1
+	}()
 
 	// Release the d.mu lock while doing I/O.
 	// Note the unusual order: Unlock and then Lock.
 	d.mu.Unlock()
 	defer d.mu.Lock()
 
+	var outputs *versionEdit
+	if remoteDesc != nil {
+		outputs, pendingOutputs, retErr = d.runRemoteCompaction(jobID, c, remoteDesc, outputMetrics)
+		if retErr != nil && c.cancelled() {
+			return nil, nil, ErrCancelledCompaction
+		}
+		if retErr != nil {
+			d.opts.Logger.Infof("[JOB %d] remote compaction failed, compacting locally: %v", jobID, retErr)
+			outputs, pendingOutputs, retErr = nil, nil, nil
+		}
+	}
+	if outputs == nil {
+		if len(subcompactions) == 1 {
+			outputs, pendingOutputs, retErr = d.writeCompactionOutputs(jobID, c, snapshots, formatVers, outputMetrics)
+		} else {
+			outputs, pendingOutputs, retErr = d.runSubcompactions(jobID, c, subcompactions, snapshots, formatVers, outputMetrics)
+		}
+	}
+	if retErr != nil {
+		return nil, pendingOutputs, retErr
+	}
+	ve.NewFiles = outputs.NewFiles
+
+	for _, cl := range c.inputs {
+		iter := cl.files.Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			c.metrics[cl.level].NumFiles--
+			c.metrics[cl.level].Size -= int64(f.Size)
+			ve.DeletedFiles[deletedFileEntry{
+				Level:   cl.level,
+				FileNum: f.FileNum,
+			}] = f
+		}
+	}
+
+	if err := d.dataDir.Sync(); err != nil {
+		return nil, pendingOutputs, err
+	}
+
+	// Refresh the disk available statistic whenever a compaction/flush
+	// completes, before re-acquiring the mutex.
+	_ = d.calculateDiskAvailableBytes()
+
+	return ve, pendingOutputs, nil
+}
+
+// writeCompactionOutputs iterates over the inputs of the compaction c, writing
+// the output tables. It returns a version edit adding the output tables, and
+// the metadata of the tables that were created, which must be deleted if the
+// compaction fails. The written tables are added to outputMetrics.
+//
+// d.mu must not be held when calling this.
+func (d *DB) writeCompactionOutputs(
+	jobID int,
+	c *compaction,
+	snapshots []uint64,
+	formatVers FormatMajorVersion,
+	outputMetrics *LevelMetrics,
+) (ve *versionEdit, pendingOutputs []*fileMetadata, retErr error) {
 	iiter, err := c.newInputIter(d.newIters, d.tableNewRangeKeyIter, snapshots)
 	if err != nil {
 		return nil, pendingOutputs, err
@@ -2255,11 +2446,19 @@ func (d *DB) runCompaction(
 	c.allowedZeroSeqNum = c.allowZeroSeqNum()
 	iter := newCompactionIter(c.cmp, c.equal, c.formatKey, d.merge, iiter, snapshots,
 		&c.rangeDelFrag, &c.rangeKeyFrag, c.allowedZeroSeqNum, c.elideTombstone,
-		c.elideRangeTombstone, d.FormatMajorVersion())
+		c.elideRangeTombstone, d.newCompactionFilter(c), d.blobs, formatVers)
+
+	// Flushes and compactions out of L0 keep read amplification in check and
+	// unblock writes, so they take priority over other compactions.
+	pacer := (pacer)(nilPacer)
+	if d.writeLimiter != nil {
+		pacer = d.writeLimiter.pacer(c.kind == compactionKindFlush || c.startLevel.level == 0)
+	}
 
 	var (
 		filenames []string
 		tw        *sstable.Writer
+		bw        *blobWriter
 		movers    sync.WaitGroup
 	)
 	defer func() {
@@ -2269,39 +2468,31 @@ func (d *DB) runCompaction(
 		if tw != nil {
 			retErr = firstError(retErr, tw.Close())
 		}
+		if bw != nil {
+			retErr = firstError(retErr, bw.close())
+		}
 		if retErr != nil {
 			for _, filename := range filenames {
 				d.opts.FS.Remove(filename)
 			}
+			// Outputs that were moved to shared storage have left filenames.
+			movers.Wait()
+			for _, meta := range pendingOutputs {
+				if meta.IsShared {
+					d.removeSharedOutput(meta)
+				}
+			}
 		}
 		for _, closer := range c.closers {
 			retErr = firstError(retErr, closer.Close())
 		}
 	}()
 
-	ve = &versionEdit{
-		DeletedFiles: map[deletedFileEntry]*fileMetadata{},
-	}
-
-	outputMetrics := &LevelMetrics{
-		BytesIn:   c.startLevel.files.SizeSum(),
-		BytesRead: c.outputLevel.files.SizeSum(),
-	}
-	if len(c.extraLevels) > 0 {
-		outputMetrics.BytesIn += c.extraLevels[0].files.SizeSum()
-	}
-	outputMetrics.BytesRead += outputMetrics.BytesIn
-
-	c.metrics = map[int]*LevelMetrics{
-		c.outputLevel.level: outputMetrics,
-	}
-	if len(c.flushing) == 0 && c.metrics[c.startLevel.level] == nil {
-		c.metrics[c.startLevel.level] = &LevelMetrics{}
-	}
-	if len(c.extraLevels) > 0 {
-		c.metrics[c.extraLevels[0].level] = &LevelMetrics{}
-	}
+	ve = &versionEdit{}
 
+	// The table is written at the maximum allowable format implied by the current
+	// format major version of the DB.
+	tableFormat := formatVers.MaxTableFormat()
 	writerOpts := d.opts.MakeWriterOptions(c.outputLevel.level, tableFormat)
 	if formatVers < FormatBlockPropertyCollector {
 		// Cannot yet write block properties.
@@ -2324,6 +2515,89 @@ func (d *DB) runCompaction(
 		}
 	}()
 
+	reason, kind := "flushing", ""
+	if c.flushing == nil {
+		reason, kind = "compacting", c.kind.String()
+	}
+
+	// Flushes separate large values into a blob file. Compactions carry the
+	// blob handles forward, unless the outputs are placed on shared storage,
+	// which cannot reference local blob files.
+	separateValues := c.flushing != nil && d.opts.MinBlobSize > 0 && formatVers >= FormatBlobFiles
+	inlineValues := c.flushing == nil && d.compactionOutputsShared(c, reason, kind)
+	// Outputs that may hold BLOBSET keys record the length of the values in
+	// blob files, which their RawValueSize property does not include.
+	if separateValues || (!inlineValues && c.referencesBlobFiles()) {
+		writerOpts.TablePropertyCollectors = append(
+			writerOpts.TablePropertyCollectors[:len(writerOpts.TablePropertyCollectors):len(writerOpts.TablePropertyCollectors)],
+			newBlobValueSizeCollector)
+	}
+	// blobFiles are the blob files referenced by the current output.
+	var blobFiles blobFileSet
+	var blobBuf []byte
+	newBlobFile := func() error {
+		d.mu.Lock()
+		fileNum := d.mu.versions.getNextFileNum()
+		d.mu.Unlock()
+
+		filename := base.MakeFilepath(d.opts.FS, d.dirname, fileTypeBlob, fileNum)
+		file, err := d.opts.FS.Create(filename)
+		if err != nil {
+			return err
+		}
+		filenames = append(filenames, filename)
+		file = vfs.NewSyncingFile(file, vfs.SyncingFileOptions{
+			NoSyncOnClose: d.opts.NoSyncOnClose,
+			BytesPerSync:  d.opts.BytesPerSync,
+		})
+		file = &compactionFile{
+			File:     file,
+			versions: d.mu.versions,
+			written:  &c.bytesWritten,
+			pacer:    pacer,
+		}
+		bw = newBlobWriter(fileNum, file)
+		return nil
+	}
+	// addPoint adds a point key to the current output, separating its value
+	// into the blob file, or reading it from its blob file, as necessary.
+	addPoint := func(key InternalKey, value []byte) error {
+		switch key.Kind() {
+		case InternalKeyKindSet, InternalKeyKindSetWithDelete:
+			if !separateValues || len(value) < d.opts.MinBlobSize {
+				break
+			}
+			if bw == nil {
+				if err := newBlobFile(); err != nil {
+					return err
+				}
+			}
+			handle, err := bw.add(value)
+			if err != nil {
+				return err
+			}
+			key.SetKind(InternalKeyKindBlobSet)
+			value = handle
+			blobFiles[bw.fileNum] = struct{}{}
+		case InternalKeyKindBlobSet:
+			if !inlineValues {
+				if err := blobFiles.add(value); err != nil {
+					return err
+				}
+				break
+			}
+			var err error
+			if blobBuf, err = d.blobs.read(value, blobBuf); err != nil {
+				return err
+			}
+			// A BLOBSET may have met with a DEL, so the value is stored as a
+			// SETWITHDEL.
+			key.SetKind(InternalKeyKindSetWithDelete)
+			value = blobBuf
+		}
+		return tw.Add(key, value)
+	}
+
 	newOutput := func() error {
 		fileMeta := &fileMetadata{}
 		d.mu.Lock()
@@ -2337,10 +2611,6 @@ func (d *DB) runCompaction(
 		if err != nil {
 			return err
 		}
-		reason := "flushing"
-		if c.flushing == nil {
-			reason = "compacting"
-		}
 		d.opts.EventListener.TableCreated(TableCreateInfo{
 			JobID:   jobID,
 			Reason:  reason,
@@ -2355,6 +2625,7 @@ func (d *DB) runCompaction(
 			File:     file,
 			versions: d.mu.versions,
 			written:  &c.bytesWritten,
+			pacer:    pacer,
 		}
 		filenames = append(filenames, filename)
 		cacheOpts := private.SSTableCacheOpts(d.cacheID, fileNum).(sstable.WriterOption)
@@ -2368,6 +2639,7 @@ func (d *DB) runCompaction(
 		tw = sstable.NewWriter(file, writerOpts, cacheOpts, internalTableOpt, &prevPointKey)
 
 		fileMeta.CreationTime = time.Now().Unix()
+		blobFiles = make(blobFileSet)
 		ve.NewFiles = append(ve.NewFiles, newFileEntry{
 			Level: c.outputLevel.level,
 			Meta:  fileMeta,
@@ -2483,6 +2755,7 @@ func (d *DB) runCompaction(
 		meta.Size = writerMeta.Size
 		meta.SmallestSeqNum = writerMeta.SmallestSeqNum
 		meta.LargestSeqNum = writerMeta.LargestSeqNum
+		meta.BlobFiles = blobFiles.sorted()
 		// If the file didn't contain any range deletions, we can fill its
 		// table stats now, avoiding unnecessarily loading the table later.
 		maybeSetStatsFromProperties(meta, &writerMeta.Properties)
@@ -2543,26 +2816,37 @@ func (d *DB) runCompaction(
 		if writerMeta.HasRangeKeys {
 			meta.ExtendRangeKeyBounds(d.cmp, writerMeta.SmallestRangeKey, writerMeta.LargestRangeKey)
 		}
+		if c.outputWriterMetas != nil {
+			c.outputWriterMetas[meta.FileNum] = writerMeta
+		}
 
-		// If the output SSTable falls in lower levels than sharedLevel, it will be moved to the shared
-		// file system asynchronously
-		if d.opts.SharedFS != nil && c.outputLevel.level >= sharedLevel {
-			if writerMeta.HasRangeKeys {
-				panic("runCompaction: shared sst does not support range keys")
-			}
-
+		// If the placement policy places the output in shared storage, it is
+		// moved to the shared file system asynchronously.
+		filename := base.MakeFilepath(d.opts.FS, d.dirname, fileTypeTable, meta.FileNum)
+		placement := d.placeTable(meta, c.outputLevel.level, reason, kind)
+		if placement == PlacementShared {
 			setSharedSSTMetadata(meta, d.opts.UniqueID)
 
-			oldFilename := base.MakeFilepath(d.opts.FS, d.dirname, fileTypeTable, meta.FileNum)
-			sharedFilename := base.MakeSharedSSTPath(d.opts.SharedFS, d.opts.SharedDir, meta.CreatorUniqueID, meta.PhysicalFileNum)
+			oldFilename := filename
+			filename = base.MakeSharedSSTPath(d.opts.SharedFS, d.opts.SharedDir, meta.CreatorUniqueID, meta.PhysicalFileNum)
+			sharedFilename := filename
 			movers.Add(1)
 			go func() {
 				defer movers.Done()
-				if err := moveFileToSharedFS(oldFilename, d.opts.FS, sharedFilename, d.opts.SharedFS); err == nil {
+				if err := moveFileToSharedFS(oldFilename, d.opts.FS, sharedFilename, d.opts.SharedFS, pacer); err == nil {
 					meta.IsShared = true
 				}
 			}()
 		}
+		if d.opts.SharedFS != nil {
+			d.opts.EventListener.TablePlaced(TablePlaceInfo{
+				JobID:     jobID,
+				Reason:    reason,
+				Path:      filename,
+				FileNum:   meta.FileNum,
+				Placement: placement,
+			})
+		}
 
 		// Verify that the sstable bounds fall within the compaction input
 		// bounds. This is a sanity check that we don't have a logic error
@@ -2645,6 +2929,11 @@ func (d *DB) runCompaction(
 
 		// Each inner loop iteration processes one key from the input iterator.
 		for ; key != nil; key, val = iter.Next() {
+			// A cancelled compaction stops at its next key. Its outputs so
+			// far, including the one being written, are removed on return.
+			if c.cancelled() {
+				return nil, pendingOutputs, ErrCancelledCompaction
+			}
 			if split := splitter.shouldSplitBefore(key, tw); split == splitNow {
 				break
 			}
@@ -2709,7 +2998,7 @@ func (d *DB) runCompaction(
 					return nil, pendingOutputs, err
 				}
 			}
-			if err := tw.Add(*key, val); err != nil {
+			if err := addPoint(*key, val); err != nil {
 				return nil, pendingOutputs, err
 			}
 		}
@@ -2742,26 +3031,18 @@ func (d *DB) runCompaction(
 		}
 	}
 
-	for _, cl := range c.inputs {
-		iter := cl.files.Iter()
-		for f := iter.First(); f != nil; f = iter.Next() {
-			c.metrics[cl.level].NumFiles--
-			c.metrics[cl.level].Size -= int64(f.Size)
-			ve.DeletedFiles[deletedFileEntry{
-				Level:   cl.level,
-				FileNum: f.FileNum,
-			}] = f
+	if bw != nil {
+		// The blob file must be synced before the tables referencing it are
+		// installed.
+		outputMetrics.BytesFlushed += bw.size()
+		err := bw.close()
+		bw = nil
+		if err != nil {
+			return nil, pendingOutputs, err
 		}
 	}
-	movers.Wait()
 
-	if err := d.dataDir.Sync(); err != nil {
-		return nil, pendingOutputs, err
-	}
-
-	// Refresh the disk available statistic whenever a compaction/flush
-	// completes, before re-acquiring the mutex.
-	_ = d.calculateDiskAvailableBytes()
+	movers.Wait()
 
 	return ve, pendingOutputs, nil
 }
@@ -2816,6 +3097,7 @@ func (d *DB) scanObsoleteFiles(list []string) {
 	var obsoleteTables []*fileMetadata
 	var obsoleteManifests []fileInfo
 	var obsoleteOptions []fileInfo
+	var obsoleteBlobs []fileInfo
 
 	for _, filename := range list {
 		fileType, fileNum, ok := base.ParseFilename(d.opts.FS, filename)
@@ -2861,6 +3143,15 @@ func (d *DB) scanObsoleteFiles(list []string) {
 				fileMeta.Size = uint64(stat.Size())
 			}
 			obsoleteTables = append(obsoleteTables, fileMeta)
+		case fileTypeBlob:
+			if _, ok := d.mu.versions.blobRefs[fileNum]; ok {
+				continue
+			}
+			fi := fileInfo{fileNum: fileNum}
+			if stat, err := d.opts.FS.Stat(filename); err == nil {
+				fi.fileSize = uint64(stat.Size())
+			}
+			obsoleteBlobs = append(obsoleteBlobs, fi)
 		default:
 			// Don't delete files we don't know about.
 			continue
@@ -2873,6 +3164,7 @@ func (d *DB) scanObsoleteFiles(list []string) {
 	d.mu.versions.incrementObsoleteTablesLocked(obsoleteTables)
 	d.mu.versions.obsoleteManifests = merge(d.mu.versions.obsoleteManifests, obsoleteManifests)
 	d.mu.versions.obsoleteOptions = merge(d.mu.versions.obsoleteOptions, obsoleteOptions)
+	d.mu.versions.obsoleteBlobs = merge(d.mu.versions.obsoleteBlobs, obsoleteBlobs)
 }
 
 // disableFileDeletions disables file deletions and then waits for any
@@ -3032,12 +3324,17 @@ func (d *DB) doDeleteObsoleteFiles(jobID int) {
 	obsoleteOptions := d.mu.versions.obsoleteOptions
 	d.mu.versions.obsoleteOptions = nil
 
+	obsoleteBlobs := d.mu.versions.obsoleteBlobs
+	d.mu.versions.obsoleteBlobs = nil
+
+	pacer := d.deletionPacerLocked()
+
 	// Release d.mu while doing I/O
 	// Note the unusual order: Unlock and then Lock.
 	d.mu.Unlock()
 	defer d.mu.Lock()
 
-	files := [4]struct {
+	files := [5]struct {
 		fileType fileType
 		obsolete []fileInfo
 	}{
@@ -3045,6 +3342,7 @@ func (d *DB) doDeleteObsoleteFiles(jobID int) {
 		{fileTypeTable, obsoleteTables},
 		{fileTypeManifest, obsoleteManifests},
 		{fileTypeOptions, obsoleteOptions},
+		{fileTypeBlob, obsoleteBlobs},
 	}
 	_, noRecycle := d.opts.Cleaner.(base.NeedsFileContents)
 	filesToDelete := make([]obsoleteFile, 0, len(files))
@@ -3064,6 +3362,8 @@ func (d *DB) doDeleteObsoleteFiles(jobID int) {
 				dir = d.walDirname
 			case fileTypeTable:
 				d.tableCache.evict(fi.fileNum)
+			case fileTypeBlob:
+				d.blobs.evict(fi.fileNum)
 			}
 
 			filesToDelete = append(filesToDelete, obsoleteFile{
@@ -3080,22 +3380,30 @@ func (d *DB) doDeleteObsoleteFiles(jobID int) {
 	if len(filesToDelete) > 0 {
 		d.deleters.Add(1)
 		// Delete asynchronously if that could get held up in the pacer.
-		if d.opts.Experimental.MinDeletionRate > 0 {
-			go d.paceAndDeleteObsoleteFiles(jobID, filesToDelete)
+		if pacer != nilPacer {
+			go d.paceAndDeleteObsoleteFiles(jobID, filesToDelete, pacer)
 		} else {
-			d.paceAndDeleteObsoleteFiles(jobID, filesToDelete)
+			d.paceAndDeleteObsoleteFiles(jobID, filesToDelete, pacer)
 		}
 	}
 }
 
+// deletionPacerLocked returns the pacer for deletions of obsolete files.
+// Options.Experimental.MinDeletionRate may be changed by DB.SetOptions, so it
+// is read with d.mu held.
+//
+// d.mu must be held when calling this.
+func (d *DB) deletionPacerLocked() pacer {
+	if d.opts.Experimental.MinDeletionRate > 0 {
+		return newDeletionPacer(d.deletionLimiter, d.getDeletionPacerInfo)
+	}
+	return nilPacer
+}
+
 // Paces and eventually deletes the list of obsolete files passed in. db.mu
 // must NOT be held when calling this method.
-func (d *DB) paceAndDeleteObsoleteFiles(jobID int, files []obsoleteFile) {
+func (d *DB) paceAndDeleteObsoleteFiles(jobID int, files []obsoleteFile, pacer pacer) {
 	defer d.deleters.Done()
-	pacer := (pacer)(nilPacer)
-	if d.opts.Experimental.MinDeletionRate > 0 {
-		pacer = newDeletionPacer(d.deletionLimiter, d.getDeletionPacerInfo)
-	}
 
 	for _, of := range files {
 		path := base.MakeFilepath(d.opts.FS, of.dir, of.fileType, of.fileNum)
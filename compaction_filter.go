@@ -0,0 +1,134 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+// CompactionFilterDecision is the decision of a CompactionFilter for a key.
+type CompactionFilterDecision int8
+
+const (
+	// CompactionFilterKeep keeps the key and its value.
+	CompactionFilterKeep CompactionFilterDecision = iota
+	// CompactionFilterRemove removes the key, along with older versions of the
+	// key. Unless the compaction can prove that no older versions of the key
+	// exist in lower levels, the key is replaced by a point tombstone.
+	CompactionFilterRemove
+	// CompactionFilterChangeValue keeps the key with a new value.
+	CompactionFilterChangeValue
+)
+
+func (d CompactionFilterDecision) String() string {
+	switch d {
+	case CompactionFilterKeep:
+		return "keep"
+	case CompactionFilterRemove:
+		return "remove"
+	case CompactionFilterChangeValue:
+		return "change-value"
+	}
+	return "unknown"
+}
+
+// CompactionFilterContext describes the flush or compaction for which a
+// CompactionFilter is created.
+type CompactionFilterContext struct {
+	// OutputLevel is the LSM level the flush or compaction writes to.
+	OutputLevel int
+	// Flush is true if memtables are flushed, and false for compactions.
+	Flush bool
+}
+
+// CompactionFilterKeyInfo describes a key passed to CompactionFilter.Filter.
+type CompactionFilterKeyInfo struct {
+	// Kind is InternalKeyKindSet for a SET, and InternalKeyKindMerge for the
+	// merged operands of a MERGE that is not merged with a SET.
+	Kind InternalKeyKind
+	// SeqNum is the sequence number of the key.
+	SeqNum uint64
+	// Snapshot is the sequence number of the earliest open snapshot that reads
+	// the key, or InternalKeySeqNumMax if the key is newer than all open
+	// snapshots. The keys of a user key that are read by the same snapshots
+	// form a snapshot stripe, and only the newest key of each stripe is passed
+	// to the filter.
+	Snapshot uint64
+}
+
+// VisibleToSnapshot returns true if an open snapshot reads the key.
+func (i CompactionFilterKeyInfo) VisibleToSnapshot() bool {
+	return i.Snapshot != InternalKeySeqNumMax
+}
+
+// CompactionFilter drops or rewrites point keys during flushes and
+// compactions, based on application logic. A CompactionFilter is created by
+// Options.CompactionFilter for every flush or compaction, and is only used by
+// a single goroutine.
+type CompactionFilter interface {
+	// Filter is invoked for the newest SET or MERGE of every snapshot stripe of
+	// a user key that is not deleted by a newer key. It returns the decision
+	// for the key and, for CompactionFilterChangeValue, the new value, which
+	// must remain valid until the next call to Filter.
+	//
+	// The decision for a key that is visible to an open snapshot is ignored,
+	// and the key is kept, so that a compaction filter never changes what a
+	// snapshot reads.
+	Filter(key, value []byte, info CompactionFilterKeyInfo) (CompactionFilterDecision, []byte)
+}
+
+// CompactionFilterFunc adapts a function to the CompactionFilter interface.
+type CompactionFilterFunc func(
+	key, value []byte, info CompactionFilterKeyInfo,
+) (CompactionFilterDecision, []byte)
+
+// Filter implements CompactionFilter.
+func (f CompactionFilterFunc) Filter(
+	key, value []byte, info CompactionFilterKeyInfo,
+) (CompactionFilterDecision, []byte) {
+	return f(key, value, info)
+}
+
+// compactionFilters applies a sequence of compaction filters to each key. The
+// first filter that removes a key decides, and values changed by a filter are
+// passed to the next one.
+type compactionFilters []CompactionFilter
+
+func (f compactionFilters) Filter(
+	key, value []byte, info CompactionFilterKeyInfo,
+) (CompactionFilterDecision, []byte) {
+	decision := CompactionFilterKeep
+	for _, filter := range f {
+		switch d, v := filter.Filter(key, value, info); d {
+		case CompactionFilterRemove:
+			return d, nil
+		case CompactionFilterChangeValue:
+			decision, value = d, v
+		}
+	}
+	return decision, value
+}
+
+// newCompactionFilter returns the compaction filter for the compaction c, or
+// nil if keys are not filtered. It combines the expiry of keys according to
+// Options.TTL with Options.CompactionFilter.
+func (d *DB) newCompactionFilter(c *compaction) CompactionFilter {
+	var filters compactionFilters
+	if f := d.newTTLCompactionFilter(); f != nil {
+		filters = append(filters, f)
+	}
+	if d.opts.CompactionFilter != nil {
+		ctx := CompactionFilterContext{
+			OutputLevel: c.outputLevel.level,
+			Flush:       c.kind == compactionKindFlush,
+		}
+		if f := d.opts.CompactionFilter(ctx); f != nil {
+			filters = append(filters, f)
+		}
+	}
+	switch len(filters) {
+	case 0:
+		return nil
+	case 1:
+		return filters[0]
+	}
+	return filters
+}
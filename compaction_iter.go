@@ -209,6 +209,14 @@ type compactionIter struct {
 	allowZeroSeqNum     bool
 	elideTombstone      func(key []byte) bool
 	elideRangeTombstone func(start, end []byte) bool
+	// filter, if non-nil, is the compaction filter applied to the newest SET or
+	// MERGE of every snapshot stripe.
+	filter CompactionFilter
+	// blobs reads the values of BLOBSET keys where the compaction needs them:
+	// for merges, and for the compaction filter. A BLOBSET key is otherwise
+	// passed through with its blob handle.
+	blobs   *blobFileCache
+	blobBuf []byte
 	// The on-disk format major version. This informs the types of keys that
 	// may be written to disk during a compaction.
 	formatVersion FormatMajorVersion
@@ -226,6 +234,8 @@ func newCompactionIter(
 	allowZeroSeqNum bool,
 	elideTombstone func(key []byte) bool,
 	elideRangeTombstone func(start, end []byte) bool,
+	filter CompactionFilter,
+	blobs *blobFileCache,
 	formatVersion FormatMajorVersion,
 ) *compactionIter {
 	i := &compactionIter{
@@ -238,6 +248,8 @@ func newCompactionIter(
 		allowZeroSeqNum:     allowZeroSeqNum,
 		elideTombstone:      elideTombstone,
 		elideRangeTombstone: elideRangeTombstone,
+		filter:              filter,
+		blobs:               blobs,
 		formatVersion:       formatVersion,
 	}
 	i.rangeDelFrag.Cmp = cmp
@@ -354,7 +366,33 @@ func (i *compactionIter) Next() (*InternalKey, []byte) {
 				continue
 			}
 
-		case InternalKeyKindSet, InternalKeyKindSetWithDelete:
+		case InternalKeyKindSet, InternalKeyKindSetWithDelete, InternalKeyKindBlobSet:
+			if i.filter != nil {
+				value, err := i.readValue(i.iterKey.Kind(), i.iterValue)
+				if err != nil {
+					i.err = err
+					i.valid = false
+					return nil, nil
+				}
+				decision, value := i.applyFilter(i.iterKey, value, i.curSnapshotIdx)
+				switch decision {
+				case CompactionFilterRemove:
+					i.saveKey()
+					if i.removeFiltered() {
+						return &i.key, i.value
+					}
+					continue
+				case CompactionFilterChangeValue:
+					i.setNext()
+					if i.key.Kind() == InternalKeyKindBlobSet {
+						// The new value replaces the blob handle.
+						i.key.SetKind(InternalKeyKindSetWithDelete)
+					}
+					i.value = value
+					return &i.key, i.value
+				}
+			}
+
 			// The key we emit for this entry is a function of the current key
 			// kind, and whether this entry is followed by a DEL/SINGLEDEL
 			// entry. setNext() does the work to move the iterator forward,
@@ -388,6 +426,26 @@ func (i *compactionIter) Next() (*InternalKey, []byte) {
 					}
 					continue
 				}
+				if i.filter != nil {
+					decision, value := i.applyFilter(&i.key, i.value, origSnapshotIdx)
+					switch decision {
+					case CompactionFilterRemove:
+						if i.closeValueCloser() != nil {
+							return nil, nil
+						}
+						// The iterator has already advanced past the merged keys, so
+						// the tombstone may only be elided if the stripe is exhausted.
+						if origSnapshotIdx == 0 && change == newStripe && i.elideTombstone(i.key.UserKey) {
+							i.valid = false
+							continue
+						}
+						i.key.SetKind(InternalKeyKindDelete)
+						i.value = nil
+						return &i.key, i.value
+					case CompactionFilterChangeValue:
+						i.value = value
+					}
+				}
 				// A non-skippable entry does not necessarily cover later merge
 				// operands, so we must not zero the current merge result's seqnum.
 				//
@@ -433,6 +491,64 @@ func (i *compactionIter) closeValueCloser() error {
 	return i.err
 }
 
+// applyFilter applies the compaction filter to the newest SET or MERGE of the
+// snapshot stripe with index snapshotIdx. The decision for a key that is
+// visible to a snapshot is always CompactionFilterKeep.
+func (i *compactionIter) applyFilter(
+	key *InternalKey, value []byte, snapshotIdx int,
+) (CompactionFilterDecision, []byte) {
+	info := CompactionFilterKeyInfo{
+		Kind:     key.Kind(),
+		SeqNum:   key.SeqNum(),
+		Snapshot: InternalKeySeqNumMax,
+	}
+	if info.Kind == InternalKeyKindSetWithDelete || info.Kind == InternalKeyKindBlobSet {
+		info.Kind = InternalKeyKindSet
+	}
+	if snapshotIdx < len(i.snapshots) {
+		info.Snapshot = i.snapshots[snapshotIdx]
+	}
+	decision, newValue := i.filter.Filter(key.UserKey, value, info)
+	if info.VisibleToSnapshot() {
+		return CompactionFilterKeep, nil
+	}
+	return decision, newValue
+}
+
+// readValue returns the value of a key of the given kind, reading it from its
+// blob file if the key is a BLOBSET. The value read from a blob file is only
+// valid until the next call to readValue.
+func (i *compactionIter) readValue(kind InternalKeyKind, value []byte) ([]byte, error) {
+	if kind != InternalKeyKindBlobSet {
+		return value, nil
+	}
+	if i.blobs == nil {
+		return nil, base.CorruptionErrorf("pebble: unexpected BLOBSET key")
+	}
+	value, err := i.blobs.read(value, i.blobBuf)
+	if err != nil {
+		return nil, err
+	}
+	i.blobBuf = value
+	return value, nil
+}
+
+// removeFiltered removes the saved key, which was removed by the compaction
+// filter, by replacing it with a point tombstone that shadows older versions
+// of the key. It returns false if the tombstone can be elided instead, in
+// which case the remaining keys of the stripe are skipped.
+func (i *compactionIter) removeFiltered() bool {
+	if i.curSnapshotIdx == 0 && i.elideTombstone(i.key.UserKey) {
+		i.skipInStripe()
+		return false
+	}
+	i.key.SetKind(InternalKeyKindDelete)
+	i.value = nil
+	i.valid = true
+	i.skip = true
+	return true
+}
+
 // snapshotIndex returns the index of the first sequence number in snapshots
 // which is greater than or equal to seq.
 func snapshotIndex(seq uint64, snapshots []uint64) (int, uint64) {
@@ -533,9 +649,11 @@ func (i *compactionIter) setNext() {
 	// There are two cases where we can early return and skip the remaining
 	// records in the stripe:
 	// - If the DB does not SETWITHDEL.
-	// - If this key is already a SETWITHDEL.
+	// - If this key is already a SETWITHDEL, or a BLOBSET, which is always
+	//   treated as if it met with a DEL.
 	if i.formatVersion < FormatSetWithDelete ||
-		i.iterKey.Kind() == InternalKeyKindSetWithDelete {
+		i.iterKey.Kind() == InternalKeyKindSetWithDelete ||
+		i.iterKey.Kind() == InternalKeyKindBlobSet {
 		i.skip = true
 		return
 	}
@@ -642,7 +760,7 @@ func (i *compactionIter) mergeNext(valueMerger ValueMerger) stripeChangeType {
 			i.skip = true
 			return sameStripeSkippable
 
-		case InternalKeyKindSet, InternalKeyKindSetWithDelete:
+		case InternalKeyKindSet, InternalKeyKindSetWithDelete, InternalKeyKindBlobSet:
 			if i.rangeDelFrag.Covers(*key, i.curSnapshotSeqNum) {
 				// We change the kind of the result key to a Set so that it shadows
 				// keys in lower levels. That is, MERGE+RANGEDEL -> SET. This isn't
@@ -657,7 +775,11 @@ func (i *compactionIter) mergeNext(valueMerger ValueMerger) stripeChangeType {
 			// value and return. We change the kind of the resulting key to a
 			// Set so that it shadows keys in lower levels. That is:
 			// MERGE + (SET*) -> SET.
-			i.err = valueMerger.MergeOlder(i.iterValue)
+			var value []byte
+			value, i.err = i.readValue(key.Kind(), i.iterValue)
+			if i.err == nil {
+				i.err = valueMerger.MergeOlder(value)
+			}
 			if i.err != nil {
 				i.valid = false
 				return sameStripeSkippable
@@ -708,8 +830,9 @@ func (i *compactionIter) singleDeleteNext() bool {
 
 		key := i.iterKey
 		switch key.Kind() {
-		case InternalKeyKindDelete, InternalKeyKindMerge, InternalKeyKindSetWithDelete:
-			// We've hit a Delete, Merge or SetWithDelete, transform the
+		case InternalKeyKindDelete, InternalKeyKindMerge, InternalKeyKindSetWithDelete,
+			InternalKeyKindBlobSet:
+			// We've hit a Delete, Merge, SetWithDelete or BlobSet, transform the
 			// SingleDelete into a full Delete.
 			i.key.SetKind(InternalKeyKindDelete)
 			i.skip = true
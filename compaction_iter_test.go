@@ -17,6 +17,7 @@ import (
 	"github.com/cockroachdb/pebble/internal/datadriven"
 	"github.com/cockroachdb/pebble/internal/keyspan"
 	"github.com/cockroachdb/pebble/internal/rangekey"
+	"github.com/cockroachdb/pebble/vfs"
 )
 
 func TestSnapshotIndex(t *testing.T) {
@@ -81,7 +82,13 @@ func TestCompactionIter(t *testing.T) {
 	var snapshots []uint64
 	var elideTombstones bool
 	var allowZeroSeqnum bool
+	var filterDecisions map[string]CompactionFilterDecision
 	var interleavingIter *keyspan.InterleavingIter
+	// The values of BLOBSET keys are written to blob files in blobFS.
+	blobFS := vfs.NewMem()
+	blobs := newBlobFileCache(blobFS, "")
+	defer blobs.close()
+	var blobFileNum FileNum
 
 	// The input to the data-driven test is dependent on the format major
 	// version we are testing against.
@@ -130,6 +137,16 @@ func TestCompactionIter(t *testing.T) {
 			func(_, _ []byte) bool {
 				return elideTombstones
 			},
+			CompactionFilterFunc(func(
+				key, value []byte, _ CompactionFilterKeyInfo,
+			) (CompactionFilterDecision, []byte) {
+				decision := filterDecisions[string(key)]
+				if decision == CompactionFilterChangeValue {
+					return decision, append([]byte("changed-"), value...)
+				}
+				return decision, nil
+			}),
+			blobs,
 			formatVersion,
 		)
 	}
@@ -146,10 +163,33 @@ func TestCompactionIter(t *testing.T) {
 				keys = keys[:0]
 				vals = vals[:0]
 				rangeKeys = rangeKeys[:0]
+				var bw *blobWriter
 				for _, key := range strings.Split(d.Input, "\n") {
 					j := strings.Index(key, ":")
-					keys = append(keys, base.ParseInternalKey(key[:j]))
-					vals = append(vals, []byte(key[j+1:]))
+					ikey := base.ParseInternalKey(key[:j])
+					value := []byte(key[j+1:])
+					if ikey.Kind() == InternalKeyKindBlobSet {
+						if bw == nil {
+							blobFileNum++
+							f, err := blobFS.Create(base.MakeFilename(fileTypeBlob, blobFileNum))
+							if err != nil {
+								return err.Error()
+							}
+							bw = newBlobWriter(blobFileNum, f)
+						}
+						handle, err := bw.add(value)
+						if err != nil {
+							return err.Error()
+						}
+						value = append([]byte(nil), handle...)
+					}
+					keys = append(keys, ikey)
+					vals = append(vals, value)
+				}
+				if bw != nil {
+					if err := bw.close(); err != nil {
+						return err.Error()
+					}
 				}
 				return ""
 
@@ -164,6 +204,7 @@ func TestCompactionIter(t *testing.T) {
 				snapshots = snapshots[:0]
 				elideTombstones = false
 				allowZeroSeqnum = false
+				filterDecisions = nil
 				for _, arg := range d.CmdArgs {
 					switch arg.Key {
 					case "snapshots":
@@ -186,6 +227,17 @@ func TestCompactionIter(t *testing.T) {
 						if err != nil {
 							return err.Error()
 						}
+					case "filter-remove", "filter-change-value":
+						if filterDecisions == nil {
+							filterDecisions = make(map[string]CompactionFilterDecision)
+						}
+						decision := CompactionFilterRemove
+						if arg.Key == "filter-change-value" {
+							decision = CompactionFilterChangeValue
+						}
+						for _, val := range arg.Vals {
+							filterDecisions[val] = decision
+						}
 					default:
 						return fmt.Sprintf("%s: unknown arg: %s", d.Cmd, arg.Key)
 					}
@@ -231,7 +283,13 @@ func TestCompactionIter(t *testing.T) {
 					default:
 						return fmt.Sprintf("unknown op: %s", parts[0])
 					}
-					if iter.Valid() {
+					if iter.Valid() && iter.Key().Kind() == InternalKeyKindBlobSet {
+						value, err := blobs.read(iter.Value(), nil)
+						if err != nil {
+							return err.Error()
+						}
+						fmt.Fprintf(&b, "%s:blob(%s)\n", iter.Key(), value)
+					} else if iter.Valid() {
 						fmt.Fprintf(&b, "%s:%s\n", iter.Key(), iter.Value())
 						if iter.Key().Kind() == InternalKeyKindRangeDelete {
 							iter.rangeDelFrag.Add(keyspan.Span{
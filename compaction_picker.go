@@ -26,6 +26,10 @@ type compactionEnv struct {
 	earliestSnapshotSeqNum  uint64
 	inProgressCompactions   []compactionInfo
 	readCompactionEnv       readCompactionEnv
+	// periodicCompactionCutoff is the creation time, in seconds since the
+	// epoch, before which files are stale. It is zero if
+	// Options.PeriodicCompactionPeriod is not set.
+	periodicCompactionCutoff int64
 }
 
 type compactionPicker interface {
@@ -549,6 +553,9 @@ func newCompactionPicker(
 	levelSizes [numLevels]int64,
 	diskAvailBytes func() uint64,
 ) compactionPicker {
+	if opts.CompactionStyle == CompactionStyleUniversal {
+		return newCompactionPickerUniversal(v, opts, inProgressCompactions, levelSizes, diskAvailBytes)
+	}
 	p := &compactionPickerByScore{
 		opts:           opts,
 		vers:           v,
@@ -1351,7 +1358,10 @@ func (p *compactionPickerByScore) pickElisionOnlyCompaction(
 // rewrites a file marked for compaction. pickRewriteCompaction will
 // pull in adjacent files in the file's atomic compaction unit if
 // necessary. A rewrite compaction outputs files to the same level as
-// the input level.
+// the input level, unless the file was marked because it is stale (see
+// Options.PeriodicCompactionPeriod) and is not in the bottommost level. Such
+// a file is compacted into the next level, so that its tombstones keep moving
+// down the LSM until they are elided.
 func (p *compactionPickerByScore) pickRewriteCompaction(env compactionEnv) (pc *pickedCompaction) {
 	for l := numLevels - 1; l >= 0; l-- {
 		v := p.vers.Levels[l].Annotation(markedForCompactionAnnotator{})
@@ -1364,6 +1374,13 @@ func (p *compactionPickerByScore) pickRewriteCompaction(env compactionEnv) (pc *
 			// Try the next level.
 			continue
 		}
+		if l < numLevels-1 && candidate.CreationTime != 0 &&
+			candidate.CreationTime < env.periodicCompactionCutoff {
+			if pc = p.pickStaleFileCompaction(env, l, candidate); pc != nil {
+				return pc
+			}
+			continue
+		}
 		lf := p.vers.Levels[l].Find(p.opts.Comparer.Compare, candidate)
 		if lf == nil {
 			panic(fmt.Sprintf("file %s not found in level %d as expected", candidate.FileNum, numLevels-1))
@@ -1410,6 +1427,27 @@ func (p *compactionPickerByScore) pickRewriteCompaction(env compactionEnv) (pc *
 	return nil
 }
 
+// pickStaleFileCompaction constructs a compaction of a stale file marked for
+// compaction into the next level. Like a manual compaction, it pulls in all
+// the files in the file's level that overlap it.
+func (p *compactionPickerByScore) pickStaleFileCompaction(
+	env compactionEnv, level int, f *fileMetadata,
+) (pc *pickedCompaction) {
+	cmp := p.opts.Comparer.Compare
+	pc = newPickedCompaction(p.opts, p.vers, level, defaultOutputLevel(level, p.baseLevel), p.baseLevel)
+	pc.kind = compactionKindRewrite
+	pc.startLevel.files = p.vers.Overlaps(level, cmp, f.Smallest.UserKey,
+		f.Largest.UserKey, f.Largest.IsExclusiveSentinel())
+	if !pc.setupInputs(p.opts, p.diskAvailBytes(), pc.startLevel) {
+		return nil
+	}
+	// Fail-safe to protect against compacting the same sstable concurrently.
+	if inputRangeAlreadyCompacting(env, pc) {
+		return nil
+	}
+	return pc
+}
+
 // pickAutoLPositive picks an automatic compaction for the candidate
 // file in a positive-numbered level. This function must not be used for
 // L0.
@@ -1249,6 +1249,127 @@ func TestCompactionOutputFileSize(t *testing.T) {
 	})
 }
 
+func TestCompactionPickerUniversal(t *testing.T) {
+	opts := (*Options)(nil).EnsureDefaults()
+	opts.CompactionStyle = CompactionStyleUniversal
+
+	parseMeta := func(s string) (*fileMetadata, error) {
+		parts := strings.Split(s, ":")
+		fileNum, err := strconv.Atoi(parts[0])
+		if err != nil {
+			return nil, err
+		}
+		fields := strings.Fields(parts[1])
+		parts = strings.Split(fields[0], "-")
+		if len(parts) != 2 {
+			return nil, errors.Errorf("malformed table spec: %s", s)
+		}
+		m := (&fileMetadata{
+			FileNum: base.FileNum(fileNum),
+			Size:    1,
+		}).ExtendPointKeyBounds(
+			opts.Comparer.Compare,
+			base.ParseInternalKey(strings.TrimSpace(parts[0])),
+			base.ParseInternalKey(strings.TrimSpace(parts[1])),
+		)
+		for _, p := range fields[1:] {
+			switch {
+			case strings.HasPrefix(p, "size="):
+				v, err := strconv.Atoi(strings.TrimPrefix(p, "size="))
+				if err != nil {
+					return nil, err
+				}
+				m.Size = uint64(v)
+			case p == "compacting":
+				m.Compacting = true
+			default:
+				return nil, errors.Errorf("unknown table field: %s", p)
+			}
+		}
+		m.SmallestSeqNum = m.Smallest.SeqNum()
+		m.LargestSeqNum = m.Largest.SeqNum()
+		return m, nil
+	}
+
+	var picker *compactionPickerUniversal
+	datadriven.RunTest(t, "testdata/compaction_picker_universal", func(td *datadriven.TestData) string {
+		switch td.Cmd {
+		case "define":
+			fileMetas := [manifest.NumLevels][]*fileMetadata{}
+			level := 0
+			for _, data := range strings.Split(td.Input, "\n") {
+				data = strings.TrimSpace(data)
+				switch data {
+				case "L0", "L1", "L2", "L3", "L4", "L5", "L6":
+					level, _ = strconv.Atoi(data[1:])
+				default:
+					meta, err := parseMeta(data)
+					if err != nil {
+						return err.Error()
+					}
+					fileMetas[level] = append(fileMetas[level], meta)
+				}
+			}
+
+			version := newVersion(opts, fileMetas)
+			var sizes [numLevels]int64
+			for l := 0; l < len(sizes); l++ {
+				version.Levels[l].Slice().Each(func(m *fileMetadata) {
+					sizes[l] += int64(m.Size)
+				})
+			}
+			picker = newCompactionPicker(version, opts, nil, sizes, diskAvailBytesInf).(*compactionPickerUniversal)
+
+			var buf bytes.Buffer
+			for _, r := range picker.runs {
+				fmt.Fprintf(&buf, "L%d: count=%d size=%d", r.level, r.count, r.size)
+				if r.compacting {
+					fmt.Fprintf(&buf, " compacting")
+				}
+				fmt.Fprintln(&buf)
+			}
+			fmt.Fprintf(&buf, "debt: %d\n", picker.estimatedCompactionDebt(0))
+			return buf.String()
+
+		case "pick-auto":
+			for _, arg := range td.CmdArgs {
+				v, err := strconv.Atoi(arg.Vals[0])
+				if err != nil {
+					return err.Error()
+				}
+				switch arg.Key {
+				case "l0_compaction_threshold":
+					opts.L0CompactionThreshold = v
+				case "size_ratio":
+					opts.UniversalCompaction.SizeRatio = v
+				case "min_merge_width":
+					opts.UniversalCompaction.MinMergeWidth = v
+				case "max_merge_width":
+					opts.UniversalCompaction.MaxMergeWidth = v
+				case "max_size_amplification_percent":
+					opts.UniversalCompaction.MaxSizeAmplificationPercent = v
+				default:
+					return fmt.Sprintf("unknown argument: %s", arg.Key)
+				}
+			}
+
+			pc := picker.pickAuto(compactionEnv{earliestUnflushedSeqNum: math.MaxUint64})
+			if pc == nil {
+				return "nil"
+			}
+			var buf bytes.Buffer
+			fmt.Fprintf(&buf, "L%d -> L%d\n", pc.startLevel.level, pc.outputLevel.level)
+			for _, cl := range pc.inputs {
+				if !cl.files.Empty() {
+					fmt.Fprintf(&buf, "L%d: %s\n", cl.level, fileNums(cl.files))
+				}
+			}
+			return buf.String()
+		}
+		return fmt.Sprintf("unrecognized command: %s", td.Cmd)
+	})
+}
+
 func fileNums(files manifest.LevelSlice) string {
 	var ss []string
 	files.Each(func(f *fileMetadata) {
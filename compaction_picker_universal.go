@@ -0,0 +1,293 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"math"
+
+	"github.com/cockroachdb/pebble/internal/manifest"
+)
+
+// sortedRun describes a sorted run considered by the universal compaction
+// picker. All of L0 forms a single sortedRun whose count is the number of L0
+// sublevels, since L0 is always compacted as a whole. Every non-empty level
+// of L1-L6 is a sortedRun with a count of one.
+type sortedRun struct {
+	level int
+	count int
+	size  uint64
+	// compacting is true if any of the run's files is being compacted.
+	compacting bool
+}
+
+// compactionPickerUniversal picks compactions for the universal compaction
+// style. The sorted runs are ordered from newest (L0) to oldest (the
+// bottommost non-empty level), and a compaction merges a window of adjacent
+// sorted runs into the level just above the next older sorted run, or into
+// the bottommost level if there is none. Since a sorted run only ever
+// contains keys that are newer than those of the older sorted runs, the
+// outputs are never moved above data that is newer than them.
+//
+// Manual, elision-only, and rewrite compactions, as well as the base level
+// used by flushes and ingestions, are inherited from compactionPickerByScore.
+type compactionPickerUniversal struct {
+	compactionPickerByScore
+
+	runs []sortedRun
+	// numRuns is the sum of the counts of the sorted runs.
+	numRuns int
+}
+
+var _ compactionPicker = &compactionPickerUniversal{}
+
+func newCompactionPickerUniversal(
+	v *version,
+	opts *Options,
+	inProgressCompactions []compactionInfo,
+	levelSizes [numLevels]int64,
+	diskAvailBytes func() uint64,
+) *compactionPickerUniversal {
+	p := &compactionPickerUniversal{
+		compactionPickerByScore: compactionPickerByScore{
+			opts:           opts,
+			vers:           v,
+			levelSizes:     levelSizes,
+			diskAvailBytes: diskAvailBytes,
+		},
+	}
+	p.initLevelMaxBytes(inProgressCompactions)
+	p.initSortedRuns()
+	return p
+}
+
+func (p *compactionPickerUniversal) initSortedRuns() {
+	for level := 0; level < numLevels; level++ {
+		lm := p.vers.Levels[level]
+		if lm.Empty() {
+			continue
+		}
+		r := sortedRun{level: level, count: 1}
+		if level == 0 {
+			r.count = len(p.vers.L0Sublevels.Levels)
+		}
+		iter := lm.Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			r.size += f.Size
+			r.compacting = r.compacting || f.Compacting
+		}
+		p.runs = append(p.runs, r)
+		p.numRuns += r.count
+	}
+}
+
+// getScores returns the number of sorted runs relative to
+// Options.L0CompactionThreshold as the score of L0. A universal compaction is
+// picked once this score reaches one.
+func (p *compactionPickerUniversal) getScores(inProgress []compactionInfo) [numLevels]float64 {
+	var scores [numLevels]float64
+	scores[0] = float64(p.numRuns) / float64(p.opts.L0CompactionThreshold)
+	return scores
+}
+
+// estimatedCompactionDebt estimates the number of bytes which need to be
+// compacted to bring the number of sorted runs below
+// Options.L0CompactionThreshold, by merging the newest sorted runs. Any L0
+// bytes that are not yet flushed count as an additional sorted run.
+func (p *compactionPickerUniversal) estimatedCompactionDebt(l0ExtraSize uint64) uint64 {
+	if p == nil {
+		return 0
+	}
+	numRuns := p.numRuns
+	if l0ExtraSize > 0 {
+		numRuns++
+	}
+	if numRuns < p.opts.L0CompactionThreshold {
+		return 0
+	}
+	debt := l0ExtraSize
+	width := 0
+	if l0ExtraSize > 0 {
+		width++
+	}
+	for _, r := range p.runs {
+		if width >= numRuns-p.opts.L0CompactionThreshold+2 {
+			break
+		}
+		debt += r.size
+		width += r.count
+	}
+	return debt
+}
+
+// pickAuto picks a compaction of adjacent sorted runs, if the number of
+// sorted runs has reached Options.L0CompactionThreshold. Like RocksDB, it
+// considers, in order:
+//
+//  1. A compaction of all sorted runs, if the size of all sorted runs but the
+//     oldest exceeds UniversalCompactionOptions.MaxSizeAmplificationPercent
+//     of the oldest sorted run.
+//  2. A compaction of sorted runs of similar size. Starting with the newest
+//     sorted run, a window is extended by the next older sorted run as long
+//     as that sorted run is at most UniversalCompactionOptions.SizeRatio
+//     percent larger than the sum of the window.
+//  3. A compaction of the newest sorted runs that brings the number of sorted
+//     runs below Options.L0CompactionThreshold.
+//
+// If no such compaction can be picked, pickAuto falls back to elision-only
+// and rewrite compactions like compactionPickerByScore.
+func (p *compactionPickerUniversal) pickAuto(env compactionEnv) (pc *pickedCompaction) {
+	if p.numRuns >= p.opts.L0CompactionThreshold {
+		if pc = p.pickSizeAmp(env); pc != nil {
+			return pc
+		}
+		if pc = p.pickSizeRatio(env); pc != nil {
+			return pc
+		}
+		if pc = p.pickRunCount(env); pc != nil {
+			return pc
+		}
+	}
+
+	if pc := p.pickElisionOnlyCompaction(env); pc != nil {
+		return pc
+	}
+	if p.vers.Stats.MarkedForCompaction > 0 {
+		if pc := p.pickRewriteCompaction(env); pc != nil {
+			return pc
+		}
+	}
+	return nil
+}
+
+func (p *compactionPickerUniversal) maxMergeWidth() int {
+	if w := p.opts.UniversalCompaction.MaxMergeWidth; w > 0 {
+		return w
+	}
+	return math.MaxInt32
+}
+
+func (p *compactionPickerUniversal) pickSizeAmp(env compactionEnv) *pickedCompaction {
+	n := len(p.runs)
+	if n < 2 {
+		return nil
+	}
+	var newerSize uint64
+	for i := range p.runs {
+		if p.runs[i].compacting {
+			return nil
+		}
+		if i < n-1 {
+			newerSize += p.runs[i].size
+		}
+	}
+	maxPercent := uint64(p.opts.UniversalCompaction.MaxSizeAmplificationPercent)
+	if newerSize*100 < p.runs[n-1].size*maxPercent {
+		return nil
+	}
+	return p.pickRuns(env, 0, n-1)
+}
+
+func (p *compactionPickerUniversal) pickSizeRatio(env compactionEnv) *pickedCompaction {
+	ratio := uint64(100 + p.opts.UniversalCompaction.SizeRatio)
+	maxWidth := p.maxMergeWidth()
+	for i := 0; i < len(p.runs); i++ {
+		if p.runs[i].compacting {
+			continue
+		}
+		size, width := p.runs[i].size, p.runs[i].count
+		j := i
+		for ; j+1 < len(p.runs); j++ {
+			next := &p.runs[j+1]
+			if next.compacting || width+next.count > maxWidth || next.size*100 > size*ratio {
+				break
+			}
+			size += next.size
+			width += next.count
+		}
+		if width < p.opts.UniversalCompaction.MinMergeWidth {
+			continue
+		}
+		if pc := p.pickRuns(env, i, j); pc != nil {
+			return pc
+		}
+	}
+	return nil
+}
+
+func (p *compactionPickerUniversal) pickRunCount(env compactionEnv) *pickedCompaction {
+	target := p.numRuns - p.opts.L0CompactionThreshold + 2
+	if maxWidth := p.maxMergeWidth(); target > maxWidth {
+		target = maxWidth
+	}
+	width := 0
+	for j := range p.runs {
+		if p.runs[j].compacting {
+			return nil
+		}
+		width += p.runs[j].count
+		if width >= target {
+			return p.pickRuns(env, 0, j)
+		}
+	}
+	return nil
+}
+
+// pickRuns returns a compaction of the sorted runs i through j, which must
+// not be compacting. The compaction outputs to the level above the next
+// older sorted run. If that level is L0, the next older sorted run is added
+// to the compaction as well.
+func (p *compactionPickerUniversal) pickRuns(env compactionEnv, i, j int) *pickedCompaction {
+	outputLevel := numLevels - 1
+	for ; j+1 < len(p.runs); j++ {
+		outputLevel = p.runs[j+1].level - 1
+		if outputLevel > 0 {
+			break
+		}
+		if p.runs[j+1].compacting {
+			return nil
+		}
+		outputLevel = numLevels - 1
+	}
+	if i == j && p.runs[i].count < 2 {
+		// Merging a single sorted run with itself is unproductive.
+		return nil
+	}
+
+	// Levels between the start level and the base level must be empty, which
+	// is not the case for the levels of the universal compaction style.
+	// Adjust the base level so that the output level determines the target
+	// output file size as if it were the base level.
+	baseLevel := p.baseLevel
+	if outputLevel < baseLevel {
+		baseLevel = outputLevel
+	}
+	pc := newPickedCompaction(p.opts, p.vers, p.runs[i].level, outputLevel, baseLevel)
+	pc.inputs = pc.inputs[:0]
+	iters := make([]manifest.LevelIterator, 0, j-i+1)
+	for k := i; k <= j; k++ {
+		files := p.vers.Levels[p.runs[k].level].Slice()
+		pc.inputs = append(pc.inputs, compactionLevel{level: p.runs[k].level, files: files})
+		iters = append(iters, files.Iter())
+	}
+	if p.runs[j].level != outputLevel {
+		pc.inputs = append(pc.inputs, compactionLevel{level: outputLevel})
+	}
+	pc.startLevel = &pc.inputs[0]
+	pc.outputLevel = &pc.inputs[len(pc.inputs)-1]
+	for k := 1; k < len(pc.inputs)-1; k++ {
+		pc.extraLevels = append(pc.extraLevels, &pc.inputs[k])
+	}
+	if pc.startLevel.level == 0 {
+		pc.l0SublevelInfo = generateSublevelInfo(pc.cmp, pc.startLevel.files)
+	}
+	pc.smallest, pc.largest = manifest.KeyRange(pc.cmp, iters...)
+	pc.score = float64(p.numRuns) / float64(p.opts.L0CompactionThreshold)
+	// Fail-safe to protect against compacting the same sstable concurrently,
+	// and against outputting to the same key range of a level concurrently.
+	if inputRangeAlreadyCompacting(env, pc) {
+		return nil
+	}
+	return pc
+}
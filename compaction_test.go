@@ -6,6 +6,7 @@ package pebble
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"math"
 	"math/rand"
@@ -3772,3 +3773,849 @@ func TestCompaction_LogAndApplyFails(t *testing.T) {
 		})
 	}
 }
+
+func TestSubcompactionBounds(t *testing.T) {
+	cmp := DefaultComparer.Compare
+	var c *compaction
+
+	datadriven.RunTest(t, "testdata/subcompaction_bounds",
+		func(d *datadriven.TestData) string {
+			switch d.Cmd {
+			case "define":
+				// Each section begins with a level (eg, "L5") naming an input
+				// level, or with "grandparents". Each table is specified as
+				// <smallest>-<largest> <size>.
+				var levels []int
+				var files [][]*fileMetadata
+				var grandparents []*fileMetadata
+				var cur *[]*fileMetadata
+				var fileNum FileNum
+				for _, line := range strings.Split(d.Input, "\n") {
+					line = strings.TrimSpace(line)
+					if line == "grandparents" {
+						cur = &grandparents
+						continue
+					}
+					if strings.HasPrefix(line, "L") {
+						level, err := strconv.Atoi(line[1:])
+						if err != nil {
+							return err.Error()
+						}
+						levels = append(levels, level)
+						files = append(files, nil)
+						cur = &files[len(files)-1]
+						continue
+					}
+					fields := strings.Fields(line)
+					if len(fields) != 2 || cur == nil {
+						return fmt.Sprintf("malformed table spec: %s", line)
+					}
+					bounds := strings.Split(fields[0], "-")
+					if len(bounds) != 2 {
+						return fmt.Sprintf("malformed table spec: %s", line)
+					}
+					size, err := strconv.ParseUint(fields[1], 10, 64)
+					if err != nil {
+						return err.Error()
+					}
+					m := (&fileMetadata{}).ExtendPointKeyBounds(
+						cmp, base.ParseInternalKey(bounds[0]), base.ParseInternalKey(bounds[1]))
+					m.FileNum = fileNum
+					m.Size = size
+					fileNum++
+					*cur = append(*cur, m)
+				}
+
+				c = &compaction{
+					kind:   compactionKindDefault,
+					cmp:    cmp,
+					equal:  DefaultComparer.Equal,
+					inputs: make([]compactionLevel, len(levels)),
+				}
+				for i, level := range levels {
+					c.inputs[i].level = level
+					if level == 0 {
+						c.inputs[i].files = manifest.NewLevelSliceSeqSorted(files[i])
+					} else {
+						c.inputs[i].files = manifest.NewLevelSliceKeySorted(cmp, files[i])
+					}
+				}
+				c.startLevel, c.outputLevel = &c.inputs[0], &c.inputs[len(c.inputs)-1]
+				c.grandparents = manifest.NewLevelSliceKeySorted(cmp, grandparents)
+				return ""
+
+			case "split":
+				var n int
+				d.ScanArgs(t, "n", &n)
+				var inputSize uint64
+				for _, cl := range c.inputs {
+					inputSize += cl.files.SizeSum()
+				}
+				bounds := c.subcompactionBounds(n, inputSize)
+				var lower []byte
+				var buf bytes.Buffer
+				for i := 0; i <= len(bounds); i++ {
+					var upper []byte
+					if i < len(bounds) {
+						upper = bounds[i]
+					}
+					sub := c.subcompaction(lower, upper)
+					fmt.Fprintf(&buf, "%s-%s:", sub.smallest.UserKey, sub.largest.UserKey)
+					for _, cl := range sub.inputs {
+						iter := cl.files.Iter()
+						for f := iter.First(); f != nil; f = iter.Next() {
+							fmt.Fprintf(&buf, " L%d:%s", cl.level, f.FileNum)
+						}
+					}
+					buf.WriteString("\n")
+					lower = upper
+				}
+				return buf.String()
+
+			default:
+				return fmt.Sprintf("unknown command: %s", d.Cmd)
+			}
+		})
+}
+
+func TestSubcompactions(t *testing.T) {
+	mem := vfs.NewMem()
+	var mu sync.Mutex
+	var created []TableCreateInfo
+	var ended []CompactionInfo
+	opts := &Options{
+		FS:                          mem,
+		DisableAutomaticCompactions: true,
+		Levels:                      make([]LevelOptions, numLevels),
+		MaxConcurrentCompactions:    4,
+		MaxSubcompactions:           4,
+		EventListener: EventListener{
+			TableCreated: func(info TableCreateInfo) {
+				mu.Lock()
+				defer mu.Unlock()
+				created = append(created, info)
+			},
+			CompactionEnd: func(info CompactionInfo) {
+				mu.Lock()
+				defer mu.Unlock()
+				ended = append(ended, info)
+			},
+		},
+	}
+	for i := range opts.Levels {
+		opts.Levels[i].TargetFileSize = 1 << 10
+	}
+	opts.testingRandomized()
+	d, err := Open("", opts)
+	require.NoError(t, err)
+
+	// Write several generations of four disjoint key ranges to L0, flushing
+	// each range separately. The last generation deletes some of the keys.
+	const numKeys = 2000
+	const numRanges = 4
+	key := func(i int) []byte { return []byte(fmt.Sprintf("key%05d", i)) }
+	for gen := 0; gen < 3; gen++ {
+		for r := 0; r < numRanges; r++ {
+			b := d.NewBatch()
+			for i := r * numKeys / numRanges; i < (r+1)*numKeys/numRanges; i++ {
+				if gen == 2 && i%3 == 0 {
+					require.NoError(t, b.Delete(key(i), nil))
+					continue
+				}
+				require.NoError(t, b.Set(key(i), []byte(fmt.Sprintf("%d-%d", gen, i)), nil))
+			}
+			require.NoError(t, b.Commit(nil))
+			require.NoError(t, d.Flush())
+		}
+	}
+
+	// The compaction of L0 into L6 is split at the boundaries of the ranges,
+	// unless it is a manual compaction.
+	d.mu.Lock()
+	d.mu.compact.compactingCount++
+	c := newCompaction(&pickedCompaction{
+		cmp:               d.cmp,
+		version:           d.mu.versions.currentVersion(),
+		inputs:            []compactionLevel{{level: 0}, {level: 6}},
+		maxOutputFileSize: 1 << 10,
+		maxOverlapBytes:   math.MaxUint64,
+	}, d.opts)
+	c.inputs[0].files = c.version.Levels[0].Slice()
+	c.smallest, c.largest = manifest.KeyRange(d.cmp, c.inputs[0].files.Iter())
+	c.isManual = true
+	require.Equal(t, 1, len(d.splitCompactionLocked(c)))
+	c.isManual = false
+	subcompactions := d.splitCompactionLocked(c)
+	require.Equal(t, numRanges, len(subcompactions))
+	for i, sub := range subcompactions {
+		require.Equal(t, string(key(i*numKeys/numRanges)), string(sub.smallest.UserKey))
+	}
+
+	// Run the compaction. No output spans the bound between two
+	// sub-compactions, and the outputs of all of them are installed by a
+	// single version edit.
+	mu.Lock()
+	created, ended = nil, nil
+	mu.Unlock()
+	d.addInProgressCompaction(c)
+	require.NoError(t, d.compact1(c, nil))
+	d.mu.compact.compactingCount--
+	d.mu.Unlock()
+
+	mu.Lock()
+	require.Equal(t, 1, len(ended))
+	require.Equal(t, len(created), len(ended[0].Output.Tables))
+	for _, info := range created {
+		require.Equal(t, ended[0].JobID, info.JobID)
+	}
+	for _, table := range ended[0].Output.Tables {
+		for _, sub := range subcompactions[1:] {
+			bound := sub.smallest.UserKey
+			require.False(t, d.cmp(table.Smallest.UserKey, bound) < 0 && d.cmp(table.Largest.UserKey, bound) >= 0,
+				"%s spans %s", table.FileNum, bound)
+		}
+	}
+	mu.Unlock()
+	require.NoError(t, d.CheckLevels(nil))
+	require.Equal(t, int64(0), d.Metrics().Levels[0].NumFiles)
+
+	for i := 0; i < numKeys; i++ {
+		v, closer, err := d.Get(key(i))
+		if i%3 == 0 {
+			require.ErrorIs(t, err, ErrNotFound)
+			continue
+		}
+		require.NoError(t, err)
+		require.Equal(t, fmt.Sprintf("2-%d", i), string(v))
+		require.NoError(t, closer.Close())
+	}
+	require.NoError(t, d.Close())
+}
+
+func TestUniversalCompaction(t *testing.T) {
+	mem := vfs.NewMem()
+	opts := &Options{
+		FS:                    mem,
+		CompactionStyle:       CompactionStyleUniversal,
+		L0CompactionThreshold: 4,
+		L0StopWritesThreshold: 100,
+	}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+
+	// Overwrite the same keys in every generation, flushing each generation to
+	// L0 and waiting for the resulting compactions.
+	const numKeys = 500
+	const numGens = 20
+	key := func(i int) []byte { return []byte(fmt.Sprintf("key%05d", i)) }
+	for gen := 0; gen < numGens; gen++ {
+		b := d.NewBatch()
+		for i := 0; i < numKeys; i++ {
+			require.NoError(t, b.Set(key(i), []byte(fmt.Sprintf("%d-%d", gen, i)), nil))
+		}
+		require.NoError(t, b.Commit(nil))
+		require.NoError(t, d.Flush())
+
+		d.mu.Lock()
+		for d.mu.compact.compactingCount > 0 {
+			d.mu.compact.cond.Wait()
+		}
+		// The compactions keep the number of sorted runs below
+		// L0CompactionThreshold.
+		p := d.mu.versions.picker.(*compactionPickerUniversal)
+		require.Less(t, p.numRuns, opts.L0CompactionThreshold)
+		d.mu.Unlock()
+	}
+	require.NoError(t, d.CheckLevels(nil))
+	require.Less(t, int64(0), d.Metrics().Compact.DefaultCount)
+
+	for i := 0; i < numKeys; i++ {
+		v, closer, err := d.Get(key(i))
+		require.NoError(t, err)
+		require.Equal(t, fmt.Sprintf("%d-%d", numGens-1, i), string(v))
+		require.NoError(t, closer.Close())
+	}
+	require.NoError(t, d.Close())
+}
+
+func TestTTL(t *testing.T) {
+	// The values of the test's keys are the Unix times at which they were
+	// written.
+	mem := vfs.NewMem()
+	opts := &Options{
+		FS:  mem,
+		TTL: time.Hour,
+		KeyExpired: func(userKey, value []byte, cutoff time.Time) bool {
+			written, err := strconv.ParseInt(string(value), 10, 64)
+			require.NoError(t, err)
+			return written < cutoff.Unix()
+		},
+	}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+
+	var offset int64
+	d.mu.Lock()
+	d.timeNow = func() time.Time {
+		return time.Now().Add(time.Duration(atomic.LoadInt64(&offset)))
+	}
+	d.mu.Unlock()
+	// Tables are created at the current time, regardless of d.timeNow.
+	setOffset := func(dur time.Duration) {
+		atomic.StoreInt64(&offset, int64(dur))
+	}
+	set := func(key string, written time.Time) {
+		require.NoError(t, d.Set([]byte(key), []byte(strconv.FormatInt(written.Unix(), 10)), nil))
+	}
+	expire := func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		d.maybeScheduleCompaction()
+		for d.mu.compact.compactingCount > 0 {
+			d.mu.compact.cond.Wait()
+		}
+	}
+	numFiles := func() (n int64) {
+		m := d.Metrics()
+		for _, l := range m.Levels {
+			n += l.NumFiles
+		}
+		return n
+	}
+	requireFound := func(key string, found bool) {
+		_, closer, err := d.Get([]byte(key))
+		if !found {
+			require.ErrorIs(t, err, ErrNotFound)
+			return
+		}
+		require.NoError(t, err)
+		require.NoError(t, closer.Close())
+	}
+
+	// Keys that have already expired are dropped when they are flushed.
+	set("a", d.timeNow().Add(-2*time.Hour))
+	set("b", d.timeNow())
+	require.NoError(t, d.Flush())
+	requireFound("a", false)
+	requireFound("b", true)
+	require.Equal(t, int64(1), numFiles())
+
+	// Tables are not dropped before they expire.
+	setOffset(30 * time.Minute)
+	expire()
+	require.Equal(t, int64(1), numFiles())
+
+	// Once a table has expired, it is dropped as a whole.
+	setOffset(90 * time.Minute)
+	expire()
+	require.Equal(t, int64(0), numFiles())
+	require.Equal(t, int64(1), d.Metrics().Compact.DeleteOnlyCount)
+	requireFound("b", false)
+
+	// A table is not dropped while a snapshot can read its keys.
+	setOffset(0)
+	set("c", d.timeNow())
+	require.NoError(t, d.Flush())
+	s := d.NewSnapshot()
+	setOffset(2 * time.Hour)
+	expire()
+	require.Equal(t, int64(1), numFiles())
+	require.NoError(t, s.Close())
+	expire()
+	require.Equal(t, int64(0), numFiles())
+
+	// An expired table is not dropped while a younger table below it holds a
+	// key it deletes. Creation times are set directly, as a table below
+	// another one is normally older.
+	setOffset(0)
+	set("d", d.timeNow())
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Compact([]byte("d"), []byte("e"), false))
+	require.NoError(t, d.Delete([]byte("d"), nil))
+	require.NoError(t, d.Flush())
+	d.mu.Lock()
+	v := d.mu.versions.currentVersion()
+	require.Equal(t, 1, v.Levels[0].Len())
+	require.Equal(t, 1, v.Levels[numLevels-1].Len())
+	l0Iter, l6Iter := v.Levels[0].Iter(), v.Levels[numLevels-1].Iter()
+	l0Iter.First().CreationTime = d.timeNow().Add(-2 * time.Hour).Unix()
+	l6Iter.First().CreationTime = d.timeNow().Unix()
+	d.mu.Unlock()
+	expire()
+	require.Equal(t, int64(2), numFiles())
+	requireFound("d", false)
+	// Once the table below has expired as well, both are dropped.
+	setOffset(2 * time.Hour)
+	expire()
+	require.Equal(t, int64(0), numFiles())
+	requireFound("d", false)
+
+	require.NoError(t, d.Close())
+}
+
+func TestPeriodicCompaction(t *testing.T) {
+	opts := &Options{
+		FS:                       vfs.NewMem(),
+		PeriodicCompactionPeriod: time.Hour,
+	}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+
+	var offset int64
+	d.mu.Lock()
+	d.timeNow = func() time.Time {
+		return time.Now().Add(time.Duration(atomic.LoadInt64(&offset)))
+	}
+	d.mu.Unlock()
+	compact := func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		d.maybeScheduleCompaction()
+		for d.mu.compact.compactingCount > 0 {
+			d.mu.compact.cond.Wait()
+		}
+	}
+
+	// Write a and b to the bottommost level, and then a tombstone for a to
+	// L0, which is not compacted on its own.
+	require.NoError(t, d.Set([]byte("a"), []byte("a"), nil))
+	require.NoError(t, d.Set([]byte("b"), []byte("b"), nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Compact([]byte("a"), []byte("z"), true))
+	require.NoError(t, d.Delete([]byte("a"), nil))
+	require.NoError(t, d.Flush())
+	compact()
+	m := d.Metrics()
+	require.Equal(t, int64(1), m.Levels[0].NumFiles)
+	require.Equal(t, int64(0), m.Compact.RewriteCount)
+
+	// Once the tables are stale, the tombstone is compacted into the
+	// bottommost level, where both it and the deleted key are dropped.
+	atomic.StoreInt64(&offset, int64(2*time.Hour))
+	compact()
+	m = d.Metrics()
+	require.Equal(t, int64(0), m.Levels[0].NumFiles)
+	require.Equal(t, int64(1), m.Levels[numLevels-1].NumFiles)
+	require.Less(t, int64(0), m.Compact.RewriteCount)
+	d.mu.Lock()
+	iter := d.mu.versions.currentVersion().Levels[numLevels-1].Iter()
+	require.Equal(t, "b", string(iter.First().Smallest.UserKey))
+	d.mu.Unlock()
+
+	require.NoError(t, d.Close())
+}
+
+func TestPauseCompactions(t *testing.T) {
+	opts := &Options{
+		FS:                    vfs.NewMem(),
+		L0CompactionThreshold: 2,
+	}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	waitForCompactions := func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		for d.mu.compact.compactingCount > 0 {
+			d.mu.compact.cond.Wait()
+		}
+	}
+
+	// Flushes continue while compactions are paused.
+	d.PauseCompactions()
+	d.PauseCompactions()
+	for _, k := range []string{"a", "b", "c"} {
+		require.NoError(t, d.Set([]byte(k), nil, nil))
+		require.NoError(t, d.Flush())
+	}
+	waitForCompactions()
+	require.Equal(t, int64(3), d.Metrics().Levels[0].NumFiles)
+	// Manual compactions fail while compactions are paused.
+	require.ErrorIs(t, d.Compact([]byte("a"), []byte("d"), false), ErrCancelledCompaction)
+	require.Equal(t, int64(3), d.Metrics().Levels[0].NumFiles)
+
+	// Compactions only resume once every pause has been resumed.
+	d.ResumeCompactions()
+	waitForCompactions()
+	require.Equal(t, int64(3), d.Metrics().Levels[0].NumFiles)
+	d.ResumeCompactions()
+	waitForCompactions()
+	require.Equal(t, int64(0), d.Metrics().Levels[0].NumFiles)
+	require.Panics(t, d.ResumeCompactions)
+
+	require.NoError(t, d.Close())
+}
+
+func TestCompactWithContextCancel(t *testing.T) {
+	mem := vfs.NewMem()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var once sync.Once
+	opts := &Options{
+		FS:                          mem,
+		DisableAutomaticCompactions: true,
+		EventListener: EventListener{
+			TableCreated: func(info TableCreateInfo) {
+				if info.Reason == "compacting" {
+					once.Do(func() {
+						close(started)
+						<-release
+					})
+				}
+			},
+		},
+	}
+	// Every key is written to its own output table.
+	opts.Levels = make([]LevelOptions, numLevels)
+	for i := range opts.Levels {
+		opts.Levels[i].TargetFileSize = 1
+	}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+
+	for _, k := range []string{"a", "b", "c", "d"} {
+		require.NoError(t, d.Set([]byte(k), []byte(k), nil))
+	}
+	require.NoError(t, d.Flush())
+	numL0Files := d.Metrics().Levels[0].NumFiles
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() { errCh <- d.CompactWithContext(ctx, []byte("a"), []byte("z"), false) }()
+	<-started
+	cancel()
+	require.ErrorIs(t, <-errCh, context.Canceled)
+	close(release)
+
+	// The compaction stops at its next key, and its outputs are removed.
+	d.mu.Lock()
+	for d.mu.compact.compactingCount > 0 {
+		d.mu.compact.cond.Wait()
+	}
+	d.mu.Unlock()
+	m := d.Metrics()
+	require.Equal(t, numL0Files, m.Levels[0].NumFiles)
+	require.Equal(t, int64(0), m.Levels[numLevels-1].NumFiles)
+	ls, err := mem.List("")
+	require.NoError(t, err)
+	var tables int
+	for _, name := range ls {
+		if ft, _, ok := base.ParseFilename(mem, name); ok && ft == fileTypeTable {
+			tables++
+		}
+	}
+	require.Equal(t, numL0Files, int64(tables))
+
+	require.NoError(t, d.Close())
+}
+
+func TestCompactionCancelWithinOutput(t *testing.T) {
+	mem := vfs.NewMem()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var once sync.Once
+	// The filter blocks the compaction in the middle of its only output.
+	filter := CompactionFilterFunc(func(
+		key, value []byte, info CompactionFilterKeyInfo,
+	) (CompactionFilterDecision, []byte) {
+		if string(key) == "b" {
+			once.Do(func() {
+				close(started)
+				<-release
+			})
+		}
+		return CompactionFilterKeep, nil
+	})
+	opts := &Options{
+		FS:                          mem,
+		DisableAutomaticCompactions: true,
+		CompactionFilter: func(ctx CompactionFilterContext) CompactionFilter {
+			if ctx.Flush {
+				return nil
+			}
+			return filter
+		},
+	}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+
+	// The second, overlapping, table prevents a move of the first.
+	for _, k := range []string{"a", "b", "c", "d"} {
+		require.NoError(t, d.Set([]byte(k), []byte(k), nil))
+	}
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Set([]byte("c"), []byte("c"), nil))
+	require.NoError(t, d.Flush())
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- d.Compact([]byte("a"), []byte("z"), false) }()
+	<-started
+	pauseCh := make(chan struct{})
+	go func() {
+		d.PauseCompactions()
+		close(pauseCh)
+	}()
+	// Wait for the compaction to be cancelled before it continues.
+	for {
+		d.mu.Lock()
+		paused := d.mu.compact.paused > 0
+		d.mu.Unlock()
+		if paused {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	<-pauseCh
+	require.ErrorIs(t, <-errCh, ErrCancelledCompaction)
+
+	// The compaction stops at the next key, and its partial output is
+	// removed.
+	m := d.Metrics()
+	require.Equal(t, int64(2), m.Levels[0].NumFiles)
+	require.Equal(t, int64(0), m.Levels[numLevels-1].NumFiles)
+	ls, err := mem.List("")
+	require.NoError(t, err)
+	var tables int
+	for _, name := range ls {
+		if ft, _, ok := base.ParseFilename(mem, name); ok && ft == fileTypeTable {
+			tables++
+		}
+	}
+	require.Equal(t, 2, tables)
+
+	d.ResumeCompactions()
+	require.NoError(t, d.Close())
+}
+
+func TestCompactionFilter(t *testing.T) {
+	var mu sync.Mutex
+	var contexts []CompactionFilterContext
+	filter := CompactionFilterFunc(func(
+		key, value []byte, info CompactionFilterKeyInfo,
+	) (CompactionFilterDecision, []byte) {
+		switch string(value) {
+		case "remove":
+			return CompactionFilterRemove, nil
+		case "change":
+			return CompactionFilterChangeValue, []byte("changed")
+		}
+		return CompactionFilterKeep, nil
+	})
+	opts := &Options{
+		FS: vfs.NewMem(),
+		CompactionFilter: func(ctx CompactionFilterContext) CompactionFilter {
+			mu.Lock()
+			defer mu.Unlock()
+			contexts = append(contexts, ctx)
+			return filter
+		},
+	}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+
+	get := func(r Reader, key string) string {
+		v, closer, err := r.Get([]byte(key))
+		if errors.Is(err, ErrNotFound) {
+			return "<not found>"
+		}
+		require.NoError(t, err)
+		defer closer.Close()
+		return string(v)
+	}
+
+	require.NoError(t, d.Set([]byte("a"), []byte("remove"), nil))
+	require.NoError(t, d.Set([]byte("b"), []byte("change"), nil))
+	require.NoError(t, d.Set([]byte("c"), []byte("keep"), nil))
+	require.NoError(t, d.Flush())
+	require.Equal(t, "<not found>", get(d, "a"))
+	require.Equal(t, "changed", get(d, "b"))
+	require.Equal(t, "keep", get(d, "c"))
+
+	// Keys that are visible to a snapshot are not filtered. The compactions
+	// rewrite the table containing a-d, since the new keys overlap it.
+	require.NoError(t, d.Set([]byte("d"), []byte("remove"), nil))
+	s := d.NewSnapshot()
+	require.NoError(t, d.Set([]byte("b"), []byte("keep"), nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Compact([]byte("a"), []byte("z"), true))
+	require.Equal(t, "remove", get(s, "d"))
+	require.Equal(t, "remove", get(d, "d"))
+	require.NoError(t, s.Close())
+	require.NoError(t, d.Set([]byte("c"), []byte("keep"), nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Compact([]byte("a"), []byte("z"), true))
+	require.Equal(t, "<not found>", get(d, "d"))
+
+	mu.Lock()
+	require.Contains(t, contexts, CompactionFilterContext{OutputLevel: 0, Flush: true})
+	require.Contains(t, contexts, CompactionFilterContext{OutputLevel: numLevels - 1})
+	mu.Unlock()
+	require.NoError(t, d.Close())
+}
+
+func TestBlobFiles(t *testing.T) {
+	mem := vfs.NewMem()
+	opts := &Options{
+		FS:                 mem,
+		FormatMajorVersion: FormatBlobFiles,
+		MinBlobSize:        16,
+	}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+
+	numBlobFiles := func() int {
+		ls, err := mem.List("")
+		require.NoError(t, err)
+		var n int
+		for _, name := range ls {
+			if ft, _, ok := base.ParseFilename(mem, name); ok && ft == fileTypeBlob {
+				n++
+			}
+		}
+		return n
+	}
+	get := func(key string) string {
+		v, closer, err := d.Get([]byte(key))
+		if errors.Is(err, ErrNotFound) {
+			return "<not found>"
+		}
+		require.NoError(t, err)
+		defer closer.Close()
+		return string(v)
+	}
+	scan := func(reverse bool) string {
+		iter := d.NewIter(nil)
+		var buf strings.Builder
+		if reverse {
+			for valid := iter.Last(); valid; valid = iter.Prev() {
+				fmt.Fprintf(&buf, "%s:%s ", iter.Key(), iter.Value())
+			}
+		} else {
+			for valid := iter.First(); valid; valid = iter.Next() {
+				fmt.Fprintf(&buf, "%s:%s ", iter.Key(), iter.Value())
+			}
+		}
+		require.NoError(t, iter.Close())
+		return strings.TrimSpace(buf.String())
+	}
+
+	large := func(s string) string { return strings.Repeat(s, 16) }
+	require.NoError(t, d.Set([]byte("a"), []byte(large("a")), nil))
+	require.NoError(t, d.Set([]byte("b"), []byte("small"), nil))
+	require.NoError(t, d.Set([]byte("c"), []byte(large("c")), nil))
+	require.NoError(t, d.Flush())
+	require.Equal(t, 1, numBlobFiles())
+	require.Equal(t, large("a"), get("a"))
+	require.Equal(t, "small", get("b"))
+
+	// Merges over a value in a blob file read the value.
+	require.NoError(t, d.Merge([]byte("c"), []byte("+"), nil))
+	require.Equal(t, large("c")+"+", get("c"))
+	expected := fmt.Sprintf("a:%s b:small c:%s+", large("a"), large("c"))
+	require.Equal(t, expected, scan(false))
+	expected = fmt.Sprintf("c:%s+ b:small a:%s", large("c"), large("a"))
+	require.Equal(t, expected, scan(true))
+
+	// Compactions keep referencing the blob file.
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Compact([]byte("a"), []byte("z"), true))
+	require.Equal(t, 1, numBlobFiles())
+	require.Equal(t, large("a"), get("a"))
+	require.Equal(t, large("c")+"+", get("c"))
+	require.NoError(t, d.CheckLevels(nil))
+
+	// The references are rebuilt when the DB is reopened.
+	require.NoError(t, d.Close())
+	d, err = Open("", opts)
+	require.NoError(t, err)
+	require.Equal(t, large("a"), get("a"))
+
+	// Once no table references the blob file, it is deleted.
+	require.NoError(t, d.Set([]byte("a"), []byte("small"), nil))
+	require.NoError(t, d.Delete([]byte("c"), nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Compact([]byte("a"), []byte("z"), true))
+	require.Equal(t, 0, numBlobFiles())
+	require.Equal(t, "small", get("a"))
+	require.Equal(t, "<not found>", get("c"))
+	require.NoError(t, d.Close())
+}
+
+// TestBlobFilesSharedPlacement tests that sstables referencing blob files are
+// kept local even if the placement policy places them in shared storage, and
+// that compactions into shared storage read the separated values back into
+// their outputs.
+func TestBlobFilesSharedPlacement(t *testing.T) {
+	const uniqueID = 17
+	mem := vfs.NewMem()
+	sharedFS := vfs.NewMem()
+	for i := 0; i < 10; i++ {
+		require.NoError(t, sharedFS.MkdirAll(fmt.Sprintf("%d/%d", uniqueID, i), 0755))
+	}
+	var mu sync.Mutex
+	var placed []TablePlaceInfo
+	d, err := Open("", &Options{
+		FS:                 mem,
+		SharedFS:           sharedFS,
+		UniqueID:           uniqueID,
+		FormatMajorVersion: FormatBlobFiles,
+		MinBlobSize:        16,
+		PlacementPolicy:    LevelPlacementPolicy{MinSharedLevel: 0},
+		EventListener: EventListener{
+			TablePlaced: func(info TablePlaceInfo) {
+				mu.Lock()
+				defer mu.Unlock()
+				placed = append(placed, info)
+			},
+		},
+	})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+
+	large := strings.Repeat("a", 16)
+	require.NoError(t, d.Set([]byte("a"), []byte(large), nil))
+	require.NoError(t, d.Flush())
+
+	// The flushed table references a blob file, so it stays local.
+	tables := func(level int) []*fileMetadata {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		var files []*fileMetadata
+		iter := d.mu.versions.currentVersion().Levels[level].Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			files = append(files, f)
+		}
+		return files
+	}
+	l0 := tables(0)
+	require.Len(t, l0, 1)
+	require.False(t, l0[0].IsShared)
+	require.Len(t, l0[0].BlobFiles, 1)
+	mu.Lock()
+	require.Equal(t, PlacementLocal, placed[len(placed)-1].Placement)
+	mu.Unlock()
+
+	// The compaction into L6 places its output in shared storage, with the
+	// values read back from the blob files, which are then obsolete. A second,
+	// overlapping table ensures the compaction is not a move.
+	require.NoError(t, d.Set([]byte("b"), []byte(large), nil))
+	require.NoError(t, d.Set([]byte("a"), []byte(large), nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Compact([]byte("a"), []byte("c"), false))
+	l6 := tables(numLevels - 1)
+	require.Len(t, l6, 1)
+	require.True(t, l6[0].IsShared)
+	require.Empty(t, l6[0].BlobFiles)
+	ls, err := mem.List("")
+	require.NoError(t, err)
+	for _, name := range ls {
+		ft, _, ok := base.ParseFilename(mem, name)
+		require.False(t, ok && ft == fileTypeBlob, "blob file %s", name)
+	}
+	v, closer, err := d.Get([]byte("a"))
+	require.NoError(t, err)
+	require.Equal(t, large, string(v))
+	require.NoError(t, closer.Close())
+}
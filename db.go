@@ -6,8 +6,10 @@
 package pebble // import "github.com/cockroachdb/pebble"
 
 import (
+	"context"
 	"fmt"
 	"io"
+	"math"
 	"os"
 	"sync"
 	"sync/atomic"
@@ -45,6 +47,10 @@ var (
 	// ErrReadOnly is returned when a write operation is performed on a read-only
 	// database.
 	ErrReadOnly = errors.New("pebble: read-only")
+	// ErrCancelledCompaction is the error of a compaction that was cancelled by
+	// DB.PauseCompactions, or by the cancellation of the context passed to
+	// DB.CompactWithContext.
+	ErrCancelledCompaction = errors.New("pebble: compaction cancelled")
 	// errNoSplit indicates that the user is trying to perform a range key
 	// operation but the configured Comparer does not provide a Split
 	// implementation.
@@ -225,6 +231,10 @@ type DB struct {
 
 		// The number of bytes available on disk.
 		diskAvailBytes uint64
+
+		// The number of bytes retained by iterators configured with
+		// IterOptions.PinKeysAndValues.
+		pinnedIterBytes int64
 	}
 
 	cacheID        uint64
@@ -239,10 +249,13 @@ type DB struct {
 	// The threshold for determining when a batch is "large" and will skip being
 	// inserted into a memtable.
 	largeBatchThreshold int
-	// The current OPTIONS file number.
+	// The current OPTIONS file number. Protected by mu once the DB is open,
+	// since SetOptions may write a new OPTIONS file.
 	optionsFileNum FileNum
-	// The on-disk size of the current OPTIONS file.
+	// The on-disk size of the current OPTIONS file. Protected by mu.
 	optionsFileSize uint64
+	// setOptionsMu serializes calls to SetOptions.
+	setOptionsMu sync.Mutex
 
 	fileLock io.Closer
 	dataDir  vfs.File
@@ -251,6 +264,8 @@ type DB struct {
 	tableCache           *tableCacheContainer
 	newIters             tableNewIters
 	tableNewRangeKeyIter keyspan.TableNewSpanIter
+	// blobs reads the values of BLOBSET keys from blob files.
+	blobs *blobFileCache
 
 	commit *commitPipeline
 
@@ -270,6 +285,9 @@ type DB struct {
 	closedCh chan struct{}
 
 	deletionLimiter limiter
+	// writeLimiter paces flushes, compactions and uploads to shared storage.
+	// It is nil if Options.Experimental.BackgroundWriteRate is 0.
+	writeLimiter *writeLimiter
 
 	// Async deletion jobs spawned by cleaners increment this WaitGroup, and
 	// call Done when completed. Once `d.mu.cleaning` is false, the db.Close()
@@ -399,6 +417,12 @@ type DB struct {
 			// The idle start time for the flush "loop", i.e., when the flushing
 			// bool above transitions to false.
 			noOngoingFlushStartTime time.Time
+			// The time at which stale files are next marked for compaction. See
+			// Options.PeriodicCompactionPeriod.
+			nextStaleFileCheck time.Time
+			// Non-zero when compactions are paused. The paused count acts as a
+			// reference count. See DB.{Pause,Resume}Compactions().
+			paused int
 		}
 
 		cleaner struct {
@@ -447,6 +471,16 @@ type DB struct {
 			// validating is set to true when validation is running.
 			validating bool
 		}
+
+		sharedWarmup struct {
+			// cond is a condition variable used to signal the completion of a
+			// job to warm up shared sstables.
+			cond sync.Cond
+			// pending is a slice of shared sstables waiting to be warmed up.
+			pending []newFileEntry
+			// warming is set to true when a warm-up is running.
+			warming bool
+		}
 	}
 
 	// Normally equal to time.Now() but may be overridden in tests.
@@ -498,20 +532,51 @@ func (d *DB) getInternal(key []byte, b *Batch, s *Snapshot) ([]byte, io.Closer,
 		seqNum = atomic.LoadUint64(&d.mu.versions.atomic.visibleSeqNum)
 	}
 
+	i := d.newGetIterator(key, b, readState, seqNum, nil /* newLevelIters */)
+	if !i.First() {
+		err := i.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, ErrNotFound
+	}
+	value := i.Value()
+	if err := i.Error(); err != nil {
+		_ = i.Close()
+		return nil, nil, err
+	}
+	return value, i, nil
+}
+
+// newGetIterator returns an Iterator over the values of key visible at
+// seqNum in the batch (if non-nil) and the memtables and sstables of the
+// readState. If newLevelIters is non-nil, it is called before each sstable
+// level is read (see getIter.newLevelIters). The returned Iterator takes over
+// the caller's reference to readState.
+func (d *DB) newGetIterator(
+	key []byte,
+	b *Batch,
+	readState *readState,
+	seqNum uint64,
+	newLevelIters func(depth int, files manifest.LevelSlice) tableNewIters,
+) *Iterator {
 	buf := getIterAllocPool.Get().(*getIterAlloc)
 
 	get := &buf.get
 	*get = getIter{
-		logger:   d.opts.Logger,
-		cmp:      d.cmp,
-		equal:    d.equal,
-		newIters: d.newIters,
-		snapshot: seqNum,
-		key:      key,
-		batch:    b,
-		mem:      readState.memtables,
-		l0:       readState.current.L0SublevelFiles,
-		version:  readState.current,
+		logger:        d.opts.Logger,
+		cmp:           d.cmp,
+		equal:         d.equal,
+		split:         d.split,
+		filterMetrics: d.tableCache.dbOpts.filterMetrics,
+		newIters:      d.newIters,
+		newLevelIters: newLevelIters,
+		snapshot:      seqNum,
+		key:           key,
+		batch:         b,
+		mem:           readState.memtables,
+		l0:            readState.current.L0SublevelFiles,
+		version:       readState.current,
 	}
 
 	// Strip off memtables which cannot possibly contain the seqNum being read
@@ -536,16 +601,9 @@ func (d *DB) getInternal(key []byte, b *Batch, s *Snapshot) ([]byte, io.Closer,
 		split:        d.split,
 		readState:    readState,
 		keyBuf:       buf.keyBuf,
+		blobs:        d.blobs,
 	}
-
-	if !i.First() {
-		err := i.Close()
-		if err != nil {
-			return nil, nil, err
-		}
-		return nil, nil, ErrNotFound
-	}
-	return i.Value(), i, nil
+	return i
 }
 
 // Set sets the value for the given key. It overwrites any previous value
@@ -884,6 +942,9 @@ func (d *DB) newIterInternal(batch *Batch, s *Snapshot, o *IterOptions) *Iterato
 		// DB.mem.queue[0].logSeqNum.
 		panic("OnlyReadGuaranteedDurable is not supported for batches or snapshots")
 	}
+	if o != nil && o.Tailing && (batch != nil || s != nil || o.KeyTypes != IterKeyTypePointsOnly) {
+		panic("pebble: Tailing is only supported for point key iterators over the DB")
+	}
 	// Grab and reference the current readState. This prevents the underlying
 	// files in the associated version from being deleted if there is a current
 	// compaction. The readState is unref'd by Iterator.Close().
@@ -915,6 +976,7 @@ func (d *DB) newIterInternal(batch *Batch, s *Snapshot, o *IterOptions) *Iterato
 		batch:               batch,
 		newIters:            d.newIters,
 		newIterRangeKey:     d.tableNewRangeKeyIter,
+		blobs:               d.blobs,
 		seqNum:              seqNum,
 	}
 	if o != nil {
@@ -941,12 +1003,19 @@ func finishInitializingIter(buf *iterAlloc) *Iterator {
 	} else {
 		// We only need to read from memtables which contain sequence numbers older
 		// than seqNum. Trim off newer memtables.
-		for i := len(memtables) - 1; i >= 0; i-- {
-			if logSeqNum := memtables[i].logSeqNum; logSeqNum < dbi.seqNum {
-				break
-			}
-			memtables = memtables[:i]
+		memtables = visibleMemtables(memtables, dbi.seqNum)
+	}
+	if dbi.opts.Tailing {
+		dbi.tailing.memtables = len(memtables)
+		dbi.tailing.spans = memtableSpans(memtables)
+	}
+	dbi.opts.pinnedBlocks = nil
+	if dbi.opts.PinKeysAndValues {
+		if dbi.pinned == nil {
+			dbi.pinned = &iterPinnedState{}
+			dbi.pinned.init(&dbi.readState.db.atomic.pinnedIterBytes)
 		}
+		dbi.opts.pinnedBlocks = &dbi.pinned.blocks
 	}
 
 	if dbi.opts.pointKeys() {
@@ -1219,6 +1288,9 @@ func (d *DB) Close() error {
 	for d.mu.tableValidation.validating {
 		d.mu.tableValidation.cond.Wait()
 	}
+	for d.mu.sharedWarmup.warming {
+		d.mu.sharedWarmup.cond.Wait()
+	}
 
 	if d.persistentCache != nil {
 		d.persistentCache.Close()
@@ -1230,6 +1302,7 @@ func (d *DB) Close() error {
 	}
 	err = firstError(err, d.mu.formatVers.marker.Close())
 	err = firstError(err, d.tableCache.close())
+	err = firstError(err, d.blobs.close())
 	if !d.opts.ReadOnly {
 		err = firstError(err, d.mu.log.Close())
 	} else if d.mu.log.LogWriter != nil {
@@ -1292,8 +1365,16 @@ func (d *DB) Close() error {
 	return err
 }
 
-// Compact the specified range of keys in the database.
+// Compact the specified range of keys in the database. It fails with
+// ErrCancelledCompaction if compactions are paused by PauseCompactions.
 func (d *DB) Compact(start, end []byte, parallelize bool) error {
+	return d.CompactWithContext(context.Background(), start, end, parallelize)
+}
+
+// CompactWithContext is like Compact, but returns the context's error as soon
+// as ctx is done. The manual compactions of the range that have not started
+// yet are then dropped, and those that are running are cancelled.
+func (d *DB) CompactWithContext(ctx context.Context, start, end []byte, parallelize bool) error {
 	if err := d.closed.Load(); err != nil {
 		panic(err)
 	}
@@ -1357,12 +1438,16 @@ func (d *DB) Compact(start, end []byte, parallelize bool) error {
 		return err
 	}
 	if mem != nil {
-		<-mem.flushed
+		select {
+		case <-mem.flushed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	}
 
 	for level := 0; level < maxLevelWithFiles; {
 		if err := d.manualCompact(
-			iStart.UserKey, iEnd.UserKey, level, parallelize); err != nil {
+			ctx, iStart.UserKey, iEnd.UserKey, level, parallelize); err != nil {
 			return err
 		}
 		level++
@@ -1375,8 +1460,14 @@ func (d *DB) Compact(start, end []byte, parallelize bool) error {
 	return nil
 }
 
-func (d *DB) manualCompact(start, end []byte, level int, parallelize bool) error {
+func (d *DB) manualCompact(
+	ctx context.Context, start, end []byte, level int, parallelize bool,
+) error {
 	d.mu.Lock()
+	if d.mu.compact.paused > 0 {
+		d.mu.Unlock()
+		return ErrCancelledCompaction
+	}
 	curr := d.mu.versions.currentVersion()
 	files := curr.Overlaps(level, d.cmp, start, end, false)
 	if files.Empty() {
@@ -1407,13 +1498,82 @@ func (d *DB) manualCompact(start, end []byte, level int, parallelize bool) error
 	// necessary to read from each channel, and so we can exit early in the event
 	// of an error.
 	for _, compaction := range compactions {
-		if err := <-compaction.done; err != nil {
-			return err
+		select {
+		case err := <-compaction.done:
+			if err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			d.cancelManualCompactions(compactions)
+			return ctx.Err()
 		}
 	}
 	return nil
 }
 
+// cancelManualCompactions drops the given manual compactions if they have not
+// been scheduled yet, and cancels them if they are running.
+func (d *DB) cancelManualCompactions(compactions []*manualCompaction) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, m := range compactions {
+		if m.c != nil {
+			m.c.cancel.cancel()
+			continue
+		}
+		for i := range d.mu.compact.manual {
+			if d.mu.compact.manual[i] == m {
+				d.mu.compact.manual = append(d.mu.compact.manual[:i], d.mu.compact.manual[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// PauseCompactions stops the DB from starting compactions, and cancels the
+// running compactions, including those run by Options.CompactionExecutor. It
+// returns once they have stopped. Flushes are not paused. Manual compactions
+// that are cancelled, that have not started yet, or that are requested while
+// compactions are paused fail with ErrCancelledCompaction.
+//
+// Calls to PauseCompactions nest: compactions resume once ResumeCompactions
+// has been called as many times as PauseCompactions.
+func (d *DB) PauseCompactions() {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.mu.compact.paused++
+	for c := range d.mu.compact.inProgress {
+		if c.cancel != nil {
+			c.cancel.cancel()
+		}
+	}
+	// Manual compactions that have not been scheduled yet fail.
+	for _, m := range d.mu.compact.manual {
+		m.done <- ErrCancelledCompaction
+	}
+	d.mu.compact.manual = nil
+	for d.mu.compact.compactingCount > 0 {
+		d.mu.compact.cond.Wait()
+	}
+}
+
+// ResumeCompactions resumes the compactions paused by PauseCompactions.
+func (d *DB) ResumeCompactions() {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.mu.compact.paused <= 0 {
+		panic("pebble: ResumeCompactions called without PauseCompactions")
+	}
+	d.mu.compact.paused--
+	d.maybeScheduleCompaction()
+}
+
 // splitManualCompaction splits a manual compaction over [start,end] on level
 // such that the resulting compactions have no key overlap.
 func (d *DB) splitManualCompaction(
@@ -1553,6 +1713,7 @@ func (d *DB) Metrics() *Metrics {
 	metrics.BlockCache = d.opts.Cache.Metrics()
 	metrics.TableCache, metrics.Filter = d.tableCache.metrics()
 	metrics.TableIters = int64(d.tableCache.iterCount())
+	metrics.PinnedIterBytes = atomic.LoadInt64(&d.atomic.pinnedIterBytes)
 	return metrics
 }
 
@@ -1687,6 +1848,209 @@ func (d *DB) EstimateDiskUsage(start, end []byte) (uint64, error) {
 	return totalSize, nil
 }
 
+// SpanStats holds approximate statistics about the keys within a span of the
+// DB. See EstimateSpanStats.
+type SpanStats struct {
+	// NumEntries is the number of point keys and range deletions, including
+	// every version of each key.
+	NumEntries uint64
+	// NumDeletions is the number of point deletions and range deletions.
+	NumDeletions uint64
+	// RawValueSize is the total size of the values of the point keys. Values
+	// separated into blob files are counted by their size in the blob files.
+	RawValueSize uint64
+}
+
+func (s *SpanStats) add(o SpanStats) {
+	s.NumEntries += o.NumEntries
+	s.NumDeletions += o.NumDeletions
+	s.RawValueSize += o.RawValueSize
+}
+
+// EstimateSpanStats returns approximate statistics about the keys within the
+// range `[start, end]`, including keys that are shadowed or deleted. The
+// estimation is computed as follows:
+//
+// - For sstables fully contained in the range, the NumEntries, NumDeletions
+//   and RawValueSize properties of the table are included.
+// - For sstables partially contained in the range, the properties are
+//   prorated by the fraction of the table's data blocks overlapping the range,
+//   as determined by the index blocks. Shared sstables are always prorated,
+//   as their keys are restricted to their virtual bounds, which may cover a
+//   fraction of the underlying table.
+// - The keys of the memtables within the range that are visible are
+//   counted, or, for large memtables, estimated from a sample of the keys.
+func (d *DB) EstimateSpanStats(start, end []byte) (SpanStats, error) {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	if d.opts.Comparer.Compare(start, end) > 0 {
+		return SpanStats{}, errors.New("invalid key-range specified (start > end)")
+	}
+
+	// Grab and reference the current readState. This prevents the underlying
+	// files in the associated version from being deleted if there is a concurrent
+	// compaction.
+	readState := d.loadReadState()
+	defer readState.unref()
+
+	// Keys of the memtables that are not yet visible are not included.
+	seqNum := atomic.LoadUint64(&d.mu.versions.atomic.visibleSeqNum)
+	var stats SpanStats
+	for _, mem := range readState.memtables {
+		memStats, err := d.estimateMemtableSpanStats(mem, start, end, seqNum)
+		if err != nil {
+			return SpanStats{}, err
+		}
+		stats.add(memStats)
+	}
+	for level, files := range readState.current.Levels {
+		iter := files.Iter()
+		if level > 0 {
+			// As in EstimateDiskUsage, `Overlaps` can only be used to restrict
+			// `files` at L1+.
+			overlaps := readState.current.Overlaps(level, d.opts.Comparer.Compare, start, end, false /* exclusiveEnd */)
+			iter = overlaps.Iter()
+		}
+		for file := iter.First(); file != nil; file = iter.Next() {
+			fileStats, err := d.estimateTableSpanStats(file, start, end)
+			if err != nil {
+				return SpanStats{}, err
+			}
+			stats.add(fileStats)
+		}
+	}
+	return stats, nil
+}
+
+// estimateTableSpanStats returns the statistics of the keys of the table
+// within [start, end].
+func (d *DB) estimateTableSpanStats(file *fileMetadata, start, end []byte) (SpanStats, error) {
+	cmp := d.opts.Comparer.Compare
+	if cmp(file.Smallest.UserKey, end) > 0 || cmp(start, file.Largest.UserKey) > 0 {
+		return SpanStats{}, nil
+	}
+	contained := cmp(start, file.Smallest.UserKey) <= 0 && cmp(file.Largest.UserKey, end) <= 0
+	var stats SpanStats
+	err := d.tableCache.withReader(file, func(r *sstable.Reader) error {
+		props := &r.Properties
+		stats = SpanStats{
+			NumEntries:   props.NumEntries,
+			NumDeletions: props.NumDeletions,
+			RawValueSize: rawValueSize(props),
+		}
+		if contained && !file.IsShared {
+			return nil
+		}
+		if props.DataSize == 0 {
+			return nil
+		}
+		// Restrict the range to the bounds of the table, which for a shared
+		// table are its virtual bounds within the underlying table.
+		lower, upper := start, end
+		if cmp(lower, file.Smallest.UserKey) < 0 {
+			lower = file.Smallest.UserKey
+		}
+		if cmp(upper, file.Largest.UserKey) > 0 {
+			upper = file.Largest.UserKey
+		}
+		size, err := r.EstimateDiskUsage(lower, upper)
+		if err != nil {
+			return err
+		}
+		if size < props.DataSize {
+			frac := float64(size) / float64(props.DataSize)
+			stats.NumEntries = uint64(float64(stats.NumEntries) * frac)
+			stats.NumDeletions = uint64(float64(stats.NumDeletions) * frac)
+			stats.RawValueSize = uint64(float64(stats.RawValueSize) * frac)
+		}
+		return nil
+	})
+	return stats, err
+}
+
+// memTableSpanStatsSamples is the maximum number of keys of a memtable that
+// EstimateSpanStats reads.
+const memTableSpanStatsSamples = 1024
+
+// estimateMemtableSpanStats returns the statistics of the keys of the
+// memtable within [start, end] that are visible at seqNum. The keys of a
+// memTable are sampled by the heights of their skiplist nodes (see
+// arenaskl.Skiplist.Sample), while those of other flushables are counted.
+func (d *DB) estimateMemtableSpanStats(
+	mem *flushableEntry, start, end []byte, seqNum uint64,
+) (SpanStats, error) {
+	cmp := d.opts.Comparer.Compare
+	var numEntries, numDeletions, rawValueSize float64
+	var err error
+	add := func(key base.InternalKey, value []byte, weight float64) {
+		if err != nil || !key.Visible(seqNum) {
+			return
+		}
+		numEntries += weight
+		switch key.Kind() {
+		case InternalKeyKindDelete, InternalKeyKindSingleDelete:
+			numDeletions += weight
+		case InternalKeyKindBlobSet:
+			// The value is a handle to the value in a blob file.
+			var h blobHandle
+			if h, err = decodeBlobHandle(value); err != nil {
+				return
+			}
+			rawValueSize += weight * float64(h.length)
+			return
+		}
+		rawValueSize += weight * float64(len(value))
+	}
+	if m, ok := mem.flushable.(*memTable); ok {
+		m.skl.Sample(start, end, memTableSpanStatsSamples, add)
+	} else {
+		iter := mem.newIter(nil)
+		for key, value := iter.SeekGE(start, base.SeekGEFlagsNone); key != nil; key, value = iter.Next() {
+			if cmp(key.UserKey, end) > 0 {
+				break
+			}
+			add(*key, value, 1)
+		}
+		err = firstError(err, iter.Close())
+	}
+	if err != nil {
+		return SpanStats{}, err
+	}
+	stats := SpanStats{
+		NumEntries:   uint64(math.Round(numEntries)),
+		NumDeletions: uint64(math.Round(numDeletions)),
+		RawValueSize: uint64(math.Round(rawValueSize)),
+	}
+
+	rangeDelIter := mem.newRangeDelIter(nil)
+	if rangeDelIter == nil {
+		return stats, nil
+	}
+	// A range deletion is split into fragments, which hold a copy of its key.
+	// Every range deletion of a memtable has a distinct sequence number.
+	seen := make(map[uint64]struct{})
+	for span := rangeDelIter.First(); span != nil; span = rangeDelIter.Next() {
+		if cmp(span.Start, end) > 0 {
+			break
+		}
+		if cmp(span.End, start) <= 0 {
+			continue
+		}
+		for _, k := range span.Keys {
+			if !base.Visible(k.SeqNum(), seqNum) {
+				continue
+			}
+			if _, ok := seen[k.Trailer]; !ok {
+				seen[k.Trailer] = struct{}{}
+				stats.NumEntries++
+				stats.NumDeletions++
+			}
+		}
+	}
+	return stats, rangeDelIter.Close()
+}
+
 func (d *DB) walPreallocateSize() int {
 	// Set the WAL preallocate size to 110% of the memtable size. Note that there
 	// is a bit of apples and oranges in units here as the memtabls size
@@ -18,7 +18,10 @@ import (
 	"time"
 
 	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/bloom"
 	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/testkeys"
+	"github.com/cockroachdb/pebble/ribbon"
 	"github.com/cockroachdb/pebble/sstable"
 	"github.com/cockroachdb/pebble/vfs"
 	"github.com/stretchr/testify/require"
@@ -1016,6 +1019,7 @@ func TestDBClosed(t *testing.T) {
 	require.True(t, errors.Is(catch(func() { _ = d.Merge(nil, nil, nil) }), ErrClosed))
 	require.True(t, errors.Is(catch(func() { _ = d.RatchetFormatMajorVersion(FormatNewest) }), ErrClosed))
 	require.True(t, errors.Is(catch(func() { _ = d.Set(nil, nil, nil) }), ErrClosed))
+	require.True(t, errors.Is(catch(func() { _ = d.SetOptions(MutableOptions{}) }), ErrClosed))
 
 	require.True(t, errors.Is(catch(func() { _ = d.NewSnapshot() }), ErrClosed))
 
@@ -1025,6 +1029,525 @@ func TestDBClosed(t *testing.T) {
 	require.True(t, errors.Is(catch(func() { _ = b.NewIter(nil) }), ErrClosed))
 }
 
+func TestSetOptions(t *testing.T) {
+	mem := vfs.NewMem()
+	var changes []OptionsChangeInfo
+	opts := &Options{
+		FS: mem,
+		EventListener: EventListener{
+			OptionsChanged: func(info OptionsChangeInfo) {
+				changes = append(changes, info)
+			},
+		},
+	}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+
+	optionsFiles := func() []string {
+		ls, err := mem.List("")
+		require.NoError(t, err)
+		var files []string
+		for _, f := range ls {
+			if ft, _, ok := base.ParseFilename(mem, f); ok && ft == fileTypeOptions {
+				files = append(files, f)
+			}
+		}
+		return files
+	}
+	require.Len(t, optionsFiles(), 1)
+
+	m := d.MutableOptions()
+	old := m
+	m.L0CompactionThreshold = 8
+	m.L0StopWritesThreshold = 20
+	m.MaxConcurrentCompactions = 3
+	m.MinDeletionRate = 1 << 20
+	m.ReadCompactionRate = 1000
+	require.NoError(t, d.SetOptions(m))
+	require.Equal(t, m, d.MutableOptions())
+	require.Len(t, changes, 1)
+	require.NoError(t, changes[0].Err)
+	require.Equal(t, old, changes[0].Old)
+	require.Equal(t, m, changes[0].New)
+
+	// The previous OPTIONS file is replaced by one with the new values. It is
+	// deleted asynchronously, since MinDeletionRate is now non-zero.
+	d.deleters.Wait()
+	files := optionsFiles()
+	require.Equal(t, []string{mem.PathBase(changes[0].Path)}, files)
+	f, err := mem.Open(files[0])
+	require.NoError(t, err)
+	data, err := io.ReadAll(f)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	for _, s := range []string{
+		"l0_compaction_threshold=8\n",
+		"l0_stop_writes_threshold=20\n",
+		"max_concurrent_compactions=3\n",
+		"min_deletion_rate=1048576\n",
+		"read_compaction_rate=1000\n",
+	} {
+		require.Contains(t, string(data), s)
+	}
+
+	// Invalid options are rejected as a whole and leave the options unchanged.
+	invalid := m
+	invalid.MaxConcurrentCompactions = 1
+	invalid.L0StopWritesThreshold = 4
+	require.Error(t, d.SetOptions(invalid))
+	invalid = m
+	invalid.MaxConcurrentCompactions = 0
+	require.Error(t, d.SetOptions(invalid))
+	invalid = m
+	invalid.PersistentCacheSize = 1 << 30
+	require.Error(t, d.SetOptions(invalid))
+	require.Equal(t, m, d.MutableOptions())
+	require.Len(t, changes, 1)
+
+	// Setting the current options is a no-op.
+	require.NoError(t, d.SetOptions(m))
+	require.Len(t, changes, 1)
+	require.Equal(t, files, optionsFiles())
+
+	require.NoError(t, d.Set([]byte("a"), []byte("b"), nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Close())
+
+	// The new OPTIONS file must be accepted when the DB is reopened.
+	d, err = Open("", &Options{FS: mem})
+	require.NoError(t, err)
+	require.NoError(t, d.Close())
+
+	d, err = Open("", &Options{FS: mem, ReadOnly: true})
+	require.NoError(t, err)
+	require.Equal(t, ErrReadOnly, d.SetOptions(m))
+	require.NoError(t, d.Close())
+}
+
+func TestMultiGet(t *testing.T) {
+	opts := &Options{
+		FS:                    vfs.NewMem(),
+		L0CompactionThreshold: 100,
+		L0StopWritesThreshold: 100,
+	}
+	opts.Levels = make([]LevelOptions, numLevels)
+	for i := range opts.Levels {
+		opts.Levels[i] = LevelOptions{FilterPolicy: bloom.FilterPolicy(10), TargetFileSize: 4 << 10}
+	}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	rng := rand.New(rand.NewSource(uint64(time.Now().UnixNano())))
+	key := func(i int) []byte { return []byte(fmt.Sprintf("%06d", i)) }
+	const numKeys = 2000
+	// Build an LSM with keys in L6, L0 and the memtable, shadowed by point and
+	// range deletions and merges in newer levels.
+	for i := 0; i < numKeys; i += 2 {
+		require.NoError(t, d.Set(key(i), []byte(fmt.Sprintf("v%d", i)), nil))
+	}
+	require.NoError(t, d.Compact(key(0), key(numKeys), false))
+	for i := 0; i < numKeys; i += 7 {
+		require.NoError(t, d.Delete(key(i), nil))
+	}
+	require.NoError(t, d.DeleteRange(key(100), key(200), nil))
+	require.NoError(t, d.Flush())
+	snap := d.NewSnapshot()
+	defer func() { require.NoError(t, snap.Close()) }()
+	for i := 1; i < numKeys; i += 5 {
+		require.NoError(t, d.Merge(key(i), []byte("m"), nil))
+	}
+	require.NoError(t, d.DeleteRange(key(500), key(520), nil))
+
+	check := func(r Reader, multiGet func([][]byte) ([]MultiGetResult, io.Closer, error)) {
+		// Include keys deleted by range deletions in tables whose filters
+		// exclude the keys.
+		keys := [][]byte{key(102), key(150), key(510)}
+		for i := 0; i < 500; i++ {
+			keys = append(keys, key(rng.Intn(numKeys+100)))
+		}
+		results, closer, err := multiGet(keys)
+		require.NoError(t, err)
+		require.Len(t, results, len(keys))
+		for i, k := range keys {
+			v, c, err := r.Get(k)
+			if errors.Is(err, ErrNotFound) {
+				require.False(t, results[i].Found, "%s", k)
+				continue
+			}
+			require.NoError(t, err)
+			require.True(t, results[i].Found, "%s", k)
+			require.Equal(t, string(v), string(results[i].Value), "%s", k)
+			require.NoError(t, c.Close())
+		}
+		require.NoError(t, closer.Close())
+	}
+	check(d, d.MultiGet)
+	check(snap, snap.MultiGet)
+
+	// The filters of the tables in L6 must have excluded some of the keys.
+	m := d.Metrics()
+	require.Greater(t, m.Filter.Hits, int64(0))
+}
+
+func TestMultiGetStopsAtFoundLevel(t *testing.T) {
+	opts := &Options{FS: vfs.NewMem()}
+	opts.Levels = make([]LevelOptions, numLevels)
+	for i := range opts.Levels {
+		opts.Levels[i] = LevelOptions{FilterPolicy: bloom.FilterPolicy(10)}
+	}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	key := func(i int) []byte { return []byte(fmt.Sprintf("%06d", i)) }
+	const numKeys = 100
+	for i := 0; i < numKeys; i++ {
+		require.NoError(t, d.Set(key(i), []byte("old"), nil))
+	}
+	require.NoError(t, d.Compact(key(0), key(numKeys), false))
+	for i := 0; i < numKeys; i++ {
+		require.NoError(t, d.Set(key(i), []byte("new"), nil))
+	}
+	require.NoError(t, d.Flush())
+	m := d.Metrics()
+	require.Equal(t, int64(1), m.Levels[0].NumFiles)
+	require.Equal(t, int64(1), m.Levels[numLevels-1].NumFiles)
+
+	// Every key is found in L0, so only the filter of the L0 table is
+	// checked, once for each key.
+	keys := make([][]byte, numKeys)
+	for i := range keys {
+		keys[i] = key(i)
+	}
+	before := d.Metrics().Filter
+	results, closer, err := d.MultiGet(keys)
+	require.NoError(t, err)
+	for i := range results {
+		require.True(t, results[i].Found)
+		require.Equal(t, "new", string(results[i].Value))
+	}
+	require.NoError(t, closer.Close())
+	after := d.Metrics().Filter
+	require.Equal(t, int64(0), after.Hits-before.Hits)
+	require.Equal(t, int64(numKeys), after.Misses-before.Misses)
+}
+
+func TestPerLevelFilterPolicy(t *testing.T) {
+	opts := &Options{
+		Comparer:                    testkeys.Comparer,
+		DisableAutomaticCompactions: true,
+		FS:                          vfs.NewMem(),
+	}
+	opts.Levels = make([]LevelOptions, numLevels)
+	opts.Levels[0] = LevelOptions{FilterPolicy: bloom.FilterPolicy(10)}
+	for i := 1; i < numLevels; i++ {
+		opts.Levels[i] = LevelOptions{FilterPolicy: ribbon.FilterPolicy(10)}
+	}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	key := func(i int) []byte { return []byte(fmt.Sprintf("%06d", i)) }
+	const numKeys = 1000
+	check := func(level int, policyName string) {
+		tables, err := d.SSTables(WithProperties())
+		require.NoError(t, err)
+		require.NotEmpty(t, tables[level])
+		for _, table := range tables[level] {
+			require.Equal(t, policyName, table.Properties.FilterPolicyName)
+		}
+		iter := d.NewIter(&IterOptions{UseL6Filters: true})
+		defer func() { require.NoError(t, iter.Close()) }()
+		for i := 0; i < numKeys+100; i++ {
+			found := iter.SeekPrefixGE(key(i))
+			if i%2 == 1 || i >= numKeys {
+				require.False(t, found, "%s", key(i))
+				continue
+			}
+			require.True(t, found, "%s", key(i))
+			require.Equal(t, fmt.Sprintf("v%d", i), string(iter.Value()))
+		}
+	}
+
+	// Write two overlapping tables to L0, so that the compaction into L6
+	// rewrites them rather than moving them.
+	for j := 0; j < 4; j += 2 {
+		for i := j; i < numKeys; i += 4 {
+			require.NoError(t, d.Set(key(i), []byte(fmt.Sprintf("v%d", i)), nil))
+		}
+		require.NoError(t, d.Flush())
+	}
+	check(0, bloom.FilterPolicy(10).Name())
+	hits := d.Metrics().Filter.Hits
+
+	require.NoError(t, d.Compact(key(0), key(numKeys), false))
+	check(numLevels-1, ribbon.FilterPolicy(10).Name())
+	// The filters of both kinds of tables must have excluded keys.
+	require.Greater(t, hits, int64(0))
+	require.Greater(t, d.Metrics().Filter.Hits, hits)
+}
+
+func TestScanInternal(t *testing.T) {
+	const uniqueID = 17
+	mem := vfs.NewMem()
+	sharedFS := vfs.NewMem()
+	for i := 0; i < 10; i++ {
+		require.NoError(t, sharedFS.MkdirAll(fmt.Sprintf("%d/%d", uniqueID, i), 0755))
+	}
+	// Place tables containing "shared" keys in shared storage.
+	d, err := Open("", &Options{
+		FS:                 mem,
+		SharedFS:           sharedFS,
+		UniqueID:           uniqueID,
+		Comparer:           testkeys.Comparer,
+		FormatMajorVersion: FormatNewest,
+		PlacementPolicy: PlacementPolicyFunc(func(info PlacementInfo) TablePlacement {
+			if bytes.HasPrefix(info.Smallest, []byte("shared")) {
+				return PlacementShared
+			}
+			return PlacementLocal
+		}),
+	})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	f, err := mem.Create("ext")
+	require.NoError(t, err)
+	w := sstable.NewWriter(f, d.opts.MakeWriterOptions(6, d.FormatMajorVersion().MaxTableFormat()))
+	require.NoError(t, w.Set([]byte("shared1"), []byte("s1")))
+	require.NoError(t, w.Set([]byte("shared2"), []byte("s2")))
+	require.NoError(t, w.Close())
+	require.NoError(t, d.Ingest([]string{"ext"}, nil))
+
+	require.NoError(t, d.Set([]byte("a"), []byte("1"), nil))
+	require.NoError(t, d.Set([]byte("c"), []byte("3"), nil))
+	require.NoError(t, d.DeleteRange([]byte("b"), []byte("c"), nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Delete([]byte("a"), nil))
+	require.NoError(t, d.Merge([]byte("c"), []byte("4"), nil))
+	require.NoError(t, d.RangeKeySet([]byte("a"), []byte("d"), []byte("@1"), []byte("rk"), nil))
+	require.NoError(t, d.DeleteRange([]byte("a"), []byte("bb"), nil))
+
+	scan := func(lower, upper []byte, visitShared bool) string {
+		var buf strings.Builder
+		visitSpan := func(kind string) func(start, end []byte, keys []InternalSpanKey) error {
+			return func(start, end []byte, keys []InternalSpanKey) error {
+				fmt.Fprintf(&buf, "%s [%s,%s):", kind, start, end)
+				for _, k := range keys {
+					fmt.Fprintf(&buf, " %s#%d", k.Kind(), k.SeqNum())
+					if k.Kind() == InternalKeyKindRangeKeySet {
+						fmt.Fprintf(&buf, "(%s=%s)", k.Suffix, k.Value)
+					}
+				}
+				buf.WriteString("\n")
+				return nil
+			}
+		}
+		visitors := ScanInternalVisitors{
+			PointKey: func(key *InternalKey, value []byte) error {
+				fmt.Fprintf(&buf, "point %s#%d,%s=%s\n", key.UserKey, key.SeqNum(), key.Kind(), value)
+				return nil
+			},
+			RangeDel: visitSpan("rangedel"),
+			RangeKey: visitSpan("rangekey"),
+		}
+		if visitShared {
+			visitors.SharedFile = func(info SharedTableInfo) error {
+				fmt.Fprintf(&buf, "shared L%d [%s,%s] creator=%d\n",
+					info.Level, info.Smallest.UserKey, info.Largest.UserKey, info.CreatorUniqueID)
+				return nil
+			}
+		}
+		require.NoError(t, d.ScanInternal(lower, upper, visitors))
+		return buf.String()
+	}
+
+	require.Equal(t, `shared L6 [shared1,shared2] creator=17
+point a#8,DEL=
+point a#5,SET=1
+point c#9,MERGE=4
+point c#6,SET=3
+rangedel [a,b): RANGEDEL#11
+rangedel [b,bb): RANGEDEL#11 RANGEDEL#7
+rangedel [bb,c): RANGEDEL#7
+rangekey [a,d): RANGEKEYSET#10(@1=rk)
+`, scan(nil, nil, true))
+
+	require.Equal(t, `point c#9,MERGE=4
+point c#6,SET=3
+point shared1#4,SET=s1
+rangedel [b,bb): RANGEDEL#11 RANGEDEL#7
+rangedel [bb,c): RANGEDEL#7
+rangekey [b,d): RANGEKEYSET#10(@1=rk)
+`, scan([]byte("b"), []byte("shared2"), false))
+
+	// An error returned by a visitor stops the scan.
+	errStop := errors.New("stop")
+	var n int
+	err = d.ScanInternal(nil, nil, ScanInternalVisitors{
+		PointKey: func(key *InternalKey, value []byte) error {
+			n++
+			return errStop
+		},
+	})
+	require.ErrorIs(t, err, errStop)
+	require.Equal(t, 1, n)
+}
+
+func TestScanInternalBlobSet(t *testing.T) {
+	d, err := Open("", &Options{
+		FS:                 vfs.NewMem(),
+		FormatMajorVersion: FormatBlobFiles,
+		MinBlobSize:        8,
+	})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	require.NoError(t, d.Set([]byte("a"), []byte("small"), nil))
+	require.NoError(t, d.Set([]byte("b"), []byte("separated value"), nil))
+	require.NoError(t, d.Flush())
+
+	// The separated value is read from its blob file, and its key is visited
+	// as a SETWITHDEL, as compactions would rewrite it.
+	var buf strings.Builder
+	require.NoError(t, d.ScanInternal(nil, nil, ScanInternalVisitors{
+		PointKey: func(key *InternalKey, value []byte) error {
+			fmt.Fprintf(&buf, "%s,%s=%s\n", key.UserKey, key.Kind(), value)
+			return nil
+		},
+	}))
+	require.Equal(t, "a,SET=small\nb,SETWITHDEL=separated value\n", buf.String())
+}
+
+func TestEstimateSpanStats(t *testing.T) {
+	opts := &Options{FS: vfs.NewMem(), L0CompactionThreshold: 100, L0StopWritesThreshold: 100}
+	opts.Levels = []LevelOptions{{BlockSize: 512}}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	key := func(i int) []byte { return []byte(fmt.Sprintf("%04d", i)) }
+	value := bytes.Repeat([]byte("v"), 100)
+	for i := 0; i < 1000; i++ {
+		require.NoError(t, d.Set(key(i), value, nil))
+	}
+	require.NoError(t, d.Flush())
+	for i := 0; i < 1000; i += 10 {
+		require.NoError(t, d.Delete(key(i), nil))
+	}
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Set(key(1), value, nil))
+	require.NoError(t, d.DeleteRange(key(100), key(200), nil))
+
+	// Every table is contained in the range, so the statistics are exact.
+	stats, err := d.EstimateSpanStats(key(0), key(999))
+	require.NoError(t, err)
+	require.Equal(t, SpanStats{
+		NumEntries:   1000 + 100 + 2,
+		NumDeletions: 100 + 1,
+		RawValueSize: 1001 * 100,
+	}, stats)
+
+	// The statistics of tables partially contained in the range are prorated.
+	stats, err = d.EstimateSpanStats(key(0), key(499))
+	require.NoError(t, err)
+	require.InDelta(t, 500+50+2, stats.NumEntries, 50)
+	require.InDelta(t, 50+1, stats.NumDeletions, 30)
+	require.InDelta(t, 501*100, stats.RawValueSize, 5000)
+
+	// The memtable's range deletion overlaps a range of a single key.
+	stats, err = d.EstimateSpanStats([]byte("0150"), []byte("0150"))
+	require.NoError(t, err)
+	require.Less(t, stats.NumEntries, uint64(50))
+	require.GreaterOrEqual(t, stats.NumDeletions, uint64(1))
+
+	stats, err = d.EstimateSpanStats([]byte("a"), []byte("b"))
+	require.NoError(t, err)
+	require.Equal(t, SpanStats{}, stats)
+
+	_, err = d.EstimateSpanStats(key(1), key(0))
+	require.Error(t, err)
+}
+
+func TestEstimateSpanStatsBlobValues(t *testing.T) {
+	opts := &Options{
+		FS:                          vfs.NewMem(),
+		FormatMajorVersion:          FormatBlobFiles,
+		MinBlobSize:                 100,
+		DisableAutomaticCompactions: true,
+	}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// Half of the values are large enough to be separated into a blob file
+	// when flushed.
+	key := func(i int) []byte { return []byte(fmt.Sprintf("%04d", i)) }
+	for i := 0; i < 100; i++ {
+		value := bytes.Repeat([]byte("v"), 10)
+		if i%2 == 0 {
+			value = bytes.Repeat([]byte("v"), 200)
+		}
+		require.NoError(t, d.Set(key(i), value, nil))
+	}
+	want := SpanStats{NumEntries: 100, RawValueSize: 50*200 + 50*10}
+	stats, err := d.EstimateSpanStats(key(0), key(99))
+	require.NoError(t, err)
+	require.Equal(t, want, stats)
+
+	// The separated values are counted by their size in the blob file, both
+	// in the flushed table and in the table compacted from it.
+	require.NoError(t, d.Flush())
+	d.mu.Lock()
+	l0Iter := d.mu.versions.currentVersion().Levels[0].Iter()
+	require.NotEmpty(t, l0Iter.First().BlobFiles)
+	d.mu.Unlock()
+	stats, err = d.EstimateSpanStats(key(0), key(99))
+	require.NoError(t, err)
+	require.Equal(t, want, stats)
+
+	require.NoError(t, d.Compact(key(0), key(99), false))
+	stats, err = d.EstimateSpanStats(key(0), key(99))
+	require.NoError(t, err)
+	require.Equal(t, want, stats)
+}
+
+func TestEstimateMemtableSpanStats(t *testing.T) {
+	d, err := Open("", &Options{FS: vfs.NewMem()})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	m := newMemTable(memTableOptions{size: 8 << 20})
+	key := func(i int) []byte { return []byte(fmt.Sprintf("%05d", i)) }
+	value := bytes.Repeat([]byte("v"), 100)
+	const n = 20000
+	for i := 0; i < n; i++ {
+		require.NoError(t, m.set(base.MakeInternalKey(key(i), uint64(i+1), InternalKeyKindSet), value))
+	}
+	handle := blobHandle{fileNum: 1, length: 1000}.encode(nil)
+	require.NoError(t, m.set(base.MakeInternalKey([]byte("blob"), 1, InternalKeyKindBlobSet), handle))
+	mem := &flushableEntry{flushable: m}
+
+	// A range of few keys is counted exactly, excluding the keys that are not
+	// visible.
+	stats, err := d.estimateMemtableSpanStats(mem, key(0), key(99), 51)
+	require.NoError(t, err)
+	require.Equal(t, SpanStats{NumEntries: 50, RawValueSize: 50 * 100}, stats)
+
+	// The value of a BLOBSET key is counted by the length of the value in the
+	// blob file.
+	stats, err = d.estimateMemtableSpanStats(mem, []byte("blob"), []byte("blob"), InternalKeySeqNumMax)
+	require.NoError(t, err)
+	require.Equal(t, SpanStats{NumEntries: 1, RawValueSize: 1000}, stats)
+
+	// A range of many keys is estimated from a sample of the keys.
+	stats, err = d.estimateMemtableSpanStats(mem, key(0), key(n-1), InternalKeySeqNumMax)
+	require.NoError(t, err)
+	require.InDelta(t, n, stats.NumEntries, n/4)
+	require.InDelta(t, n*100, stats.RawValueSize, n*100/4)
+}
+
 func TestDBConcurrentCommitCompactFlush(t *testing.T) {
 	d, err := Open("", testingRandomized(&Options{
 		FS: vfs.NewMem(),
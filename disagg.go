@@ -0,0 +1,121 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/sstable"
+)
+
+// ErrNotShared is returned by DB.ExportSharedSSTs if the keys in the span are
+// not all stored in shared sstables.
+var ErrNotShared = errors.New("pebble: span is not stored in shared sstables")
+
+// ExportSharedSSTs returns the metadata of the shared sstables storing the
+// point keys in [start, end), restricted to that span. The result may be passed
+// to DB.Ingest of another DB using the same SharedFS and SharedDir, but a
+// different UniqueID. That DB reads the latest version of every key in the
+// span, as of the export, with sequence numbers below its own keys.
+//
+// All the point keys and range deletions in the span must be stored in shared
+// sstables in a single level. DB.Compact moves the keys in a span to the
+// bottommost level, which is stored in shared storage by the default
+// PlacementPolicy. Range keys are not exported. ErrNotShared is returned if
+// the span is (partly) stored locally, or if a key's latest version is a MERGE.
+// A DB reading a foreign sstable fails the read if it finds such a key, as the
+// operands in the sstable are not merged with each other.
+func (d *DB) ExportSharedSSTs(start, end []byte) ([]SharedSSTMeta, error) {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	if d.cmp(start, end) >= 0 {
+		return nil, errors.Errorf("ExportSharedSSTs start %s is not less than end %s",
+			d.opts.Comparer.FormatKey(start), d.opts.Comparer.FormatKey(end))
+	}
+	if d.opts.SharedFS == nil {
+		return nil, ErrNotShared
+	}
+
+	readState := d.loadReadState()
+	defer readState.unref()
+
+	iStart := base.MakeInternalKey(start, InternalKeySeqNumMax, InternalKeyKindMax)
+	iEnd := base.MakeExclusiveSentinelKey(InternalKeyKindRangeDelete, end)
+	m := (&fileMetadata{}).ExtendPointKeyBounds(d.cmp, iStart, iEnd)
+	for _, mem := range readState.memtables {
+		if ingestMemtableOverlaps(d.cmp, mem, []*fileMetadata{m}) {
+			return nil, ErrNotShared
+		}
+	}
+
+	level := -1
+	var files []*fileMetadata
+	for l := range readState.current.Levels {
+		overlaps := readState.current.Overlaps(l, d.cmp, start, end, true /* exclusiveEnd */)
+		iter := overlaps.Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			if !f.IsShared || (level != -1 && level != l) {
+				return nil, ErrNotShared
+			}
+			level = l
+			files = append(files, f)
+		}
+	}
+
+	var metas []SharedSSTMeta
+	for _, f := range files {
+		smallest, largest, err := d.sharedSSTBounds(f, level, start, end)
+		if err != nil {
+			return nil, err
+		}
+		if smallest == nil {
+			// The sstable has no point keys in the span.
+			continue
+		}
+		metas = append(metas, SharedSSTMeta{
+			CreatorUniqueID: f.CreatorUniqueID,
+			PhysicalFileNum: f.PhysicalFileNum,
+			Smallest:        base.MakeInternalKey(smallest, 0, InternalKeyKindSet),
+			Largest:         base.MakeInternalKey(largest, 0, InternalKeyKindSet),
+			FileSmallest:    f.FileSmallest,
+			FileLargest:     f.FileLargest,
+		})
+	}
+	return metas, nil
+}
+
+// sharedSSTBounds returns the smallest and largest user keys in [start, end) of
+// the shared sstable f in the given level, or nil if it has no keys in the
+// span. ErrNotShared is returned if the latest version of a key is a MERGE.
+func (d *DB) sharedSSTBounds(
+	f *fileMetadata, level int, start, end []byte,
+) (smallest, largest []byte, _ error) {
+	err := d.tableCache.withReader(f, func(r *sstable.Reader) error {
+		iter, err := r.NewIter(start, end)
+		if err != nil {
+			return err
+		}
+		iter.SetLevel(level)
+		for k, _ := iter.SeekGE(start, base.SeekGEFlagsNone); k != nil; k, _ = iter.Next() {
+			if largest != nil && d.equal(k.UserKey, largest) {
+				continue
+			}
+			if k.Kind() == InternalKeyKindMerge {
+				_ = iter.Close()
+				return ErrNotShared
+			}
+			largest = append(largest[:0], k.UserKey...)
+			if smallest == nil {
+				smallest = append([]byte(nil), k.UserKey...)
+			}
+		}
+		return firstError(iter.Error(), iter.Close())
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return smallest, largest, nil
+}
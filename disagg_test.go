@@ -2,12 +2,18 @@ package pebble
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"math/rand"
 	"testing"
 	"time"
 
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/errors/oserror"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/errorfs"
 	"github.com/cockroachdb/pebble/internal/manifest"
+	"github.com/cockroachdb/pebble/sstable"
 	"github.com/cockroachdb/pebble/vfs"
 	"github.com/stretchr/testify/require"
 )
@@ -51,6 +57,7 @@ func TestDBWithSharedSST(t *testing.T) {
 	visible := make(map[string]bool)
 
 	// inject key boundaries function to filter out the upper
+	defer func(f func(*manifest.FileMetadata, uint32)) { setSharedSSTMetadata = f }(setSharedSSTMetadata)
 	setSharedSSTMetadata = func(meta *manifest.FileMetadata, creatorUniqueID uint32) {
 		// The output sst is shared so update its boundaries
 		meta.FileSmallest, meta.FileLargest = meta.Smallest, meta.Largest
@@ -151,3 +158,354 @@ func TestDBWithSharedSST(t *testing.T) {
 
 	require.NoError(t, d.Close())
 }
+
+func TestExportSharedSSTs(t *testing.T) {
+	fs := vfs.NewMem()
+	sharedFS := errorfs.NewSharedStore(vfs.NewMem(), errorfs.SharedStoreOptions{})
+	open := func(dir string, uniqueID uint32) *DB {
+		require.NoError(t, fs.MkdirAll(dir, 0755))
+		d, err := Open(dir, &Options{
+			FS:       fs,
+			SharedFS: sharedFS,
+			UniqueID: uniqueID,
+			Merger:   DefaultMerger,
+		})
+		require.NoError(t, err)
+		return d
+	}
+	src := open("src", 1)
+	for _, k := range []string{"a", "b", "c", "d", "e", "f"} {
+		require.NoError(t, src.Set([]byte(k), []byte(k+"1"), nil))
+	}
+	require.NoError(t, src.Flush())
+	// Protect the older versions with a snapshot, so that the merge operand
+	// is not merged with its base when compacted.
+	snap := src.NewSnapshot()
+	require.NoError(t, src.Set([]byte("c"), []byte("c2"), nil))
+	require.NoError(t, src.Delete([]byte("d"), nil))
+	require.NoError(t, src.Merge([]byte("e"), []byte("+"), nil))
+
+	// The unflushed keys in the span are stored locally.
+	_, err := src.ExportSharedSSTs([]byte("b"), []byte("f"))
+	require.ErrorIs(t, err, ErrNotShared)
+
+	require.NoError(t, src.Compact([]byte("a"), []byte("g"), false))
+	_, err = src.ExportSharedSSTs([]byte("b"), []byte("f"))
+	require.ErrorIs(t, err, ErrNotShared)
+	require.NoError(t, snap.Close())
+
+	// The span excluding the merge operand can be exported. Only the latest
+	// versions of its keys are visible.
+	metas, err := src.ExportSharedSSTs([]byte("b"), []byte("e"))
+	require.NoError(t, err)
+	require.NotEmpty(t, metas)
+	require.Equal(t, "b", string(metas[0].Smallest.UserKey))
+	require.Equal(t, "d", string(metas[len(metas)-1].Largest.UserKey))
+
+	dst := open("dst", 2)
+	require.NoError(t, dst.Ingest(nil, metas))
+	iter := dst.NewIter(nil)
+	var got []string
+	for valid := iter.First(); valid; valid = iter.Next() {
+		got = append(got, fmt.Sprintf("%s:%s", iter.Key(), iter.Value()))
+	}
+	require.NoError(t, iter.Close())
+	require.Equal(t, []string{"b:b1", "c:c2"}, got)
+
+	// Keys written to the destination shadow the ingested ones.
+	require.NoError(t, dst.Set([]byte("c"), []byte("c3"), nil))
+	v, closer, err := dst.Get([]byte("c"))
+	require.NoError(t, err)
+	require.Equal(t, "c3", string(v))
+	require.NoError(t, closer.Close())
+
+	require.NoError(t, dst.Close())
+	require.NoError(t, src.Close())
+}
+
+func TestWarmSharedTables(t *testing.T) {
+	fs := vfs.NewMem()
+	sharedFS := errorfs.NewSharedStore(vfs.NewMem(), errorfs.SharedStoreOptions{})
+	opts := &Options{
+		FS:       fs,
+		SharedFS: sharedFS,
+		UniqueID: 1,
+	}
+	// Table stats collection would open the tables concurrently with the
+	// warm-up.
+	opts.private.disableTableStats = true
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	// Ingest three tables into L6, each of which is placed in shared storage.
+	for _, k := range []string{"a", "b", "c"} {
+		f, err := fs.Create(k + ".sst")
+		require.NoError(t, err)
+		w := sstable.NewWriter(f, sstable.WriterOptions{})
+		require.NoError(t, w.Set([]byte(k), []byte(k)))
+		require.NoError(t, w.Close())
+		require.NoError(t, d.Ingest([]string{k + ".sst"}, nil))
+	}
+	require.NoError(t, d.Close())
+
+	// reopen opens the DB with an empty block cache, returning the number of
+	// cache misses incurred by reading each key after the warm-up completed.
+	reopen := func(warm bool, budget uint64) []int64 {
+		opts := opts.Clone()
+		opts.Cache = NewCache(1 << 20)
+		defer opts.Cache.Unref()
+		opts.WarmSharedTables = warm
+		opts.WarmSharedTablesBytes = budget
+		d, err := Open("", opts)
+		require.NoError(t, err)
+		defer func() { require.NoError(t, d.Close()) }()
+
+		d.mu.Lock()
+		for d.mu.sharedWarmup.warming || len(d.mu.sharedWarmup.pending) > 0 {
+			d.mu.sharedWarmup.cond.Wait()
+		}
+		d.mu.Unlock()
+
+		var misses []int64
+		for _, k := range []string{"a", "b", "c"} {
+			before := opts.Cache.Metrics().Misses
+			v, closer, err := d.Get([]byte(k))
+			require.NoError(t, err)
+			require.Equal(t, k, string(v))
+			require.NoError(t, closer.Close())
+			misses = append(misses, opts.Cache.Metrics().Misses-before)
+		}
+		return misses
+	}
+
+	// Without a warm-up, every table is opened, reading its metaindex and
+	// properties blocks, before its index and data blocks are read. A warmed up
+	// table only needs its data block.
+	require.Equal(t, []int64{4, 4, 4}, reopen(false, 0))
+	require.Equal(t, []int64{1, 1, 1}, reopen(true, 0))
+	// A budget of a single byte is exhausted by the first table.
+	require.Equal(t, []int64{1, 4, 4}, reopen(true, 1))
+}
+
+type compactionExecutorFunc func(
+	ctx context.Context, desc *RemoteCompactionDesc,
+) (*RemoteCompactionResult, error)
+
+func (f compactionExecutorFunc) ExecuteCompaction(
+	ctx context.Context, desc *RemoteCompactionDesc,
+) (*RemoteCompactionResult, error) {
+	return f(ctx, desc)
+}
+
+func TestRemoteCompaction(t *testing.T) {
+	// In the "fail" mode the executor fails, and in the "invalid" mode it
+	// reports an output beyond the bounds of the compaction. In both, the DB
+	// compacts locally instead.
+	for _, mode := range []string{"ok", "fail", "invalid"} {
+		t.Run(mode, func(t *testing.T) {
+			fail := mode != "ok"
+			fs := vfs.NewMem()
+			sharedFS := errorfs.NewSharedStore(vfs.NewMem(), errorfs.SharedStoreOptions{})
+			worker := &InProcessCompactionExecutor{
+				Dirname: "worker",
+				Options: &Options{FS: vfs.NewMem(), SharedFS: sharedFS},
+			}
+			var descs []*RemoteCompactionDesc
+			var results []*RemoteCompactionResult
+			opts := &Options{
+				FS:       fs,
+				SharedFS: sharedFS,
+				UniqueID: 1,
+				// Place all tables, including those ingested into L0, in
+				// shared storage.
+				PlacementPolicy: LevelPlacementPolicy{MinSharedLevel: 0},
+				CompactionExecutor: compactionExecutorFunc(
+					func(ctx context.Context, desc *RemoteCompactionDesc) (*RemoteCompactionResult, error) {
+						descs = append(descs, desc)
+						if mode == "fail" {
+							return nil, errors.New("injected error")
+						}
+						res, err := worker.ExecuteCompaction(ctx, desc)
+						if err != nil {
+							return nil, err
+						}
+						results = append(results, res)
+						if mode == "invalid" {
+							o := &res.Outputs[len(res.Outputs)-1]
+							o.LargestPoint = base.MakeInternalKey([]byte("z"), 0, base.InternalKeyKindSet)
+						}
+						return res, nil
+					}),
+			}
+			d, err := Open("", opts)
+			require.NoError(t, err)
+			defer func() { require.NoError(t, d.Close()) }()
+
+			ingest := func(name string, fn func(w *sstable.Writer)) {
+				f, err := fs.Create(name)
+				require.NoError(t, err)
+				w := sstable.NewWriter(f, sstable.WriterOptions{})
+				fn(w)
+				require.NoError(t, w.Close())
+				require.NoError(t, d.Ingest([]string{name}, nil))
+			}
+			key := func(i int) []byte { return []byte(fmt.Sprintf("k%03d", i)) }
+			// Ingest a table into L6, and then an overlapping table, which
+			// overwrites and deletes some of the keys, into L0.
+			ingest("l6.sst", func(w *sstable.Writer) {
+				for i := 0; i < 100; i++ {
+					require.NoError(t, w.Set(key(i), []byte("old")))
+				}
+			})
+			snap := d.NewSnapshot()
+			defer func() { require.NoError(t, snap.Close()) }()
+			ingest("l5.sst", func(w *sstable.Writer) {
+				require.NoError(t, w.DeleteRange(key(1), key(2)))
+				for i := 0; i < 100; i += 2 {
+					if i%4 == 0 {
+						require.NoError(t, w.Delete(key(i)))
+					} else {
+						require.NoError(t, w.Set(key(i), []byte("new")))
+					}
+				}
+			})
+			m := d.Metrics()
+			require.Equal(t, int64(1), m.Levels[0].NumFiles)
+			require.Equal(t, int64(1), m.Levels[6].NumFiles)
+
+			require.NoError(t, d.Compact(key(0), key(100), false))
+			require.Equal(t, 1, len(descs))
+			require.Equal(t, []int{0, 6}, []int{descs[0].Inputs[0].Level, descs[0].Inputs[1].Level})
+			require.Equal(t, 1, len(descs[0].Snapshots))
+			if mode != "fail" {
+				// The outputs report the bounds of their range deletions
+				// separately from those of their point keys.
+				require.Equal(t, 1, len(results))
+				o := results[0].Outputs[0]
+				require.True(t, o.HasPointKeys)
+				require.True(t, o.HasRangeDelKeys)
+				require.Equal(t, key(1), o.SmallestRangeDel.UserKey)
+				require.False(t, o.HasRangeKeys)
+			}
+			if mode == "invalid" {
+				// The outputs written by the executor are removed from shared
+				// storage.
+				for _, o := range results[0].Outputs {
+					path := base.MakeSharedSSTPath(sharedFS, "", 1, o.FileNum)
+					_, err := sharedFS.Stat(path)
+					require.True(t, oserror.IsNotExist(err), "output %s: %v", o.FileNum, err)
+				}
+			}
+
+			m = d.Metrics()
+			require.Equal(t, int64(0), m.Levels[0].NumFiles)
+			require.NotZero(t, m.Levels[6].NumFiles)
+			d.mu.Lock()
+			iter := d.mu.versions.currentVersion().Levels[6].Iter()
+			for f := iter.First(); f != nil; f = iter.Next() {
+				require.True(t, f.IsShared)
+				require.Equal(t, uint32(1), f.CreatorUniqueID)
+				// The outputs of a remote compaction use the reserved file
+				// numbers.
+				reserved := f.FileNum >= descs[0].FirstOutputFileNum &&
+					uint64(f.FileNum-descs[0].FirstOutputFileNum) < descs[0].NumOutputFileNums
+				require.Equal(t, !fail, reserved)
+			}
+			d.mu.Unlock()
+
+			for i := 0; i < 100; i++ {
+				expected := "old"
+				if i%4 == 0 || i == 1 {
+					expected = ""
+				} else if i%2 == 0 {
+					expected = "new"
+				}
+				v, closer, err := d.Get(key(i))
+				if expected == "" {
+					require.ErrorIs(t, err, ErrNotFound)
+				} else {
+					require.NoError(t, err)
+					require.Equal(t, expected, string(v))
+					require.NoError(t, closer.Close())
+				}
+				// The snapshot preceding the ingestion into L0 still reads the
+				// old values.
+				v, closer, err = snap.Get(key(i))
+				require.NoError(t, err)
+				require.Equal(t, "old", string(v))
+				require.NoError(t, closer.Close())
+			}
+		})
+	}
+}
+
+func TestRemoteCompactionPause(t *testing.T) {
+	fs := vfs.NewMem()
+	sharedFS := errorfs.NewSharedStore(vfs.NewMem(), errorfs.SharedStoreOptions{})
+	worker := &InProcessCompactionExecutor{
+		Dirname: "worker",
+		Options: &Options{FS: vfs.NewMem(), SharedFS: sharedFS},
+	}
+	var descs []*RemoteCompactionDesc
+	block := true
+	started := make(chan struct{}, 1)
+	opts := &Options{
+		FS:              fs,
+		SharedFS:        sharedFS,
+		UniqueID:        1,
+		PlacementPolicy: LevelPlacementPolicy{MinSharedLevel: 0},
+		CompactionExecutor: compactionExecutorFunc(
+			func(ctx context.Context, desc *RemoteCompactionDesc) (*RemoteCompactionResult, error) {
+				descs = append(descs, desc)
+				if block {
+					// The executor runs until the compaction is cancelled.
+					started <- struct{}{}
+					<-ctx.Done()
+				}
+				return worker.ExecuteCompaction(ctx, desc)
+			}),
+	}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	key := func(i int) []byte { return []byte(fmt.Sprintf("k%03d", i)) }
+	for i, name := range []string{"l6.sst", "l0.sst"} {
+		f, err := fs.Create(name)
+		require.NoError(t, err)
+		w := sstable.NewWriter(f, sstable.WriterOptions{})
+		for j := i; j < 100; j += 2 {
+			require.NoError(t, w.Set(key(j), []byte(name)))
+		}
+		require.NoError(t, w.Close())
+		require.NoError(t, d.Ingest([]string{name}, nil))
+	}
+
+	// Pausing compactions cancels the context passed to the executor, and
+	// the compaction fails.
+	errCh := make(chan error, 1)
+	go func() { errCh <- d.Compact(key(0), key(100), false) }()
+	<-started
+	d.PauseCompactions()
+	require.ErrorIs(t, <-errCh, ErrCancelledCompaction)
+	m := d.Metrics()
+	require.Equal(t, int64(1), m.Levels[0].NumFiles)
+	require.Equal(t, int64(1), m.Levels[6].NumFiles)
+	// Any outputs written by the worker are removed from shared storage.
+	require.Equal(t, 1, len(descs))
+	for i := uint64(0); i < descs[0].NumOutputFileNums; i++ {
+		path := base.MakeSharedSSTPath(sharedFS, "", 1, descs[0].FirstOutputFileNum+base.FileNum(i))
+		_, err := sharedFS.Stat(path)
+		require.True(t, oserror.IsNotExist(err), "%s: %v", path, err)
+	}
+
+	// Manual compactions fail while compactions are paused.
+	require.ErrorIs(t, d.Compact(key(0), key(100), false), ErrCancelledCompaction)
+	require.Equal(t, 1, len(descs))
+
+	block = false
+	d.ResumeCompactions()
+	require.NoError(t, d.Compact(key(0), key(100), false))
+	m = d.Metrics()
+	require.Equal(t, int64(0), m.Levels[0].NumFiles)
+	require.Equal(t, 2, len(descs))
+}
@@ -230,6 +230,32 @@ func (i ManifestDeleteInfo) SafeFormat(w redact.SafePrinter, _ rune) {
 	w.Printf("[JOB %d] MANIFEST deleted %s", redact.Safe(i.JobID), redact.Safe(i.FileNum))
 }
 
+// OptionsChangeInfo contains the info for an options change event.
+type OptionsChangeInfo struct {
+	// JobID is the ID of the job that changed the options.
+	JobID int
+	// Path and FileNum identify the OPTIONS file written with the new options.
+	Path    string
+	FileNum FileNum
+	// Old and New are the values of the mutable options before and after the
+	// change. If Err is non-nil, the options were not changed.
+	Old, New MutableOptions
+	Err      error
+}
+
+func (i OptionsChangeInfo) String() string {
+	return redact.StringWithoutMarkers(i)
+}
+
+// SafeFormat implements redact.SafeFormatter.
+func (i OptionsChangeInfo) SafeFormat(w redact.SafePrinter, _ rune) {
+	if i.Err != nil {
+		w.Printf("[JOB %d] OPTIONS change error: %s", redact.Safe(i.JobID), i.Err)
+		return
+	}
+	w.Printf("[JOB %d] OPTIONS changed %s: %s", redact.Safe(i.JobID), redact.Safe(i.FileNum), i.New)
+}
+
 // TableCreateInfo contains the info for a table creation event.
 type TableCreateInfo struct {
 	JobID int
@@ -250,6 +276,29 @@ func (i TableCreateInfo) SafeFormat(w redact.SafePrinter, _ rune) {
 		redact.Safe(i.JobID), redact.Safe(i.Reason), redact.Safe(i.FileNum))
 }
 
+// TablePlaceInfo contains the info for a table placement event.
+type TablePlaceInfo struct {
+	JobID int
+	// Reason is the reason for the table creation: "compacting", "flushing", or
+	// "ingesting".
+	Reason string
+	// Path is the path of the table once placed.
+	Path    string
+	FileNum FileNum
+	// Placement is where the Options.PlacementPolicy placed the table.
+	Placement TablePlacement
+}
+
+func (i TablePlaceInfo) String() string {
+	return redact.StringWithoutMarkers(i)
+}
+
+// SafeFormat implements redact.SafeFormatter.
+func (i TablePlaceInfo) SafeFormat(w redact.SafePrinter, _ rune) {
+	w.Printf("[JOB %d] %s: sstable placed %s (%s)",
+		redact.Safe(i.JobID), redact.Safe(i.Reason), redact.Safe(i.FileNum), redact.Safe(i.Placement))
+}
+
 // TableDeleteInfo contains the info for a table deletion event.
 type TableDeleteInfo struct {
 	JobID   int
@@ -450,12 +499,23 @@ type EventListener struct {
 	// ManifestDeleted is invoked after a manifest has been deleted.
 	ManifestDeleted func(ManifestDeleteInfo)
 
+	// OptionsChanged is invoked after DB.SetOptions has applied new options
+	// and written them to a new OPTIONS file, or failed to do so.
+	OptionsChanged func(OptionsChangeInfo)
+
 	// TableCreated is invoked when a table has been created.
 	TableCreated func(TableCreateInfo)
 
 	// TableDeleted is invoked after a table has been deleted.
 	TableDeleted func(TableDeleteInfo)
 
+	// TablePlaced is invoked when Options.PlacementPolicy has decided where a
+	// flushed, compacted or ingested table is stored. It is only invoked if
+	// Options.SharedFS is set. A flushed or compacted table is placed once
+	// it is written, after TableCreated is invoked for it, and an ingested
+	// table is placed once its level is known.
+	TablePlaced func(TablePlaceInfo)
+
 	// TableIngested is invoked after an externally created table has been
 	// ingested via a call to DB.Ingest().
 	TableIngested func(TableIngestInfo)
@@ -518,12 +578,18 @@ func (l *EventListener) EnsureDefaults(logger Logger) {
 	if l.ManifestDeleted == nil {
 		l.ManifestDeleted = func(info ManifestDeleteInfo) {}
 	}
+	if l.OptionsChanged == nil {
+		l.OptionsChanged = func(info OptionsChangeInfo) {}
+	}
 	if l.TableCreated == nil {
 		l.TableCreated = func(info TableCreateInfo) {}
 	}
 	if l.TableDeleted == nil {
 		l.TableDeleted = func(info TableDeleteInfo) {}
 	}
+	if l.TablePlaced == nil {
+		l.TablePlaced = func(info TablePlaceInfo) {}
+	}
 	if l.TableIngested == nil {
 		l.TableIngested = func(info TableIngestInfo) {}
 	}
@@ -582,12 +648,18 @@ func MakeLoggingEventListener(logger Logger) EventListener {
 		ManifestDeleted: func(info ManifestDeleteInfo) {
 			logger.Infof("%s", info)
 		},
+		OptionsChanged: func(info OptionsChangeInfo) {
+			logger.Infof("%s", info)
+		},
 		TableCreated: func(info TableCreateInfo) {
 			logger.Infof("%s", info)
 		},
 		TableDeleted: func(info TableDeleteInfo) {
 			logger.Infof("%s", info)
 		},
+		TablePlaced: func(info TablePlaceInfo) {
+			logger.Infof("%s", info)
+		},
 		TableIngested: func(info TableIngestInfo) {
 			logger.Infof("%s", info)
 		},
@@ -653,6 +725,10 @@ func TeeEventListener(a, b EventListener) EventListener {
 			a.ManifestDeleted(info)
 			b.ManifestDeleted(info)
 		},
+		OptionsChanged: func(info OptionsChangeInfo) {
+			a.OptionsChanged(info)
+			b.OptionsChanged(info)
+		},
 		TableCreated: func(info TableCreateInfo) {
 			a.TableCreated(info)
 			b.TableCreated(info)
@@ -661,6 +737,10 @@ func TeeEventListener(a, b EventListener) EventListener {
 			a.TableDeleted(info)
 			b.TableDeleted(info)
 		},
+		TablePlaced: func(info TablePlaceInfo) {
+			a.TablePlaced(info)
+			b.TablePlaced(info)
+		},
 		TableIngested: func(info TableIngestInfo) {
 			a.TableIngested(info)
 			b.TableIngested(info)
@@ -98,6 +98,8 @@ func validateExternalIterOpts(iterOpts *IterOptions) error {
 		return errors.Errorf("pebble: external iterator: OnlyReadGuaranteedDurable unsupported")
 	case iterOpts.UseL6Filters:
 		return errors.Errorf("pebble: external iterator: UseL6Filters unsupported")
+	case iterOpts.PinKeysAndValues:
+		return errors.Errorf("pebble: external iterator: PinKeysAndValues unsupported")
 	}
 	return nil
 }
@@ -25,6 +25,7 @@ const (
 	fileTypeOptions  = base.FileTypeOptions
 	fileTypeTemp     = base.FileTypeTemp
 	fileTypeOldTemp  = base.FileTypeOldTemp
+	fileTypeBlob     = base.FileTypeBlob
 )
 
 // setCurrentFile sets the CURRENT file to point to the manifest with
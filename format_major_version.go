@@ -87,11 +87,23 @@ const (
 	FormatMarkedCompacted
 	// FormatRangeKeys is a format major version that introduces range keys.
 	FormatRangeKeys
+	// FormatBlobFiles is a format major version that introduces blob files,
+	// which store the values of BLOBSET keys outside of sstables. Values are
+	// only separated into blob files when Options.MinBlobSize is set.
+	FormatBlobFiles
+	// FormatDataBlockHashIndex is a format major version that introduces
+	// hash indexes in data blocks (sstable.TableFormatPebblev3). Hash indexes
+	// are only written for levels with LevelOptions.DataBlockHashIndex set.
+	FormatDataBlockHashIndex
+	// FormatPartitionedFilters is a format major version that introduces
+	// partitioned table filters (sstable.TableFormatPebblev4). Filters are
+	// only partitioned for levels with LevelOptions.PartitionedFilters set.
+	FormatPartitionedFilters
 	// FormatNewest always contains the most recent format major version.
 	// NB: When adding new versions, the MaxTableFormat method should also be
 	// updated to return the maximum allowable version for the new
 	// FormatMajorVersion.
-	FormatNewest FormatMajorVersion = FormatRangeKeys
+	FormatNewest FormatMajorVersion = FormatPartitionedFilters
 )
 
 // MaxTableFormat returns the maximum sstable.TableFormat that can be used at
@@ -103,8 +115,12 @@ func (v FormatMajorVersion) MaxTableFormat() sstable.TableFormat {
 		return sstable.TableFormatRocksDBv2
 	case FormatBlockPropertyCollector, FormatSplitUserKeysMarked, FormatMarkedCompacted:
 		return sstable.TableFormatPebblev1
-	case FormatRangeKeys:
+	case FormatRangeKeys, FormatBlobFiles:
 		return sstable.TableFormatPebblev2
+	case FormatDataBlockHashIndex:
+		return sstable.TableFormatPebblev3
+	case FormatPartitionedFilters:
+		return sstable.TableFormatPebblev4
 	default:
 		panic(fmt.Sprintf("pebble: unsupported format major version: %s", v))
 	}
@@ -206,6 +222,15 @@ var formatMajorVersionMigrations = map[FormatMajorVersion]func(*DB) error{
 	FormatRangeKeys: func(d *DB) error {
 		return d.finalizeFormatVersUpgrade(FormatRangeKeys)
 	},
+	FormatBlobFiles: func(d *DB) error {
+		return d.finalizeFormatVersUpgrade(FormatBlobFiles)
+	},
+	FormatDataBlockHashIndex: func(d *DB) error {
+		return d.finalizeFormatVersUpgrade(FormatDataBlockHashIndex)
+	},
+	FormatPartitionedFilters: func(d *DB) error {
+		return d.finalizeFormatVersUpgrade(FormatPartitionedFilters)
+	},
 }
 
 const formatVersionMarkerName = `format-version`
@@ -11,8 +11,10 @@ import (
 	"testing"
 	"time"
 
+	"github.com/cockroachdb/pebble/bloom"
 	"github.com/cockroachdb/pebble/internal/base"
 	"github.com/cockroachdb/pebble/internal/datadriven"
+	"github.com/cockroachdb/pebble/internal/testkeys"
 	"github.com/cockroachdb/pebble/sstable"
 	"github.com/cockroachdb/pebble/vfs"
 	"github.com/cockroachdb/pebble/vfs/atomicfs"
@@ -43,6 +45,12 @@ func TestRatchetFormat(t *testing.T) {
 	require.Equal(t, FormatBlockPropertyCollector, d.FormatMajorVersion())
 	require.NoError(t, d.RatchetFormatMajorVersion(FormatRangeKeys))
 	require.Equal(t, FormatRangeKeys, d.FormatMajorVersion())
+	require.NoError(t, d.RatchetFormatMajorVersion(FormatBlobFiles))
+	require.Equal(t, FormatBlobFiles, d.FormatMajorVersion())
+	require.NoError(t, d.RatchetFormatMajorVersion(FormatDataBlockHashIndex))
+	require.Equal(t, FormatDataBlockHashIndex, d.FormatMajorVersion())
+	require.NoError(t, d.RatchetFormatMajorVersion(FormatPartitionedFilters))
+	require.Equal(t, FormatPartitionedFilters, d.FormatMajorVersion())
 	require.NoError(t, d.Close())
 
 	// If we Open the database again, leaving the default format, the
@@ -199,6 +207,9 @@ func TestFormatMajorVersions_TableFormat(t *testing.T) {
 		FormatSplitUserKeysMarked:     sstable.TableFormatPebblev1,
 		FormatMarkedCompacted:         sstable.TableFormatPebblev1,
 		FormatRangeKeys:               sstable.TableFormatPebblev2,
+		FormatBlobFiles:               sstable.TableFormatPebblev2,
+		FormatDataBlockHashIndex:      sstable.TableFormatPebblev3,
+		FormatPartitionedFilters:      sstable.TableFormatPebblev4,
 	}
 
 	// Valid versions.
@@ -316,3 +327,129 @@ func TestSplitUserKeyMigration(t *testing.T) {
 
 		})
 }
+
+func TestFormatMajorVersions_DataBlockHashIndex(t *testing.T) {
+	for _, fmv := range []FormatMajorVersion{FormatBlobFiles, FormatDataBlockHashIndex} {
+		t.Run(fmv.String(), func(t *testing.T) {
+			mem := vfs.NewMem()
+			opts := &Options{
+				Comparer:                    testkeys.Comparer,
+				FS:                          mem,
+				FormatMajorVersion:          fmv,
+				DisableAutomaticCompactions: true,
+				Levels:                      []LevelOptions{{DataBlockHashIndex: true}},
+			}
+			d, err := Open("", opts)
+			require.NoError(t, err)
+			defer func() { require.NoError(t, d.Close()) }()
+
+			for _, k := range []string{"a", "b", "c"} {
+				require.NoError(t, d.Set([]byte(k), []byte(k), nil))
+			}
+			require.NoError(t, d.Flush())
+
+			// Hash indexes are only written once the format major version
+			// allows it.
+			want := sstable.TableFormatPebblev2
+			if fmv >= FormatDataBlockHashIndex {
+				want = sstable.TableFormatPebblev3
+			}
+			tables, err := d.SSTables()
+			require.NoError(t, err)
+			require.Len(t, tables[0], 1)
+			f, err := mem.Open(base.MakeFilepath(mem, "", fileTypeTable, tables[0][0].FileNum))
+			require.NoError(t, err)
+			r, err := sstable.NewReader(f, sstable.ReaderOptions{Comparer: testkeys.Comparer})
+			require.NoError(t, err)
+			tf, err := r.TableFormat()
+			require.NoError(t, err)
+			require.NoError(t, r.Close())
+			require.Equal(t, want, tf)
+
+			// Gets seek the table by the key's prefix, which uses the hash
+			// index when the table has one.
+			for _, k := range []string{"a", "b", "c"} {
+				v, closer, err := d.Get([]byte(k))
+				require.NoError(t, err)
+				require.Equal(t, k, string(v))
+				stats := closer.(*Iterator).getIterAlloc.get.levelIter.Stats()
+				require.Equal(t, want == sstable.TableFormatPebblev3, stats.HashIndexSeeks > 0)
+				require.NoError(t, closer.Close())
+			}
+			_, _, err = d.Get([]byte("d"))
+			require.Equal(t, ErrNotFound, err)
+		})
+	}
+}
+
+func TestFormatMajorVersions_PartitionedFilters(t *testing.T) {
+	for _, fmv := range []FormatMajorVersion{FormatDataBlockHashIndex, FormatPartitionedFilters} {
+		t.Run(fmv.String(), func(t *testing.T) {
+			mem := vfs.NewMem()
+			opts := &Options{
+				FS:                          mem,
+				FormatMajorVersion:          fmv,
+				DisableAutomaticCompactions: true,
+				Levels: []LevelOptions{{
+					BlockSize:          1,
+					IndexBlockSize:     1,
+					FilterPolicy:       bloom.FilterPolicy(10),
+					PartitionedFilters: true,
+				}},
+			}
+			d, err := Open("", opts)
+			require.NoError(t, err)
+			defer func() { require.NoError(t, d.Close()) }()
+
+			const numKeys = 100
+			for i := 0; i < numKeys; i++ {
+				k := fmt.Sprintf("key%03d", i)
+				require.NoError(t, d.Set([]byte(k), []byte(k), nil))
+			}
+			require.NoError(t, d.Flush())
+
+			// Filters are only partitioned once the format major version
+			// allows it.
+			tables, err := d.SSTables()
+			require.NoError(t, err)
+			require.Len(t, tables[0], 1)
+			f, err := mem.Open(base.MakeFilepath(mem, "", fileTypeTable, tables[0][0].FileNum))
+			require.NoError(t, err)
+			r, err := sstable.NewReader(f, d.opts.MakeReaderOptions())
+			require.NoError(t, err)
+			tf, err := r.TableFormat()
+			require.NoError(t, err)
+			l, err := r.Layout()
+			require.NoError(t, err)
+			require.NoError(t, r.Close())
+			if fmv >= FormatPartitionedFilters {
+				require.Equal(t, sstable.TableFormatPebblev4, tf)
+				require.Less(t, 1, len(l.FilterPartitions))
+			} else {
+				require.Equal(t, sstable.TableFormatPebblev3, tf)
+				require.Len(t, l.FilterPartitions, 0)
+			}
+
+			for i := 0; i < numKeys; i++ {
+				k := fmt.Sprintf("key%03d", i)
+				v, closer, err := d.Get([]byte(k))
+				require.NoError(t, err)
+				require.Equal(t, k, string(v))
+				require.NoError(t, closer.Close())
+			}
+			// Lookups of absent keys are rejected by the filters.
+			keys := make([][]byte, numKeys)
+			for i := range keys {
+				keys[i] = []byte(fmt.Sprintf("key%03d-absent", i))
+			}
+			before := d.Metrics().Filter
+			results, closer, err := d.MultiGet(keys)
+			require.NoError(t, err)
+			for i := range results {
+				require.False(t, results[i].Found)
+			}
+			require.NoError(t, closer.Close())
+			require.Less(t, int64(0), d.Metrics().Filter.Hits-before.Hits)
+		})
+	}
+}
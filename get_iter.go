@@ -6,6 +6,7 @@ package pebble
 
 import (
 	"fmt"
+	"sync/atomic"
 
 	"github.com/cockroachdb/pebble/internal/base"
 	"github.com/cockroachdb/pebble/internal/keyspan"
@@ -17,24 +18,37 @@ import (
 // internalIterator, but specialized for Get operations so that it loads data
 // lazily.
 type getIter struct {
-	logger       Logger
-	cmp          Compare
-	equal        Equal
-	newIters     tableNewIters
-	snapshot     uint64
-	key          []byte
-	iter         internalIterator
-	rangeDelIter keyspan.FragmentIterator
-	tombstone    *keyspan.Span
-	levelIter    levelIter
-	level        int
-	batch        *Batch
-	mem          flushableList
-	l0           []manifest.LevelSlice
-	version      *version
-	iterKey      *InternalKey
-	iterValue    []byte
-	err          error
+	logger Logger
+	cmp    Compare
+	equal  Equal
+	// split, if non-nil, is used to seek the sstable levels by the prefix of
+	// the key, so that the sstables' filters and data block hash indexes are
+	// consulted.
+	split Split
+	// filterMetrics counts the lookups of memtables skipped and not skipped by
+	// their filters.
+	filterMetrics *FilterMetrics
+	newIters      tableNewIters
+	// newLevelIters, if non-nil, is called before the lookup reads each
+	// sstable level, and returns the tableNewIters used for the files of the
+	// level in place of newIters. The levels are numbered by depth in the
+	// order they are read: the newest L0 sublevel is at depth 0, and L1
+	// follows the oldest L0 sublevel. It is used by MultiGet.
+	newLevelIters func(depth int, files manifest.LevelSlice) tableNewIters
+	snapshot      uint64
+	key           []byte
+	iter          internalIterator
+	rangeDelIter  keyspan.FragmentIterator
+	tombstone     *keyspan.Span
+	levelIter     levelIter
+	level         int
+	batch         *Batch
+	mem           flushableList
+	l0            []manifest.LevelSlice
+	version       *version
+	iterKey       *InternalKey
+	iterValue     []byte
+	err           error
 }
 
 // TODO(sumeer): CockroachDB code doesn't use getIter, but, for completeness,
@@ -140,9 +154,16 @@ func (g *getIter) Next() (*InternalKey, []byte) {
 		// Create iterators from memtables from newest to oldest.
 		if n := len(g.mem); n > 0 {
 			m := g.mem[n-1]
-			g.iter = m.newIter(nil)
 			g.rangeDelIter = m.newRangeDelIter(nil)
 			g.mem = g.mem[:n-1]
+			if !g.memTableMayContain(m) {
+				// The memtable's range deletions may still delete the key in
+				// lower levels.
+				g.iter = emptyIter
+				g.iterKey, g.iterValue = nil, nil
+				continue
+			}
+			g.iter = m.newIter(nil)
 			g.iterKey, g.iterValue = g.iter.SeekGE(g.key, base.SeekGEFlagsNone)
 			continue
 		}
@@ -150,14 +171,18 @@ func (g *getIter) Next() (*InternalKey, []byte) {
 		if g.level == 0 {
 			// Create iterators from L0 from newest to oldest.
 			if n := len(g.l0); n > 0 {
-				files := g.l0[n-1].Iter()
+				files := g.l0[n-1]
 				g.l0 = g.l0[:n-1]
+				newIters := g.newIters
+				if g.newLevelIters != nil {
+					newIters = g.newLevelIters(len(g.version.L0SublevelFiles)-n, files)
+				}
 				iterOpts := IterOptions{logger: g.logger}
-				g.levelIter.init(iterOpts, g.cmp, nil /* split */, g.newIters,
-					files, manifest.L0Sublevel(n), nil)
+				g.levelIter.init(iterOpts, g.cmp, g.split, newIters,
+					files.Iter(), manifest.L0Sublevel(n), nil)
 				g.levelIter.initRangeDel(&g.rangeDelIter)
 				g.iter = &g.levelIter
-				g.iterKey, g.iterValue = g.iter.SeekGE(g.key, base.SeekGEFlagsNone)
+				g.iterKey, g.iterValue = g.seekLevel()
 				continue
 			}
 			g.level++
@@ -171,14 +196,43 @@ func (g *getIter) Next() (*InternalKey, []byte) {
 			continue
 		}
 
+		newIters := g.newIters
+		if g.newLevelIters != nil {
+			newIters = g.newLevelIters(len(g.version.L0SublevelFiles)+g.level-1, g.version.Levels[g.level].Slice())
+		}
 		iterOpts := IterOptions{logger: g.logger}
-		g.levelIter.init(iterOpts, g.cmp, nil /* split */, g.newIters,
+		g.levelIter.init(iterOpts, g.cmp, g.split, newIters,
 			g.version.Levels[g.level].Iter(), manifest.Level(g.level), nil)
 		g.levelIter.initRangeDel(&g.rangeDelIter)
 		g.level++
 		g.iter = &g.levelIter
-		g.iterKey, g.iterValue = g.iter.SeekGE(g.key, base.SeekGEFlagsNone)
+		g.iterKey, g.iterValue = g.seekLevel()
+	}
+}
+
+// seekLevel positions the level iterator at the key being looked up. If split
+// is set, the level is seeked by the prefix of the key.
+func (g *getIter) seekLevel() (*InternalKey, []byte) {
+	if g.split == nil {
+		return g.levelIter.SeekGE(g.key, base.SeekGEFlagsNone)
+	}
+	prefix := g.key[:g.split(g.key)]
+	return g.levelIter.SeekPrefixGE(prefix, g.key, base.SeekGEFlagsNone)
+}
+
+// memTableMayContain returns false if the filter of the memtable excludes the
+// key being looked up.
+func (g *getIter) memTableMayContain(m *flushableEntry) bool {
+	mem, ok := m.flushable.(*memTable)
+	if !ok || mem.filter == nil {
+		return true
+	}
+	if mem.mayContain(g.key) {
+		atomic.AddInt64(&g.filterMetrics.Misses, 1)
+		return true
 	}
+	atomic.AddInt64(&g.filterMetrics.Hits, 1)
+	return false
 }
 
 func (g *getIter) Prev() (*InternalKey, []byte) {
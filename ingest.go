@@ -9,7 +9,9 @@ import (
 	"time"
 
 	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/errors/oserror"
 	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/cache"
 	"github.com/cockroachdb/pebble/internal/keyspan"
 	"github.com/cockroachdb/pebble/internal/manifest"
 	"github.com/cockroachdb/pebble/internal/private"
@@ -75,7 +77,18 @@ func ingestLoad1(
 	cacheOpts := private.SSTableCacheOpts(cacheID, fileNum).(sstable.ReaderOption)
 	// Create meta earlier and attach it to reader (does not affect non-shared sst)
 	meta := &fileMetadata{}
-	r, err := sstable.NewReader(f, opts.MakeReaderOptions(), cacheOpts, &sstable.FileMetadataOpt{Meta: meta})
+	readerOpts := []sstable.ReaderOption{
+		cacheOpts, &sstable.FileMetadataOpt{Meta: meta}, sstable.DBUniqueIDOpt{ID: opts.UniqueID},
+	}
+	if isShared {
+		// Key the blocks by the shared object so that those read while loading
+		// are reused once the table is installed.
+		readerOpts = append(readerOpts, sstable.BlockCacheKeyOpt{
+			ID:      cache.SharedObjectID(smeta.CreatorUniqueID),
+			FileNum: smeta.PhysicalFileNum,
+		})
+	}
+	r, err := sstable.NewReader(f, opts.MakeReaderOptions(), readerOpts...)
 	if err != nil {
 		return nil, err
 	}
@@ -100,8 +113,6 @@ func ingestLoad1(
 	if isShared {
 		meta.CreatorUniqueID = smeta.CreatorUniqueID
 		meta.PhysicalFileNum = smeta.PhysicalFileNum
-		meta.Smallest = smeta.Smallest
-		meta.Largest = smeta.Largest
 		meta.FileSmallest = smeta.FileSmallest
 		meta.FileLargest = smeta.FileLargest
 	}
@@ -117,8 +128,17 @@ func ingestLoad1(
 	// calculating stats before we can remove the original link.
 	maybeSetStatsFromProperties(meta, &r.Properties)
 
-	// XXX(chen): I think the following logic also applies to shared ssts but
-	// we must first "mount" the meta to the reader.. (this has been done above)
+	if isShared {
+		// A shared sstable is restricted to the virtual bounds it was exported
+		// with, which are not necessarily the bounds of its keys. Shared
+		// sstables do not contain range keys.
+		meta.ExtendPointKeyBounds(opts.Comparer.Compare, smeta.Smallest, smeta.Largest)
+		if err := meta.Validate(opts.Comparer.Compare, opts.Comparer.FormatKey); err != nil {
+			return nil, err
+		}
+		return meta, nil
+	}
+
 	{
 		iter, err := r.NewIter(nil /* lower */, nil /* upper */)
 		if err != nil {
@@ -174,8 +194,7 @@ func ingestLoad1(
 	}
 
 	// Update the range-key bounds for the table.
-	// XXX(chen): only for local ssts as shared sst does not support rangekeys
-	if !isShared {
+	{
 		iter, err := r.NewRawRangeKeyIter()
 		if err != nil {
 			return nil, err
@@ -261,7 +280,7 @@ func ingestLoad(
 			}
 			if m != nil {
 				meta = append(meta, m)
-				newPaths = append(newPaths, paths[i])
+				newPaths = append(newPaths, spath)
 				shared = append(shared, true)
 			}
 		}
@@ -324,8 +343,34 @@ func ingestCleanup(fs vfs.FS, dirname string, meta []*fileMetadata) error {
 	return firstErr
 }
 
+// ingestCleanupShared removes the copies of the sstables in meta, marked in
+// upload, that ingestLink made in shared storage. Copies that do not exist are
+// ignored.
+func ingestCleanupShared(opts *Options, meta []*fileMetadata, upload []bool) error {
+	var firstErr error
+	for i := range meta {
+		if upload == nil || !upload[i] {
+			continue
+		}
+		target := base.MakeSharedSSTPath(opts.SharedFS, opts.SharedDir, opts.UniqueID, meta[i].FileNum)
+		if err := opts.SharedFS.Remove(target); err != nil && !oserror.IsNotExist(err) {
+			firstErr = firstError(firstErr, err)
+		}
+	}
+	return firstErr
+}
+
+// ingestLink links the sstables in paths into the DB directory. The sstables
+// marked in upload, if non-nil, are also copied to shared storage, under the
+// names they have once placed there.
 func ingestLink(
-	jobID int, opts *Options, dirname string, paths []string, meta []*fileMetadata, shared []bool,
+	jobID int,
+	opts *Options,
+	dirname string,
+	paths []string,
+	meta []*fileMetadata,
+	shared []bool,
+	upload []bool,
 ) error {
 	// Wrap the normal filesystem with one which wraps newly created files with
 	// vfs.NewSyncingFile.
@@ -363,14 +408,24 @@ func ingestLink(
 		} else {
 			err = vfs.LinkOrCopy(fs, paths[i], target)
 		}
-		if err == nil && opts.SharedFS != nil {
-			sharedTarget := base.MakeSharedSSTPath(opts.SharedFS, opts.SharedDir, opts.UniqueID, meta[i].PhysicalFileNum)
+		linked := meta[:i]
+		if err == nil && upload != nil && upload[i] {
+			// Ingest removes the copy if the sstable ends up placed locally or
+			// the ingestion fails.
+			linked = meta[:i+1]
+			sharedTarget := base.MakeSharedSSTPath(opts.SharedFS, opts.SharedDir, opts.UniqueID, meta[i].FileNum)
 			err = vfs.CopyAcrossFS(fs, paths[i], opts.SharedFS, sharedTarget)
 		}
 		if err != nil {
-			if err2 := ingestCleanup(fs, dirname, meta[:i]); err2 != nil {
+			if err2 := ingestCleanup(fs, dirname, linked); err2 != nil {
 				opts.Logger.Infof("ingest cleanup failed: %v", err2)
 			}
+			if upload != nil {
+				// The copy of the current sstable may be partially written.
+				if err2 := ingestCleanupShared(opts, meta[:i+1], upload); err2 != nil {
+					opts.Logger.Infof("ingest cleanup of shared storage failed: %v", err2)
+				}
+			}
 			return err
 		}
 		if opts.EventListener.TableCreated != nil {
@@ -772,12 +827,26 @@ func (d *DB) ingest(
 		return IngestOperationStats{}, err
 	}
 
+	// Decide where the sstables are placed, so that only the sstables placed
+	// on shared storage are uploaded to it.
+	placements, err := d.ingestPlace(meta, shared, targetLevelFunc)
+	if err != nil {
+		return IngestOperationStats{}, err
+	}
+	var upload []bool
+	if placements != nil {
+		upload = make([]bool, len(meta))
+		for i := range placements {
+			upload[i] = placements[i].placement == PlacementShared
+		}
+	}
+
 	// Hard link the sstables into the DB directory. Since the sstables aren't
 	// referenced by a version, they won't be used. If the hard linking fails
 	// (e.g. because the files reside on a different filesystem), ingestLink will
 	// fall back to copying, and if that fails we undo our work and return an
 	// error.
-	if err := ingestLink(jobID, d.opts, d.dirname, paths, meta, shared); err != nil {
+	if err := ingestLink(jobID, d.opts, d.dirname, paths, meta, shared, upload); err != nil {
 		return IngestOperationStats{}, err
 	}
 	// Fsync the directory we added the tables to. We need to do this at some
@@ -785,6 +854,14 @@ func (d *DB) ingest(
 	// can have the tables referenced in the MANIFEST, but not present in the
 	// directory.
 	if err := d.dataDir.Sync(); err != nil {
+		if err2 := ingestCleanup(d.opts.FS, d.dirname, meta); err2 != nil {
+			d.opts.Logger.Infof("ingest cleanup failed: %v", err2)
+		}
+		if upload != nil {
+			if err2 := ingestCleanupShared(d.opts, meta, upload); err2 != nil {
+				d.opts.Logger.Infof("ingest cleanup of shared storage failed: %v", err2)
+			}
+		}
 		return IngestOperationStats{}, err
 	}
 
@@ -838,7 +915,7 @@ func (d *DB) ingest(
 
 		// Assign the sstables to the correct level in the LSM and apply the
 		// version edit.
-		ve, err = d.ingestApply(jobID, meta, targetLevelFunc)
+		ve, err = d.ingestApply(jobID, meta, shared, placements, targetLevelFunc)
 	}
 
 	d.commit.AllocateSeqNum(len(meta), prepare, apply)
@@ -847,6 +924,11 @@ func (d *DB) ingest(
 		if err2 := ingestCleanup(d.opts.FS, d.dirname, meta); err2 != nil {
 			d.opts.Logger.Infof("ingest cleanup failed: %v", err2)
 		}
+		if upload != nil {
+			if err2 := ingestCleanupShared(d.opts, meta, upload); err2 != nil {
+				d.opts.Logger.Infof("ingest cleanup of shared storage failed: %v", err2)
+			}
+		}
 	} else {
 		for i, path := range paths {
 			// No removal for shared ssts
@@ -858,6 +940,17 @@ func (d *DB) ingest(
 				d.opts.Logger.Infof("ingest failed to remove original file: %s", err2)
 			}
 		}
+		if upload != nil {
+			// The copies made in shared storage of the sstables that ended up
+			// placed locally are not referenced.
+			unused := make([]bool, len(meta))
+			for i := range meta {
+				unused[i] = upload[i] && !meta[i].IsShared
+			}
+			if err2 := ingestCleanupShared(d.opts, meta, unused); err2 != nil {
+				d.opts.Logger.Infof("ingest failed to remove shared copy: %s", err2)
+			}
+		}
 	}
 
 	info := TableIngestInfo{
@@ -896,8 +989,51 @@ type ingestTargetLevelFunc func(
 	meta *fileMetadata,
 ) (int, error)
 
+// ingestPlacement is the placement decided for an ingested sstable before it
+// is linked, and the level it was decided for.
+type ingestPlacement struct {
+	level     int
+	placement TablePlacement
+}
+
+// ingestPlace decides where each of the sstables in meta is stored, returning
+// nil if the DB has no shared storage. The placement depends on the level the
+// sstable is ingested into, which is only final once the sstables are applied
+// by ingestApply, but it must be known before the sstables are linked, so that
+// only the sstables placed on shared storage are uploaded to it. The level is
+// therefore predicted from the current version. Foreign shared sstables are
+// skipped.
+func (d *DB) ingestPlace(
+	meta []*fileMetadata, shared []bool, findTargetLevel ingestTargetLevelFunc,
+) ([]ingestPlacement, error) {
+	if d.opts.SharedFS == nil {
+		return nil, nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	current := d.mu.versions.currentVersion()
+	baseLevel := d.mu.versions.picker.getBaseLevel()
+	iterOps := IterOptions{logger: d.opts.Logger}
+	placements := make([]ingestPlacement, len(meta))
+	for i, m := range meta {
+		if shared[i] {
+			continue
+		}
+		level, err := findTargetLevel(d.newIters, iterOps, d.cmp, current, baseLevel, d.mu.compact.inProgress, m)
+		if err != nil {
+			return nil, err
+		}
+		placements[i] = ingestPlacement{level: level, placement: d.placeTable(m, level, "ingesting", "")}
+	}
+	return placements, nil
+}
+
 func (d *DB) ingestApply(
-	jobID int, meta []*fileMetadata, findTargetLevel ingestTargetLevelFunc,
+	jobID int,
+	meta []*fileMetadata,
+	shared []bool,
+	placements []ingestPlacement,
+	findTargetLevel ingestTargetLevelFunc,
 ) (*versionEdit, error) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
@@ -929,15 +1065,53 @@ func (d *DB) ingestApply(
 			d.mu.versions.logUnlock()
 			return nil, err
 		}
-		if f.Level >= sharedLevel && d.opts.SharedFS != nil {
+		if shared[i] {
+			// The keys of foreign shared sstables are read with the sequence
+			// numbers reserved for L5 and L6, so they must be ingested there.
+			if f.Level < sharedLevel {
+				d.mu.versions.logUnlock()
+				return nil, errors.Errorf(
+					"pebble: shared sstable %s cannot be ingested into L%d", m, f.Level)
+			}
+			// Shared sstables only exist in shared storage, regardless of the
+			// level they're ingested into.
 			m.IsShared = true
-			obsoleteFiles = append(obsoleteFiles, obsoleteFile{
-				dir:         d.dirname,
-				fileNum:     m.FileNum,
-				fileType:    fileTypeTable,
-				fileSize:    m.Size,
-				skipMetrics: true,
-			})
+		} else {
+			// Only the sstables placed on shared storage by ingestPlace were
+			// uploaded to it. If the sstable ends up in a different level than
+			// predicted, the placement is decided again, but it can only stay
+			// local if it was not uploaded.
+			placement := PlacementLocal
+			if placements != nil && placements[i].placement == PlacementShared {
+				placement = PlacementShared
+				if f.Level != placements[i].level {
+					placement = d.placeTable(m, f.Level, "ingesting", "")
+				}
+			}
+			path := base.MakeFilepath(d.opts.FS, d.dirname, fileTypeTable, m.FileNum)
+			if placement == PlacementShared {
+				// The sstable was uploaded to shared storage by ingestLink. The
+				// local link is no longer needed.
+				setSharedSSTMetadata(m, d.opts.UniqueID)
+				m.IsShared = true
+				obsoleteFiles = append(obsoleteFiles, obsoleteFile{
+					dir:         d.dirname,
+					fileNum:     m.FileNum,
+					fileType:    fileTypeTable,
+					fileSize:    m.Size,
+					skipMetrics: true,
+				})
+				path = base.MakeSharedSSTPath(d.opts.SharedFS, d.opts.SharedDir, m.CreatorUniqueID, m.PhysicalFileNum)
+			}
+			if d.opts.SharedFS != nil {
+				d.opts.EventListener.TablePlaced(TablePlaceInfo{
+					JobID:     jobID,
+					Reason:    "ingesting",
+					Path:      path,
+					FileNum:   m.FileNum,
+					Placement: placement,
+				})
+			}
 		}
 		f.Meta = m
 		levelMetrics := metrics[f.Level]
@@ -958,12 +1132,13 @@ func (d *DB) ingestApply(
 	d.updateReadStateLocked(d.opts.DebugCheck)
 	d.updateTableStatsLocked(ve.NewFiles)
 	d.deleters.Add(1)
-	go d.paceAndDeleteObsoleteFiles(jobID, obsoleteFiles)
+	go d.paceAndDeleteObsoleteFiles(jobID, obsoleteFiles, d.deletionPacerLocked())
 	d.deleteObsoleteFiles(jobID, false /* waitForOngoing */)
 	// The ingestion may have pushed a level over the threshold for compaction,
 	// so check to see if one is necessary and schedule it.
 	d.maybeScheduleCompaction()
 	d.maybeValidateSSTablesLocked(ve.NewFiles)
+	d.maybeWarmSharedTablesLocked(ve.NewFiles)
 	return ve, nil
 }
 
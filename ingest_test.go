@@ -288,7 +288,7 @@ func TestIngestLink(t *testing.T) {
 				mem.Remove(paths[i])
 			}
 
-			err := ingestLink(0 /* jobID */, opts, dir, paths, meta, shared)
+			err := ingestLink(0 /* jobID */, opts, dir, paths, meta, shared, nil)
 			if i < count {
 				if err == nil {
 					t.Fatalf("expected error, but found success")
@@ -348,7 +348,7 @@ func TestIngestLinkFallback(t *testing.T) {
 	opts.EnsureDefaults()
 
 	meta := []*fileMetadata{{FileNum: 1}}
-	require.NoError(t, ingestLink(0, opts, "", []string{"source"}, meta, []bool{false}))
+	require.NoError(t, ingestLink(0, opts, "", []string{"source"}, meta, []bool{false}, nil))
 
 	dest, err := mem.Open("000001.sst")
 	require.NoError(t, err)
@@ -1048,6 +1048,46 @@ func TestIngestStats(t *testing.T) {
 	require.NoError(t, d.Close())
 }
 
+func TestIngestDataBlockHashIndex(t *testing.T) {
+	mem := vfs.NewMem()
+	d, err := Open("", &Options{
+		FS:                 mem,
+		FormatMajorVersion: FormatBlobFiles,
+	})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	build := func(path string) {
+		f, err := mem.Create(path)
+		require.NoError(t, err)
+		w := sstable.NewWriter(f, sstable.WriterOptions{
+			TableFormat:        sstable.TableFormatPebblev3,
+			DataBlockHashIndex: true,
+		})
+		for _, k := range []string{"a", "b", "c"} {
+			require.NoError(t, w.Set([]byte(k), []byte(k)))
+		}
+		require.NoError(t, w.Close())
+	}
+
+	// Tables with hash indexes are rejected until the format major version
+	// allows them.
+	build("ext1")
+	err = d.Ingest([]string{"ext1"}, nil)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "unsupported at DB format major version")
+
+	require.NoError(t, d.RatchetFormatMajorVersion(FormatDataBlockHashIndex))
+	build("ext2")
+	require.NoError(t, d.Ingest([]string{"ext2"}, nil))
+	for _, k := range []string{"a", "b", "c"} {
+		v, closer, err := d.Get([]byte(k))
+		require.NoError(t, err)
+		require.Equal(t, k, string(v))
+		require.NoError(t, closer.Close())
+	}
+}
+
 func TestIngestFlushQueuedLargeBatch(t *testing.T) {
 	// Verify that ingestion forces a flush of a queued large batch.
 
@@ -315,6 +315,56 @@ func (s *Skiplist) NewFlushIter(bytesFlushed *uint64) base.InternalIterator {
 	}
 }
 
+// Sample calls fn with a sample of the entries of the skiplist whose user keys
+// are within [lower, upper], along with the number of entries each sampled
+// entry stands for. Since the height of every node is chosen at random, the
+// nodes whose towers reach a level are a random sample of all of the nodes,
+// each standing for (1/pValue)^level nodes. The entries sampled are those of
+// the lowest level with at most maxSamples nodes within the bounds, so all of
+// the entries are passed to fn, with a weight of 1, if there are at most
+// maxSamples of them.
+func (s *Skiplist) Sample(
+	lower, upper []byte, maxSamples int, fn func(key base.InternalKey, value []byte, weight float64),
+) {
+	// Find the first node within the bounds at each level, from the top
+	// down, until a level has more than maxSamples nodes within the bounds.
+	var starts [maxHeight]*node
+	height := int(s.Height())
+	level := height - 1
+	searchKey := base.MakeSearchKey(lower)
+	prev := s.head
+	for h := height - 1; h >= 0; h-- {
+		prev, starts[h], _ = s.findSpliceForLevel(searchKey, h, prev)
+		if s.countNodes(starts[h], h, upper, maxSamples+1) > maxSamples {
+			break
+		}
+		level = h
+	}
+
+	weight := math.Pow(1/pValue, float64(level))
+	for nd := starts[level]; nd != s.tail; nd = s.getNext(nd, level) {
+		key := base.DecodeInternalKey(nd.getKeyBytes(s.arena))
+		if s.cmp(key.UserKey, upper) > 0 {
+			break
+		}
+		fn(key, nd.getValue(s.arena), weight)
+	}
+}
+
+// countNodes returns the number of nodes at the given level, starting at nd,
+// whose user keys are at most upper. It stops counting at limit.
+func (s *Skiplist) countNodes(nd *node, level int, upper []byte, limit int) int {
+	var n int
+	for ; nd != s.tail && n < limit; nd = s.getNext(nd, level) {
+		key := base.DecodeInternalKey(nd.getKeyBytes(s.arena))
+		if s.cmp(key.UserKey, upper) > 0 {
+			break
+		}
+		n++
+	}
+	return n
+}
+
 func (s *Skiplist) newNode(
 	key base.InternalKey, value []byte,
 ) (nd *node, height uint32, err error) {
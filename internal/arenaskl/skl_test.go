@@ -502,6 +502,39 @@ func TestIteratorNext(t *testing.T) {
 	require.False(t, it.Valid())
 }
 
+// TestSample tests that the entries sampled within bounds estimate their
+// number.
+func TestSample(t *testing.T) {
+	const n = 10000
+	l := NewSkiplist(newArena(arenaSize), bytes.Compare)
+	for i := 0; i < n; i++ {
+		require.NoError(t, l.Add(makeIntKey(i), makeValue(i)))
+	}
+	lower, upper := makeIntKey(1000).UserKey, makeIntKey(8999).UserKey
+
+	sample := func(maxSamples int) (samples int, total float64) {
+		l.Sample(lower, upper, maxSamples, func(key base.InternalKey, value []byte, weight float64) {
+			require.True(t, bytes.Compare(key.UserKey, lower) >= 0)
+			require.True(t, bytes.Compare(key.UserKey, upper) <= 0)
+			samples++
+			total += weight
+		})
+		return samples, total
+	}
+
+	// All of the entries are sampled if there are at most maxSamples.
+	samples, total := sample(n)
+	require.Equal(t, 8000, samples)
+	require.Equal(t, float64(8000), total)
+
+	// Otherwise, at most maxSamples are sampled, and their weights add up to
+	// an estimate of the number of entries.
+	samples, total = sample(500)
+	require.LessOrEqual(t, samples, 500)
+	require.Greater(t, samples, 0)
+	require.InDelta(t, 8000, total, 4000)
+}
+
 // TestIteratorPrev tests a basic iteration over all nodes from the end.
 func TestIteratorPrev(t *testing.T) {
 	const n = 100
@@ -33,6 +33,7 @@ const (
 	FileTypeOptions
 	FileTypeOldTemp
 	FileTypeTemp
+	FileTypeBlob
 )
 
 // MakeFilename builds a filename from components.
@@ -54,6 +55,8 @@ func MakeFilename(fileType FileType, fileNum FileNum) string {
 		return fmt.Sprintf("CURRENT.%s.dbtmp", fileNum)
 	case FileTypeTemp:
 		return fmt.Sprintf("temporary.%s.dbtmp", fileNum)
+	case FileTypeBlob:
+		return fmt.Sprintf("%s.blob", fileNum)
 	}
 	panic("unreachable")
 }
@@ -118,6 +121,8 @@ func ParseFilename(fs vfs.FS, filename string) (fileType FileType, fileNum FileN
 			return FileTypeTable, fileNum, true
 		case "log":
 			return FileTypeLog, fileNum, true
+		case "blob":
+			return FileTypeBlob, fileNum, true
 		}
 	}
 	return 0, fileNum, false
@@ -23,6 +23,8 @@ func TestParseFilename(t *testing.T) {
 		"abcdef.log":             false,
 		"000001ldb":              false,
 		"000001.sst":             true,
+		"000001.blob":            true,
+		"000001.blobs":           false,
 		"CURRENT":                true,
 		"CURRaNT":                false,
 		"LOCK":                   true,
@@ -64,6 +66,7 @@ func TestFilenameRoundTrip(t *testing.T) {
 		FileTypeOptions:  true,
 		FileTypeOldTemp:  true,
 		FileTypeTemp:     true,
+		FileTypeBlob:     true,
 	}
 	fs := vfs.NewMem()
 	for fileType, numbered := range testCases {
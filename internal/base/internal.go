@@ -60,6 +60,12 @@ const (
 	InternalKeyKindRangeKeyUnset InternalKeyKind = 20
 	InternalKeyKindRangeKeySet   InternalKeyKind = 21
 
+	// InternalKeyKindBlobSet keys are SET keys whose value is stored in a blob
+	// file. The value stored with the key is a handle to the value in the blob
+	// file. A BLOBSET may have met with a DELETE or SINGLEDEL key in a prior
+	// compaction, like a SETWITHDEL key. This key kind is specific to Pebble.
+	InternalKeyKindBlobSet InternalKeyKind = 22
+
 	// This maximum value isn't part of the file format. It's unlikely,
 	// but future extensions may increase this value.
 	//
@@ -69,7 +75,7 @@ const (
 	// which sorts 'less than or equal to' any other valid internalKeyKind, when
 	// searching for any kind of internal key formed by a certain user key and
 	// seqNum.
-	InternalKeyKindMax InternalKeyKind = 21
+	InternalKeyKindMax InternalKeyKind = 22
 
 	// A marker for an invalid key.
 	InternalKeyKindInvalid InternalKeyKind = 255
@@ -107,6 +113,7 @@ var internalKeyKindNames = []string{
 	InternalKeyKindRangeKeySet:    "RANGEKEYSET",
 	InternalKeyKindRangeKeyUnset:  "RANGEKEYUNSET",
 	InternalKeyKindRangeKeyDelete: "RANGEKEYDEL",
+	InternalKeyKindBlobSet:        "BLOBSET",
 	InternalKeyKindInvalid:        "INVALID",
 }
 
@@ -191,6 +198,7 @@ var kindsMap = map[string]InternalKeyKind{
 	"RANGEKEYSET":   InternalKeyKindRangeKeySet,
 	"RANGEKEYUNSET": InternalKeyKindRangeKeyUnset,
 	"RANGEKEYDEL":   InternalKeyKindRangeKeyDelete,
+	"BLOBSET":       InternalKeyKindBlobSet,
 }
 
 // ParseInternalKey parses the string representation of an internal key. The
@@ -330,6 +330,9 @@ type InternalIteratorStats struct {
 	// can be useful for discovering instances of
 	// https://github.com/cockroachdb/pebble/issues/1070.
 	PointsCoveredByRangeTombstones uint64
+	// The count of seeks within data blocks that were positioned using the
+	// blocks' hash indexes.
+	HashIndexSeeks uint64
 }
 
 // Merge merges the stats in from into the given stats.
@@ -340,6 +343,7 @@ func (s *InternalIteratorStats) Merge(from InternalIteratorStats) {
 	s.ValueBytes += from.ValueBytes
 	s.PointCount += from.PointCount
 	s.PointsCoveredByRangeTombstones += from.PointsCoveredByRangeTombstones
+	s.HashIndexSeeks += from.HashIndexSeeks
 }
 
 type internalIteratorWithEmptyStats struct {
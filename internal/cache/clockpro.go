@@ -627,6 +627,12 @@ type Metrics struct {
 // file numbers are never reused, (fileNum,offset) are unique for the lifetime
 // of a Pebble instance.
 //
+// Blocks of sstables that live in shared storage are instead keyed by the
+// physical object backing them: the ID is derived from the creator's unique ID
+// via SharedObjectID and the fileNum is the object's physical file number.
+// Every virtual table backed by the same object, in any Pebble instance
+// sharing the Cache, then reads the same cached blocks.
+//
 // In addition to maintaining a map from (fileNum,offset) to data, each shard
 // maintains a map of the cached blocks for a particular fileNum. This allows
 // efficient eviction of all of the blocks for a file which is used when an
@@ -881,3 +887,17 @@ func (c *Cache) Metrics() Metrics {
 func (c *Cache) NewID() uint64 {
 	return atomic.AddUint64(&c.idAlloc, 1)
 }
+
+// sharedObjectIDBit is set in every ID returned by SharedObjectID. IDs
+// allocated by Cache.NewID never reach this bit, so the two namespaces are
+// disjoint.
+const sharedObjectIDBit = uint64(1) << 63
+
+// SharedObjectID returns the ID used as the namespace for cached blocks of
+// shared sstables created by the Pebble instance with the given unique ID.
+// Unlike the IDs returned by Cache.NewID, the result is the same for every
+// Cache and every Pebble instance, which allows the blocks of a shared object
+// to be cached once regardless of how many virtual tables reference it.
+func SharedObjectID(creatorUniqueID uint32) uint64 {
+	return sharedObjectIDBit | uint64(creatorUniqueID)
+}
@@ -157,6 +157,39 @@ func TestMultipleDBs(t *testing.T) {
 	}
 }
 
+func TestSharedObjectID(t *testing.T) {
+	cache := newShards(100, 1)
+	defer cache.Unref()
+
+	// IDs of shared objects must not collide with per-DB IDs, and must be the
+	// same no matter which DB computes them.
+	dbID := cache.NewID()
+	sharedID := SharedObjectID(uint32(dbID))
+	if sharedID == dbID {
+		t.Fatalf("shared object ID %d collides with DB ID", sharedID)
+	}
+	if other := SharedObjectID(uint32(dbID)); other != sharedID {
+		t.Fatalf("expected %d, but found %d", sharedID, other)
+	}
+	if other := SharedObjectID(uint32(dbID) + 1); other == sharedID {
+		t.Fatalf("expected distinct IDs for distinct creators, but found %d", other)
+	}
+
+	cache.Set(sharedID, 7, 0, testValue(cache, "a", 5), false).Release()
+	cache.Set(dbID, 7, 0, testValue(cache, "b", 5), false).Release()
+	h := cache.Get(SharedObjectID(uint32(dbID)), 7, 0, false)
+	if v := h.Get(); string(v) != "aaaaa" {
+		t.Fatalf("expected aaaaa, but found %s", v)
+	}
+	h.Release()
+	cache.EvictFile(dbID, 7)
+	h = cache.Get(sharedID, 7, 0, false)
+	if v := h.Get(); string(v) != "aaaaa" {
+		t.Fatalf("expected aaaaa, but found %s", v)
+	}
+	h.Release()
+}
+
 func TestZeroSize(t *testing.T) {
 	cache := newShards(0, 1)
 	defer cache.Unref()
@@ -0,0 +1,235 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package errorfs
+
+import (
+	"math/rand"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/vfs"
+)
+
+// SharedStoreOptions configures the faults injected by a SharedStore.
+type SharedStoreOptions struct {
+	// Seed seeds the pseudorandom number generator deciding which operations
+	// fail. For a given seed, the same sequence of operations sees the same
+	// faults.
+	Seed int64
+	// FirstByteLatency is the delay incurred by the first read of every opened
+	// object, modeling the time-to-first-byte of a GET request.
+	FirstByteLatency time.Duration
+	// ReadErrorProbability is the probability with which a ReadAt fails with a
+	// transient error, modeling a 5xx response. Retrying the read succeeds
+	// unless another error is injected.
+	ReadErrorProbability float64
+	// GhostWriteProbability is the probability with which an upload is
+	// persisted remotely, but Sync or Close reports a failure, modeling a
+	// request whose response was lost.
+	GhostWriteProbability float64
+	// ListLag is the number of List calls for which a newly created object is
+	// not yet listed, modeling an eventually consistent listing.
+	ListLag int
+}
+
+// SharedStoreMetrics counts the faults injected by a SharedStore.
+type SharedStoreMetrics struct {
+	ReadErrors  int64
+	GhostWrites int64
+	SlowReads   int64
+	HiddenLists int64
+}
+
+// SharedStore implements vfs.FS on top of another vfs.FS, emulating the
+// semantics and partial failures of an object store used as Options.SharedFS:
+//
+//   - Objects have no directories. Creating an object implicitly creates its
+//     parent directories.
+//   - The first read of an opened object is delayed.
+//   - Reads fail transiently.
+//   - Uploads are persisted, but reported as failed.
+//   - Listings do not immediately reflect newly created objects.
+//
+// All injected errors wrap ErrInjected.
+type SharedStore struct {
+	vfs.FS
+	opts SharedStoreOptions
+
+	mu struct {
+		sync.Mutex
+		rng *rand.Rand
+		// unlisted maps the names of recently created objects to the number of
+		// List calls that will still omit them.
+		unlisted map[string]int
+		metrics  SharedStoreMetrics
+	}
+}
+
+var _ vfs.FS = (*SharedStore)(nil)
+
+// NewSharedStore wraps fs, returning a SharedStore injecting faults as
+// configured by opts.
+func NewSharedStore(fs vfs.FS, opts SharedStoreOptions) *SharedStore {
+	s := &SharedStore{FS: fs, opts: opts}
+	s.mu.rng = rand.New(rand.NewSource(opts.Seed))
+	s.mu.unlisted = make(map[string]int)
+	return s
+}
+
+// Unwrap returns the FS implementation underlying s.
+// See pebble/vfs.Root.
+func (s *SharedStore) Unwrap() vfs.FS {
+	return s.FS
+}
+
+// Metrics returns the number of faults injected so far.
+func (s *SharedStore) Metrics() SharedStoreMetrics {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.mu.metrics
+}
+
+// maybe returns true with probability p, incrementing the counter if so.
+func (s *SharedStore) maybe(p float64, counter *int64) bool {
+	if p <= 0 {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.mu.rng.Float64() < p {
+		*counter++
+		return true
+	}
+	return false
+}
+
+// Create implements FS.Create.
+func (s *SharedStore) Create(name string) (vfs.File, error) {
+	if err := s.FS.MkdirAll(s.FS.PathDir(name), 0755); err != nil {
+		return nil, err
+	}
+	f, err := s.FS.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	if s.opts.ListLag > 0 {
+		s.mu.Lock()
+		s.mu.unlisted[name] = s.opts.ListLag
+		s.mu.Unlock()
+	}
+	return &sharedStoreFile{File: f, store: s, name: name}, nil
+}
+
+// Open implements FS.Open.
+func (s *SharedStore) Open(name string, opts ...vfs.OpenOption) (vfs.File, error) {
+	f, err := s.FS.Open(name, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &sharedStoreFile{File: f, store: s, name: name, slow: s.opts.FirstByteLatency > 0}, nil
+}
+
+// Remove implements FS.Remove.
+func (s *SharedStore) Remove(name string) error {
+	s.mu.Lock()
+	delete(s.mu.unlisted, name)
+	s.mu.Unlock()
+	return s.FS.Remove(name)
+}
+
+// List implements FS.List. Objects created less than ListLag List calls ago
+// are omitted.
+func (s *SharedStore) List(dir string) ([]string, error) {
+	names, err := s.FS.List(dir)
+	if err != nil || s.opts.ListLag == 0 {
+		return names, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hidden := make(map[string]bool)
+	for name, n := range s.mu.unlisted {
+		if s.FS.PathDir(name) == dir {
+			hidden[s.FS.PathBase(name)] = true
+		}
+		if n <= 1 {
+			delete(s.mu.unlisted, name)
+		} else {
+			s.mu.unlisted[name] = n - 1
+		}
+	}
+	visible := names[:0]
+	for _, name := range names {
+		if hidden[name] {
+			s.mu.metrics.HiddenLists++
+			continue
+		}
+		visible = append(visible, name)
+	}
+	sort.Strings(visible)
+	return visible, nil
+}
+
+// MkdirAll implements FS.MkdirAll.
+func (s *SharedStore) MkdirAll(dir string, perm os.FileMode) error {
+	return s.FS.MkdirAll(dir, perm)
+}
+
+type sharedStoreFile struct {
+	vfs.File
+	store *SharedStore
+	name  string
+	// slow is true until the first read of an opened object.
+	slow bool
+	// ghost is set once a ghost write has been reported, so that the failure
+	// is reported once per upload.
+	ghost bool
+}
+
+func (f *sharedStoreFile) maybeDelay() {
+	if f.slow {
+		f.slow = false
+		f.store.mu.Lock()
+		f.store.mu.metrics.SlowReads++
+		f.store.mu.Unlock()
+		time.Sleep(f.store.opts.FirstByteLatency)
+	}
+}
+
+func (f *sharedStoreFile) Read(p []byte) (int, error) {
+	f.maybeDelay()
+	return f.File.Read(p)
+}
+
+func (f *sharedStoreFile) ReadAt(p []byte, off int64) (int, error) {
+	f.maybeDelay()
+	if f.store.maybe(f.store.opts.ReadErrorProbability, &f.store.mu.metrics.ReadErrors) {
+		return 0, errors.WithStack(ErrInjected)
+	}
+	return f.File.ReadAt(p, off)
+}
+
+// maybeGhostWrite returns an injected error after err == nil, i.e. after the
+// upload was persisted by the underlying FS.
+func (f *sharedStoreFile) maybeGhostWrite(err error) error {
+	if err != nil || f.ghost {
+		return err
+	}
+	if f.store.maybe(f.store.opts.GhostWriteProbability, &f.store.mu.metrics.GhostWrites) {
+		f.ghost = true
+		return errors.Wrapf(ErrInjected, "upload of %s", f.name)
+	}
+	return nil
+}
+
+func (f *sharedStoreFile) Sync() error {
+	return f.maybeGhostWrite(f.File.Sync())
+}
+
+func (f *sharedStoreFile) Close() error {
+	return f.maybeGhostWrite(f.File.Close())
+}
@@ -208,6 +208,10 @@ type FileMetadata struct {
 	// can read
 	FileSmallest InternalKey
 	FileLargest  InternalKey
+
+	// BlobFiles are the file numbers of the blob files that store the values
+	// of the table's BLOBSET keys, in increasing order.
+	BlobFiles []base.FileNum
 }
 
 // ExtendPointKeyBounds attempts to extend the lower and upper point key bounds
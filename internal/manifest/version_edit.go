@@ -56,6 +56,7 @@ const (
 	customTagCreationTime      = 6
 	customTagIsShared          = 7
 	customTagPathID            = 65
+	customTagBlobFiles         = 66
 	customTagNonSafeIgnoreMask = 1 << 6
 )
 
@@ -272,6 +273,7 @@ func (v *VersionEdit) Decode(r io.Reader) error {
 			var creationTime uint64
 			var creatorUniqueID uint64
 			var physicalFileNum uint64
+			var blobFiles []base.FileNum
 			if tag == tagNewFile4 || tag == tagNewFile5 {
 				for {
 					customTag, err := d.readUvarint()
@@ -330,6 +332,16 @@ func (v *VersionEdit) Decode(r io.Reader) error {
 							return err
 						}
 
+					case customTagBlobFiles:
+						for len(field) > 0 {
+							fileNum, n := binary.Uvarint(field)
+							if n <= 0 {
+								return base.CorruptionErrorf("new-file4: invalid blob files")
+							}
+							blobFiles = append(blobFiles, base.FileNum(fileNum))
+							field = field[n:]
+						}
+
 					default:
 						if (customTag & customTagNonSafeIgnoreMask) != 0 {
 							return base.CorruptionErrorf("new-file4: custom field not supported: %d", customTag)
@@ -344,6 +356,7 @@ func (v *VersionEdit) Decode(r io.Reader) error {
 				SmallestSeqNum:      smallestSeqNum,
 				LargestSeqNum:       largestSeqNum,
 				MarkedForCompaction: markedForCompaction,
+				BlobFiles:           blobFiles,
 			}
 			if tag != tagNewFile5 { // no range keys present
 				m.SmallestPointKey = base.DecodeInternalKey(smallestPointKey)
@@ -437,7 +450,8 @@ func (v *VersionEdit) Encode(w io.Writer) error {
 		e.writeUvarint(uint64(x.FileNum))
 	}
 	for _, x := range v.NewFiles {
-		customFields := x.Meta.MarkedForCompaction || x.Meta.CreationTime != 0 || x.Meta.IsShared
+		customFields := x.Meta.MarkedForCompaction || x.Meta.CreationTime != 0 || x.Meta.IsShared ||
+			len(x.Meta.BlobFiles) > 0
 		var tag uint64
 		switch {
 		case x.Meta.HasRangeKeys:
@@ -498,6 +512,16 @@ func (v *VersionEdit) Encode(w io.Writer) error {
 				e.writeKey(x.Meta.FileSmallest)
 				e.writeKey(x.Meta.FileLargest)
 			}
+			if len(x.Meta.BlobFiles) > 0 {
+				e.writeUvarint(customTagBlobFiles)
+				var field []byte
+				var buf [binary.MaxVarintLen64]byte
+				for _, fileNum := range x.Meta.BlobFiles {
+					n := binary.PutUvarint(buf[:], uint64(fileNum))
+					field = append(field, buf[:n]...)
+				}
+				e.writeBytes(field)
+			}
 			e.writeUvarint(customTagTerminate)
 		}
 	}
@@ -58,6 +58,7 @@ func TestVersionEditRoundTrip(t *testing.T) {
 		SmallestSeqNum:      3,
 		LargestSeqNum:       5,
 		MarkedForCompaction: true,
+		BlobFiles:           []base.FileNum{801, 803},
 	}).ExtendPointKeyBounds(
 		cmp,
 		base.DecodeInternalKey([]byte("A\x00\x01\x02\x03\x04\x05\x06\x07")),
@@ -15,6 +15,7 @@ const (
 	dbClose
 	dbCompact
 	dbFlush
+	dbReplicate
 	dbRestart
 	iterClose
 	iterFirst
@@ -81,6 +82,7 @@ func defaultConfig() config {
 			dbCheckpoint:         1,
 			dbCompact:            1,
 			dbFlush:              2,
+			dbReplicate:          1,
 			dbRestart:            2,
 			iterClose:            5,
 			iterFirst:            100,
@@ -124,6 +126,17 @@ func defaultConfig() config {
 	}
 }
 
+// withoutRangeKeys returns a copy of the config that generates no range key
+// operations. Shared sstables cannot hold range keys, so only workloads without
+// them can be exported through DB.ExportSharedSSTs.
+func (c config) withoutRangeKeys() config {
+	c.ops = append([]int(nil), c.ops...)
+	c.ops[writerRangeKeySet] = 0
+	c.ops[writerRangeKeyUnset] = 0
+	c.ops[writerRangeKeyDelete] = 0
+	return c
+}
+
 func mustDynamic(dyn randvar.Dynamic, err error) randvar.Dynamic {
 	if err != nil {
 		panic(err)
@@ -92,6 +92,7 @@ func generate(rng *rand.Rand, count uint64, cfg config, km *keyManager) []op {
 		dbCheckpoint:         g.dbCheckpoint,
 		dbCompact:            g.dbCompact,
 		dbFlush:              g.dbFlush,
+		dbReplicate:          g.dbReplicate,
 		dbRestart:            g.dbRestart,
 		iterClose:            g.iterClose,
 		iterFirst:            g.iterFirst,
@@ -448,6 +449,19 @@ func (g *generator) dbFlush() {
 	g.add(&flushOp{})
 }
 
+func (g *generator) dbReplicate() {
+	// Generate new key(s) with a 1% probability.
+	start := g.randKeyToRead(0.01)
+	end := g.randKeyToRead(0.01)
+	if g.cmp(start, end) > 0 {
+		start, end = end, start
+	}
+	g.add(&replicateOp{
+		start: start,
+		end:   end,
+	})
+}
+
 func (g *generator) dbRestart() {
 	// Close any live iterators and snapshots, so that we can close the DB
 	// cleanly.
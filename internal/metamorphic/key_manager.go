@@ -462,6 +462,7 @@ func opWrittenKeys(untypedOp op) [][]byte {
 	case *deleteRangeOp:
 		return [][]byte{t.start, t.end}
 	case *flushOp:
+	case *replicateOp:
 	case *getOp:
 	case *ingestOp:
 	case *initOp:
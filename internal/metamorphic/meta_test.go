@@ -179,6 +179,34 @@ func testMetaRun(t *testing.T, runDir string, seed uint64, historyPath string) {
 		opts.WALDir = opts.FS.PathJoin(runDir, opts.WALDir)
 	}
 
+	// Set up a shared store that injects the partial failures of an object
+	// store. Reads fail with the same *errorRate probability as local reads.
+	// Failed uploads are retried or leave tables on the local filesystem, so
+	// they do not affect the history.
+	if testOpts.sharedFS {
+		opts.SharedFS = errorfs.NewSharedStore(vfs.NewMem(), errorfs.SharedStoreOptions{
+			Seed:                  int64(seed),
+			FirstByteLatency:      10 * time.Microsecond,
+			ReadErrorProbability:  *errorRate,
+			GhostWriteProbability: 0.1,
+			ListLag:               2,
+		})
+		opts.PersistentCacheSize = testOpts.persistentCacheSize
+		opts.WarmSharedTables = testOpts.warmSharedTables
+		opts.WarmSharedTablesBytes = testOpts.warmSharedTablesBytes
+		if testOpts.remoteCompactions {
+			// The worker writes the same tables as the DB, but uses a
+			// separate scratch filesystem.
+			workerOpts := opts.Clone()
+			workerOpts.FS = vfs.NewMem()
+			workerOpts.EventListener = pebble.EventListener{}
+			opts.CompactionExecutor = &pebble.InProcessCompactionExecutor{
+				Dirname: "worker",
+				Options: workerOpts,
+			}
+		}
+	}
+
 	historyFile, err := os.Create(historyPath)
 	require.NoError(t, err)
 	defer historyFile.Close()
@@ -268,6 +296,11 @@ func TestMeta(t *testing.T) {
 	// read by the child processes when performing a test run.
 	km := newKeyManager()
 	cfg := defaultConfig()
+	if rng.Intn(4) == 0 {
+		// Exercise replicating spans through shared sstables, which is only
+		// possible when they hold no range keys.
+		cfg = cfg.withoutRangeKeys()
+	}
 	if *previousOps != "" {
 		// During split-version testing, we load keys from an `ops` file
 		// produced by a metamorphic test run of an earlier Pebble version.
@@ -359,6 +392,20 @@ func TestMeta(t *testing.T) {
 		options[name] = opts
 	}
 
+	// Run each option set using a shared store once more without it, and compare
+	// the two histories directly. Moving tables to shared storage and
+	// replicating spans through them must not change the results.
+	var sharedPairs [][2]string
+	for _, name := range names {
+		if options[name].sharedFS {
+			sharedPairs = append(sharedPairs, [2]string{name, name + "-local"})
+		}
+	}
+	for _, pair := range sharedPairs {
+		names = append(names, pair[1])
+		options[pair[1]] = localOptions(options[pair[0]])
+	}
+
 	// If the user provided the path to an initial database state to use, update
 	// all the options to pull from it.
 	if *initialStatePath != "" {
@@ -387,12 +434,10 @@ func TestMeta(t *testing.T) {
 
 	}
 
-	base := readHistory(t, getHistoryPath(names[0]))
-	for i := 1; i < len(names); i++ {
-		lines := readHistory(t, getHistoryPath(names[i]))
+	compareHistories := func(nameA, nameB string) {
 		diff := difflib.UnifiedDiff{
-			A:       base,
-			B:       lines,
+			A:       readHistory(t, getHistoryPath(nameA)),
+			B:       readHistory(t, getHistoryPath(nameB)),
 			Context: 5,
 		}
 		text, err := difflib.GetUnifiedDiffString(diff)
@@ -401,8 +446,8 @@ func TestMeta(t *testing.T) {
 			// NB: We force an exit rather than using t.Fatal because the latter
 			// will run another instance of the test if -count is specified, while
 			// we're happy to exit on the first failure.
-			optionsStrA := optionsToString(options[names[0]])
-			optionsStrB := optionsToString(options[names[i]])
+			optionsStrA := optionsToString(options[nameA])
+			optionsStrB := optionsToString(options[nameB])
 
 			fmt.Printf(`
 ===== SEED =====
@@ -416,10 +461,16 @@ func TestMeta(t *testing.T) {
 %s
 ===== OPS =====
 %s
-`, seed, metaDir, names[0], names[i], text, names[0], optionsStrA, names[1], optionsStrB, formattedOps)
+`, seed, metaDir, nameA, nameB, text, nameA, optionsStrA, nameB, optionsStrB, formattedOps)
 			os.Exit(1)
 		}
 	}
+	for i := 1; i < len(names); i++ {
+		compareHistories(names[0], names[i])
+	}
+	for _, pair := range sharedPairs {
+		compareHistories(pair[0], pair[1])
+	}
 }
 
 func readFile(path string) string {
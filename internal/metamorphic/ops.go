@@ -192,6 +192,118 @@ func (o *flushOp) String() string {
 	return "db.Flush()"
 }
 
+// replicateOp models exporting the span [start, end) of the DB and ingesting it
+// into a new DB, whose contents are recorded. If the span is stored in shared
+// sstables, they are ingested into the new DB as pebble.SharedSSTMeta.
+// Otherwise, the span's keys are copied into an sstable that is ingested. Both
+// must result in the same contents.
+type replicateOp struct {
+	start []byte
+	end   []byte
+}
+
+func (o *replicateOp) run(t *test, h *history) {
+	// Compact the span into the bottommost level, which is stored in shared
+	// storage if the DB has any.
+	err := withRetries(func() error {
+		return t.db.Compact(o.start, o.end, false /* parallelize */)
+	})
+	var kvs []string
+	if err == nil {
+		err = withRetries(func() (err error) {
+			kvs, err = o.replicate(t)
+			return err
+		})
+	}
+	h.Recordf("%s // %q %v", o, kvs, err)
+}
+
+func (o *replicateOp) replicate(t *test) (kvs []string, retErr error) {
+	fs := t.opts.FS
+	dir := fs.PathJoin(t.dir, "replicas", fmt.Sprintf("op-%06d", t.idx))
+	if err := fs.RemoveAll(dir); err != nil {
+		return nil, err
+	}
+	if err := fs.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	opts := t.opts.Clone()
+	t.replicas++
+	opts.UniqueID = t.opts.UniqueID + t.replicas
+	opts.WALDir = ""
+	opts.EventListener = pebble.EventListener{}
+	opts.DebugCheck = nil
+	db, err := pebble.Open(dir, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		retErr = firstError(retErr, db.Close())
+	}()
+
+	var metas []pebble.SharedSSTMeta
+	if t.opts.SharedFS != nil {
+		metas, err = t.db.ExportSharedSSTs(o.start, o.end)
+		if errors.Is(err, pebble.ErrNotShared) {
+			metas, err = nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(metas) > 0 {
+		err = db.Ingest(nil, metas)
+	} else {
+		var path string
+		path, err = o.build(t)
+		if err == nil && path != "" {
+			err = db.Ingest([]string{path}, nil)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	iter := db.NewIter(nil)
+	for valid := iter.First(); valid; valid = iter.Next() {
+		kvs = append(kvs, fmt.Sprintf("%s=%s", iter.Key(), iter.Value()))
+	}
+	return kvs, firstError(iter.Error(), iter.Close())
+}
+
+func (o *replicateOp) String() string {
+	return fmt.Sprintf("db.Replicate(%q, %q)", o.start, o.end)
+}
+
+// build writes the latest version of the point keys in the span to an
+// sstable, returning its path, or "" if the span has no point keys.
+func (o *replicateOp) build(t *test) (string, error) {
+	iter := t.db.NewIter(&pebble.IterOptions{LowerBound: o.start, UpperBound: o.end})
+	if !iter.First() {
+		return "", iter.Close()
+	}
+
+	rootFS := vfs.Root(t.opts.FS)
+	path := rootFS.PathJoin(t.tmpDir, "replica")
+	f, err := rootFS.Create(path)
+	if err != nil {
+		return "", firstError(err, iter.Close())
+	}
+	tableFormat := t.db.FormatMajorVersion().MaxTableFormat()
+	w := sstable.NewWriter(f, t.opts.MakeWriterOptions(0, tableFormat))
+	for valid := true; valid; valid = iter.Next() {
+		if err := w.Set(iter.Key(), iter.Value()); err != nil {
+			_ = w.Close()
+			return "", firstError(err, iter.Close())
+		}
+	}
+	if err := firstError(iter.Error(), iter.Close()); err != nil {
+		_ = w.Close()
+		return "", err
+	}
+	return path, w.Close()
+}
+
 // mergeOp models a Write.Merge operation.
 type mergeOp struct {
 	writerID objID
@@ -10,23 +10,29 @@ import (
 	"math"
 	"os"
 	"path/filepath"
+	"strconv"
 
 	"github.com/cockroachdb/pebble"
 	"github.com/cockroachdb/pebble/bloom"
 	"github.com/cockroachdb/pebble/internal/base"
 	"github.com/cockroachdb/pebble/internal/cache"
 	"github.com/cockroachdb/pebble/internal/testkeys"
+	"github.com/cockroachdb/pebble/ribbon"
 	"github.com/cockroachdb/pebble/sstable"
 	"github.com/cockroachdb/pebble/vfs"
 	"golang.org/x/exp/rand"
 )
 
 func parseOptions(opts *testOptions, data string) error {
+	var parseErr error
 	hooks := &pebble.ParseHooks{
 		NewCache: pebble.NewCache,
 		NewFilterPolicy: func(name string) (pebble.FilterPolicy, error) {
-			if name == "none" {
+			switch name {
+			case "none":
 				return nil, nil
+			case ribbon.FilterPolicy(10).Name():
+				return ribbon.FilterPolicy(10), nil
 			}
 			return bloom.FilterPolicy(10), nil
 		},
@@ -56,13 +62,30 @@ func parseOptions(opts *testOptions, data string) error {
 				opts.useBlockPropertyCollector = true
 				opts.opts.BlockPropertyCollectors = blockPropertyCollectorConstructors
 				return true
+			case "TestOptions.shared_fs":
+				opts.sharedFS = true
+				return true
+			case "TestOptions.persistent_cache_size":
+				opts.persistentCacheSize, parseErr = strconv.ParseUint(value, 10, 64)
+				return true
+			case "TestOptions.warm_shared_tables":
+				opts.warmSharedTables = true
+				return true
+			case "TestOptions.warm_shared_tables_bytes":
+				opts.warmSharedTablesBytes, parseErr = strconv.ParseUint(value, 10, 64)
+				return true
+			case "TestOptions.remote_compactions":
+				opts.remoteCompactions = true
+				return true
 			default:
 				return false
 			}
 		},
 	}
-	err := opts.opts.Parse(data, hooks)
-	return err
+	if err := opts.opts.Parse(data, hooks); err != nil {
+		return err
+	}
+	return parseErr
 }
 
 func optionsToString(opts *testOptions) string {
@@ -88,6 +111,21 @@ func optionsToString(opts *testOptions) string {
 	if opts.useBlockPropertyCollector {
 		fmt.Fprintf(&buf, "  use_block_property_filter=%t\n", opts.useBlockPropertyCollector)
 	}
+	if opts.sharedFS {
+		fmt.Fprint(&buf, "  shared_fs=true\n")
+	}
+	if opts.persistentCacheSize != 0 {
+		fmt.Fprintf(&buf, "  persistent_cache_size=%d\n", opts.persistentCacheSize)
+	}
+	if opts.warmSharedTables {
+		fmt.Fprint(&buf, "  warm_shared_tables=true\n")
+	}
+	if opts.warmSharedTablesBytes != 0 {
+		fmt.Fprintf(&buf, "  warm_shared_tables_bytes=%d\n", opts.warmSharedTablesBytes)
+	}
+	if opts.remoteCompactions {
+		fmt.Fprint(&buf, "  remote_compactions=true\n")
+	}
 
 	s := opts.opts.String()
 	if buf.Len() == 0 {
@@ -126,6 +164,20 @@ type testOptions struct {
 	// Use a block property collector, which may be used by block property
 	// filters.
 	useBlockPropertyCollector bool
+	// Use a fault-injecting shared store as Options.SharedFS, so that tables in
+	// the bottommost levels are moved to it.
+	sharedFS bool
+	// The size of the persistent cache of shared tables. Only relevant if
+	// sharedFS is set.
+	persistentCacheSize uint64
+	// Warm up shared tables in the background, loading at most
+	// warmSharedTablesBytes per warm-up if non-zero. Only relevant if sharedFS
+	// is set.
+	warmSharedTables      bool
+	warmSharedTablesBytes uint64
+	// Run compactions of shared tables through an in-process
+	// CompactionExecutor. Only relevant if sharedFS is set.
+	remoteCompactions bool
 }
 
 func standardOptions() []*testOptions {
@@ -229,6 +281,20 @@ func standardOptions() []*testOptions {
 		23: `
 [TestOptions]
   use_block_property_filter=true
+`,
+		24: `
+[Options]
+  lbase_max_bytes=1
+[TestOptions]
+  shared_fs=true
+  persistent_cache_size=1048576
+  warm_shared_tables=true
+  remote_compactions=true
+`,
+		25: `
+[Options]
+  compaction_style=universal
+  max_concurrent_compactions=2
 `,
 	}
 
@@ -268,11 +334,18 @@ func randomOptions(rng *rand.Rand) *testOptions {
 	opts.LBaseMaxBytes = 1 << uint(rng.Intn(30))       // 1B - 1GB
 	opts.MaxConcurrentCompactions = rng.Intn(4)        // 0-3
 	opts.MaxManifestFileSize = 1 << uint(rng.Intn(30)) // 1B  - 1GB
+	opts.MaxSubcompactions = 1 + rng.Intn(4)           // 1-4
 	opts.MemTableSize = 2 << (10 + uint(rng.Intn(16))) // 2KB - 256MB
 	opts.MemTableStopWritesThreshold = 2 + rng.Intn(5) // 2 - 5
 	if rng.Intn(2) == 0 {
 		opts.WALDir = "data/wal"
 	}
+	if rng.Intn(4) == 0 {
+		opts.CompactionStyle = pebble.CompactionStyleUniversal
+		opts.UniversalCompaction.SizeRatio = 1 + rng.Intn(100)                    // 1 - 100
+		opts.UniversalCompaction.MinMergeWidth = 2 + rng.Intn(4)                  // 2 - 5
+		opts.UniversalCompaction.MaxSizeAmplificationPercent = 50 + rng.Intn(300) // 50 - 349
+	}
 	if rng.Intn(4) == 0 {
 		// Enable Writer parallelism for 25% of the random options. Setting
 		// MaxWriterConcurrency to any value greater than or equal to 1 has the
@@ -286,6 +359,14 @@ func randomOptions(rng *rand.Rand) *testOptions {
 	lopts.BlockSizeThreshold = 50 + rng.Intn(50)   // 50 - 100
 	lopts.IndexBlockSize = 1 << uint(rng.Intn(24)) // 1 - 16MB
 	lopts.TargetFileSize = 1 << uint(rng.Intn(28)) // 1 - 256MB
+	switch rng.Intn(4) {
+	case 0:
+		lopts.FilterPolicy = bloom.FilterPolicy(10)
+	case 1:
+		lopts.FilterPolicy = ribbon.FilterPolicy(10)
+	}
+	lopts.DataBlockHashIndex = rng.Intn(2) == 0
+	lopts.PartitionedFilters = rng.Intn(2) == 0
 	opts.Levels = []pebble.LevelOptions{lopts}
 
 	testOpts.opts = opts
@@ -303,9 +384,37 @@ func randomOptions(rng *rand.Rand) *testOptions {
 	if testOpts.useBlockPropertyCollector {
 		testOpts.opts.BlockPropertyCollectors = blockPropertyCollectorConstructors
 	}
+	testOpts.sharedFS = rng.Intn(4) == 0
+	if testOpts.sharedFS {
+		// Replicas opened by replicateOps use the following UniqueIDs.
+		opts.UniqueID = uint32(rng.Intn(1 << 16))
+		if rng.Intn(2) == 0 {
+			testOpts.persistentCacheSize = 1 << uint(10+rng.Intn(20)) // 1KB - 512MB
+		}
+		testOpts.warmSharedTables = rng.Intn(2) == 0
+		if testOpts.warmSharedTables && rng.Intn(2) == 0 {
+			testOpts.warmSharedTablesBytes = 1 << uint(10+rng.Intn(10)) // 1KB - 512KB
+		}
+		testOpts.remoteCompactions = rng.Intn(2) == 0
+	}
 	return testOpts
 }
 
+// localOptions returns a copy of opts that does not use a shared store. A run
+// with the copy must produce the same history as a run with opts.
+func localOptions(opts *testOptions) *testOptions {
+	local := &testOptions{opts: defaultOptions()}
+	if err := parseOptions(local, optionsToString(opts)); err != nil {
+		panic(err)
+	}
+	local.sharedFS = false
+	local.persistentCacheSize = 0
+	local.warmSharedTables = false
+	local.warmSharedTablesBytes = 0
+	local.remoteCompactions = false
+	return local
+}
+
 func setupInitialState(dir string, testOpts *testOptions) error {
 	// Copy (vfs.Default,<initialStatePath>) to (testOpts.opts.FS,<dir>).
 	ok, err := vfs.Clone(
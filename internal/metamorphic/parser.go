@@ -62,6 +62,8 @@ func opArgs(op op) (receiverID *objID, targetID *objID, args []interface{}) {
 		return &t.iterID, nil, nil
 	case *flushOp:
 		return nil, nil, nil
+	case *replicateOp:
+		return nil, nil, []interface{}{&t.start, &t.end}
 	case *getOp:
 		return &t.readerID, nil, []interface{}{&t.key}
 	case *ingestOp:
@@ -135,6 +137,7 @@ var methods = map[string]*methodInfo{
 	"RangeKeyDelete":  makeMethod(rangeKeyDeleteOp{}, dbTag, batchTag),
 	"RangeKeySet":     makeMethod(rangeKeySetOp{}, dbTag, batchTag),
 	"RangeKeyUnset":   makeMethod(rangeKeyUnsetOp{}, dbTag, batchTag),
+	"Replicate":       makeMethod(replicateOp{}, dbTag),
 	"Restart":         makeMethod(dbRestartOp{}, dbTag),
 	"SeekGE":          makeMethod(iterSeekGEOp{}, iterTag),
 	"SeekLT":          makeMethod(iterSeekLTOp{}, iterTag),
@@ -28,6 +28,10 @@ type test struct {
 	testOpts  *testOptions
 	writeOpts *pebble.WriteOptions
 	tmpDir    string
+	// replicas counts the DBs opened by replicateOps. Each replica is given a
+	// distinct UniqueID, as shared sstables and their cached blocks are keyed
+	// by the UniqueID of the DB that created them.
+	replicas uint32
 	// The slots for the batches, iterators, and snapshots. These are read and
 	// written by the ops to pass state from one op to another.
 	batches   []*pebble.Batch
@@ -119,6 +119,10 @@ type IteratorStats struct {
 	// ReverseStepCount includes Prev.
 	ReverseStepCount [NumStatsKind]int
 	InternalStats    InternalIteratorStats
+	// PinnedBytes is the memory retained by an iterator configured with
+	// IterOptions.PinKeysAndValues: the size of the pinned sstable blocks and
+	// of the copied keys and values.
+	PinnedBytes uint64
 }
 
 var _ redact.SafeFormatter = &IteratorStats{}
@@ -170,6 +174,11 @@ type Iterator struct {
 	value       []byte
 	valueBuf    []byte
 	valueCloser io.Closer
+	// valueIsBlob is true if value is the blob handle of a BLOBSET key, in
+	// which case Value reads the value from its blob file.
+	valueIsBlob bool
+	blobs       *blobFileCache
+	blobBuf     []byte
 	// boundsBuf holds two buffers used to store the lower and upper bounds.
 	// Whenever the Iterator's bounds change, the new bounds are copied into
 	// boundsBuf[boundsBufIdx]. The two bounds share a slice to reduce
@@ -235,6 +244,100 @@ type Iterator struct {
 	lastPositioningOp lastPositioningOpKind
 	// Used in some tests to disable the random disabling of seek optimizations.
 	forceEnableSeekOpt bool
+	// tailing holds the state of an iterator configured with
+	// IterOptions.Tailing.
+	tailing tailingState
+	// pinned holds the memory retained by an iterator configured with
+	// IterOptions.PinKeysAndValues. It is nil if the option was never set.
+	pinned *iterPinnedState
+}
+
+// iterPinnedChunkSize is the minimum size of the chunks of memory keys and
+// values are copied into by an iterator configured with
+// IterOptions.PinKeysAndValues.
+const iterPinnedChunkSize = 4 << 10 // 4 KB
+
+// iterPinnedState holds the memory retained by an iterator configured with
+// IterOptions.PinKeysAndValues: the sstable blocks it has read, and the chunks
+// of memory the keys and values that are not stored in a pinned block are
+// copied into.
+type iterPinnedState struct {
+	blocks sstable.PinnedBlocks
+	// buf is the current chunk. Copies are appended to it until it is full,
+	// at which point a new chunk is allocated. Filled chunks are retained by
+	// the slices returned from them.
+	buf []byte
+	// bufSize is the total size of the chunks allocated.
+	bufSize uint64
+	// key and value are the last key and value copied. They are used to avoid
+	// copying the same key or value again when Key or Value is called
+	// repeatedly at the same position.
+	key, value []byte
+	// counter, if non-nil, is atomically adjusted by the size of the chunks
+	// allocated and released. The pinned blocks adjust it too.
+	counter *int64
+	// readStates holds the read states a tailing iterator has moved off of.
+	// They are retained so that the keys and values returned from their
+	// memtables remain valid.
+	readStates []*readState
+}
+
+func (p *iterPinnedState) init(counter *int64) {
+	p.blocks.Init(counter)
+	p.counter = counter
+}
+
+// copy returns a copy of b that remains valid until release is called.
+func (p *iterPinnedState) copy(b []byte) []byte {
+	if len(b) > cap(p.buf)-len(p.buf) {
+		n := iterPinnedChunkSize
+		if len(b) > n {
+			n = len(b)
+		}
+		p.buf = make([]byte, 0, n)
+		p.bufSize += uint64(n)
+		if p.counter != nil {
+			atomic.AddInt64(p.counter, int64(n))
+		}
+	}
+	start := len(p.buf)
+	p.buf = append(p.buf, b...)
+	// Cap the copy so that appending to it cannot overwrite the next one.
+	return p.buf[start:len(p.buf):len(p.buf)]
+}
+
+// size returns the total size of the memory retained.
+func (p *iterPinnedState) size() uint64 {
+	return p.blocks.Size() + p.bufSize
+}
+
+// release releases the pinned blocks and the chunks, invalidating all the
+// keys and values returned.
+func (p *iterPinnedState) release() {
+	p.blocks.Release()
+	for _, s := range p.readStates {
+		s.unref()
+	}
+	if p.counter != nil {
+		atomic.AddInt64(p.counter, -int64(p.bufSize))
+	}
+	*p = iterPinnedState{}
+}
+
+// sameSlice returns true if a and b are the same slice of the same array, or
+// are both empty.
+func sameSlice(a, b []byte) bool {
+	return len(a) == len(b) && (len(a) == 0 || &a[0] == &b[0])
+}
+
+// containsSlice returns true if the non-empty slice b lies within buf.
+func containsSlice(buf, b []byte) bool {
+	if len(buf) == 0 || len(b) == 0 {
+		return false
+	}
+	start := uintptr(unsafe.Pointer(&buf[0]))
+	p := uintptr(unsafe.Pointer(&b[0]))
+	return p >= start && p+uintptr(len(b)) <= start+uintptr(len(buf))
 }
 
 // iteratorRangeKeyState holds an iterator's range key iteration state.
@@ -411,6 +514,7 @@ func (i *Iterator) findNextEntry(limit []byte) {
 			i.keyBuf = append(i.keyBuf[:0], key.UserKey...)
 			i.key = i.keyBuf
 			i.value = nil
+			i.valueIsBlob = false
 			// There may also be a live point key at this userkey that we have
 			// not yet read. We need to find the next entry with this user key
 			// to find it. Save the range key so we don't lose it when we Next
@@ -429,10 +533,11 @@ func (i *Iterator) findNextEntry(limit []byte) {
 			i.nextUserKey()
 			continue
 
-		case InternalKeyKindSet, InternalKeyKindSetWithDelete:
+		case InternalKeyKindSet, InternalKeyKindSetWithDelete, InternalKeyKindBlobSet:
 			i.keyBuf = append(i.keyBuf[:0], key.UserKey...)
 			i.key = i.keyBuf
 			i.value = i.iterValue
+			i.valueIsBlob = key.Kind() == InternalKeyKindBlobSet
 			i.iterValidityState = IterValid
 			i.setRangeKey()
 			return
@@ -491,8 +596,9 @@ func (i *Iterator) nextPointCurrentUserKey() bool {
 	case InternalKeyKindDelete, InternalKeyKindSingleDelete:
 		return false
 
-	case InternalKeyKindSet, InternalKeyKindSetWithDelete:
+	case InternalKeyKindSet, InternalKeyKindSetWithDelete, InternalKeyKindBlobSet:
 		i.value = i.iterValue
+		i.valueIsBlob = key.Kind() == InternalKeyKindBlobSet
 		return true
 
 	case InternalKeyKindMerge:
@@ -526,6 +632,7 @@ func (i *Iterator) mergeForward(key base.InternalKey) (valid bool) {
 	var needDelete bool
 	i.value, needDelete, i.valueCloser, i.err = finishValueMerger(
 		valueMerger, true /* includesBase */)
+	i.valueIsBlob = false
 	if i.err != nil {
 		return false
 	}
@@ -715,6 +822,7 @@ func (i *Iterator) findPrevEntry(limit []byte) {
 				if valueMerger != nil {
 					var needDelete bool
 					i.value, needDelete, i.valueCloser, i.err = finishValueMerger(valueMerger, true /* includesBase */)
+					i.valueIsBlob = false
 					if i.err == nil && needDelete {
 						// The point key at this key is deleted. If we also have
 						// a range key boundary at this key, we still want to
@@ -768,6 +876,7 @@ func (i *Iterator) findPrevEntry(limit []byte) {
 
 		case InternalKeyKindDelete, InternalKeyKindSingleDelete:
 			i.value = nil
+			i.valueIsBlob = false
 			i.iterValidityState = IterExhausted
 			valueMerger = nil
 			i.iterKey, i.iterValue = i.iter.Prev()
@@ -788,7 +897,7 @@ func (i *Iterator) findPrevEntry(limit []byte) {
 			}
 			continue
 
-		case InternalKeyKindSet, InternalKeyKindSetWithDelete:
+		case InternalKeyKindSet, InternalKeyKindSetWithDelete, InternalKeyKindBlobSet:
 			i.keyBuf = append(i.keyBuf[:0], key.UserKey...)
 			i.key = i.keyBuf
 			// iterValue is owned by i.iter and could change after the Prev()
@@ -797,6 +906,7 @@ func (i *Iterator) findPrevEntry(limit []byte) {
 			// we just point i.value to the unsafe i.iter-owned value buffer.
 			i.valueBuf = append(i.valueBuf[:0], i.iterValue...)
 			i.value = i.valueBuf
+			i.valueIsBlob = key.Kind() == InternalKeyKindBlobSet
 			// TODO(jackson): We may save the same range key many times. We can
 			// avoid that with some help from the InterleavingIter. See also the
 			// TODO in saveRangeKey.
@@ -818,7 +928,11 @@ func (i *Iterator) findPrevEntry(limit []byte) {
 				}
 				i.iterValidityState = IterValid
 			} else if valueMerger == nil {
-				valueMerger, i.err = i.merge(i.key, i.value)
+				// The older value may need to be read from its blob file.
+				i.readBlobValue()
+				if i.err == nil {
+					valueMerger, i.err = i.merge(i.key, i.value)
+				}
 				if i.err == nil {
 					i.err = valueMerger.MergeNewer(i.iterValue)
 				}
@@ -850,6 +964,7 @@ func (i *Iterator) findPrevEntry(limit []byte) {
 		if valueMerger != nil {
 			var needDelete bool
 			i.value, needDelete, i.valueCloser, i.err = finishValueMerger(valueMerger, true /* includesBase */)
+			i.valueIsBlob = false
 			if i.err == nil && needDelete {
 				i.key = nil
 				i.value = nil
@@ -909,9 +1024,15 @@ func (i *Iterator) mergeNext(key InternalKey, valueMerger ValueMerger) {
 			// point.
 			return
 
-		case InternalKeyKindSet, InternalKeyKindSetWithDelete:
+		case InternalKeyKindSet, InternalKeyKindSetWithDelete, InternalKeyKindBlobSet:
 			// We've hit a Set value. Merge with the existing value and return.
-			i.err = valueMerger.MergeOlder(i.iterValue)
+			value := i.iterValue
+			if key.Kind() == InternalKeyKindBlobSet {
+				if value, i.err = i.readBlob(value); i.err != nil {
+					return
+				}
+			}
+			i.err = valueMerger.MergeOlder(value)
 			return
 
 		case InternalKeyKindMerge:
@@ -1283,6 +1404,9 @@ func (i *Iterator) Last() bool {
 // Next moves the iterator to the next key/value pair. Returns true if the
 // iterator is pointing at a valid entry and false otherwise.
 func (i *Iterator) Next() bool {
+	if i.opts.Tailing {
+		return i.tailingNext()
+	}
 	return i.NextWithLimit(nil) == IterValid
 }
 
@@ -1678,20 +1802,124 @@ func (i *Iterator) RangeBounds() (start, end []byte) {
 
 // Key returns the key of the current key/value pair, or nil if done. The
 // caller should not modify the contents of the returned slice, and its
-// contents may change on the next call to Next.
+// contents may change on the next call to Next, unless the iterator is
+// configured with IterOptions.PinKeysAndValues.
 func (i *Iterator) Key() []byte {
+	if i.opts.PinKeysAndValues {
+		i.pinKey()
+	}
 	return i.key
 }
 
+// pinKey replaces i.key with a slice that remains valid until the iterator is
+// closed. A key read from a memtable, a batch or a pinned sstable block is
+// returned without copying. A key stored with prefix compression in an
+// sstable block is assembled in a buffer reused by the sstable iterator, and
+// is copied.
+func (i *Iterator) pinKey() {
+	if sameSlice(i.key, i.pinned.key) {
+		return
+	}
+	switch {
+	case i.stableKey(i.key):
+	case i.iterKey != nil && bytes.Equal(i.iterKey.UserKey, i.key) && i.stableKey(i.iterKey.UserKey):
+		// The key was saved in i.keyBuf from the internal iterator, which is
+		// still positioned at it.
+		i.key = i.iterKey.UserKey
+	default:
+		i.key = i.pinned.copy(i.key)
+	}
+	i.pinned.key = i.key
+}
+
+// stableKey returns true if b lies within memory that remains valid until
+// the iterator is closed.
+func (i *Iterator) stableKey(b []byte) bool {
+	return len(b) == 0 || i.pinned.blocks.Contains(b) || i.inMemtableOrBatch(b)
+}
+
+// inMemtableOrBatch returns true if b lies within the memory of one of the
+// iterator's memtables or of its batch, which remains valid until the
+// iterator is closed.
+func (i *Iterator) inMemtableOrBatch(b []byte) bool {
+	if i.batch != nil && containsSlice(i.batch.data, b) {
+		return true
+	}
+	if i.readState == nil {
+		return false
+	}
+	for _, m := range i.readState.memtables {
+		switch f := m.flushable.(type) {
+		case *memTable:
+			if containsSlice(f.arenaBuf, b) {
+				return true
+			}
+		case *flushableBatch:
+			if containsSlice(f.data, b) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // Value returns the value of the current key/value pair, or nil if done. The
 // caller should not modify the contents of the returned slice, and its
-// contents may change on the next call to Next.
+// contents may change on the next call to Next, unless the iterator is
+// configured with IterOptions.PinKeysAndValues.
+//
+// A value that is stored in a blob file is read when Value is first called
+// for the key. If the value cannot be read, Value returns nil and the error is
+// returned by Error.
 //
 // Only valid if HasPointAndRange() returns true for hasPoint.
 func (i *Iterator) Value() []byte {
+	i.readBlobValue()
+	if i.opts.PinKeysAndValues {
+		i.pinValue()
+	}
 	return i.value
 }
 
+// pinValue replaces i.value with a copy that remains valid until the
+// iterator is closed, unless i.value already remains valid. A value read from
+// a memtable, a batch or a pinned sstable block is stable, but a value held
+// in one of the iterator's buffers or owned by a merge's closer is not.
+func (i *Iterator) pinValue() {
+	if sameSlice(i.value, i.pinned.value) {
+		return
+	}
+	if i.valueCloser != nil || sameSlice(i.value, i.valueBuf) || sameSlice(i.value, i.blobBuf) {
+		i.value = i.pinned.copy(i.value)
+		i.pinned.value = i.value
+	}
+}
+
+// readBlobValue replaces the blob handle in i.value with the value read from
+// the blob file, if i.value is a blob handle. If the value cannot be read,
+// i.value is set to nil and the error is stored in i.err.
+func (i *Iterator) readBlobValue() {
+	if !i.valueIsBlob {
+		return
+	}
+	i.valueIsBlob = false
+	i.value, i.err = i.readBlob(i.value)
+}
+
+// readBlob reads the value referenced by the blob handle. The value is only
+// valid until the next call to readBlob.
+func (i *Iterator) readBlob(handle []byte) ([]byte, error) {
+	if i.blobs == nil {
+		return nil, base.CorruptionErrorf("pebble: unexpected BLOBSET key")
+	}
+	value, err := i.blobs.read(handle, i.blobBuf)
+	if err != nil {
+		return nil, err
+	}
+	i.blobBuf = value
+	return value, nil
+}
+
 // RangeKeys returns the range key values and their suffixes covering the
 // current iterator position. The range bounds may be retrieved separately
 // through Iterator.RangeBounds().
@@ -1779,6 +2007,14 @@ func (i *Iterator) Close() error {
 		i.valueCloser = nil
 	}
 
+	// Release the memory retained for IterOptions.PinKeysAndValues. The
+	// iterator stacks are closed above, and have handed their last blocks to
+	// the pinned state.
+	if i.pinned != nil {
+		i.pinned.release()
+		i.pinned = nil
+	}
+
 	const maxKeyBufCacheSize = 4 << 10 // 4 KB
 
 	if i.rangeKey != nil {
@@ -1928,9 +2164,18 @@ func (i *Iterator) SetOptions(o *IterOptions) {
 	// we need to reconstruct the iterator stacks. If they both supply a table
 	// filter, we can't be certain that it's the same filter since we have no
 	// mechanism to compare the filter closures.
+	//
+	// If the Context changed, the iterators of the point iterator stack hold
+	// the old one.
+	//
+	// If PinKeysAndValues changed, the sstable iterators of the point iterator
+	// stack pin blocks or not according to the old setting. Blocks already
+	// pinned remain pinned until the iterator is closed.
 	closeBoth := i.err != nil ||
 		o.OnlyReadGuaranteedDurable != i.opts.OnlyReadGuaranteedDurable ||
-		o.TableFilter != nil || i.opts.TableFilter != nil
+		o.TableFilter != nil || i.opts.TableFilter != nil ||
+		o.Context != i.opts.Context ||
+		o.PinKeysAndValues != i.opts.PinKeysAndValues
 
 	// If either options specify block property filters for an iterator stack,
 	// reconstruct it.
@@ -2113,6 +2358,9 @@ func (i *Iterator) ResetStats() {
 func (i *Iterator) Stats() IteratorStats {
 	stats := i.stats
 	stats.InternalStats = i.iter.Stats()
+	if i.pinned != nil {
+		stats.PinnedBytes = i.pinned.size()
+	}
 	return stats
 }
 
@@ -2178,6 +2426,7 @@ func (i *Iterator) Clone(opts CloneOptions) (*Iterator, error) {
 		batchSeqNum:         i.batchSeqNum,
 		newIters:            i.newIters,
 		newIterRangeKey:     i.newIterRangeKey,
+		blobs:               i.blobs,
 		seqNum:              i.seqNum,
 	}
 	dbi.saveBounds(dbi.opts.LowerBound, dbi.opts.UpperBound)
@@ -2220,4 +2469,7 @@ func (stats *IteratorStats) SafeFormat(s redact.SafePrinter, verb rune) {
 			humanize.SI.Uint64(stats.InternalStats.PointsCoveredByRangeTombstones),
 		)
 	}
+	if stats.PinnedBytes != 0 {
+		s.Printf(",\n(pinned-bytes: %s)", humanize.IEC.Uint64(stats.PinnedBytes))
+	}
 }
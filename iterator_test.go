@@ -6,6 +6,7 @@ package pebble
 
 import (
 	"bytes"
+	"context"
 	"flag"
 	"fmt"
 	"io"
@@ -1474,6 +1475,278 @@ func TestIteratorGuaranteedDurable(t *testing.T) {
 	})
 }
 
+func TestTailingIterator(t *testing.T) {
+	d, err := Open("", &Options{FS: vfs.NewMem()})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+
+	iter := d.NewIter(&IterOptions{Tailing: true})
+	defer func() {
+		require.NoError(t, iter.Close())
+	}()
+	set := func(keys ...string) {
+		for _, k := range keys {
+			require.NoError(t, d.Set([]byte(k), []byte(k), nil))
+		}
+	}
+	// next returns the keys observed by calls to Next until it returns false.
+	next := func() string {
+		var keys []string
+		for iter.Next() {
+			keys = append(keys, string(iter.Key()))
+		}
+		require.NoError(t, iter.Error())
+		return strings.Join(keys, ",")
+	}
+
+	require.False(t, iter.First())
+	require.Equal(t, "", next())
+	set("a")
+	require.Equal(t, "a", next())
+	set("b", "c")
+	require.Equal(t, "b,c", next())
+
+	// Keys before the iterator's position are not observed.
+	set("bb", "d")
+	require.Equal(t, "d", next())
+
+	// Flushed memtables and compacted tables are observed.
+	require.NoError(t, d.Flush())
+	set("e")
+	require.Equal(t, "e", next())
+	require.NoError(t, d.Compact([]byte("a"), []byte("z"), false))
+	set("f")
+	require.Equal(t, "f", next())
+
+	// New range deletions in the memtable read by the iterator are observed.
+	set("g")
+	require.NoError(t, d.DeleteRange([]byte("g"), []byte("h"), nil))
+	set("h")
+	require.Equal(t, "h", next())
+	b := d.NewBatch()
+	require.NoError(t, b.Set([]byte("j"), nil, nil))
+	require.NoError(t, b.DeleteRange([]byte("j"), []byte("k"), nil))
+	require.NoError(t, b.Set([]byte("k"), nil, nil))
+	require.NoError(t, b.Commit(nil))
+	require.Equal(t, "k", next())
+
+	// Bounds are respected.
+	require.NoError(t, iter.Close())
+	iter = d.NewIter(&IterOptions{Tailing: true, UpperBound: []byte("m")})
+	require.True(t, iter.SeekGE([]byte("k")))
+	require.Equal(t, "", next())
+	set("l", "n")
+	require.Equal(t, "l", next())
+
+	require.Panics(t, func() {
+		s := d.NewSnapshot()
+		defer s.Close()
+		s.NewIter(&IterOptions{Tailing: true})
+	})
+}
+
+func TestIteratorContext(t *testing.T) {
+	opts := &Options{FS: vfs.NewMem()}
+	opts.Levels = []LevelOptions{{BlockSize: 64}}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+
+	const numKeys = 1000
+	for i := 0; i < numKeys; i++ {
+		require.NoError(t, d.Set([]byte(fmt.Sprintf("%04d", i)), []byte("v"), nil))
+	}
+	require.NoError(t, d.Flush())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	iter := d.NewIter(&IterOptions{Context: ctx})
+	defer func() {
+		require.NoError(t, iter.Close())
+	}()
+	count := func() int {
+		n := 0
+		for valid := iter.First(); valid; valid = iter.Next() {
+			n++
+		}
+		return n
+	}
+	require.Equal(t, numKeys, count())
+	require.NoError(t, iter.Error())
+
+	// Once the context is canceled, reading the next block fails.
+	require.True(t, iter.First())
+	cancel()
+	n := 1
+	for iter.Next() {
+		n++
+	}
+	require.Less(t, n, numKeys)
+	require.ErrorIs(t, iter.Error(), context.Canceled)
+	require.False(t, iter.SeekGE([]byte("0500")))
+	require.ErrorIs(t, iter.Error(), context.Canceled)
+
+	// Replacing the context resets the iterator.
+	iter.SetOptions(&IterOptions{})
+	require.Equal(t, numKeys, count())
+	require.NoError(t, iter.Error())
+
+	// Skipping keys deleted by a range deletion in the same memtable reads no
+	// blocks, but is interrupted once the context is done.
+	for i := numKeys; i < 2*numKeys; i++ {
+		require.NoError(t, d.Set([]byte(fmt.Sprintf("%04d", i)), []byte("v"), nil))
+	}
+	require.NoError(t, d.DeleteRange([]byte("1001"), []byte("1999"), nil))
+	ctx2 := &countdownContext{Context: context.Background(), remaining: -1}
+	iter2 := d.NewIter(&IterOptions{Context: ctx2})
+	require.True(t, iter2.SeekGE([]byte("1000")))
+	ctx2.remaining = 100
+	require.False(t, iter2.Next())
+	require.ErrorIs(t, iter2.Error(), context.Canceled)
+	require.ErrorIs(t, iter2.Close(), context.Canceled)
+}
+
+// countdownContext is a context that is done once Err has been called more
+// than remaining times. It is never done if remaining is negative.
+type countdownContext struct {
+	context.Context
+	remaining int
+}
+
+func (c *countdownContext) Err() error {
+	if c.remaining < 0 {
+		return nil
+	}
+	if c.remaining == 0 {
+		return context.Canceled
+	}
+	c.remaining--
+	return nil
+}
+
+func TestIteratorPinKeysAndValues(t *testing.T) {
+	// A small cache ensures that the blocks read are evicted, and freed once
+	// released, while iterating.
+	cache := NewCache(1 << 10)
+	defer cache.Unref()
+	opts := &Options{FS: vfs.NewMem(), Cache: cache}
+	opts.Levels = []LevelOptions{{BlockSize: 64}}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+
+	// Every third key has a merge operand in the memtable on top of its value
+	// in an sstable, and every fifth key is only in the memtable.
+	const numKeys = 500
+	want := make(map[string]string)
+	for i := 0; i < numKeys; i++ {
+		k := fmt.Sprintf("%04d", i)
+		if i%5 == 0 {
+			continue
+		}
+		require.NoError(t, d.Set([]byte(k), []byte("v"+k), nil))
+		want[k] = "v" + k
+	}
+	require.NoError(t, d.Flush())
+	for i := 0; i < numKeys; i++ {
+		k := fmt.Sprintf("%04d", i)
+		switch {
+		case i%5 == 0:
+			require.NoError(t, d.Set([]byte(k), []byte("m"+k), nil))
+			want[k] = "m" + k
+		case i%3 == 0:
+			require.NoError(t, d.Merge([]byte(k), []byte("+"), nil))
+			want[k] += "+"
+		}
+	}
+
+	iter := d.NewIter(&IterOptions{PinKeysAndValues: true})
+	var keys, values [][]byte
+	for valid := iter.First(); valid; valid = iter.Next() {
+		keys = append(keys, iter.Key())
+		values = append(values, iter.Value())
+	}
+	for valid := iter.Last(); valid; valid = iter.Prev() {
+		keys = append(keys, iter.Key())
+		values = append(values, iter.Value())
+	}
+	require.NoError(t, iter.Error())
+	require.Equal(t, 2*numKeys, len(keys))
+	require.Greater(t, iter.Stats().PinnedBytes, uint64(0))
+	require.Equal(t, int64(iter.Stats().PinnedBytes), d.Metrics().PinnedIterBytes)
+
+	// Disabling pinning does not invalidate the keys and values returned.
+	iter.SetOptions(&IterOptions{})
+	n := 0
+	for valid := iter.First(); valid; valid = iter.Next() {
+		n++
+	}
+	require.Equal(t, numKeys, n)
+	require.Equal(t, "0000", string(keys[0]))
+	require.Equal(t, fmt.Sprintf("%04d", numKeys-1), string(keys[numKeys]))
+	for j := range keys {
+		require.Equal(t, want[string(keys[j])], string(values[j]), "key %s", keys[j])
+	}
+
+	require.NoError(t, iter.Close())
+	require.Equal(t, int64(0), d.Metrics().PinnedIterBytes)
+}
+
+func TestIteratorPinKeysWithoutCopying(t *testing.T) {
+	for _, restartInterval := range []int{1, 16} {
+		t.Run(fmt.Sprintf("restart-interval=%d", restartInterval), func(t *testing.T) {
+			cache := NewCache(1 << 10)
+			defer cache.Unref()
+			opts := &Options{FS: vfs.NewMem(), Cache: cache}
+			opts.Levels = []LevelOptions{{BlockSize: 64, BlockRestartInterval: restartInterval}}
+			d, err := Open("", opts)
+			require.NoError(t, err)
+			defer func() {
+				require.NoError(t, d.Close())
+			}()
+
+			// Even keys are in an sstable, and odd keys in the memtable.
+			const numKeys = 200
+			key := func(i int) string { return fmt.Sprintf("%04d", i) }
+			for i := 0; i < numKeys; i += 2 {
+				require.NoError(t, d.Set([]byte(key(i)), []byte(key(i)), nil))
+			}
+			require.NoError(t, d.Flush())
+			for i := 1; i < numKeys; i += 2 {
+				require.NoError(t, d.Set([]byte(key(i)), []byte(key(i)), nil))
+			}
+
+			iter := d.NewIter(&IterOptions{PinKeysAndValues: true})
+			var keys, values [][]byte
+			for valid := iter.First(); valid; valid = iter.Next() {
+				keys = append(keys, iter.Key())
+				values = append(values, iter.Value())
+			}
+			require.NoError(t, iter.Error())
+
+			// Keys in the memtable and keys stored without prefix compression
+			// in sstable blocks are returned without copying. With a restart
+			// interval of 1, no key is prefix compressed.
+			if restartInterval == 1 {
+				require.Equal(t, uint64(0), iter.pinned.bufSize)
+			} else {
+				require.Greater(t, iter.pinned.bufSize, uint64(0))
+			}
+			require.Len(t, keys, numKeys)
+			for i := range keys {
+				require.Equal(t, key(i), string(keys[i]))
+				require.Equal(t, key(i), string(values[i]))
+			}
+			require.NoError(t, iter.Close())
+		})
+	}
+}
+
 func TestIteratorBoundsLifetimes(t *testing.T) {
 	d := newTestkeysDatabase(t, testkeys.Alpha(2))
 	defer func() { require.NoError(t, d.Close()) }()
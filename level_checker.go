@@ -71,6 +71,9 @@ type simpleMergingIter struct {
 	numPoints int64
 	merge     Merge
 	formatKey base.FormatKey
+	// blobs is used to read the values of BLOBSET keys that are merged into.
+	blobs   *blobFileCache
+	blobBuf []byte
 }
 
 func (m *simpleMergingIter) init(
@@ -167,8 +170,15 @@ func (m *simpleMergingIter) step() bool {
 					m.err = closer.Close()
 				}
 				m.valueMerger = nil
-			case InternalKeyKindSet, InternalKeyKindSetWithDelete:
-				m.err = m.valueMerger.MergeOlder(item.value)
+			case InternalKeyKindSet, InternalKeyKindSetWithDelete, InternalKeyKindBlobSet:
+				value := item.value
+				if item.key.Kind() == InternalKeyKindBlobSet {
+					value, m.err = m.blobs.read(value, m.blobBuf)
+					m.blobBuf = value[:0]
+				}
+				if m.err == nil {
+					m.err = m.valueMerger.MergeOlder(value)
+				}
 				if m.err == nil {
 					var closer io.Closer
 					_, closer, m.err = m.valueMerger.Finish(true /* includesBase */)
@@ -347,6 +357,7 @@ type checkConfig struct {
 	stats     *CheckLevelsStats
 	merge     Merge
 	formatKey base.FormatKey
+	blobs     *blobFileCache
 }
 
 func checkRangeTombstones(c *checkConfig) error {
@@ -572,6 +583,7 @@ func (d *DB) CheckLevels(stats *CheckLevelsStats) error {
 		stats:     stats,
 		merge:     d.merge,
 		formatKey: d.opts.Comparer.FormatKey,
+		blobs:     d.blobs,
 	}
 	return checkLevelsInternal(checkConfig)
 }
@@ -656,6 +668,7 @@ func checkLevelsInternal(c *checkConfig) (err error) {
 	}
 
 	mergingIter := &simpleMergingIter{}
+	mergingIter.blobs = c.blobs
 	mergingIter.init(c.merge, c.cmp, c.seqNum, c.formatKey, mlevels...)
 	for cont := mergingIter.step(); cont; cont = mergingIter.step() {
 	}
@@ -226,6 +226,8 @@ func (l *levelIter) init(
 	l.tableOpts.TableFilter = opts.TableFilter
 	l.tableOpts.PointKeyFilters = opts.PointKeyFilters
 	l.tableOpts.UseL6Filters = opts.UseL6Filters
+	l.tableOpts.Context = opts.Context
+	l.tableOpts.pinnedBlocks = opts.pinnedBlocks
 	l.tableOpts.level = l.level
 	l.cmp = cmp
 	l.split = split
@@ -579,6 +581,12 @@ func (l *levelIter) loadFile(file *fileMetadata, dir int) loadFileReturnIndicato
 			}
 		}
 
+		if ctx := l.tableOpts.Context; ctx != nil {
+			if l.err = ctx.Err(); l.err != nil {
+				return noFileLoaded
+			}
+		}
+
 		var rangeDelIter keyspan.FragmentIterator
 		var iter internalIterator
 		iter, rangeDelIter, l.err = l.newIters(l.files.Current(), &l.tableOpts, l.bytesIterated)
@@ -929,7 +937,9 @@ func (l *levelIter) skipEmptyFileForward() (*InternalKey, []byte) {
 			if *l.rangeDelIterPtr != nil && l.filteredIter != nil &&
 				l.filteredIter.MaybeFilteredKeys() {
 				l.largestBoundary = &l.iterFile.Largest
-				l.boundaryContext.isIgnorableBoundaryKey = true
+				if l.boundaryContext != nil {
+					l.boundaryContext.isIgnorableBoundaryKey = true
+				}
 				return l.largestBoundary, nil
 			}
 		}
@@ -1015,7 +1025,9 @@ func (l *levelIter) skipEmptyFileBackward() (*InternalKey, []byte) {
 			// the next file.
 			if *l.rangeDelIterPtr != nil && l.filteredIter != nil && l.filteredIter.MaybeFilteredKeys() {
 				l.smallestBoundary = &l.iterFile.Smallest
-				l.boundaryContext.isIgnorableBoundaryKey = true
+				if l.boundaryContext != nil {
+					l.boundaryContext.isIgnorableBoundaryKey = true
+				}
 				return l.smallestBoundary, nil
 			}
 		}
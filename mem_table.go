@@ -13,6 +13,7 @@ import (
 	"unsafe"
 
 	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/bloom"
 	"github.com/cockroachdb/pebble/internal/arenaskl"
 	"github.com/cockroachdb/pebble/internal/base"
 	"github.com/cockroachdb/pebble/internal/keyspan"
@@ -83,11 +84,21 @@ type memTable struct {
 	writerRefs int32
 	tombstones keySpanCache
 	rangeKeys  keySpanCache
+	// filter, if non-nil, is a bloom filter over the prefixes of the point
+	// keys of the memtable, as determined by split. See
+	// Options.MemTableBloomFilterPercent.
+	filter *bloom.DynamicFilter
+	split  Split
 	// The current logSeqNum at the time the memtable was created. This is
 	// guaranteed to be less than or equal to any seqnum stored in the memtable.
 	logSeqNum uint64
 }
 
+// memTableBloomBitsPerKey is the number of bits of a memtable's filter
+// expected to be used by each key, which determines the number of bits set for
+// each key.
+const memTableBloomBitsPerKey = 10
+
 // memTableOptions holds configuration used when creating a memTable. All of
 // the fields are optional and will be filled with defaults if not specified
 // which is used by tests.
@@ -138,6 +149,10 @@ func newMemTable(opts memTableOptions) *memTable {
 	if m.arenaBuf == nil {
 		m.arenaBuf = make([]byte, opts.size)
 	}
+	if opts.MemTableBloomFilterPercent > 0 {
+		m.filter = bloom.NewDynamicFilter(opts.size*opts.MemTableBloomFilterPercent/100, memTableBloomBitsPerKey)
+		m.split = opts.Comparer.Split
+	}
 
 	arena := arenaskl.NewArena(m.arenaBuf)
 	m.skl.Reset(arena, m.cmp)
@@ -211,6 +226,9 @@ func (m *memTable) apply(batch *Batch, seqNum uint64) error {
 			// to the memtable.
 			seqNum--
 		default:
+			if m.filter != nil {
+				m.filter.Add(m.prefix(ukey))
+			}
 			err = ins.Add(&m.skl, ikey, value)
 		}
 		if err != nil {
@@ -230,6 +248,20 @@ func (m *memTable) apply(batch *Batch, seqNum uint64) error {
 	return nil
 }
 
+// prefix returns the prefix of the key that is added to the memtable's filter.
+func (m *memTable) prefix(key []byte) []byte {
+	if m.split == nil {
+		return key
+	}
+	return key[:m.split(key)]
+}
+
+// mayContain returns false if the memtable's filter excludes the point keys
+// with the given user key.
+func (m *memTable) mayContain(key []byte) bool {
+	return m.filter == nil || m.filter.MayContain(m.prefix(key))
+}
+
 // newIter returns an iterator that is unpositioned (Iterator.Valid() will
 // return false). The iterator can be positioned via a call to SeekGE,
 // SeekLT, First or Last.
@@ -19,6 +19,8 @@ import (
 	"github.com/cockroachdb/pebble/internal/base"
 	"github.com/cockroachdb/pebble/internal/datadriven"
 	"github.com/cockroachdb/pebble/internal/rangekey"
+	"github.com/cockroachdb/pebble/internal/testkeys"
+	"github.com/cockroachdb/pebble/vfs"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/exp/rand"
 	"golang.org/x/sync/errgroup"
@@ -389,6 +391,55 @@ func TestMemTableConcurrentDeleteRange(t *testing.T) {
 	}
 }
 
+func TestMemTableBloomFilter(t *testing.T) {
+	d, err := Open("", &Options{
+		FS:                         vfs.NewMem(),
+		Comparer:                   testkeys.Comparer,
+		MemTableBloomFilterPercent: 10,
+	})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	get := func(key string) string {
+		v, closer, err := d.Get([]byte(key))
+		if errors.Is(err, ErrNotFound) {
+			return "<not found>"
+		}
+		require.NoError(t, err)
+		defer closer.Close()
+		return string(v)
+	}
+
+	require.NoError(t, d.Set([]byte("a@1"), []byte("a1"), nil))
+	require.NoError(t, d.Set([]byte("c"), []byte("c"), nil))
+	require.NoError(t, d.Flush())
+	for i := 0; i < 100; i++ {
+		require.NoError(t, d.Set([]byte(fmt.Sprintf("b%03d@1", i)), []byte("b"), nil))
+	}
+	require.NoError(t, d.DeleteRange([]byte("c"), []byte("d"), nil))
+
+	before := d.Metrics().Filter
+	require.Equal(t, "b", get("b042@1"))
+	// The filter holds the prefixes of the keys, as determined by Split.
+	require.Equal(t, "<not found>", get("b042@2"))
+	after := d.Metrics().Filter
+	require.Equal(t, before.Misses+2, after.Misses)
+
+	// Keys not in the memtable are found in lower levels.
+	before = after
+	require.Equal(t, "a1", get("a@1"))
+	require.Equal(t, "<not found>", get("e"))
+	after = d.Metrics().Filter
+	require.Equal(t, before.Hits+2, after.Hits)
+
+	// The memtable's range deletions apply even if its filter excludes the
+	// key.
+	before = after
+	require.Equal(t, "<not found>", get("c"))
+	after = d.Metrics().Filter
+	require.Equal(t, before.Hits+1, after.Hits)
+}
+
 func buildMemTable(b *testing.B) (*memTable, [][]byte) {
 	m := newMemTable(memTableOptions{})
 	var keys [][]byte
@@ -6,6 +6,7 @@ package pebble
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"runtime/debug"
 
@@ -244,6 +245,11 @@ type mergingIter struct {
 
 	combinedIterState *combinedIterState
 
+	// ctx, if non-nil, is checked after each key skipped because it is
+	// deleted by a range tombstone, so that skipping a long run of deleted
+	// keys can be canceled.
+	ctx context.Context
+
 	// Elide range tombstones from being returned during iteration. Set to true
 	// when mergingIter is a child of Iterator and the mergingIter is processing
 	// range tombstones.
@@ -280,9 +286,11 @@ func (m *mergingIter) init(
 ) {
 	m.err = nil // clear cached iteration error
 	m.logger = opts.getLogger()
+	m.ctx = nil
 	if opts != nil {
 		m.lower = opts.LowerBound
 		m.upper = opts.UpperBound
+		m.ctx = opts.Context
 	}
 	m.snapshot = InternalKeySeqNumMax
 	m.levels = levels
@@ -696,6 +704,7 @@ func (m *mergingIter) findNextEntry() (*InternalKey, []byte) {
 		if m.isNextEntryDeleted(item) {
 			m.stats.PointsCoveredByRangeTombstones++
 			reseeked = true
+			m.checkContext()
 			continue
 		}
 		if item.key.Visible(m.snapshot) &&
@@ -708,6 +717,13 @@ func (m *mergingIter) findNextEntry() (*InternalKey, []byte) {
 	return nil, nil
 }
 
+// checkContext sets m.err to the error of m.ctx, if it is done.
+func (m *mergingIter) checkContext() {
+	if m.ctx != nil {
+		m.err = m.ctx.Err()
+	}
+}
+
 // Steps to the prev entry. item is the current top item in the heap.
 func (m *mergingIter) prevEntry(item *mergingIterItem) {
 	l := &m.levels[item.index]
@@ -850,6 +866,7 @@ func (m *mergingIter) findPrevEntry() (*InternalKey, []byte) {
 		m.addItemStats(item)
 		if m.isPrevEntryDeleted(item) {
 			m.stats.PointsCoveredByRangeTombstones++
+			m.checkContext()
 			continue
 		}
 		if item.key.Visible(m.snapshot) &&
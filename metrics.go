@@ -161,6 +161,9 @@ type Metrics struct {
 		Count int64
 	}
 
+	// Filter holds the metrics of the filters of sstables and, if
+	// Options.MemTableBloomFilterPercent is set, of memtables. A hit is a
+	// lookup of a data block or memtable skipped due to a filter.
 	Filter FilterMetrics
 
 	Levels [numLevels]LevelMetrics
@@ -203,6 +206,10 @@ type Metrics struct {
 	// Count of the number of open sstable iterators.
 	TableIters int64
 
+	// The number of bytes of sstable blocks, keys and values retained by open
+	// iterators configured with IterOptions.PinKeysAndValues.
+	PinnedIterBytes int64
+
 	WAL struct {
 		// Number of live WAL files.
 		Files int64
@@ -0,0 +1,329 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/cockroachdb/pebble/internal/keyspan"
+	"github.com/cockroachdb/pebble/internal/manifest"
+	"github.com/cockroachdb/pebble/sstable"
+)
+
+// multiGetConcurrency is the maximum number of goroutines a MultiGet uses to
+// check the filters of the tables of a level.
+const multiGetConcurrency = 8
+
+// MultiGetResult is the result of looking up one of the keys passed to
+// MultiGet.
+type MultiGetResult struct {
+	// Value is the value of the key, if Found. The caller should not modify
+	// the contents of Value, which remains valid until the io.Closer returned
+	// by MultiGet is closed.
+	Value []byte
+	// Found is false if the DB does not contain the key.
+	Found bool
+}
+
+// MultiGet gets the values of the given keys, reading all of them from the
+// same state of the DB. The i-th result holds the value of keys[i].
+//
+// MultiGet looks up the keys concurrently, level by level. Before the lookups
+// read an sstable level, the filter of each of its tables is checked at once
+// for all of the keys falling within the table that reach the level, and the
+// lookups skip the tables whose filters exclude their keys. It is more
+// efficient than calling Get for each key.
+//
+// It is safe to modify the contents of the arguments after MultiGet returns.
+// On success, the caller MUST call closer.Close() or a memory leak will
+// occur.
+func (d *DB) MultiGet(keys [][]byte) ([]MultiGetResult, io.Closer, error) {
+	return d.multiGetInternal(keys, nil /* snapshot */)
+}
+
+func (d *DB) multiGetInternal(keys [][]byte, s *Snapshot) ([]MultiGetResult, io.Closer, error) {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+
+	// Grab and reference the current readState, which is shared by the
+	// lookups of all of the keys. Each lookup takes its own reference, which
+	// is released when the lookup's Iterator is closed.
+	readState := d.loadReadState()
+	defer readState.unref()
+
+	var seqNum uint64
+	if s != nil {
+		seqNum = s.seqNum
+	} else {
+		seqNum = atomic.LoadUint64(&d.mu.versions.atomic.visibleSeqNum)
+	}
+
+	// Sort the keys, so that consecutive keys fall in the same tables.
+	mg := &multiGet{
+		d:       d,
+		keys:    keys,
+		order:   make([]int, len(keys)),
+		lookups: make([]multiGetLookup, len(keys)),
+		active:  len(keys),
+	}
+	mg.cond.L = &mg.mu
+	for i := range mg.order {
+		mg.order[i] = i
+	}
+	sort.Slice(mg.order, func(a, b int) bool {
+		return d.cmp(keys[mg.order[a]], keys[mg.order[b]]) < 0
+	})
+
+	results := make([]MultiGetResult, len(keys))
+	closer := &multiGetCloser{iters: make([]*Iterator, len(keys))}
+	errs := make([]error, len(keys))
+	var wg sync.WaitGroup
+	for k := range keys {
+		wg.Add(1)
+		readState.ref()
+		go func(k int) {
+			defer wg.Done()
+			i := d.newGetIterator(keys[k], nil /* batch */, readState, seqNum, mg.newLevelIters(k))
+			found := i.First()
+			mg.done()
+			if !found {
+				errs[k] = i.Close()
+				return
+			}
+			closer.iters[k] = i
+			results[k] = MultiGetResult{Value: i.Value(), Found: true}
+			errs[k] = i.Error()
+		}(k)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			_ = closer.Close()
+			return nil, nil, err
+		}
+	}
+	return results, closer, nil
+}
+
+// multiGet coordinates the lookups of the keys passed to MultiGet. Each key
+// is looked up by its own goroutine, and the lookups proceed through the
+// sstable levels in lockstep: a lookup about to read a level waits until
+// every lookup that has not completed is also waiting. The filters of the
+// shallowest level waited on are then checked for the keys of the lookups
+// waiting on it, which go on to read the level. Levels that no lookup
+// reaches are never checked.
+type multiGet struct {
+	d    *DB
+	keys [][]byte
+	// order holds the indexes of keys in key order.
+	order []int
+
+	mu      sync.Mutex
+	cond    sync.Cond
+	lookups []multiGetLookup
+	// active is the number of lookups that have not completed, and waiting
+	// the number of those that are waiting to read a level.
+	active, waiting int
+	err             error
+}
+
+// multiGetLookup is the state of the lookup of one of the keys of a MultiGet.
+type multiGetLookup struct {
+	// waiting is true while the lookup waits to read the level at depth,
+	// whose files are files.
+	waiting bool
+	depth   int
+	files   manifest.LevelSlice
+	// excluded is the set of tables of the level whose filters exclude the
+	// key, or nil if there are none.
+	excluded map[*fileMetadata]struct{}
+}
+
+// newLevelIters returns the getIter.newLevelIters of the lookup of keys[k].
+// It waits for the filters of the level to be checked, and returns a
+// tableNewIters that skips the tables whose filters exclude the key.
+func (mg *multiGet) newLevelIters(k int) func(int, manifest.LevelSlice) tableNewIters {
+	return func(depth int, files manifest.LevelSlice) tableNewIters {
+		mg.mu.Lock()
+		defer mg.mu.Unlock()
+		l := &mg.lookups[k]
+		*l = multiGetLookup{waiting: true, depth: depth, files: files}
+		mg.waiting++
+		mg.maybeCheckFiltersLocked()
+		for l.waiting {
+			mg.cond.Wait()
+		}
+		if err := mg.err; err != nil {
+			return func(
+				*manifest.FileMetadata, *IterOptions, *uint64,
+			) (internalIterator, keyspan.FragmentIterator, error) {
+				return nil, nil, err
+			}
+		}
+		if l.excluded == nil {
+			return mg.d.newIters
+		}
+		return mg.d.multiGetNewIters(l.excluded)
+	}
+}
+
+// done is called when a lookup completes.
+func (mg *multiGet) done() {
+	mg.mu.Lock()
+	defer mg.mu.Unlock()
+	mg.active--
+	mg.maybeCheckFiltersLocked()
+}
+
+// maybeCheckFiltersLocked checks the filters of the shallowest level waited
+// on, and releases the lookups waiting on it, once every lookup that has not
+// completed is waiting. The filters are checked with mg.mu held, as no other
+// lookup can make progress in the meantime.
+func (mg *multiGet) maybeCheckFiltersLocked() {
+	if mg.waiting == 0 || mg.waiting < mg.active {
+		return
+	}
+	depth := -1
+	var files manifest.LevelSlice
+	for i := range mg.lookups {
+		if l := &mg.lookups[i]; l.waiting && (depth < 0 || l.depth < depth) {
+			depth, files = l.depth, l.files
+		}
+	}
+	var keys []int
+	for _, k := range mg.order {
+		if l := &mg.lookups[k]; l.waiting && l.depth == depth {
+			keys = append(keys, k)
+		}
+	}
+	if mg.err == nil {
+		mg.err = mg.checkFilters(files, keys)
+	}
+	for _, k := range keys {
+		mg.lookups[k].waiting = false
+	}
+	mg.waiting -= len(keys)
+	mg.cond.Broadcast()
+}
+
+// checkFilters checks the filters of the tables of a level, whose files are
+// files, for the keys with the given indexes, which are in key order. The keys
+// falling within a table are checked against its filter at once, and tables
+// are checked concurrently. The tables whose filters exclude a key are
+// recorded in the key's lookup.
+func (mg *multiGet) checkFilters(files manifest.LevelSlice, keys []int) error {
+	d := mg.d
+	type tableProbe struct {
+		file *fileMetadata
+		// keys are the indexes of the keys within the bounds of the table,
+		// and excluded the subset of those excluded by its filter.
+		keys, excluded []int
+	}
+	var probes []tableProbe
+	probeIndex := make(map[*fileMetadata]int)
+	iter := files.Iter()
+	for _, k := range keys {
+		key := mg.keys[k]
+		// A user key may be spread across multiple tables of a level.
+		for f := iter.SeekGE(d.cmp, key); f != nil && d.cmp(f.Smallest.UserKey, key) <= 0; f = iter.Next() {
+			if !f.HasPointKeys {
+				continue
+			}
+			i, ok := probeIndex[f]
+			if !ok {
+				i = len(probes)
+				probeIndex[f] = i
+				probes = append(probes, tableProbe{file: f})
+			}
+			probes[i].keys = append(probes[i].keys, k)
+		}
+	}
+
+	errs := make([]error, len(probes))
+	sem := make(chan struct{}, multiGetConcurrency)
+	var wg sync.WaitGroup
+	for i := range probes {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			p := &probes[i]
+			prefixes := make([][]byte, len(p.keys))
+			for j, k := range p.keys {
+				prefixes[j] = mg.keys[k]
+				if d.split != nil {
+					prefixes[j] = mg.keys[k][:d.split(mg.keys[k])]
+				}
+			}
+			mayContain := make([]bool, len(p.keys))
+			errs[i] = d.tableCache.withReader(p.file, func(r *sstable.Reader) error {
+				return r.MayContain(prefixes, mayContain)
+			})
+			for j, ok := range mayContain {
+				if !ok {
+					p.excluded = append(p.excluded, p.keys[j])
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for i := range probes {
+		if errs[i] != nil {
+			return errs[i]
+		}
+		for _, k := range probes[i].excluded {
+			l := &mg.lookups[k]
+			if l.excluded == nil {
+				l.excluded = make(map[*fileMetadata]struct{})
+			}
+			l.excluded[probes[i].file] = struct{}{}
+		}
+	}
+	return nil
+}
+
+// multiGetNewIters returns a tableNewIters that skips the point keys of the
+// tables whose filters exclude the key being looked up. The range deletions of
+// those tables are still returned, as they may delete the key in lower levels.
+func (d *DB) multiGetNewIters(excluded map[*fileMetadata]struct{}) tableNewIters {
+	return func(
+		file *manifest.FileMetadata, opts *IterOptions, bytesIterated *uint64,
+	) (internalIterator, keyspan.FragmentIterator, error) {
+		if _, ok := excluded[file]; !ok {
+			return d.newIters(file, opts, bytesIterated)
+		}
+		var o IterOptions
+		if opts != nil {
+			o = *opts
+		}
+		o.TableFilter = func(map[string]string) bool { return false }
+		return d.newIters(file, &o, bytesIterated)
+	}
+}
+
+// multiGetCloser closes the Iterators holding the values returned by a
+// MultiGet.
+type multiGetCloser struct {
+	iters []*Iterator
+}
+
+func (c *multiGetCloser) Close() error {
+	var err error
+	for _, i := range c.iters {
+		if i != nil {
+			err = firstError(err, i.Close())
+		}
+	}
+	return err
+}
@@ -100,6 +100,9 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 			if d.tableCache != nil {
 				_ = d.tableCache.close()
 			}
+			if d.blobs != nil {
+				_ = d.blobs.close()
+			}
 
 			for _, mem := range d.mu.mem.queue {
 				switch t := mem.flushable.(type) {
@@ -123,6 +126,11 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 	d.deletionLimiter = rate.NewLimiter(
 		rate.Limit(d.opts.Experimental.MinDeletionRate),
 		d.opts.Experimental.MinDeletionRate)
+	if d.opts.Experimental.BackgroundWriteRate > 0 {
+		d.writeLimiter = newWriteLimiter(
+			d.opts.Experimental.BackgroundWriteRate,
+			d.opts.Experimental.CompactionDebtConcurrency)
+	}
 	d.mu.nextJobID = 1
 	d.mu.mem.nextSize = opts.MemTableSize
 	if d.mu.mem.nextSize > initialMemTableSize {
@@ -361,9 +369,6 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 		opts.UniqueID = uniqueID
 	}
 
-	// Inject UniqueID to sstable package
-	sstable.DBUniqueID = opts.UniqueID
-
 	if opts.SharedFS != nil && opts.PersistentCacheSize != 0 {
 		d.persistentCache = newPersistentCache(opts.FS, dirname, opts.SharedFS, opts.SharedDir, opts.UniqueID, opts.PersistentCacheSize)
 		d.persistentCache.Start()
@@ -373,6 +378,7 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 	d.tableCache = newTableCacheContainer(opts.TableCache, d.cacheID, dirname, opts.FS, opts.SharedDir, opts.SharedFS, d.persistentCache, d.opts, tableCacheSize)
 	d.newIters = d.tableCache.newIters
 	d.tableNewRangeKeyIter = d.tableCache.newRangeKeyIter
+	d.blobs = newBlobFileCache(opts.FS, dirname)
 
 	sort.Slice(logFiles, func(i, j int) bool {
 		return logFiles[i].num < logFiles[j].num
@@ -445,36 +451,11 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 	if !d.opts.ReadOnly {
 		// Write the current options to disk.
 		d.optionsFileNum = d.mu.versions.getNextFileNum()
-		tmpPath := base.MakeFilepath(opts.FS, dirname, fileTypeTemp, d.optionsFileNum)
-		optionsPath := base.MakeFilepath(opts.FS, dirname, fileTypeOptions, d.optionsFileNum)
-
-		// Write them to a temporary file first, in case we crash before
-		// we're done. A corrupt options file prevents opening the
-		// database.
-		optionsFile, err := opts.FS.Create(tmpPath)
-		if err != nil {
-			return nil, err
-		}
 		serializedOpts := []byte(opts.String())
-		if _, err := optionsFile.Write(serializedOpts); err != nil {
-			return nil, errors.CombineErrors(err, optionsFile.Close())
-		}
-		d.optionsFileSize = uint64(len(serializedOpts))
-		if err := optionsFile.Sync(); err != nil {
-			return nil, errors.CombineErrors(err, optionsFile.Close())
-		}
-		if err := optionsFile.Close(); err != nil {
-			return nil, err
-		}
-		// Atomically rename to the OPTIONS-XXXXXX path. This rename is
-		// guaranteed to be atomic because the destination path does not
-		// exist.
-		if err := opts.FS.Rename(tmpPath, optionsPath); err != nil {
-			return nil, err
-		}
-		if err := d.dataDir.Sync(); err != nil {
+		if err := d.writeOptionsFile(d.optionsFileNum, serializedOpts); err != nil {
 			return nil, err
 		}
+		d.optionsFileSize = uint64(len(serializedOpts))
 	}
 
 	if !d.opts.ReadOnly {
@@ -486,13 +467,38 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 	}
 	d.mu.tableStats.cond.L = &d.mu.Mutex
 	d.mu.tableValidation.cond.L = &d.mu.Mutex
+	d.mu.sharedWarmup.cond.L = &d.mu.Mutex
 	if !d.opts.ReadOnly && !d.opts.private.disableTableStats {
 		d.maybeCollectTableStatsLocked()
 	}
+	if d.opts.WarmSharedTables {
+		var shared []newFileEntry
+		current := d.mu.versions.currentVersion()
+		for level := range current.Levels {
+			iter := current.Levels[level].Iter()
+			for f := iter.First(); f != nil; f = iter.Next() {
+				shared = append(shared, newFileEntry{Level: level, Meta: f})
+			}
+		}
+		d.maybeWarmSharedTablesLocked(shared)
+	}
 	d.calculateDiskAvailableBytes()
 
 	d.maybeScheduleFlush()
 	d.maybeScheduleCompaction()
+	if !d.opts.ReadOnly {
+		var interval time.Duration
+		if d.opts.TTL > 0 {
+			interval = ageCheckInterval(d.opts.TTL)
+		}
+		if p := d.opts.PeriodicCompactionPeriod; p > 0 &&
+			(interval == 0 || ageCheckInterval(p) < interval) {
+			interval = ageCheckInterval(p)
+		}
+		if interval > 0 {
+			go d.runAgeTicker(interval)
+		}
+	}
 
 	// Note: this is a no-op if invariants are disabled or race is enabled.
 	//
@@ -523,6 +529,35 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 	return d, nil
 }
 
+// writeOptionsFile writes the serialized options to the OPTIONS file with the
+// given file number, and syncs the data directory.
+func (d *DB) writeOptionsFile(fileNum FileNum, serializedOpts []byte) error {
+	tmpPath := base.MakeFilepath(d.opts.FS, d.dirname, fileTypeTemp, fileNum)
+	optionsPath := base.MakeFilepath(d.opts.FS, d.dirname, fileTypeOptions, fileNum)
+
+	// Write them to a temporary file first, in case we crash before we're
+	// done. A corrupt options file prevents opening the database.
+	optionsFile, err := d.opts.FS.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	if _, err := optionsFile.Write(serializedOpts); err != nil {
+		return errors.CombineErrors(err, optionsFile.Close())
+	}
+	if err := optionsFile.Sync(); err != nil {
+		return errors.CombineErrors(err, optionsFile.Close())
+	}
+	if err := optionsFile.Close(); err != nil {
+		return err
+	}
+	// Atomically rename to the OPTIONS-XXXXXX path. This rename is guaranteed
+	// to be atomic because the destination path does not exist.
+	if err := d.opts.FS.Rename(tmpPath, optionsPath); err != nil {
+		return err
+	}
+	return d.dataDir.Sync()
+}
+
 // GetVersion returns the engine version string from the latest options
 // file present in dir. Used to check what Pebble or RocksDB version was last
 // used to write to the database stored in this directory. An empty string is
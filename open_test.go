@@ -148,7 +148,7 @@ func TestNewDBFilenames(t *testing.T) {
 			"LOCK",
 			"MANIFEST-000001",
 			"OPTIONS-000003",
-			"marker.format-version.000007.008",
+			"marker.format-version.000010.011",
 			"marker.manifest.000001.MANIFEST-000001",
 		},
 	}
@@ -6,8 +6,10 @@ package pebble
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
+	"math"
 	"runtime"
 	"strconv"
 	"strings"
@@ -163,8 +165,40 @@ type IterOptions struct {
 	// existing is not low or if we just expect a one-time Seek (where loading the
 	// data block directly is better).
 	UseL6Filters bool
+	// Tailing configures the iterator to observe writes committed after it
+	// was created. When Next reaches the end of the keys visible to the
+	// iterator, it picks up newly committed keys, flushed memtables and
+	// ingested tables, and resumes after the last key it returned. A key that
+	// was already returned is not returned again, even if it was overwritten.
+	// Tailing iterators only support point keys, and may not be used with
+	// batches or snapshots, or for prefix iteration.
+	Tailing bool
+	// Context, if non-nil, bounds the time the iterator spends reading. It is
+	// checked before opening each sstable, before reading each sstable block,
+	// and while skipping keys deleted by range deletions. Once it is done, the
+	// iterator becomes invalid and Error returns the context's error. Reads
+	// from memtables do not check the context, and a block read that is in
+	// progress, e.g. from Options.SharedFS, is not interrupted.
+	Context context.Context
+	// PinKeysAndValues, if true, keeps the slices returned by Iterator.Key
+	// and Iterator.Value valid until the iterator is closed, rather than only
+	// until the next call that moves the iterator. The sstable blocks the
+	// iterator reads are kept in memory until it is closed, so that keys and
+	// values stored in them, as in memtables and batches, are returned without
+	// copying. Keys stored with prefix compression in sstable blocks, and
+	// values that the iterator must construct, such as the results of merges,
+	// are copied into memory held by the iterator. The memory retained is
+	// reported by IteratorStats.PinnedBytes and Metrics.PinnedIterBytes.
+	//
+	// Pinning suits iterators over small spans whose keys and values are
+	// retained by the caller. A long scan with pinning retains every block it
+	// reads, which may exceed the block cache's capacity.
+	PinKeysAndValues bool
 	// Internal options.
 	logger Logger
+	// pinnedBlocks holds the sstable blocks pinned by an Iterator with
+	// PinKeysAndValues set.
+	pinnedBlocks *sstable.PinnedBlocks
 	// Level corresponding to this file. Only passed in if constructed by a
 	// levelIter (including regular reads and compaction inputs).
 	level manifest.Level
@@ -299,11 +333,22 @@ type LevelOptions struct {
 	// The default value (DefaultCompression) uses snappy compression.
 	Compression Compression
 
+	// DataBlockHashIndex enables a hash index in each data block, allowing
+	// point lookups to skip the binary search over the block's restart points.
+	// See sstable.WriterOptions.DataBlockHashIndex. Hash indexes are only
+	// written once the DB's format major version is at least
+	// FormatDataBlockHashIndex.
+	//
+	// The default value is false.
+	DataBlockHashIndex bool
+
 	// FilterPolicy defines a filter algorithm (such as a Bloom filter) that can
 	// reduce disk reads for Get calls.
 	//
 	// One such implementation is bloom.FilterPolicy(10) from the pebble/bloom
-	// package.
+	// package. Another is ribbon.FilterPolicy(10) from the pebble/ribbon
+	// package, which has the same false positive rate using ~30% less space,
+	// but is slower to build.
 	//
 	// The default value means to use no filter.
 	FilterPolicy FilterPolicy
@@ -326,6 +371,17 @@ type LevelOptions struct {
 	// The default value is the value of BlockSize.
 	IndexBlockSize int
 
+	// PartitionedFilters splits the table filter of tables with a two-level
+	// index into partitions aligned with the lower-level index blocks, so
+	// that a lookup only loads the partition covering its key. See
+	// sstable.WriterOptions.PartitionedFilters. Has no effect unless
+	// FilterPolicy is set and FilterType is TableFilter. Filters are only
+	// partitioned once the DB's format major version is at least
+	// FormatPartitionedFilters.
+	//
+	// The default value is false.
+	PartitionedFilters bool
+
 	// The target file size for the level.
 	TargetFileSize int64
 }
@@ -358,6 +414,88 @@ func (o *LevelOptions) EnsureDefaults() *LevelOptions {
 	return o
 }
 
+// CompactionStyle selects the strategy used to pick automatic compactions.
+type CompactionStyle int8
+
+const (
+	// CompactionStyleLevel picks compactions by comparing the size of each
+	// level to a dynamically computed target size, compacting a level into the
+	// next one once it exceeds its target.
+	CompactionStyleLevel CompactionStyle = iota
+	// CompactionStyleUniversal treats L0 and every non-empty level as a sorted
+	// run, and merges adjacent sorted runs of similar size into one. It
+	// trades higher space and read amplification for lower write
+	// amplification. See UniversalCompactionOptions.
+	CompactionStyleUniversal
+)
+
+// String implements fmt.Stringer.
+func (s CompactionStyle) String() string {
+	switch s {
+	case CompactionStyleLevel:
+		return "level"
+	case CompactionStyleUniversal:
+		return "universal"
+	default:
+		panic(fmt.Sprintf("unknown compaction style %d", s))
+	}
+}
+
+// UniversalCompactionOptions holds the parameters for the universal
+// compaction style. Each L0 sublevel and each non-empty level of L1-L6 is a
+// sorted run. Once the number of sorted runs reaches
+// Options.L0CompactionThreshold, a compaction merging adjacent sorted runs is
+// picked.
+type UniversalCompactionOptions struct {
+	// SizeRatio is the percentage by which a sorted run may be larger than the
+	// sum of the newer sorted runs picked so far for it to be picked as well.
+	//
+	// The default value is 1.
+	SizeRatio int
+
+	// MinMergeWidth is the minimum number of sorted runs merged by a
+	// compaction picked because of the size ratio.
+	//
+	// The default value is 2.
+	MinMergeWidth int
+
+	// MaxMergeWidth is the maximum number of sorted runs merged by a
+	// compaction picked because of the size ratio or because of the number of
+	// sorted runs.
+	//
+	// The default value is 0, which does not limit the number of sorted runs.
+	MaxMergeWidth int
+
+	// MaxSizeAmplificationPercent is the size of all sorted runs but the
+	// oldest one, as a percentage of the oldest sorted run, beyond which all
+	// sorted runs are merged into one.
+	//
+	// The default value is 200.
+	MaxSizeAmplificationPercent int
+}
+
+// EnsureDefaults ensures that the default values for all of the options have
+// been initialized. It is valid to call EnsureDefaults on a nil receiver. A
+// non-nil result will always be returned.
+func (o *UniversalCompactionOptions) EnsureDefaults() *UniversalCompactionOptions {
+	if o == nil {
+		o = &UniversalCompactionOptions{}
+	}
+	if o.SizeRatio <= 0 {
+		o.SizeRatio = 1
+	}
+	if o.MinMergeWidth < 2 {
+		o.MinMergeWidth = 2
+	}
+	if o.MaxMergeWidth < 0 {
+		o.MaxMergeWidth = 0
+	}
+	if o.MaxSizeAmplificationPercent <= 0 {
+		o.MaxSizeAmplificationPercent = 200
+	}
+	return o
+}
+
 // Options holds the optional parameters for configuring pebble. These options
 // apply to the DB at large; per-query options are defined by the IterOptions
 // and WriteOptions types.
@@ -381,6 +519,20 @@ type Options struct {
 	// The default cleaner uses the DeleteCleaner.
 	Cleaner Cleaner
 
+	// CompactionFilter, if set, creates the CompactionFilter used by a flush or
+	// compaction to drop or rewrite point keys. It may return nil if the keys
+	// written by the flush or compaction are not filtered.
+	CompactionFilter func(ctx CompactionFilterContext) CompactionFilter
+
+	// CompactionStyle selects the strategy used to pick automatic compactions.
+	//
+	// The default value is CompactionStyleLevel.
+	CompactionStyle CompactionStyle
+
+	// UniversalCompaction configures the picking of compactions when
+	// CompactionStyle is CompactionStyleUniversal.
+	UniversalCompaction UniversalCompactionOptions
+
 	// Comparer defines a total ordering over the space of []byte keys: a 'less
 	// than' relationship. The same comparison algorithm must be used for reads
 	// and writes over the lifetime of the DB.
@@ -435,6 +587,17 @@ type Options struct {
 		// concurrency slots as determined by the two options is chosen.
 		CompactionDebtConcurrency int
 
+		// BackgroundWriteRate is the number of bytes per second that flushes,
+		// compactions and uploads of sstables to shared storage may write,
+		// combined. The rate is raised by BackgroundWriteRate for every
+		// CompactionDebtConcurrency bytes of compaction debt, so that
+		// compactions can catch up once they have fallen behind. Flushes and
+		// compactions out of L0 take priority: they are never slowed down, but
+		// the bytes they write count against the rate left to other
+		// compactions. Setting this to 0 disables background write pacing,
+		// which is also the default.
+		BackgroundWriteRate int
+
 		// DeleteRangeFlushDelay configures how long the database should wait
 		// before forcing a flush of a memtable that contains a range
 		// deletion. Disk space cannot be reclaimed until the range deletion
@@ -621,12 +784,40 @@ type Options struct {
 	// the queued MemTables.
 	MemTableSize int
 
+	// MemTableBloomFilterPercent, if positive, enables a bloom filter over the
+	// prefixes (as determined by Comparer.Split) of the point keys of each
+	// MemTable, sized to the given percentage of the MemTable's size. Gets
+	// consult the filter before searching the MemTable, which avoids searching
+	// MemTables that do not contain the key. A MemTableBloomFilterPercent of 1
+	// yields a false positive rate of ~1% for keys and values averaging 80
+	// bytes.
+	//
+	// The default value is 0, which disables the filter.
+	MemTableBloomFilterPercent int
+
 	// Hard limit on the size of queued of MemTables. Writes are stopped when the
 	// sum of the queued memtable sizes exceeds
 	// MemTableStopWritesThreshold*MemTableSize. This value should be at least 2
 	// or writes will stop whenever a MemTable is being flushed.
 	MemTableStopWritesThreshold int
 
+	// MinBlobSize enables key-value separation: values of at least MinBlobSize
+	// bytes are written to blob files when memtables are flushed, and the
+	// sstables store handles to the values instead. Compactions rewrite the
+	// handles rather than the values, and blob files are deleted once no
+	// sstable references them. Iterators read a value from its blob file when
+	// it is retrieved. Zero, the default, disables key-value separation.
+	// Requires FormatBlobFiles.
+	//
+	// Blob files are always stored in FS, and sstables referencing them are
+	// always stored locally, regardless of the PlacementPolicy: sstables in
+	// SharedFS must be self-contained, as they are read by remote compaction
+	// workers and by other DBs they are exported to. A compaction whose
+	// outputs are placed in shared storage therefore reads the separated
+	// values back into its outputs, and values are only separated in the
+	// levels stored locally.
+	MinBlobSize int
+
 	// Merger defines the associative merge operation to use for merging values
 	// written with {Batch,DB}.Merge.
 	//
@@ -640,6 +831,14 @@ type Options struct {
 	// - when a manual compaction for a level is split and parallelized
 	MaxConcurrentCompactions int
 
+	// MaxSubcompactions specifies the maximum number of key-range
+	// sub-compactions that a single automatic compaction may be split into.
+	// The sub-compactions are run in parallel, and each sub-compaction beyond
+	// the first occupies one of the MaxConcurrentCompactions slots while the
+	// compaction runs. The outputs of the sub-compactions are installed in a
+	// single version edit. The default is 1, which disables sub-compactions.
+	MaxSubcompactions int
+
 	// DisableAutomaticCompactions dictates whether automatic compactions are
 	// scheduled or not. The default is false (enabled). This option is only used
 	// externally when running a manual compaction, and internally for tests.
@@ -676,10 +875,37 @@ type Options struct {
 	// files from that of others in SharedFS.
 	UniqueID uint32
 
+	// PlacementPolicy decides whether flushed, compacted and ingested sstables
+	// are stored in FS or SharedFS. It is only consulted when SharedFS is set.
+	// The default is DefaultPlacementPolicy, which places L5 and L6 in
+	// SharedFS.
+	PlacementPolicy PlacementPolicy
+
+	// CompactionExecutor, if set, runs compactions whose inputs and outputs
+	// are all stored in SharedFS outside of the DB, e.g. on another machine.
+	// If the executor fails to run a compaction, the DB runs it itself. See
+	// CompactionExecutor.
+	CompactionExecutor CompactionExecutor
+
 	// PersistentCacheSize is the size of persistent cache in bytes.
 	// If it is zero, no persistent case will be created.
 	PersistentCacheSize uint64
 
+	// WarmSharedTables enables loading the index and filter blocks of shared
+	// sstables into the block cache in the background, when the DB is opened
+	// and after shared sstables are ingested. Warmed tables don't pay the
+	// latency of SharedFS for their metadata blocks on their first reads.
+	// Tables in higher levels are warmed first and, within a level, tables with
+	// more sampled reads are warmed first. Warm-ups do not populate the
+	// persistent cache, as sstable.PersistentCacheOpt does not currently hand
+	// it to sstable readers.
+	WarmSharedTables bool
+
+	// WarmSharedTablesBytes limits the number of bytes of blocks loaded by a
+	// warm-up of shared sstables. Tables left over once the budget is
+	// exhausted are not warmed. If zero, the number of bytes is unlimited.
+	WarmSharedTablesBytes uint64
+
 	// TableCache is an initialized TableCache which should be set as an
 	// option if the DB needs to be initialized with a pre-existing table cache.
 	// If TableCache is nil, then a table cache which is unique to the DB instance
@@ -693,6 +919,36 @@ type Options struct {
 	// and lives for the lifetime of the table.
 	TablePropertyCollectors []func() TablePropertyCollector
 
+	// TTL, if non-zero, is the age after which sstables are dropped from the
+	// LSM as a whole, without rewriting them. The age of an sstable is
+	// measured from its creation time, so an sstable that is rewritten by a
+	// compaction becomes young again. An sstable is only dropped once no open
+	// snapshot can read any of its keys, and once every older sstable that
+	// overlaps it can be dropped as well, so that dropping it never exposes the
+	// older versions of its keys, including those it deletes.
+	//
+	// The default value is 0, i.e. sstables never expire.
+	TTL time.Duration
+
+	// KeyExpired, if set along with TTL, is invoked by compactions for the
+	// newest version of every SET key that is not visible to an open
+	// snapshot. It returns whether the key's data was written before cutoff,
+	// which is the current time minus TTL, in which case the key is deleted.
+	// Older versions of the key are deleted as well.
+	KeyExpired func(userKey, value []byte, cutoff time.Time) bool
+
+	// PeriodicCompactionPeriod, if non-zero, is the age after which sstables
+	// are marked for compaction. Marked sstables are compacted into the next
+	// level, or rewritten in place in the bottommost level, when there is no
+	// other compaction to perform. This bounds the time that point tombstones
+	// and range deletions, and the data they delete, remain on disk in key
+	// ranges that are no longer written. As with TTL, the age of an sstable is
+	// measured from its creation time.
+	//
+	// The default value is 0, i.e. sstables are never compacted because of
+	// their age.
+	PeriodicCompactionPeriod time.Duration
+
 	// BlockPropertyCollectors is a list of BlockPropertyCollector creation
 	// functions. A new BlockPropertyCollector is created for each sstable
 	// built and lives for the lifetime of writing that table.
@@ -776,6 +1032,10 @@ func (o *Options) EnsureDefaults() *Options {
 	if o.Comparer == nil {
 		o.Comparer = DefaultComparer
 	}
+	o.UniversalCompaction.EnsureDefaults()
+	if o.PlacementPolicy == nil {
+		o.PlacementPolicy = DefaultPlacementPolicy
+	}
 	if o.Experimental.L0CompactionConcurrency <= 0 {
 		o.Experimental.L0CompactionConcurrency = 10
 	}
@@ -859,6 +1119,9 @@ func (o *Options) EnsureDefaults() *Options {
 	if o.MaxConcurrentCompactions <= 0 {
 		o.MaxConcurrentCompactions = 1
 	}
+	if o.MaxSubcompactions <= 0 {
+		o.MaxSubcompactions = 1
+	}
 	if o.NumPrevManifest <= 0 {
 		o.NumPrevManifest = 1
 	}
@@ -957,10 +1220,12 @@ func (o *Options) String() string {
 	fmt.Fprintf(&buf, "  pebble_version=0.1\n")
 	fmt.Fprintf(&buf, "\n")
 	fmt.Fprintf(&buf, "[Options]\n")
+	fmt.Fprintf(&buf, "  background_write_rate=%d\n", o.Experimental.BackgroundWriteRate)
 	fmt.Fprintf(&buf, "  bytes_per_sync=%d\n", o.BytesPerSync)
 	fmt.Fprintf(&buf, "  cache_size=%d\n", cacheSize)
 	fmt.Fprintf(&buf, "  cleaner=%s\n", o.Cleaner)
 	fmt.Fprintf(&buf, "  compaction_debt_concurrency=%d\n", o.Experimental.CompactionDebtConcurrency)
+	fmt.Fprintf(&buf, "  compaction_style=%s\n", o.CompactionStyle)
 	fmt.Fprintf(&buf, "  comparer=%s\n", o.Comparer.Name)
 	fmt.Fprintf(&buf, "  delete_range_flush_delay=%s\n", o.Experimental.DeleteRangeFlushDelay)
 	fmt.Fprintf(&buf, "  disable_wal=%t\n", o.DisableWAL)
@@ -974,10 +1239,14 @@ func (o *Options) String() string {
 	fmt.Fprintf(&buf, "  max_concurrent_compactions=%d\n", o.MaxConcurrentCompactions)
 	fmt.Fprintf(&buf, "  max_manifest_file_size=%d\n", o.MaxManifestFileSize)
 	fmt.Fprintf(&buf, "  max_open_files=%d\n", o.MaxOpenFiles)
+	fmt.Fprintf(&buf, "  max_subcompactions=%d\n", o.MaxSubcompactions)
+	fmt.Fprintf(&buf, "  mem_table_bloom_filter_percent=%d\n", o.MemTableBloomFilterPercent)
 	fmt.Fprintf(&buf, "  mem_table_size=%d\n", o.MemTableSize)
 	fmt.Fprintf(&buf, "  mem_table_stop_writes_threshold=%d\n", o.MemTableStopWritesThreshold)
+	fmt.Fprintf(&buf, "  min_blob_size=%d\n", o.MinBlobSize)
 	fmt.Fprintf(&buf, "  min_deletion_rate=%d\n", o.Experimental.MinDeletionRate)
 	fmt.Fprintf(&buf, "  merger=%s\n", o.Merger.Name)
+	fmt.Fprintf(&buf, "  periodic_compaction_period=%s\n", o.PeriodicCompactionPeriod)
 	fmt.Fprintf(&buf, "  read_compaction_rate=%d\n", o.Experimental.ReadCompactionRate)
 	fmt.Fprintf(&buf, "  read_sampling_multiplier=%d\n", o.Experimental.ReadSamplingMultiplier)
 	fmt.Fprintf(&buf, "  strict_wal_tail=%t\n", o.private.strictWALTail)
@@ -992,6 +1261,7 @@ func (o *Options) String() string {
 		fmt.Fprintf(&buf, "%s", o.TablePropertyCollectors[i]().Name())
 	}
 	fmt.Fprintf(&buf, "]\n")
+	fmt.Fprintf(&buf, "  ttl=%s\n", o.TTL)
 	fmt.Fprintf(&buf, "  unique_id=%d\n", o.UniqueID)
 	fmt.Fprintf(&buf, "  validate_on_ingest=%t\n", o.Experimental.ValidateOnIngest)
 	fmt.Fprintf(&buf, "  wal_dir=%s\n", o.WALDir)
@@ -999,6 +1269,16 @@ func (o *Options) String() string {
 	fmt.Fprintf(&buf, "  max_writer_concurrency=%d\n", o.Experimental.MaxWriterConcurrency)
 	fmt.Fprintf(&buf, "  force_writer_parallelism=%t\n", o.Experimental.ForceWriterParallelism)
 
+	if o.CompactionStyle == CompactionStyleUniversal {
+		u := &o.UniversalCompaction
+		fmt.Fprintf(&buf, "\n")
+		fmt.Fprintf(&buf, "[Universal]\n")
+		fmt.Fprintf(&buf, "  max_merge_width=%d\n", u.MaxMergeWidth)
+		fmt.Fprintf(&buf, "  max_size_amplification_percent=%d\n", u.MaxSizeAmplificationPercent)
+		fmt.Fprintf(&buf, "  min_merge_width=%d\n", u.MinMergeWidth)
+		fmt.Fprintf(&buf, "  size_ratio=%d\n", u.SizeRatio)
+	}
+
 	for i := range o.Levels {
 		l := &o.Levels[i]
 		fmt.Fprintf(&buf, "\n")
@@ -1006,9 +1286,11 @@ func (o *Options) String() string {
 		fmt.Fprintf(&buf, "  block_restart_interval=%d\n", l.BlockRestartInterval)
 		fmt.Fprintf(&buf, "  block_size=%d\n", l.BlockSize)
 		fmt.Fprintf(&buf, "  compression=%s\n", l.Compression)
+		fmt.Fprintf(&buf, "  data_block_hash_index=%t\n", l.DataBlockHashIndex)
 		fmt.Fprintf(&buf, "  filter_policy=%s\n", filterPolicyName(l.FilterPolicy))
 		fmt.Fprintf(&buf, "  filter_type=%s\n", l.FilterType)
 		fmt.Fprintf(&buf, "  index_block_size=%d\n", l.IndexBlockSize)
+		fmt.Fprintf(&buf, "  partitioned_filters=%t\n", l.PartitionedFilters)
 		fmt.Fprintf(&buf, "  target_file_size=%d\n", l.TargetFileSize)
 	}
 
@@ -1100,6 +1382,8 @@ func (o *Options) Parse(s string, hooks *ParseHooks) error {
 		case section == "Options":
 			var err error
 			switch key {
+			case "background_write_rate":
+				o.Experimental.BackgroundWriteRate, err = strconv.Atoi(value)
 			case "bytes_per_sync":
 				o.BytesPerSync, err = strconv.Atoi(value)
 			case "cache_size":
@@ -1135,6 +1419,17 @@ func (o *Options) Parse(s string, hooks *ParseHooks) error {
 				}
 			case "compaction_debt_concurrency":
 				o.Experimental.CompactionDebtConcurrency, err = strconv.Atoi(value)
+			case "compaction_style":
+				// RocksDB OPTIONS files name the compaction styles
+				// kCompactionStyle{Level,Universal}.
+				switch value {
+				case "level", "kCompactionStyleLevel":
+					o.CompactionStyle = CompactionStyleLevel
+				case "universal", "kCompactionStyleUniversal":
+					o.CompactionStyle = CompactionStyleUniversal
+				default:
+					return errors.Errorf("pebble: unknown compaction style: %q", errors.Safe(value))
+				}
 			case "delete_range_flush_delay":
 				o.Experimental.DeleteRangeFlushDelay, err = time.ParseDuration(value)
 			case "disable_wal":
@@ -1172,10 +1467,16 @@ func (o *Options) Parse(s string, hooks *ParseHooks) error {
 				o.MaxManifestFileSize, err = strconv.ParseInt(value, 10, 64)
 			case "max_open_files":
 				o.MaxOpenFiles, err = strconv.Atoi(value)
+			case "max_subcompactions":
+				o.MaxSubcompactions, err = strconv.Atoi(value)
+			case "mem_table_bloom_filter_percent":
+				o.MemTableBloomFilterPercent, err = strconv.Atoi(value)
 			case "mem_table_size":
 				o.MemTableSize, err = strconv.Atoi(value)
 			case "mem_table_stop_writes_threshold":
 				o.MemTableStopWritesThreshold, err = strconv.Atoi(value)
+			case "min_blob_size":
+				o.MinBlobSize, err = strconv.Atoi(value)
 			case "min_compaction_rate":
 				// Do nothing; option existed in older versions of pebble, and
 				// may be meaningful again eventually.
@@ -1197,6 +1498,17 @@ func (o *Options) Parse(s string, hooks *ParseHooks) error {
 						o.Merger, err = hooks.NewMerger(value)
 					}
 				}
+			case "periodic_compaction_period":
+				o.PeriodicCompactionPeriod, err = time.ParseDuration(value)
+			case "periodic_compaction_seconds":
+				// RocksDB's equivalent of periodic_compaction_period. Like
+				// ttl, it uses values too large for a time.Duration to select
+				// its default.
+				var secs uint64
+				if secs, err = strconv.ParseUint(value, 10, 64); err == nil &&
+					secs <= uint64(math.MaxInt64/time.Second) {
+					o.PeriodicCompactionPeriod = time.Duration(secs) * time.Second
+				}
 			case "read_compaction_rate":
 				o.Experimental.ReadCompactionRate, err = strconv.ParseInt(value, 10, 64)
 			case "read_sampling_multiplier":
@@ -1212,6 +1524,17 @@ func (o *Options) Parse(s string, hooks *ParseHooks) error {
 				}
 			case "table_property_collectors":
 				// TODO(peter): set o.TablePropertyCollectors
+			case "ttl":
+				// RocksDB specifies the TTL in seconds, and uses values too large
+				// for a time.Duration to select its default.
+				var secs uint64
+				if secs, err = strconv.ParseUint(value, 10, 64); err == nil {
+					if secs <= uint64(math.MaxInt64/time.Second) {
+						o.TTL = time.Duration(secs) * time.Second
+					}
+				} else {
+					o.TTL, err = time.ParseDuration(value)
+				}
 			case "unique_id":
 				var uniqueID uint64
 				uniqueID, err = strconv.ParseUint(value, 10, 32)
@@ -1235,6 +1558,27 @@ func (o *Options) Parse(s string, hooks *ParseHooks) error {
 			}
 			return err
 
+		case section == "Universal":
+			u := &o.UniversalCompaction
+			var err error
+			switch key {
+			case "max_merge_width":
+				u.MaxMergeWidth, err = strconv.Atoi(value)
+			case "max_size_amplification_percent":
+				u.MaxSizeAmplificationPercent, err = strconv.Atoi(value)
+			case "min_merge_width":
+				u.MinMergeWidth, err = strconv.Atoi(value)
+			case "size_ratio":
+				u.SizeRatio, err = strconv.Atoi(value)
+			default:
+				if hooks != nil && hooks.SkipUnknown != nil && hooks.SkipUnknown(section+"."+key, value) {
+					return nil
+				}
+				return errors.Errorf("pebble: unknown option: %s.%s",
+					errors.Safe(section), errors.Safe(key))
+			}
+			return err
+
 		case strings.HasPrefix(section, "Level "):
 			var index int
 			if n, err := fmt.Sscanf(section, `Level "%d"`, &index); err != nil {
@@ -1272,6 +1616,8 @@ func (o *Options) Parse(s string, hooks *ParseHooks) error {
 				default:
 					return errors.Errorf("pebble: unknown compression: %q", errors.Safe(value))
 				}
+			case "data_block_hash_index":
+				l.DataBlockHashIndex, err = strconv.ParseBool(value)
 			case "filter_policy":
 				if hooks != nil && hooks.NewFilterPolicy != nil {
 					l.FilterPolicy, err = hooks.NewFilterPolicy(value)
@@ -1285,6 +1631,8 @@ func (o *Options) Parse(s string, hooks *ParseHooks) error {
 				}
 			case "index_block_size":
 				l.IndexBlockSize, err = strconv.Atoi(value)
+			case "partitioned_filters":
+				l.PartitionedFilters, err = strconv.ParseBool(value)
 			case "target_file_size":
 				l.TargetFileSize, err = strconv.ParseInt(value, 10, 64)
 			default:
@@ -1359,10 +1707,18 @@ func (o *Options) Validate() error {
 		fmt.Fprintf(&buf, "L0StopWritesThreshold (%d) must be >= L0CompactionThreshold (%d)\n",
 			o.L0StopWritesThreshold, o.L0CompactionThreshold)
 	}
+	if u := &o.UniversalCompaction; u.MaxMergeWidth > 0 && u.MaxMergeWidth < u.MinMergeWidth {
+		fmt.Fprintf(&buf, "UniversalCompaction.MaxMergeWidth (%d) must be >= MinMergeWidth (%d)\n",
+			u.MaxMergeWidth, u.MinMergeWidth)
+	}
 	if uint64(o.MemTableSize) >= maxMemTableSize {
 		fmt.Fprintf(&buf, "MemTableSize (%s) must be < %s\n",
 			humanize.Uint64(uint64(o.MemTableSize)), humanize.Uint64(maxMemTableSize))
 	}
+	if o.MemTableBloomFilterPercent < 0 || o.MemTableBloomFilterPercent > 100 {
+		fmt.Fprintf(&buf, "MemTableBloomFilterPercent (%d) must be in [0, 100]\n",
+			o.MemTableBloomFilterPercent)
+	}
 	if o.MemTableStopWritesThreshold < 2 {
 		fmt.Fprintf(&buf, "MemTableStopWritesThreshold (%d) must be >= 2\n",
 			o.MemTableStopWritesThreshold)
@@ -1414,8 +1770,10 @@ func (o *Options) MakeWriterOptions(level int, format sstable.TableFormat) sstab
 	writerOpts.BlockSize = levelOpts.BlockSize
 	writerOpts.BlockSizeThreshold = levelOpts.BlockSizeThreshold
 	writerOpts.Compression = levelOpts.Compression
+	writerOpts.DataBlockHashIndex = levelOpts.DataBlockHashIndex && format >= sstable.TableFormatPebblev3
 	writerOpts.FilterPolicy = levelOpts.FilterPolicy
 	writerOpts.FilterType = levelOpts.FilterType
 	writerOpts.IndexBlockSize = levelOpts.IndexBlockSize
+	writerOpts.PartitionedFilters = levelOpts.PartitionedFilters && format >= sstable.TableFormatPebblev4
 	return writerOpts
 }
@@ -69,10 +69,12 @@ func TestOptionsString(t *testing.T) {
   pebble_version=0.1
 
 [Options]
+  background_write_rate=0
   bytes_per_sync=524288
   cache_size=8388608
   cleaner=delete
   compaction_debt_concurrency=1073741824
+  compaction_style=level
   comparer=leveldb.BytewiseComparator
   delete_range_flush_delay=0s
   disable_wal=false
@@ -86,15 +88,20 @@ func TestOptionsString(t *testing.T) {
   max_concurrent_compactions=1
   max_manifest_file_size=134217728
   max_open_files=1000
+  max_subcompactions=1
+  mem_table_bloom_filter_percent=0
   mem_table_size=4194304
   mem_table_stop_writes_threshold=2
+  min_blob_size=0
   min_deletion_rate=0
   merger=pebble.concatenate
+  periodic_compaction_period=0s
   read_compaction_rate=16000
   read_sampling_multiplier=16
   strict_wal_tail=true
   table_cache_shards=8
   table_property_collectors=[]
+  ttl=0s
   unique_id=0
   validate_on_ingest=false
   wal_dir=
@@ -106,9 +113,11 @@ func TestOptionsString(t *testing.T) {
   block_restart_interval=16
   block_size=4096
   compression=Snappy
+  data_block_hash_index=false
   filter_policy=none
   filter_type=table
   index_block_size=4096
+  partitioned_filters=false
   target_file_size=2097152
 `
 
@@ -223,6 +232,14 @@ func TestOptionsParse(t *testing.T) {
 			opts.Levels[0].BlockSize = 1024
 			opts.Levels[1].BlockSize = 2048
 			opts.Levels[2].BlockSize = 4096
+			opts.CompactionStyle = CompactionStyleUniversal
+			opts.UniversalCompaction.SizeRatio = 10
+			opts.UniversalCompaction.MaxMergeWidth = 5
+			opts.TTL = 36 * time.Hour
+			opts.PeriodicCompactionPeriod = 24 * time.Hour
+			opts.MemTableBloomFilterPercent = 2
+			opts.MinBlobSize = 1 << 10
+			opts.Experimental.BackgroundWriteRate = 50 << 20
 			opts.Experimental.CompactionDebtConcurrency = 100
 			opts.Experimental.DeleteRangeFlushDelay = 10 * time.Second
 			opts.Experimental.MinDeletionRate = 200
@@ -5,6 +5,7 @@
 package pebble
 
 import (
+	"io"
 	"time"
 
 	"github.com/cockroachdb/errors"
@@ -116,3 +117,85 @@ type noopPacer struct{}
 func (p *noopPacer) maybeThrottle(_ uint64) error {
 	return nil
 }
+
+// writeLimiter is a byte-rate limiter shared by the background writes of a
+// DB: flushes, compactions and uploads of sstables to shared storage. It
+// prevents background work from saturating the disk or network and hurting
+// the latency of foreground operations.
+//
+// The rate is auto-tuned to the compaction debt: it is raised by
+// Options.Experimental.BackgroundWriteRate for every
+// Options.Experimental.CompactionDebtConcurrency bytes of debt, so that
+// compactions are not held back once they have fallen behind.
+type writeLimiter struct {
+	limiter       *rate.Limiter
+	baseRate      int
+	debtThreshold uint64
+}
+
+func newWriteLimiter(bytesPerSec int, debtThreshold int) *writeLimiter {
+	return &writeLimiter{
+		limiter:       rate.NewLimiter(rate.Limit(bytesPerSec), bytesPerSec),
+		baseRate:      bytesPerSec,
+		debtThreshold: uint64(debtThreshold),
+	}
+}
+
+// pacer returns a pacer for a job of the given priority.
+func (l *writeLimiter) pacer(highPriority bool) pacer {
+	return &writePacer{limiter: l.limiter, highPriority: highPriority}
+}
+
+// tune adjusts the rate of the limiter to the current compaction debt.
+func (l *writeLimiter) tune(compactionDebt uint64) {
+	multiplier := 1 + compactionDebt/l.debtThreshold
+	l.limiter.SetLimit(rate.Limit(float64(l.baseRate) * float64(multiplier)))
+}
+
+// writePacer is the pacer of a single flush, compaction or upload. The bytes
+// written by high-priority jobs (flushes and compactions out of L0) are
+// reserved in the limiter, but the jobs are never throttled, leaving less of
+// the rate to low-priority jobs.
+type writePacer struct {
+	limiter      *rate.Limiter
+	highPriority bool
+}
+
+// maybeThrottle accounts for bytesWritten in the limiter, and slows down the
+// job if it is low-priority and writes faster than the limiter allows.
+func (p *writePacer) maybeThrottle(bytesWritten uint64) error {
+	burst := uint64(p.limiter.Burst())
+	for bytesWritten > 0 {
+		n := bytesWritten
+		if n > burst {
+			n = burst
+		}
+		bytesWritten -= n
+		if p.highPriority {
+			// The reservation is never waited on, so the tokens may go into
+			// debt, which low-priority jobs pay off.
+			p.limiter.ReserveN(time.Now(), int(n))
+			continue
+		}
+		d := p.limiter.DelayN(time.Now(), int(n))
+		if d == rate.InfDuration {
+			return errors.Errorf("pacing failed")
+		}
+		time.Sleep(d)
+	}
+	return nil
+}
+
+// pacedWriter is an io.Writer wrapper that paces the writes through a pacer.
+type pacedWriter struct {
+	io.Writer
+	pacer pacer
+}
+
+// Write implements the io.Writer interface.
+func (w *pacedWriter) Write(p []byte) (n int, err error) {
+	if err := w.pacer.maybeThrottle(uint64(len(p))); err != nil {
+		return 0, err
+	}
+	return w.Writer.Write(p)
+}
@@ -13,6 +13,8 @@ import (
 	"time"
 
 	"github.com/cockroachdb/pebble/internal/datadriven"
+	"github.com/cockroachdb/pebble/internal/rate"
+	"github.com/stretchr/testify/require"
 )
 
 type mockPrintLimiter struct {
@@ -105,3 +107,20 @@ func TestCompactionPacerMaybeThrottle(t *testing.T) {
 			}
 		})
 }
+
+func TestWriteLimiter(t *testing.T) {
+	l := newWriteLimiter(1000, 100)
+
+	// High-priority writes are never throttled, but put the limiter into
+	// debt that low-priority writes have to wait for.
+	start := time.Now()
+	require.NoError(t, l.pacer(true /* highPriority */).maybeThrottle(3000))
+	require.Less(t, time.Since(start), time.Second)
+	require.Greater(t, l.limiter.DelayN(time.Now(), 1), time.Second)
+
+	// The rate grows with the compaction debt.
+	l.tune(250)
+	require.Equal(t, rate.Limit(3000), l.limiter.Limit())
+	l.tune(0)
+	require.Equal(t, rate.Limit(1000), l.limiter.Limit())
+}
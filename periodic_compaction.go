@@ -0,0 +1,54 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+// periodicCompactionCutoff returns the creation time, in seconds since the
+// epoch, before which sstables are stale according to
+// Options.PeriodicCompactionPeriod.
+func (d *DB) periodicCompactionCutoff() int64 {
+	return d.timeNow().Add(-d.opts.PeriodicCompactionPeriod).Unix()
+}
+
+// maybeMarkStaleFilesLocked marks the files of the current version that were
+// created before cutoff for compaction, at most once per check interval. The
+// compaction picker compacts marked files at its lowest priority, through
+// pickRewriteCompaction. Files without a creation time are never stale.
+//
+// Unlike the files marked by markFilesWithSplitUserKeysLocked, stale files are
+// not durably marked: the mark is recomputed from the files' creation times
+// when the DB is reopened.
+//
+// d.mu and the manifest lock must be held when calling this.
+func (d *DB) maybeMarkStaleFilesLocked(cutoff int64) {
+	now := d.timeNow()
+	if now.Before(d.mu.compact.nextStaleFileCheck) {
+		return
+	}
+	d.mu.compact.nextStaleFileCheck = now.Add(ageCheckInterval(d.opts.PeriodicCompactionPeriod))
+
+	vers := d.mu.versions.currentVersion()
+	for l := range vers.Levels {
+		oldest := vers.Levels[l].Annotation(oldestCreationTimeAnnotator{})
+		if oldest == nil || oldest.(*fileMetadata).CreationTime >= cutoff {
+			continue
+		}
+		var marked bool
+		iter := vers.Levels[l].Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			if f.MarkedForCompaction || f.Compacting ||
+				f.CreationTime == 0 || f.CreationTime >= cutoff {
+				continue
+			}
+			f.MarkedForCompaction = true
+			vers.Stats.MarkedForCompaction++
+			marked = true
+		}
+		if marked {
+			// The level's B-tree nodes may be annotated as having no files
+			// marked for compaction, which is no longer true.
+			vers.Levels[l].InvalidateAnnotation(markedForCompactionAnnotator{})
+		}
+	}
+}
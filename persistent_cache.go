@@ -24,7 +24,8 @@ type persistentCache struct {
 		usedCapacity uint64
 	}
 
-	files    chan *persistentCacheValue
+	files chan *persistentCacheValue
+	// capacity is protected by mu.
 	capacity uint64
 
 	localFS, sharedFS   vfs.FS
@@ -104,6 +105,14 @@ func newPersistentCache(
 	return psc
 }
 
+// SetCapacity changes the capacity of the cache. If the cache is over the new
+// capacity, files are evicted when the next file is cached.
+func (l *persistentCache) SetCapacity(capacity uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.capacity = capacity
+}
+
 func (l *persistentCache) MarkDeleted(fileNum base.FileNum) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
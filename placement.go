@@ -0,0 +1,125 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"time"
+
+	"github.com/cockroachdb/pebble/internal/manifest"
+)
+
+// TablePlacement describes where an sstable is stored.
+type TablePlacement int8
+
+const (
+	// PlacementLocal stores the sstable in Options.FS.
+	PlacementLocal TablePlacement = iota
+	// PlacementShared stores the sstable in Options.SharedFS.
+	PlacementShared
+)
+
+func (p TablePlacement) String() string {
+	switch p {
+	case PlacementLocal:
+		return "local"
+	case PlacementShared:
+		return "shared"
+	}
+	return "unknown"
+}
+
+// PlacementInfo describes an sstable that is about to be installed in the LSM.
+// It is passed to a PlacementPolicy to decide where the sstable is stored.
+type PlacementInfo struct {
+	// Level is the LSM level the sstable is written or ingested into.
+	Level int
+	// Reason is the reason for the table creation: "compacting", "flushing", or
+	// "ingesting".
+	Reason string
+	// CompactionKind is the kind of compaction writing the sstable, e.g.
+	// "default", "read" or "rewrite". Empty for flushes and ingestions.
+	CompactionKind string
+	// Smallest and Largest are the user key bounds of the sstable.
+	Smallest []byte
+	Largest  []byte
+	// CreationTime is the Unix timestamp, in seconds, at which the sstable was
+	// created.
+	CreationTime int64
+}
+
+// PlacementPolicy decides whether a flushed, compacted or ingested sstable is
+// stored on the local filesystem or on Options.SharedFS. It is only consulted
+// when Options.SharedFS is set.
+//
+// Placement must be safe for concurrent use. Sstables containing range keys or
+// referencing blob files are always stored locally, regardless of the
+// decision. See Options.MinBlobSize for how separated values reach shared
+// storage.
+type PlacementPolicy interface {
+	Placement(info PlacementInfo) TablePlacement
+}
+
+// LevelPlacementPolicy places sstables in levels >= MinSharedLevel on shared
+// storage, and all others locally.
+type LevelPlacementPolicy struct {
+	MinSharedLevel int
+}
+
+// Placement implements PlacementPolicy.
+func (p LevelPlacementPolicy) Placement(info PlacementInfo) TablePlacement {
+	if info.Level >= p.MinSharedLevel {
+		return PlacementShared
+	}
+	return PlacementLocal
+}
+
+// DefaultPlacementPolicy is the PlacementPolicy used when
+// Options.PlacementPolicy is nil. It stores L5 and L6 on shared storage.
+var DefaultPlacementPolicy PlacementPolicy = LevelPlacementPolicy{MinSharedLevel: sharedLevel}
+
+// PlacementPolicyFunc adapts a function to the PlacementPolicy interface.
+type PlacementPolicyFunc func(info PlacementInfo) TablePlacement
+
+// Placement implements PlacementPolicy.
+func (f PlacementPolicyFunc) Placement(info PlacementInfo) TablePlacement {
+	return f(info)
+}
+
+// placeTable consults the placement policy for the sstable described by meta,
+// returning PlacementLocal if the DB has no shared storage or if the sstable
+// cannot be stored on it.
+func (d *DB) placeTable(
+	meta *manifest.FileMetadata, level int, reason string, kind string,
+) TablePlacement {
+	if d.opts.SharedFS == nil || meta.HasRangeKeys || len(meta.BlobFiles) > 0 {
+		return PlacementLocal
+	}
+	return d.opts.PlacementPolicy.Placement(PlacementInfo{
+		Level:          level,
+		Reason:         reason,
+		CompactionKind: kind,
+		Smallest:       meta.Smallest.UserKey,
+		Largest:        meta.Largest.UserKey,
+		CreationTime:   meta.CreationTime,
+	})
+}
+
+// compactionOutputsShared returns true if the placement policy is expected to
+// place the outputs of the compaction c on shared storage. The decision is
+// made for each output when it is finished, so this is only a prediction based
+// on the bounds of the compaction.
+func (d *DB) compactionOutputsShared(c *compaction, reason string, kind string) bool {
+	if d.opts.SharedFS == nil {
+		return false
+	}
+	return d.opts.PlacementPolicy.Placement(PlacementInfo{
+		Level:          c.outputLevel.level,
+		Reason:         reason,
+		CompactionKind: kind,
+		Smallest:       c.smallest.UserKey,
+		Largest:        c.largest.UserKey,
+		CreationTime:   time.Now().Unix(),
+	}) == PlacementShared
+}
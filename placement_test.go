@@ -0,0 +1,158 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/cockroachdb/pebble/sstable"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlacementPolicy(t *testing.T) {
+	const uniqueID = 17
+	mem := vfs.NewMem()
+	sharedFS := vfs.NewMem()
+	for i := 0; i < 10; i++ {
+		require.NoError(t, sharedFS.MkdirAll(fmt.Sprintf("%d/%d", uniqueID, i), 0755))
+	}
+
+	// Keep the "hot" prefix local and place everything else in shared
+	// storage, regardless of level.
+	var mu sync.Mutex
+	var infos []PlacementInfo
+	policy := PlacementPolicyFunc(func(info PlacementInfo) TablePlacement {
+		mu.Lock()
+		defer mu.Unlock()
+		infos = append(infos, info)
+		if bytes.HasPrefix(info.Smallest, []byte("hot")) {
+			return PlacementLocal
+		}
+		return PlacementShared
+	})
+	var placed []TablePlaceInfo
+	d, err := Open("", &Options{
+		FS:              mem,
+		SharedFS:        sharedFS,
+		UniqueID:        uniqueID,
+		PlacementPolicy: policy,
+		EventListener: EventListener{
+			TablePlaced: func(info TablePlaceInfo) {
+				mu.Lock()
+				defer mu.Unlock()
+				placed = append(placed, info)
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	ingest := func(path string, keys ...string) {
+		f, err := mem.Create(path)
+		require.NoError(t, err)
+		w := sstable.NewWriter(f, sstable.WriterOptions{})
+		for _, k := range keys {
+			require.NoError(t, w.Set([]byte(k), []byte(k)))
+		}
+		require.NoError(t, w.Close())
+		require.NoError(t, d.Ingest([]string{path}, nil))
+	}
+	ingest("ext-hot", "hot1", "hot2")
+	ingest("ext-cold", "cold1", "cold2")
+
+	require.NoError(t, d.Set([]byte("a"), []byte("a"), nil))
+	require.NoError(t, d.Flush())
+
+	mu.Lock()
+	require.Len(t, placed, 3)
+	require.Equal(t, "ingesting", infos[0].Reason)
+	require.Equal(t, PlacementLocal, placed[0].Placement)
+	require.Equal(t, PlacementShared, placed[1].Placement)
+	require.Equal(t, "flushing", infos[2].Reason)
+	require.Equal(t, 0, infos[2].Level)
+	require.Equal(t, PlacementShared, placed[2].Placement)
+	require.NotZero(t, infos[2].CreationTime)
+	mu.Unlock()
+
+	readState := d.loadReadState()
+	for l := range readState.current.Levels {
+		iter := readState.current.Levels[l].Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			hot := bytes.HasPrefix(f.Smallest.UserKey, []byte("hot"))
+			require.Equal(t, !hot, f.IsShared, "table %s", f.FileNum)
+		}
+	}
+	readState.unref()
+
+	for _, k := range []string{"hot1", "cold2", "a"} {
+		v, closer, err := d.Get([]byte(k))
+		require.NoError(t, err)
+		require.Equal(t, k, string(v))
+		require.NoError(t, closer.Close())
+	}
+	require.NoError(t, d.Close())
+}
+
+// createCountingFS counts the files created through it.
+type createCountingFS struct {
+	vfs.FS
+	created int32
+}
+
+func (fs *createCountingFS) Create(name string) (vfs.File, error) {
+	atomic.AddInt32(&fs.created, 1)
+	return fs.FS.Create(name)
+}
+
+func TestIngestLocalPlacementSkipsUpload(t *testing.T) {
+	const uniqueID = 17
+	mem := vfs.NewMem()
+	sharedFS := &createCountingFS{FS: vfs.NewMem()}
+	for i := 0; i < 10; i++ {
+		require.NoError(t, sharedFS.MkdirAll(fmt.Sprintf("%d/%d", uniqueID, i), 0755))
+	}
+	d, err := Open("", &Options{
+		FS:       mem,
+		SharedFS: sharedFS,
+		UniqueID: uniqueID,
+		PlacementPolicy: PlacementPolicyFunc(func(info PlacementInfo) TablePlacement {
+			if bytes.HasPrefix(info.Smallest, []byte("hot")) {
+				return PlacementLocal
+			}
+			return PlacementShared
+		}),
+	})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+
+	ingest := func(path string, key string) {
+		f, err := mem.Create(path)
+		require.NoError(t, err)
+		w := sstable.NewWriter(f, sstable.WriterOptions{})
+		require.NoError(t, w.Set([]byte(key), []byte(key)))
+		require.NoError(t, w.Close())
+		require.NoError(t, d.Ingest([]string{path}, nil))
+	}
+
+	// The placement is decided before the sstable is linked, so an sstable
+	// placed locally is never uploaded to shared storage.
+	ingest("ext-hot", "hot")
+	require.Equal(t, int32(0), atomic.LoadInt32(&sharedFS.created))
+	ingest("ext-cold", "cold")
+	require.Equal(t, int32(1), atomic.LoadInt32(&sharedFS.created))
+
+	for _, k := range []string{"hot", "cold"} {
+		v, closer, err := d.Get([]byte(k))
+		require.NoError(t, err)
+		require.Equal(t, k, string(v))
+		require.NoError(t, closer.Close())
+	}
+}
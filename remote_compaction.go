@@ -0,0 +1,599 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"context"
+	"encoding/json"
+	"sync/atomic"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/cache"
+	"github.com/cockroachdb/pebble/internal/manifest"
+	"github.com/cockroachdb/pebble/sstable"
+)
+
+// CompactionExecutor runs compactions on behalf of a DB, typically on another
+// machine. Only compactions whose input and output sstables are all stored in
+// Options.SharedFS are handed to the executor, as the inputs are then readable
+// by, and the outputs writable by, any machine with access to SharedFS.
+//
+// ExecuteCompaction is passed a description of the compaction that consists
+// of plain values, so that it can be serialized and sent to a worker. The
+// worker runs the compaction by calling RunRemoteCompaction and sends the
+// result back. If ExecuteCompaction returns an error, the DB runs the
+// compaction itself.
+//
+// The context passed to ExecuteCompaction is cancelled if the compaction is
+// cancelled, for example by DB.PauseCompactions. ExecuteCompaction should then
+// stop the compaction and return promptly, typically by cancelling the context
+// passed to RunRemoteCompaction.
+//
+// ExecuteCompaction must be safe for concurrent use.
+type CompactionExecutor interface {
+	ExecuteCompaction(
+		ctx context.Context, desc *RemoteCompactionDesc,
+	) (*RemoteCompactionResult, error)
+}
+
+// RemoteCompactionDesc describes a compaction to be run by a
+// CompactionExecutor.
+type RemoteCompactionDesc struct {
+	// JobID is the ID of the compaction job in the DB.
+	JobID int
+	// UniqueID is the Options.UniqueID of the DB. The outputs are written to
+	// SharedFS on behalf of the DB, under its UniqueID.
+	UniqueID uint32
+	// Comparer and Merger are the names of the DB's Comparer and Merger.
+	Comparer string
+	Merger   string
+	// FormatMajorVersion is the format major version of the DB, which
+	// determines the format of the output sstables.
+	FormatMajorVersion FormatMajorVersion
+	// Inputs are the input levels of the compaction, in the order of the
+	// levels. The outputs are written to the last input level.
+	Inputs []RemoteCompactionLevel
+	// Grandparents are the tables in the level below the output level that
+	// overlap the compaction. They determine where the outputs are split. Only
+	// their bounds and sizes are used.
+	Grandparents []RemoteTable
+	// Smallest and Largest are the bounds of the compaction inputs.
+	Smallest InternalKey
+	Largest  InternalKey
+	// Snapshots are the sequence numbers of the open snapshots of the DB,
+	// which must be preserved by the compaction.
+	Snapshots []uint64
+	// InuseKeyRanges are the user key ranges of the levels below the output
+	// level, which hold keys that may be shadowed by the compaction's
+	// tombstones. Tombstones outside of these ranges are elided, and sequence
+	// numbers are zeroed if the inputs don't overlap them.
+	InuseKeyRanges []RemoteKeyRange
+	// DisableSpanElision disables the elision of range tombstones and range
+	// keys.
+	DisableSpanElision bool
+	// MaxOutputFileSize is the target size of the outputs, and
+	// MaxOverlapBytes the maximum number of grandparent bytes an output may
+	// overlap.
+	MaxOutputFileSize uint64
+	MaxOverlapBytes   uint64
+	// FirstOutputFileNum and NumOutputFileNums are the range of file numbers
+	// reserved for the outputs. The compaction fails if it needs more outputs.
+	FirstOutputFileNum base.FileNum
+	NumOutputFileNums  uint64
+}
+
+// RemoteCompactionLevel describes the input tables of a compaction in a
+// level.
+type RemoteCompactionLevel struct {
+	Level  int
+	Tables []RemoteTable
+}
+
+// RemoteKeyRange is an inclusive range of user keys.
+type RemoteKeyRange struct {
+	Start []byte
+	End   []byte
+}
+
+// RemoteTable describes an input table of a compaction.
+type RemoteTable struct {
+	FileNum         base.FileNum
+	CreatorUniqueID uint32
+	PhysicalFileNum base.FileNum
+	Size            uint64
+	SmallestSeqNum  uint64
+	LargestSeqNum   uint64
+	// SubLevel is the L0 sublevel of a table in L0.
+	SubLevel int
+	// Smallest and Largest are the (virtual) bounds of the table in the DB.
+	Smallest InternalKey
+	Largest  InternalKey
+	// FileSmallest and FileLargest are the bounds of the table's file.
+	FileSmallest InternalKey
+	FileLargest  InternalKey
+}
+
+// RemoteCompactionResult describes the output tables of a compaction run by a
+// CompactionExecutor, in key order. The outputs are stored in SharedFS.
+type RemoteCompactionResult struct {
+	Outputs []RemoteCompactionOutput
+}
+
+// RemoteCompactionOutput describes an output table of a compaction.
+type RemoteCompactionOutput struct {
+	FileNum        base.FileNum
+	Size           uint64
+	SmallestSeqNum uint64
+	LargestSeqNum  uint64
+	CreationTime   int64
+	// HasPointKeys, HasRangeDelKeys and HasRangeKeys are set if the table
+	// contains point keys, range deletions and range keys respectively, in
+	// which case the corresponding bounds are set.
+	HasPointKeys     bool
+	SmallestPoint    InternalKey
+	LargestPoint     InternalKey
+	HasRangeDelKeys  bool
+	SmallestRangeDel InternalKey
+	LargestRangeDel  InternalKey
+	HasRangeKeys     bool
+	SmallestRangeKey InternalKey
+	LargestRangeKey  InternalKey
+}
+
+// fileMetadata returns the metadata of the output table, with its bounds
+// extended in the same way as those of a locally written output.
+func (o *RemoteCompactionOutput) fileMetadata(cmp Compare) *fileMetadata {
+	m := &fileMetadata{
+		FileNum:        o.FileNum,
+		Size:           o.Size,
+		SmallestSeqNum: o.SmallestSeqNum,
+		LargestSeqNum:  o.LargestSeqNum,
+		CreationTime:   o.CreationTime,
+	}
+	if o.HasPointKeys {
+		m.ExtendPointKeyBounds(cmp, o.SmallestPoint, o.LargestPoint)
+	}
+	if o.HasRangeDelKeys {
+		m.ExtendPointKeyBounds(cmp, o.SmallestRangeDel, o.LargestRangeDel)
+	}
+	if o.HasRangeKeys {
+		m.ExtendRangeKeyBounds(cmp, o.SmallestRangeKey, o.LargestRangeKey)
+	}
+	return m
+}
+
+func makeRemoteTable(f *fileMetadata) RemoteTable {
+	return RemoteTable{
+		FileNum:         f.FileNum,
+		CreatorUniqueID: f.CreatorUniqueID,
+		PhysicalFileNum: f.PhysicalFileNum,
+		Size:            f.Size,
+		SmallestSeqNum:  f.SmallestSeqNum,
+		LargestSeqNum:   f.LargestSeqNum,
+		SubLevel:        f.SubLevel,
+		Smallest:        f.Smallest,
+		Largest:         f.Largest,
+		FileSmallest:    f.FileSmallest,
+		FileLargest:     f.FileLargest,
+	}
+}
+
+func (t *RemoteTable) fileMetadata(cmp Compare) *fileMetadata {
+	m := (&fileMetadata{
+		FileNum:         t.FileNum,
+		Size:            t.Size,
+		SmallestSeqNum:  t.SmallestSeqNum,
+		LargestSeqNum:   t.LargestSeqNum,
+		SubLevel:        t.SubLevel,
+		IsShared:        true,
+		CreatorUniqueID: t.CreatorUniqueID,
+		PhysicalFileNum: t.PhysicalFileNum,
+		FileSmallest:    t.FileSmallest,
+		FileLargest:     t.FileLargest,
+	}).ExtendPointKeyBounds(cmp, t.Smallest, t.Largest)
+	return m
+}
+
+// remoteCompactionAllowedLocked returns true if the compaction c may be run by
+// Options.CompactionExecutor. All of its inputs must be shared, and its
+// outputs must be placed in shared storage.
+//
+// d.mu must be held when calling this.
+func (d *DB) remoteCompactionAllowedLocked(c *compaction) bool {
+	if d.opts.CompactionExecutor == nil || d.opts.SharedFS == nil ||
+		len(c.flushing) != 0 {
+		return false
+	}
+	for _, cl := range c.inputs {
+		iter := cl.files.Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			if !f.IsShared || f.HasRangeKeys {
+				return false
+			}
+		}
+	}
+	return d.compactionOutputsShared(c, "compacting", c.kind.String())
+}
+
+// makeRemoteCompactionDescLocked describes the compaction c for a
+// CompactionExecutor, reserving file numbers for its outputs.
+//
+// d.mu must be held when calling this.
+func (d *DB) makeRemoteCompactionDescLocked(
+	jobID int, c *compaction, snapshots []uint64, formatVers FormatMajorVersion,
+) *RemoteCompactionDesc {
+	desc := &RemoteCompactionDesc{
+		JobID:              jobID,
+		UniqueID:           d.opts.UniqueID,
+		Comparer:           d.opts.Comparer.Name,
+		Merger:             d.opts.Merger.Name,
+		FormatMajorVersion: formatVers,
+		Smallest:           c.smallest,
+		Largest:            c.largest,
+		Snapshots:          snapshots,
+		DisableSpanElision: c.disableSpanElision,
+		MaxOutputFileSize:  c.maxOutputFileSize,
+		MaxOverlapBytes:    c.maxOverlapBytes,
+	}
+	var inputSize uint64
+	for _, cl := range c.inputs {
+		level := RemoteCompactionLevel{Level: cl.level}
+		iter := cl.files.Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			level.Tables = append(level.Tables, makeRemoteTable(f))
+		}
+		desc.Inputs = append(desc.Inputs, level)
+		inputSize += cl.files.SizeSum()
+	}
+	iter := c.grandparents.Iter()
+	for f := iter.First(); f != nil; f = iter.Next() {
+		desc.Grandparents = append(desc.Grandparents, makeRemoteTable(f))
+	}
+	for _, r := range c.inuseKeyRanges {
+		desc.InuseKeyRanges = append(desc.InuseKeyRanges, RemoteKeyRange{Start: r.Start, End: r.End})
+	}
+
+	// Outputs are split when they reach the target file size, and before
+	// overlapping too many grandparents. Reserve generously, as the file
+	// numbers are cheap.
+	desc.NumOutputFileNums = 16 + 2*uint64(len(desc.Grandparents))
+	if c.maxOutputFileSize > 0 {
+		desc.NumOutputFileNums += 2 * inputSize / c.maxOutputFileSize
+	}
+	desc.FirstOutputFileNum = d.mu.versions.nextFileNum
+	d.mu.versions.nextFileNum += base.FileNum(desc.NumOutputFileNums)
+	return desc
+}
+
+// runRemoteCompaction runs the compaction c by handing desc to
+// Options.CompactionExecutor. It returns a version edit adding the outputs,
+// and the metadata of the outputs. The outputs are added to outputMetrics.
+//
+// If the outputs reported by the executor are invalid, the outputs that were
+// reserved for the compaction are removed from shared storage and an error is
+// returned.
+//
+// The context passed to the executor is cancelled if c is cancelled.
+//
+// d.mu must not be held when calling this.
+func (d *DB) runRemoteCompaction(
+	jobID int, c *compaction, desc *RemoteCompactionDesc, outputMetrics *LevelMetrics,
+) (_ *versionEdit, _ []*fileMetadata, err error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-c.cancel.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	res, err := d.opts.CompactionExecutor.ExecuteCompaction(ctx, desc)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		if err == nil {
+			return
+		}
+		for i := range res.Outputs {
+			o := &res.Outputs[i]
+			// An output that was not reserved for the compaction may belong
+			// to another table, and is left alone.
+			if o.FileNum < desc.FirstOutputFileNum ||
+				uint64(o.FileNum-desc.FirstOutputFileNum) >= desc.NumOutputFileNums {
+				continue
+			}
+			meta := &fileMetadata{FileNum: o.FileNum}
+			setSharedSSTMetadata(meta, d.opts.UniqueID)
+			d.removeSharedOutput(meta)
+		}
+	}()
+	// The outputs of a compaction that completed as it was cancelled are
+	// removed.
+	if c.cancelled() {
+		return nil, nil, ErrCancelledCompaction
+	}
+
+	ve := &versionEdit{}
+	var metrics LevelMetrics
+	var prev *fileMetadata
+	for i := range res.Outputs {
+		o := &res.Outputs[i]
+		if o.FileNum < desc.FirstOutputFileNum ||
+			uint64(o.FileNum-desc.FirstOutputFileNum) >= desc.NumOutputFileNums {
+			return nil, nil, errors.Errorf("pebble: remote compaction output %s was not reserved", o.FileNum)
+		}
+		meta := o.fileMetadata(d.cmp)
+		if !meta.HasPointKeys && !meta.HasRangeKeys {
+			return nil, nil, errors.Errorf("pebble: remote compaction output %s has no keys", o.FileNum)
+		}
+		if d.cmp(meta.Smallest.UserKey, c.smallest.UserKey) < 0 ||
+			d.cmp(meta.Largest.UserKey, c.largest.UserKey) > 0 {
+			return nil, nil, errors.Errorf("pebble: remote compaction output %s [%s-%s] grew beyond bounds of input [%s-%s]",
+				meta.FileNum, meta.Smallest.Pretty(d.opts.Comparer.FormatKey),
+				meta.Largest.Pretty(d.opts.Comparer.FormatKey),
+				c.smallest.Pretty(d.opts.Comparer.FormatKey), c.largest.Pretty(d.opts.Comparer.FormatKey))
+		}
+		if prev != nil {
+			v := d.cmp(meta.Smallest.UserKey, prev.Largest.UserKey)
+			if v < 0 || v == 0 && !prev.Largest.IsExclusiveSentinel() {
+				return nil, nil, errors.Errorf("pebble: remote compaction outputs %s and %s overlap",
+					prev.FileNum, meta.FileNum)
+			}
+		}
+		setSharedSSTMetadata(meta, d.opts.UniqueID)
+		meta.IsShared = true
+		path := base.MakeSharedSSTPath(d.opts.SharedFS, d.opts.SharedDir, meta.CreatorUniqueID, meta.PhysicalFileNum)
+		if _, err := d.opts.SharedFS.Stat(path); err != nil {
+			return nil, nil, err
+		}
+		ve.NewFiles = append(ve.NewFiles, newFileEntry{Level: c.outputLevel.level, Meta: meta})
+		metrics.TablesCompacted++
+		metrics.BytesCompacted += meta.Size
+		metrics.Size += int64(meta.Size)
+		metrics.NumFiles++
+		prev = meta
+	}
+
+	pendingOutputs := make([]*fileMetadata, 0, len(ve.NewFiles))
+	for _, e := range ve.NewFiles {
+		pendingOutputs = append(pendingOutputs, e.Meta)
+		path := base.MakeSharedSSTPath(d.opts.SharedFS, d.opts.SharedDir, e.Meta.CreatorUniqueID, e.Meta.PhysicalFileNum)
+		d.opts.EventListener.TableCreated(TableCreateInfo{
+			JobID:   jobID,
+			Reason:  "compacting",
+			Path:    path,
+			FileNum: e.Meta.FileNum,
+		})
+		d.opts.EventListener.TablePlaced(TablePlaceInfo{
+			JobID:     jobID,
+			Reason:    "compacting",
+			Path:      path,
+			FileNum:   e.Meta.FileNum,
+			Placement: PlacementShared,
+		})
+	}
+	outputMetrics.Add(&metrics)
+	for _, cl := range c.inputs {
+		c.bytesIterated += cl.files.SizeSum()
+	}
+	c.allowedZeroSeqNum = c.allowZeroSeqNum()
+	return ve, pendingOutputs, nil
+}
+
+// RunRemoteCompaction runs the compaction described by desc on behalf of the
+// DB that created the description, and returns the outputs to pass back to the
+// DB. It is called by the workers of a CompactionExecutor.
+//
+// The outputs are written to a scratch directory, dirname, in opts.FS, and
+// then moved to opts.SharedFS. The SharedFS, SharedDir, Comparer and Merger in
+// opts must match those of the DB, and the level options determine the format
+// of the outputs, so opts is typically a copy of the DB's options with a
+// different FS. If the compaction fails, any outputs it has written are
+// removed. If ctx is cancelled, the compaction stops at its next key and fails
+// with ErrCancelledCompaction.
+func RunRemoteCompaction(
+	ctx context.Context, dirname string, opts *Options, desc *RemoteCompactionDesc,
+) (_ *RemoteCompactionResult, err error) {
+	// The options may be shared by concurrent compactions. Copy the level
+	// options, which are defaulted in place.
+	opts = opts.Clone()
+	opts.Levels = append([]LevelOptions(nil), opts.Levels...)
+	opts = opts.EnsureDefaults()
+	if opts.SharedFS == nil {
+		return nil, errors.New("pebble: remote compactions require a SharedFS")
+	}
+	if opts.Comparer.Name != desc.Comparer {
+		return nil, errors.Errorf("pebble: comparer name from desc %q != comparer name from options %q",
+			errors.Safe(desc.Comparer), errors.Safe(opts.Comparer.Name))
+	}
+	if opts.Merger.Name != desc.Merger {
+		return nil, errors.Errorf("pebble: merger name from desc %q != merger name from options %q",
+			errors.Safe(desc.Merger), errors.Safe(opts.Merger.Name))
+	}
+	if len(desc.Inputs) < 2 {
+		return nil, errors.Errorf("pebble: remote compaction has %d input levels", len(desc.Inputs))
+	}
+	// The outputs are written on behalf of the DB, and are always shared.
+	opts.UniqueID = desc.UniqueID
+	opts.PlacementPolicy = LevelPlacementPolicy{MinSharedLevel: 0}
+	if err := opts.FS.MkdirAll(dirname, 0755); err != nil {
+		return nil, err
+	}
+
+	if opts.Cache == nil {
+		opts.Cache = cache.New(cacheDefaultSize)
+	} else {
+		opts.Cache.Ref()
+	}
+	defer opts.Cache.Unref()
+
+	// The compaction is run by a DB that consists only of the state needed to
+	// write compaction outputs.
+	d := &DB{
+		cacheID: opts.Cache.NewID(),
+		dirname: dirname,
+		opts:    opts,
+		cmp:     opts.Comparer.Compare,
+		equal:   opts.equal(),
+		merge:   opts.Merger.Merge,
+		split:   opts.Comparer.Split,
+		closed:  new(atomic.Value),
+		timeNow: time.Now,
+	}
+	d.mu.versions = &versionSet{nextFileNum: desc.FirstOutputFileNum}
+	d.tableCache = newTableCacheContainer(nil, d.cacheID, dirname, opts.FS, opts.SharedDir,
+		opts.SharedFS, nil /* psCache */, opts, TableCacheSize(opts.MaxOpenFiles))
+	defer func() {
+		err = firstError(err, d.tableCache.close())
+	}()
+	d.newIters = d.tableCache.newIters
+	d.tableNewRangeKeyIter = d.tableCache.newRangeKeyIter
+
+	c := &compaction{
+		kind:               compactionKindDefault,
+		cmp:                d.cmp,
+		equal:              d.equal,
+		formatKey:          opts.Comparer.FormatKey,
+		logger:             opts.Logger,
+		maxOutputFileSize:  desc.MaxOutputFileSize,
+		maxOverlapBytes:    desc.MaxOverlapBytes,
+		disableSpanElision: desc.DisableSpanElision,
+		smallest:           desc.Smallest,
+		largest:            desc.Largest,
+		inputs:             make([]compactionLevel, len(desc.Inputs)),
+		outputWriterMetas:  make(map[base.FileNum]*sstable.WriterMetadata),
+		cancel:             newCompactionCancel(),
+	}
+	for i, l := range desc.Inputs {
+		files := make([]*fileMetadata, len(l.Tables))
+		for j := range l.Tables {
+			files[j] = l.Tables[j].fileMetadata(d.cmp)
+		}
+		c.inputs[i].level = l.Level
+		if l.Level == 0 {
+			c.inputs[i].files = manifest.NewLevelSliceSeqSorted(files)
+		} else {
+			c.inputs[i].files = manifest.NewLevelSliceKeySorted(d.cmp, files)
+		}
+	}
+	c.startLevel = &c.inputs[0]
+	c.outputLevel = &c.inputs[len(c.inputs)-1]
+	for i := 1; i < len(c.inputs)-1; i++ {
+		c.extraLevels = append(c.extraLevels, &c.inputs[i])
+	}
+	if c.startLevel.level == 0 {
+		c.l0SublevelInfo = generateSublevelInfo(d.cmp, c.startLevel.files)
+	}
+	grandparents := make([]*fileMetadata, len(desc.Grandparents))
+	for i := range desc.Grandparents {
+		grandparents[i] = desc.Grandparents[i].fileMetadata(d.cmp)
+	}
+	c.grandparents = manifest.NewLevelSliceKeySorted(d.cmp, grandparents)
+	for _, r := range desc.InuseKeyRanges {
+		c.inuseKeyRanges = append(c.inuseKeyRanges, manifest.UserKeyRange{Start: r.Start, End: r.End})
+	}
+
+	// The compaction is cancelled if ctx is done before it completes.
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.cancel.cancel()
+		case <-finished:
+		}
+	}()
+
+	ve, pendingOutputs, err := d.writeCompactionOutputs(
+		desc.JobID, c, desc.Snapshots, desc.FormatMajorVersion, &LevelMetrics{})
+	if err == nil {
+		if n := uint64(d.mu.versions.nextFileNum - desc.FirstOutputFileNum); n > desc.NumOutputFileNums {
+			err = errors.Errorf("pebble: remote compaction wrote %d outputs, %d were reserved",
+				errors.Safe(n), errors.Safe(desc.NumOutputFileNums))
+		}
+	}
+	if err == nil {
+		for _, e := range ve.NewFiles {
+			if !e.Meta.IsShared {
+				err = errors.Errorf("pebble: remote compaction output %s could not be moved to shared storage",
+					e.Meta.FileNum)
+				break
+			}
+		}
+	}
+	if err != nil {
+		for _, m := range pendingOutputs {
+			opts.FS.Remove(base.MakeFilepath(opts.FS, dirname, fileTypeTable, m.FileNum))
+			opts.SharedFS.Remove(base.MakeSharedSSTPath(opts.SharedFS, opts.SharedDir, desc.UniqueID, m.FileNum))
+		}
+		return nil, err
+	}
+
+	res := &RemoteCompactionResult{}
+	for _, e := range ve.NewFiles {
+		w := c.outputWriterMetas[e.Meta.FileNum]
+		res.Outputs = append(res.Outputs, RemoteCompactionOutput{
+			FileNum:          e.Meta.FileNum,
+			Size:             e.Meta.Size,
+			SmallestSeqNum:   e.Meta.SmallestSeqNum,
+			LargestSeqNum:    e.Meta.LargestSeqNum,
+			CreationTime:     e.Meta.CreationTime,
+			HasPointKeys:     w.HasPointKeys,
+			SmallestPoint:    w.SmallestPoint,
+			LargestPoint:     w.LargestPoint,
+			HasRangeDelKeys:  w.HasRangeDelKeys,
+			SmallestRangeDel: w.SmallestRangeDel,
+			LargestRangeDel:  w.LargestRangeDel,
+			HasRangeKeys:     w.HasRangeKeys,
+			SmallestRangeKey: w.SmallestRangeKey,
+			LargestRangeKey:  w.LargestRangeKey,
+		})
+	}
+	return res, nil
+}
+
+// InProcessCompactionExecutor is a CompactionExecutor that runs compactions
+// in the current process, using a scratch directory in Options.FS. The
+// descriptions and results of the compactions are serialized and
+// deserialized, as they would be when sent to and received from another
+// process. It is intended for testing.
+type InProcessCompactionExecutor struct {
+	// Dirname is the scratch directory for the outputs.
+	Dirname string
+	// Options are the options passed to RunRemoteCompaction.
+	Options *Options
+}
+
+var _ CompactionExecutor = (*InProcessCompactionExecutor)(nil)
+
+// ExecuteCompaction implements CompactionExecutor.
+func (e *InProcessCompactionExecutor) ExecuteCompaction(
+	ctx context.Context, desc *RemoteCompactionDesc,
+) (*RemoteCompactionResult, error) {
+	buf, err := json.Marshal(desc)
+	if err != nil {
+		return nil, err
+	}
+	var decoded RemoteCompactionDesc
+	if err := json.Unmarshal(buf, &decoded); err != nil {
+		return nil, err
+	}
+	res, err := RunRemoteCompaction(ctx, e.Dirname, e.Options, &decoded)
+	if err != nil {
+		return nil, err
+	}
+	if buf, err = json.Marshal(res); err != nil {
+		return nil, err
+	}
+	var decodedRes RemoteCompactionResult
+	if err := json.Unmarshal(buf, &decodedRes); err != nil {
+		return nil, err
+	}
+	return &decodedRes, nil
+}
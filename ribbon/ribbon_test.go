@@ -0,0 +1,157 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package ribbon
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/cockroachdb/pebble/bloom"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/stretchr/testify/require"
+)
+
+func newTableFilter(bitsPerKey int, keys ...[]byte) tableFilter {
+	w := FilterPolicy(bitsPerKey).NewWriter(base.TableFilter)
+	for _, key := range keys {
+		w.AddKey(key)
+	}
+	return tableFilter(w.Finish(nil))
+}
+
+func le32(i int) []byte {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, uint32(i))
+	return b
+}
+
+func TestSmallRibbonFilter(t *testing.T) {
+	f := newTableFilter(10, []byte("hello"), []byte("world"))
+	m := map[string]bool{
+		"hello": true,
+		"world": true,
+		"x":     false,
+		"foo":   false,
+	}
+	for k, want := range m {
+		require.EqualValues(t, want, f.MayContain([]byte(k)), k)
+	}
+
+	// An empty filter matches nothing.
+	f = newTableFilter(10)
+	require.False(t, f.MayContain([]byte("hello")))
+}
+
+func TestRibbonFilter(t *testing.T) {
+	nextLength := func(x int) int {
+		if x < 10 {
+			return x + 1
+		}
+		if x < 100 {
+			return x + 10
+		}
+		if x < 1000 {
+			return x + 100
+		}
+		return x + 1000
+	}
+
+	w := FilterPolicy(10).NewWriter(base.TableFilter)
+loop:
+	for length := 1; length <= 10000; length = nextLength(length) {
+		keys := make([][]byte, 0, length)
+		for i := 0; i < length; i++ {
+			keys = append(keys, le32(i))
+		}
+		// Reuse the writer, as a table filter writer is reused across the
+		// partitions of a partitioned filter.
+		for _, key := range keys {
+			w.AddKey(key)
+		}
+		f := tableFilter(w.Finish(nil))
+		// 7 bits per slot, 5% more slots than keys, and 127 extra slots for
+		// the last key, rounded up to a multiple of 64 slots.
+		maxLen := trailerLen + 7*(length+length/20+127+63)/8
+		if len(f) > maxLen {
+			t.Errorf("length=%d: len(f)=%d > max len %d", length, len(f), maxLen)
+			continue
+		}
+
+		// All added keys must match.
+		for _, key := range keys {
+			if !f.MayContain(key) {
+				t.Errorf("length=%d: did not contain key %q", length, key)
+				continue loop
+			}
+		}
+
+		// Check false positive rate, which is expected to be 2^-7.
+		nFalsePositive := 0
+		for i := 0; i < 10000; i++ {
+			if f.MayContain(le32(1e9 + i)) {
+				nFalsePositive++
+			}
+		}
+		if nFalsePositive > 0.0125*10000 {
+			t.Errorf("length=%d: %d false positives in 10000", length, nFalsePositive)
+		}
+	}
+}
+
+func TestRibbonFilterDuplicateKeys(t *testing.T) {
+	var keys [][]byte
+	for i := 0; i < 1000; i++ {
+		keys = append(keys, le32(i%100))
+	}
+	f := newTableFilter(10, keys...)
+	for _, key := range keys {
+		require.True(t, f.MayContain(key))
+	}
+	// The filter is sized for the keys added, including the duplicates that
+	// are not consecutive.
+	require.Less(t, len(f), 7*(1000+1000/20+127+63)/8+trailerLen)
+}
+
+func TestRibbonFilterSize(t *testing.T) {
+	// A Ribbon filter has at most the false positive rate of a Bloom filter
+	// with the same bits per key, while being about 30% smaller.
+	const n = 100000
+	for _, bitsPerKey := range []int{8, 10, 14, 20} {
+		bw := bloom.FilterPolicy(bitsPerKey).NewWriter(base.TableFilter)
+		rw := FilterPolicy(bitsPerKey).NewWriter(base.TableFilter)
+		for i := 0; i < n; i++ {
+			bw.AddKey(le32(i))
+			rw.AddKey(le32(i))
+		}
+		bf, rf := bw.Finish(nil), tableFilter(rw.Finish(nil))
+		require.Less(t, float64(len(rf)), 0.8*float64(len(bf)), "bits per key %d", bitsPerKey)
+
+		var bloomFalsePositives, ribbonFalsePositives int
+		for i := 0; i < 100000; i++ {
+			key := le32(1e9 + i)
+			if bloom.FilterPolicy(bitsPerKey).MayContain(base.TableFilter, bf, key) {
+				bloomFalsePositives++
+			}
+			if rf.MayContain(key) {
+				ribbonFalsePositives++
+			}
+		}
+		// Allow for some noise in the measured rates.
+		require.LessOrEqual(t, ribbonFalsePositives, bloomFalsePositives+bloomFalsePositives/10,
+			"bits per key %d", bitsPerKey)
+	}
+}
+
+func TestResultBits(t *testing.T) {
+	for bitsPerKey, want := range map[int]uint8{
+		0:   1,
+		1:   1,
+		10:  7,
+		20:  14,
+		100: maxResultBits,
+	} {
+		require.Equal(t, want, resultBits(bitsPerKey), "bits per key %d", bitsPerKey)
+	}
+}
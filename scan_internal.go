@@ -0,0 +1,318 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"sync/atomic"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/keyspan"
+	"github.com/cockroachdb/pebble/internal/manifest"
+)
+
+// InternalSpanKey is a key of a range deletion or range key span visited by
+// ScanInternal. It holds the key's kind and sequence number and, for range
+// keys, its suffix and value.
+type InternalSpanKey = keyspan.Key
+
+// SharedTableInfo describes a table on shared storage visited by
+// ScanInternal.
+type SharedTableInfo struct {
+	TableInfo
+	// Level is the level of the LSM the table is in.
+	Level int
+	// CreatorUniqueID is the Options.UniqueID of the DB that created the
+	// table, and PhysicalFileNum its file number in that DB. Together they
+	// locate the table on shared storage.
+	CreatorUniqueID uint32
+	PhysicalFileNum FileNum
+}
+
+// ScanInternalVisitors holds the functions ScanInternal calls for the
+// internal keys of the DB. A nil function skips keys of its type. If a
+// function returns an error, the scan stops and ScanInternal returns the
+// error.
+type ScanInternalVisitors struct {
+	// PointKey is called for every version of every point key, including
+	// deletions, in internal key order: ascending by user key, then descending
+	// by sequence number. Point keys deleted by range deletions are visited.
+	// The values of keys separated into blob files are read from the blob
+	// files, and the keys visited as SETWITHDEL keys. The key and value are only
+	// valid for the duration of the call.
+	PointKey func(key *InternalKey, value []byte) error
+	// RangeDel is called for each fragment of the range deletions of the DB,
+	// with the keys of the range deletions covering the fragment, in
+	// descending sequence number order. The fragments do not overlap, and are
+	// visited in ascending key order.
+	RangeDel func(start, end []byte, keys []InternalSpanKey) error
+	// RangeKey is called for each fragment of the range keys of the DB, like
+	// RangeDel.
+	RangeKey func(start, end []byte, keys []InternalSpanKey) error
+	// SharedFile, if non-nil, is called for each table on shared storage
+	// overlapping the bounds of the scan, and the contents of the table are
+	// not visited. Note that the keys of the table are not truncated to the
+	// bounds of the scan.
+	SharedFile func(info SharedTableInfo) error
+}
+
+// ScanInternal scans the internal keys of the DB in [lower, upper), calling
+// the visitors for the shared tables, point keys, range deletions and range
+// keys in that order. A nil bound leaves the scan unbounded on that side.
+// Range deletions and range keys are truncated to the bounds.
+//
+// Unlike an Iterator, ScanInternal exposes the sequence numbers and kinds of
+// keys, and does not elide shadowed or deleted keys. It reads a consistent
+// view of the DB: keys committed after ScanInternal is called are not
+// visited.
+func (d *DB) ScanInternal(lower, upper []byte, visitors ScanInternalVisitors) error {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	readState := d.loadReadState()
+	defer readState.unref()
+	seqNum := atomic.LoadUint64(&d.mu.versions.atomic.visibleSeqNum)
+	memtables := visibleMemtables(readState.memtables, seqNum)
+
+	s := scanInternal{d: d, lower: lower, upper: upper, seqNum: seqNum}
+	if err := s.collectTables(readState.current, visitors.SharedFile); err != nil {
+		return err
+	}
+	if visitors.PointKey != nil {
+		if err := s.visitPointKeys(memtables, visitors.PointKey); err != nil {
+			return err
+		}
+	}
+	if visitors.RangeDel != nil {
+		if err := s.visitRangeDels(memtables, visitors.RangeDel); err != nil {
+			return err
+		}
+	}
+	if visitors.RangeKey != nil {
+		if err := s.visitRangeKeys(memtables, visitors.RangeKey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scanInternal holds the state of a call to DB.ScanInternal.
+type scanInternal struct {
+	d            *DB
+	lower, upper []byte
+	seqNum       uint64
+	// levels holds the tables within the bounds of the scan whose contents
+	// are scanned, as key-sorted slices. Every L0 table is in a slice of its
+	// own.
+	levels []scanInternalLevel
+}
+
+type scanInternalLevel struct {
+	level manifest.Level
+	files manifest.LevelSlice
+}
+
+// collectTables collects the tables overlapping the bounds of the scan. If
+// visitShared is non-nil, it is called for the tables on shared storage,
+// which are excluded from the scan.
+func (s *scanInternal) collectTables(v *version, visitShared func(SharedTableInfo) error) error {
+	cmp := s.d.cmp
+	for level := 0; level < numLevels; level++ {
+		var files []*fileMetadata
+		iter := v.Levels[level].Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			if (s.upper != nil && cmp(f.Smallest.UserKey, s.upper) >= 0) ||
+				(s.lower != nil && cmp(f.Largest.UserKey, s.lower) < 0) {
+				continue
+			}
+			if f.IsShared && visitShared != nil {
+				err := visitShared(SharedTableInfo{
+					TableInfo:       f.TableInfo(),
+					Level:           level,
+					CreatorUniqueID: f.CreatorUniqueID,
+					PhysicalFileNum: f.PhysicalFileNum,
+				})
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			if level == 0 {
+				s.levels = append(s.levels, scanInternalLevel{
+					level: manifest.Level(0),
+					files: manifest.NewLevelSliceKeySorted(cmp, []*fileMetadata{f}),
+				})
+				continue
+			}
+			files = append(files, f)
+		}
+		if len(files) > 0 {
+			s.levels = append(s.levels, scanInternalLevel{
+				level: manifest.Level(level),
+				files: manifest.NewLevelSliceKeySorted(cmp, files),
+			})
+		}
+	}
+	return nil
+}
+
+func (s *scanInternal) visitPointKeys(
+	memtables flushableList, visit func(*InternalKey, []byte) error,
+) error {
+	iterOpts := IterOptions{LowerBound: s.lower, UpperBound: s.upper, logger: s.d.opts.Logger}
+	iters := make([]internalIterator, 0, len(memtables)+len(s.levels))
+	for i := len(memtables) - 1; i >= 0; i-- {
+		iters = append(iters, memtables[i].newIter(&iterOpts))
+	}
+	for _, l := range s.levels {
+		iters = append(iters, newLevelIter(iterOpts, s.d.cmp, nil /* split */, s.d.newIters,
+			l.files.Iter(), l.level, nil /* bytesIterated */))
+	}
+	iter := newMergingIter(s.d.opts.Logger, s.d.cmp, nil /* split */, iters...)
+	iter.snapshot = s.seqNum
+
+	var blobBuf []byte
+	var err error
+	var key *InternalKey
+	var value []byte
+	if s.lower != nil {
+		key, value = iter.SeekGE(s.lower, base.SeekGEFlagsNone)
+	} else {
+		key, value = iter.First()
+	}
+	for ; key != nil; key, value = iter.Next() {
+		if s.upper != nil && s.d.cmp(key.UserKey, s.upper) >= 0 {
+			break
+		}
+		if key.Kind() == InternalKeyKindBlobSet {
+			if blobBuf, err = s.d.blobs.read(value, blobBuf); err != nil {
+				break
+			}
+			value = blobBuf
+			// Compactions rewrite BLOBSET keys as SETWITHDEL keys when they
+			// read the values back, so they are visited as such.
+			k := *key
+			k.SetKind(InternalKeyKindSetWithDelete)
+			key = &k
+		}
+		if err = visit(key, value); err != nil {
+			break
+		}
+	}
+	return firstError(err, iter.Close())
+}
+
+func (s *scanInternal) visitRangeDels(
+	memtables flushableList, visit func(start, end []byte, keys []InternalSpanKey) error,
+) error {
+	d := s.d
+	var iters []keyspan.FragmentIterator
+	for i := len(memtables) - 1; i >= 0; i-- {
+		if iter := memtables[i].newRangeDelIter(nil); iter != nil {
+			iters = append(iters, iter)
+		}
+	}
+	for _, l := range s.levels {
+		newRangeDelIter := func(
+			f *manifest.FileMetadata, _ *keyspan.SpanIterOptions,
+		) (keyspan.FragmentIterator, error) {
+			iter, rangeDelIter, err := d.newIters(f, nil /* iter options */, nil /* bytesIterated */)
+			if err != nil {
+				return nil, err
+			}
+			if err := iter.Close(); err != nil {
+				if rangeDelIter != nil {
+					_ = rangeDelIter.Close()
+				}
+				return nil, err
+			}
+			if rangeDelIter == nil {
+				return emptyKeyspanIter, nil
+			}
+			// Range deletions are only valid within the bounds of their table.
+			return keyspan.Truncate(d.cmp, rangeDelIter, nil, nil, &f.Smallest, &f.Largest), nil
+		}
+		li := &keyspan.LevelIter{}
+		li.Init(keyspan.SpanIterOptions{}, d.cmp, newRangeDelIter, l.files.Iter(), l.level,
+			d.opts.Logger, manifest.KeyTypePoint)
+		iters = append(iters, li)
+	}
+	return s.visitSpans(iters, visit)
+}
+
+func (s *scanInternal) visitRangeKeys(
+	memtables flushableList, visit func(start, end []byte, keys []InternalSpanKey) error,
+) error {
+	d := s.d
+	var iters []keyspan.FragmentIterator
+	for i := len(memtables) - 1; i >= 0; i-- {
+		if iter := memtables[i].newRangeKeyIter(nil); iter != nil {
+			iters = append(iters, iter)
+		}
+	}
+	for _, l := range s.levels {
+		li := &keyspan.LevelIter{}
+		li.Init(keyspan.SpanIterOptions{}, d.cmp, d.tableNewRangeKeyIter, l.files.Iter(), l.level,
+			d.opts.Logger, manifest.KeyTypeRange)
+		iters = append(iters, li)
+	}
+	return s.visitSpans(iters, visit)
+}
+
+// visitSpans merges the spans of the iterators, and visits the fragments of
+// the merged spans within the bounds of the scan that have keys visible at
+// the scan's sequence number. It closes the iterators.
+func (s *scanInternal) visitSpans(
+	iters []keyspan.FragmentIterator, visit func(start, end []byte, keys []InternalSpanKey) error,
+) error {
+	if len(iters) == 0 {
+		return nil
+	}
+	var mi keyspan.MergingIter
+	mi.Init(s.d.cmp, keyspan.TransformerFunc(func(_ base.Compare, in keyspan.Span, out *keyspan.Span) error {
+		out.Start, out.End = in.Start, in.End
+		out.Keys = out.Keys[:0]
+		for _, k := range in.Keys {
+			if base.Visible(k.SeqNum(), s.seqNum) {
+				out.Keys = append(out.Keys, k)
+			}
+		}
+		return nil
+	}), iters...)
+
+	// SeekGE positions a keyspan iterator at the first span starting at or
+	// after the key, so seek to the span that may contain the lower bound.
+	var span *keyspan.Span
+	if s.lower == nil {
+		span = mi.First()
+	} else if span = mi.SeekLT(s.lower); span == nil {
+		span = mi.First()
+	} else if s.d.cmp(span.End, s.lower) <= 0 {
+		span = mi.Next()
+	}
+	var err error
+	for ; span != nil; span = mi.Next() {
+		if s.upper != nil && s.d.cmp(span.Start, s.upper) >= 0 {
+			break
+		}
+		if span.Empty() {
+			continue
+		}
+		start, end := span.Start, span.End
+		if s.lower != nil && s.d.cmp(start, s.lower) < 0 {
+			start = s.lower
+		}
+		if s.upper != nil && s.d.cmp(end, s.upper) > 0 {
+			end = s.upper
+		}
+		if err = visit(start, end, span.Keys); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = mi.Error()
+	}
+	return errors.CombineErrors(err, mi.Close())
+}
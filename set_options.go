@@ -0,0 +1,184 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/rate"
+	"github.com/cockroachdb/redact"
+)
+
+// MutableOptions holds the subset of Options that may be changed while a DB
+// is open, using DB.SetOptions.
+type MutableOptions struct {
+	// L0CompactionThreshold is Options.L0CompactionThreshold.
+	L0CompactionThreshold int
+	// L0StopWritesThreshold is Options.L0StopWritesThreshold.
+	L0StopWritesThreshold int
+	// MaxConcurrentCompactions is Options.MaxConcurrentCompactions.
+	MaxConcurrentCompactions int
+	// MinDeletionRate is Options.Experimental.MinDeletionRate.
+	MinDeletionRate int
+	// PersistentCacheSize is Options.PersistentCacheSize. It may only be
+	// changed if the DB was opened with a persistent cache.
+	PersistentCacheSize uint64
+	// ReadCompactionRate is Options.Experimental.ReadCompactionRate. A new
+	// rate applies to files added to the LSM after the change.
+	ReadCompactionRate int64
+}
+
+func makeMutableOptions(o *Options) MutableOptions {
+	return MutableOptions{
+		L0CompactionThreshold:    o.L0CompactionThreshold,
+		L0StopWritesThreshold:    o.L0StopWritesThreshold,
+		MaxConcurrentCompactions: o.MaxConcurrentCompactions,
+		MinDeletionRate:          o.Experimental.MinDeletionRate,
+		PersistentCacheSize:      o.PersistentCacheSize,
+		ReadCompactionRate:       o.Experimental.ReadCompactionRate,
+	}
+}
+
+func (m MutableOptions) apply(o *Options) {
+	o.L0CompactionThreshold = m.L0CompactionThreshold
+	o.L0StopWritesThreshold = m.L0StopWritesThreshold
+	o.MaxConcurrentCompactions = m.MaxConcurrentCompactions
+	o.Experimental.MinDeletionRate = m.MinDeletionRate
+	o.PersistentCacheSize = m.PersistentCacheSize
+	o.Experimental.ReadCompactionRate = m.ReadCompactionRate
+}
+
+// validate checks the values that Options.Validate does not, since they are
+// defaulted by Options.EnsureDefaults when a DB is opened.
+func (m MutableOptions) validate() error {
+	switch {
+	case m.L0CompactionThreshold < 1:
+		return errors.Errorf("pebble: L0CompactionThreshold (%d) must be >= 1", m.L0CompactionThreshold)
+	case m.MaxConcurrentCompactions < 1:
+		return errors.Errorf("pebble: MaxConcurrentCompactions (%d) must be >= 1", m.MaxConcurrentCompactions)
+	case m.MinDeletionRate < 0:
+		return errors.Errorf("pebble: MinDeletionRate (%d) must be >= 0", m.MinDeletionRate)
+	case m.ReadCompactionRate < 1:
+		return errors.Errorf("pebble: ReadCompactionRate (%d) must be >= 1", m.ReadCompactionRate)
+	}
+	return nil
+}
+
+func (m MutableOptions) String() string {
+	return redact.StringWithoutMarkers(m)
+}
+
+// SafeFormat implements redact.SafeFormatter.
+func (m MutableOptions) SafeFormat(w redact.SafePrinter, _ rune) {
+	w.Printf("l0_compaction_threshold=%d l0_stop_writes_threshold=%d max_concurrent_compactions=%d "+
+		"min_deletion_rate=%d persistent_cache_size=%d read_compaction_rate=%d",
+		redact.Safe(m.L0CompactionThreshold), redact.Safe(m.L0StopWritesThreshold),
+		redact.Safe(m.MaxConcurrentCompactions), redact.Safe(m.MinDeletionRate),
+		redact.Safe(m.PersistentCacheSize), redact.Safe(m.ReadCompactionRate))
+}
+
+// MutableOptions returns the current values of the options that may be
+// changed by SetOptions.
+func (d *DB) MutableOptions() MutableOptions {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return makeMutableOptions(d.opts)
+}
+
+// SetOptions changes the options of an open DB. The new options are validated
+// as a whole, written to a new OPTIONS file, and then applied atomically: a
+// concurrent compaction or write observes either all of the old values or all
+// of the new ones. To change a subset of the options, modify the value
+// returned by MutableOptions:
+//
+//	m := d.MutableOptions()
+//	m.MaxConcurrentCompactions = 4
+//	err := d.SetOptions(m)
+//
+// If an error is returned, the options are unchanged.
+func (d *DB) SetOptions(m MutableOptions) error {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	if d.opts.ReadOnly {
+		return ErrReadOnly
+	}
+
+	// Serialize SetOptions calls, since d.mu is released while the OPTIONS
+	// file is written.
+	d.setOptionsMu.Lock()
+	defer d.setOptionsMu.Unlock()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	old := makeMutableOptions(d.opts)
+	if m == old {
+		return nil
+	}
+	opts := d.opts.Clone()
+	m.apply(opts)
+	if err := firstError(m.validate(), opts.Validate()); err != nil {
+		return err
+	}
+	if m.PersistentCacheSize != old.PersistentCacheSize && d.persistentCache == nil {
+		return errors.New("pebble: PersistentCacheSize cannot be changed without a persistent cache")
+	}
+
+	jobID := d.mu.nextJobID
+	d.mu.nextJobID++
+	fileNum := d.mu.versions.getNextFileNum()
+	serializedOpts := []byte(opts.String())
+	info := OptionsChangeInfo{
+		JobID:   jobID,
+		Path:    base.MakeFilepath(d.opts.FS, d.dirname, fileTypeOptions, fileNum),
+		FileNum: fileNum,
+		Old:     old,
+		New:     m,
+	}
+
+	d.mu.Unlock()
+	err := d.writeOptionsFile(fileNum, serializedOpts)
+	d.mu.Lock()
+	if err != nil {
+		info.Err = err
+		d.opts.EventListener.OptionsChanged(info)
+		return err
+	}
+
+	m.apply(d.opts)
+	if m.MinDeletionRate != old.MinDeletionRate {
+		d.deletionLimiter = rate.NewLimiter(rate.Limit(m.MinDeletionRate), m.MinDeletionRate)
+	}
+	if m.PersistentCacheSize != old.PersistentCacheSize {
+		d.persistentCache.SetCapacity(m.PersistentCacheSize)
+	}
+	if m.L0CompactionThreshold != old.L0CompactionThreshold {
+		// The compaction picker computes the level scores when it is created,
+		// so recreate it with the new threshold.
+		vs := d.mu.versions
+		vs.picker = newCompactionPicker(vs.currentVersion(), d.opts,
+			d.getInProgressCompactionInfoLocked(nil), vs.metrics.levelSizes(), vs.diskAvailBytes)
+		if !vs.dynamicBaseLevel {
+			vs.picker.forceBaseLevel1()
+		}
+	}
+
+	// The previous OPTIONS file is now obsolete.
+	d.mu.versions.obsoleteOptions = append(d.mu.versions.obsoleteOptions, fileInfo{
+		fileNum:  d.optionsFileNum,
+		fileSize: d.optionsFileSize,
+	})
+	d.optionsFileNum = fileNum
+	d.optionsFileSize = uint64(len(serializedOpts))
+	d.opts.EventListener.OptionsChanged(info)
+
+	// Wake up any writers stalled on the old L0StopWritesThreshold, and pick up
+	// compactions allowed by the new thresholds and concurrency.
+	d.mu.compact.cond.Broadcast()
+	d.maybeScheduleCompaction()
+	d.deleteObsoleteFiles(jobID, false /* waitForOngoing */)
+	return nil
+}
@@ -0,0 +1,122 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"sort"
+	"sync/atomic"
+
+	"github.com/cockroachdb/pebble/internal/humanize"
+	"github.com/cockroachdb/pebble/sstable"
+)
+
+// The first reads of a shared sstable need its index and filter blocks, which
+// pay the latency of SharedFS unless they're in the block cache. When
+// Options.WarmSharedTables is set, the shared sstables of a DB are warmed up
+// in the background after it is opened, and ingested shared sstables are
+// warmed up after every ingestion, by loading their metadata blocks into the
+// block cache.
+//
+// Tables are appended to d.mu.sharedWarmup.pending. If no warm-up is
+// running, one is started in a separate goroutine. Only one warm-up runs at a
+// time, and a completing warm-up starts a new one if tables accumulated in the
+// meantime.
+
+// maybeWarmSharedTablesLocked adds the shared sstables among newFiles to the
+// pending queue of tables to be warmed up, when the feature is enabled.
+// DB.mu must be locked when calling.
+func (d *DB) maybeWarmSharedTablesLocked(newFiles []newFileEntry) {
+	if !d.opts.WarmSharedTables || d.opts.SharedFS == nil {
+		return
+	}
+	for _, nf := range newFiles {
+		if nf.Meta.IsShared {
+			d.mu.sharedWarmup.pending = append(d.mu.sharedWarmup.pending, nf)
+		}
+	}
+	if d.shouldWarmSharedTablesLocked() {
+		go d.warmSharedTables()
+	}
+}
+
+// shouldWarmSharedTablesLocked returns true if a warm-up of shared sstables
+// should run. DB.mu must be locked when calling.
+func (d *DB) shouldWarmSharedTablesLocked() bool {
+	return !d.mu.sharedWarmup.warming &&
+		d.closed.Load() == nil &&
+		len(d.mu.sharedWarmup.pending) > 0
+}
+
+// warmSharedTables warms up the shared sstables in the pending queue, in
+// order of priority, until Options.WarmSharedTablesBytes is exhausted.
+func (d *DB) warmSharedTables() {
+	d.mu.Lock()
+	if !d.shouldWarmSharedTablesLocked() {
+		d.mu.Unlock()
+		return
+	}
+
+	pending := d.mu.sharedWarmup.pending
+	d.mu.sharedWarmup.pending = nil
+	d.mu.sharedWarmup.warming = true
+	jobID := d.mu.nextJobID
+	d.mu.nextJobID++
+	rs := d.loadReadState()
+
+	// Drop DB.mu before performing IO.
+	d.mu.Unlock()
+
+	// Warm up higher levels first, as every read consults them. Within a
+	// level, warm up the tables that were sampled most by reads first.
+	sampledReads := func(nf newFileEntry) int64 {
+		return nf.Meta.InitAllowedSeeks - atomic.LoadInt64(&nf.Meta.Atomic.AllowedSeeks)
+	}
+	sort.SliceStable(pending, func(i, j int) bool {
+		if pending[i].Level != pending[j].Level {
+			return pending[i].Level < pending[j].Level
+		}
+		return sampledReads(pending[i]) > sampledReads(pending[j])
+	})
+
+	var tables int
+	var loaded uint64
+	budget := d.opts.WarmSharedTablesBytes
+	for _, f := range pending {
+		if budget != 0 && loaded >= budget {
+			break
+		}
+		if d.closed.Load() != nil {
+			break
+		}
+		// The table may have been compacted since it was queued, in which case
+		// it no longer needs to be warmed up.
+		if !rs.current.Contains(f.Level, d.cmp, f.Meta) {
+			continue
+		}
+		err := d.tableCache.withReader(f.Meta, func(r *sstable.Reader) error {
+			n, err := r.LoadMetadataBlocks()
+			loaded += n
+			return err
+		})
+		if err != nil {
+			// Warming up is best effort; a failure is only logged.
+			d.opts.Logger.Infof("[JOB %d] warming up shared sstable %s failed: %s",
+				jobID, f.Meta.FileNum, err)
+			continue
+		}
+		tables++
+	}
+	rs.unref()
+	d.opts.Logger.Infof("[JOB %d] warmed up %d shared sstables (%s)",
+		jobID, tables, humanize.Uint64(loaded))
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.mu.sharedWarmup.warming = false
+	d.mu.sharedWarmup.cond.Broadcast()
+	if d.shouldWarmSharedTablesLocked() {
+		go d.warmSharedTables()
+	}
+}
@@ -38,6 +38,15 @@ func (s *Snapshot) Get(key []byte) ([]byte, io.Closer, error) {
 	return s.db.getInternal(key, nil /* batch */, s)
 }
 
+// MultiGet gets the values of the given keys from the snapshot. See
+// DB.MultiGet.
+func (s *Snapshot) MultiGet(keys [][]byte) ([]MultiGetResult, io.Closer, error) {
+	if s.db == nil {
+		panic(ErrClosed)
+	}
+	return s.db.multiGetInternal(keys, s)
+}
+
 // NewIter returns an iterator that is unpositioned (Iterator.Valid() will
 // return false). The iterator can be positioned via a call to SeekGE,
 // SeekLT, First or Last.
@@ -5,6 +5,7 @@
 package sstable
 
 import (
+	"bytes"
 	"encoding/binary"
 	"unsafe"
 
@@ -35,6 +36,8 @@ type blockWriter struct {
 	curValue        []byte
 	prevKey         []byte
 	tmp             [4]byte
+	// hashIndex accumulates the hash index of a data block, if enabled.
+	hashIndex blockHashIndexBuilder
 }
 
 func (w *blockWriter) clear() {
@@ -44,6 +47,10 @@ func (w *blockWriter) clear() {
 		curKey:   w.curKey[:0],
 		curValue: w.curValue[:0],
 		prevKey:  w.prevKey[:0],
+		hashIndex: blockHashIndexBuilder{
+			hashes:   w.hashIndex.hashes[:0],
+			restarts: w.hashIndex.restarts[:0],
+		},
 	}
 }
 
@@ -144,6 +151,28 @@ func (w *blockWriter) add(key InternalKey, value []byte) {
 	key.Encode(w.curKey)
 
 	w.store(size, value)
+	if w.hashIndex.enabled {
+		w.addHashIndexEntry()
+	}
+}
+
+// addHashIndexEntry adds the current key to the hash index if its prefix
+// differs from that of the previous key.
+func (w *blockWriter) addHashIndexEntry() {
+	h := &w.hashIndex
+	if h.overflow {
+		return
+	}
+	if len(w.restarts) > hashIndexMaxRestarts {
+		h.overflow = true
+		return
+	}
+	prefix := h.prefix(w.curKey[:len(w.curKey)-8])
+	if w.nEntries > 1 && bytes.Equal(prefix, h.prefix(w.prevKey[:len(w.prevKey)-8])) {
+		return
+	}
+	h.hashes = append(h.hashes, hashIndexHash(prefix))
+	h.restarts = append(h.restarts, uint8(len(w.restarts)-1))
 }
 
 func (w *blockWriter) finish() []byte {
@@ -162,7 +191,12 @@ func (w *blockWriter) finish() []byte {
 		binary.LittleEndian.PutUint32(tmp4, x)
 		w.buf = append(w.buf, tmp4...)
 	}
-	binary.LittleEndian.PutUint32(tmp4, uint32(len(w.restarts)))
+	footer := uint32(len(w.restarts))
+	if w.hashIndex.size() > 0 {
+		w.buf = w.hashIndex.finish(w.buf)
+		footer |= hashIndexFlag
+	}
+	binary.LittleEndian.PutUint32(tmp4, footer)
 	w.buf = append(w.buf, tmp4...)
 	result := w.buf
 
@@ -171,6 +205,7 @@ func (w *blockWriter) finish() []byte {
 	w.nextRestart = 0
 	w.buf = w.buf[:0]
 	w.restarts = w.restarts[:0]
+	w.hashIndex.reset()
 	return result
 }
 
@@ -180,7 +215,7 @@ func (w *blockWriter) finish() []byte {
 const emptyBlockSize = 4
 
 func (w *blockWriter) estimatedSize() int {
-	return len(w.buf) + 4*len(w.restarts) + emptyBlockSize
+	return len(w.buf) + 4*len(w.restarts) + w.hashIndex.size() + emptyBlockSize
 }
 
 type blockEntry struct {
@@ -234,7 +269,10 @@ type blockIter struct {
 	restarts int32
 	// Number of restart points in this block. Encoded at the end of the block
 	// as a uint32.
-	numRestarts  int32
+	numRestarts int32
+	// hashBuckets holds the buckets of the block's hash index, or is nil if the
+	// block has no hash index. See blockHashIndexBuilder.
+	hashBuckets  []byte
 	globalSeqNum uint64
 	ptr          unsafe.Pointer
 	data         []byte
@@ -271,6 +309,10 @@ type blockIter struct {
 	cached      []blockEntry
 	cachedBuf   []byte
 	cacheHandle cache.Handle
+	// pinned, if non-nil, takes ownership of the cache handle of the block
+	// when the iterator moves to another block or is closed, instead of the
+	// handle being released.
+	pinned *PinnedBlocks
 	// The first key in the block. This is used by the caller to set bounds
 	// for block iteration for already loaded blocks.
 	firstKey InternalKey
@@ -289,12 +331,21 @@ func (i *blockIter) String() string {
 }
 
 func (i *blockIter) init(cmp Compare, block block, globalSeqNum uint64) error {
-	numRestarts := int32(binary.LittleEndian.Uint32(block[len(block)-4:]))
+	footer := binary.LittleEndian.Uint32(block[len(block)-4:])
+	restartsEnd := int32(len(block)) - 4
+	i.hashBuckets = nil
+	if footer&hashIndexFlag != 0 {
+		footer &^= hashIndexFlag
+		numBuckets := int32(binary.LittleEndian.Uint16(block[restartsEnd-2:]))
+		restartsEnd -= 2 + numBuckets
+		i.hashBuckets = block[restartsEnd : restartsEnd+numBuckets]
+	}
+	numRestarts := int32(footer)
 	if numRestarts == 0 {
 		return base.CorruptionErrorf("pebble/table: invalid table (block has no restart points)")
 	}
 	i.cmp = cmp
-	i.restarts = int32(len(block)) - 4*(1+numRestarts)
+	i.restarts = restartsEnd - 4*numRestarts
 	i.numRestarts = numRestarts
 	i.globalSeqNum = globalSeqNum
 	i.ptr = unsafe.Pointer(&block[0])
@@ -314,17 +365,31 @@ func (i *blockIter) init(cmp Compare, block block, globalSeqNum uint64) error {
 }
 
 func (i *blockIter) initHandle(cmp Compare, block cache.Handle, globalSeqNum uint64) error {
-	i.cacheHandle.Release()
+	i.releaseHandle()
 	i.cacheHandle = block
+	if i.pinned != nil {
+		i.pinned.load(block.Get())
+	}
 	return i.init(cmp, block.Get(), globalSeqNum)
 }
 
+// releaseHandle releases the cache handle of the block, or hands it to
+// i.pinned if set.
+func (i *blockIter) releaseHandle() {
+	if i.pinned != nil {
+		i.pinned.pin(i.cacheHandle)
+	} else {
+		i.cacheHandle.Release()
+	}
+}
+
 func (i *blockIter) invalidate() {
 	i.clearCache()
 	i.offset = 0
 	i.nextOffset = 0
 	i.restarts = 0
 	i.numRestarts = 0
+	i.hashBuckets = nil
 	i.data = nil
 }
 
@@ -636,6 +701,54 @@ func (i *blockIter) SeekGE(key []byte, flags base.SeekGEFlags) (*InternalKey, []
 	return nil, nil
 }
 
+// seekPrefixGE is like SeekGE, but uses the block's hash index, if any, to
+// find the restart interval holding the first key with the given prefix,
+// which must be the prefix of key extracted by split. If the prefix is not
+// found in the hash index, or the hash index cannot rule out that an earlier
+// restart interval holds the first key >= key, it falls back to SeekGE. It
+// also returns whether the hash index was used.
+func (i *blockIter) seekPrefixGE(
+	split Split, prefix, key []byte,
+) (_ *InternalKey, _ []byte, hashed bool) {
+	if i.hashBuckets == nil {
+		k, v := i.SeekGE(key, base.SeekGEFlagsNone)
+		return k, v, false
+	}
+	index := i.hashBuckets[hashIndexHash(prefix)%uint32(len(i.hashBuckets))]
+	if index == hashIndexEmpty || index == hashIndexCollision || int32(index) >= i.numRestarts {
+		k, v := i.SeekGE(key, base.SeekGEFlagsNone)
+		return k, v, false
+	}
+
+	i.clearCache()
+	ikey := base.MakeSearchKey(key)
+	i.offset = int32(binary.LittleEndian.Uint32(i.data[i.restarts+4*int32(index):]))
+	i.readEntry()
+	i.decodeInternalKey(i.key)
+	if index > 0 && base.InternalCompare(i.cmp, i.ikey, ikey) >= 0 {
+		// The restart point's key is the first key >= key only if it has the
+		// prefix sought: the keys with a prefix are contiguous, and the
+		// interval holds the first of them. Otherwise the bucket belongs to
+		// another prefix, and an earlier interval may hold keys >= key.
+		userKey := i.ikey.UserKey
+		if split != nil {
+			userKey = userKey[:split(userKey)]
+		}
+		if !bytes.Equal(userKey, prefix) {
+			k, v := i.SeekGE(key, base.SeekGEFlagsNone)
+			return k, v, false
+		}
+	}
+
+	// Iterate from the restart point to somewhere >= the key sought.
+	for ; i.valid(); i.Next() {
+		if base.InternalCompare(i.cmp, i.ikey, ikey) >= 0 {
+			return &i.ikey, i.val, true
+		}
+	}
+	return nil, nil, true
+}
+
 // SeekPrefixGE implements internalIterator.SeekPrefixGE, as documented in the
 // pebble package.
 func (i *blockIter) SeekPrefixGE(
@@ -944,7 +1057,7 @@ func (i *blockIter) Error() error {
 // Close implements internalIterator.Close, as documented in the pebble
 // package.
 func (i *blockIter) Close() error {
-	i.cacheHandle.Release()
+	i.releaseHandle()
 	i.cacheHandle = cache.Handle{}
 	i.val = nil
 	return nil
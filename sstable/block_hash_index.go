@@ -0,0 +1,113 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package sstable
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// A data block hash index maps the prefixes of the keys in a data block to
+// the restart intervals holding them, allowing point lookups to skip the
+// binary search over the block's restart points. See the description of the
+// block format in table.go.
+
+const (
+	// hashIndexEmpty marks a bucket to which no prefix in the block hashes.
+	hashIndexEmpty = 255
+	// hashIndexCollision marks a bucket to which prefixes in different
+	// restart intervals hash.
+	hashIndexCollision = 254
+	// hashIndexMaxRestarts is the maximum number of restart points in a block
+	// with a hash index. The indexes of the restart points must fit in a
+	// bucket without clashing with the markers above.
+	hashIndexMaxRestarts = hashIndexCollision
+	// hashIndexFlag is set in the restart point count at the end of a block
+	// with a hash index.
+	hashIndexFlag = 1 << 31
+)
+
+// hashIndexHash returns the hash of a key prefix in a data block hash index.
+// It is FNV-1a, manually inlined to avoid allocating a hash.Hash32.
+func hashIndexHash(prefix []byte) uint32 {
+	h := uint32(2166136261)
+	for _, c := range prefix {
+		h ^= uint32(c)
+		h *= 16777619
+	}
+	return h
+}
+
+// hashIndexNumBuckets returns the number of buckets of a hash index with n
+// prefixes, aiming for a bucket utilization of 75%. The number of buckets is
+// odd, for a better distribution of the hashes.
+func hashIndexNumBuckets(n int) int {
+	b := (n + n/3) | 1
+	if b > math.MaxUint16 {
+		b = math.MaxUint16
+	}
+	return b
+}
+
+// blockHashIndexBuilder accumulates the hash index of a data block.
+type blockHashIndexBuilder struct {
+	enabled bool
+	// split extracts the prefixes indexed from the user keys. A nil split
+	// indexes whole user keys.
+	split Split
+	// overflow is set once the block has too many restart points for a hash
+	// index, in which case the block is written without one.
+	overflow bool
+	// hashes and restarts hold, for the first key of each run of keys with
+	// the same prefix, the hash of the prefix and the index of the restart
+	// interval holding the key.
+	hashes   []uint32
+	restarts []uint8
+}
+
+func (b *blockHashIndexBuilder) prefix(userKey []byte) []byte {
+	if b.split == nil {
+		return userKey
+	}
+	return userKey[:b.split(userKey)]
+}
+
+func (b *blockHashIndexBuilder) reset() {
+	b.overflow = false
+	b.hashes = b.hashes[:0]
+	b.restarts = b.restarts[:0]
+}
+
+// size returns the encoded size of the hash index, including the trailing
+// bucket count.
+func (b *blockHashIndexBuilder) size() int {
+	if !b.enabled || b.overflow || len(b.hashes) == 0 {
+		return 0
+	}
+	return hashIndexNumBuckets(len(b.hashes)) + 2
+}
+
+// finish appends the hash index to buf.
+func (b *blockHashIndexBuilder) finish(buf []byte) []byte {
+	numBuckets := hashIndexNumBuckets(len(b.hashes))
+	n := len(buf)
+	for j := 0; j < numBuckets; j++ {
+		buf = append(buf, hashIndexEmpty)
+	}
+	buckets := buf[n:]
+	for j, h := range b.hashes {
+		bucket := &buckets[h%uint32(numBuckets)]
+		switch *bucket {
+		case hashIndexEmpty:
+			*bucket = b.restarts[j]
+		case hashIndexCollision, b.restarts[j]:
+		default:
+			*bucket = hashIndexCollision
+		}
+	}
+	var tmp [2]byte
+	binary.LittleEndian.PutUint16(tmp[:], uint16(numBuckets))
+	return append(buf, tmp[:]...)
+}
@@ -7,6 +7,7 @@ package sstable
 import (
 	"bytes"
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 	"testing"
@@ -15,6 +16,7 @@ import (
 
 	"github.com/cockroachdb/pebble/internal/base"
 	"github.com/cockroachdb/pebble/internal/datadriven"
+	"github.com/cockroachdb/pebble/internal/testkeys"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/exp/rand"
 )
@@ -486,3 +488,69 @@ func BenchmarkBlockIterPrev(b *testing.B) {
 			})
 	}
 }
+
+func TestBlockHashIndex(t *testing.T) {
+	// Build keys with up to five versions of every prefix of the keyspace,
+	// omitting every third prefix.
+	ks := testkeys.Alpha(2).Slice(0, 200)
+	var keys [][]byte
+	for i := 0; i < ks.Count(); i++ {
+		if i%3 == 2 {
+			continue
+		}
+		for ts := 1 + i%5; ts >= 1; ts-- {
+			keys = append(keys, testkeys.KeyAt(ks, i, ts))
+		}
+	}
+	cmp := testkeys.Comparer.Compare
+	sort.Slice(keys, func(i, j int) bool { return cmp(keys[i], keys[j]) < 0 })
+
+	for _, split := range []Split{nil, testkeys.Comparer.Split} {
+		for _, restartInterval := range []int{1, 4, 16} {
+			t.Run(fmt.Sprintf("split=%t,restart-interval=%d", split != nil, restartInterval), func(t *testing.T) {
+				w := &blockWriter{
+					restartInterval: restartInterval,
+					hashIndex:       blockHashIndexBuilder{enabled: true, split: split},
+				}
+				for _, k := range keys {
+					w.add(base.MakeInternalKey(k, 0, InternalKeyKindSet), k)
+				}
+				block := w.finish()
+
+				hashIter, err := newBlockIter(cmp, block)
+				require.NoError(t, err)
+				// With a restart interval of 1, the block has too many restart
+				// points for a hash index.
+				require.Equal(t, restartInterval > 1, hashIter.hashBuckets != nil)
+				iter, err := newBlockIter(cmp, block)
+				require.NoError(t, err)
+
+				for i := 0; i < ks.Count(); i++ {
+					for ts := 0; ts <= 6; ts++ {
+						key := testkeys.Key(ks, i)
+						if ts > 0 {
+							key = testkeys.KeyAt(ks, i, ts)
+						}
+						prefix := key
+						if split != nil {
+							prefix = key[:split(key)]
+						}
+						want, _ := iter.SeekGE(key, base.SeekGEFlagsNone)
+						got, _, _ := hashIter.seekPrefixGE(split, prefix, key)
+						if want == nil {
+							require.Nil(t, got, "seek %q", key)
+							continue
+						}
+						require.NotNil(t, got, "seek %q", key)
+						require.Equal(t, string(want.UserKey), string(got.UserKey), "seek %q", key)
+
+						// The iterator is positioned correctly for iteration.
+						want, _ = iter.Next()
+						got, _ = hashIter.Next()
+						require.Equal(t, want == nil, got == nil, "next after %q", key)
+					}
+				}
+			})
+		}
+	}
+}
@@ -48,6 +48,8 @@ func optsFromArgs(td *datadriven.TestData, writerOpts *WriterOptions) error {
 			}
 		case "filter":
 			writerOpts.FilterPolicy = bloom.FilterPolicy(10)
+		case "data-block-hash-index":
+			writerOpts.DataBlockHashIndex = true
 		case "comparer-split-4b-suffix":
 			writerOpts.Comparer = test4bSuffixComparer
 		}
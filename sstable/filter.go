@@ -4,7 +4,10 @@
 
 package sstable
 
-import "sync/atomic"
+import (
+	"bytes"
+	"sync/atomic"
+)
 
 // FilterMetrics holds metrics for the filter policy.
 type FilterMetrics struct {
@@ -100,3 +103,94 @@ func (f *tableFilterWriter) metaName() string {
 func (f *tableFilterWriter) policyName() string {
 	return f.policy.Name()
 }
+
+// partitionedFilterWriter builds a table filter that is partitioned in line
+// with the index partitions of a table with a two-level index, so that a
+// lookup only needs to load the filter partition covering the key sought.
+// If the table ends up with a single-level index, the filter is written as a
+// regular table filter.
+//
+// The Writer calls finishDataBlock whenever it finishes a data block, so the
+// prefixes of the keys in a data block are buffered until the Writer decides
+// which partition the block belongs to.
+type partitionedFilterWriter struct {
+	tableFilterWriter
+	// blockPrefixes holds the distinct consecutive prefixes added since the
+	// last call to finishDataBlock, with blockPrefixEnds holding their end
+	// offsets.
+	blockPrefixes   []byte
+	blockPrefixEnds []int
+	// partitions holds the finished filter partitions.
+	partitions [][]byte
+}
+
+func newPartitionedFilterWriter(policy FilterPolicy) *partitionedFilterWriter {
+	return &partitionedFilterWriter{
+		tableFilterWriter: tableFilterWriter{
+			policy: policy,
+			writer: policy.NewWriter(TableFilter),
+		},
+	}
+}
+
+func (f *partitionedFilterWriter) addKey(key []byte) {
+	if n := len(f.blockPrefixEnds); n > 0 {
+		start := 0
+		if n > 1 {
+			start = f.blockPrefixEnds[n-2]
+		}
+		if bytes.Equal(f.blockPrefixes[start:], key) {
+			return
+		}
+	}
+	f.blockPrefixes = append(f.blockPrefixes, key...)
+	f.blockPrefixEnds = append(f.blockPrefixEnds, len(f.blockPrefixes))
+}
+
+// finishDataBlock adds the prefixes of the data block just finished to the
+// current partition. If cutPartition is true, the block is the first one of
+// a new index partition, so the current partition is finished first.
+func (f *partitionedFilterWriter) finishDataBlock(cutPartition bool) {
+	if cutPartition {
+		// The separator of the last data block of the partition may be greater
+		// than keys with the prefix of the first key of the next block, so a
+		// lookup of such a key may land on this partition. Add the prefix to
+		// the partition so that the lookup is not wrongly excluded.
+		if len(f.blockPrefixEnds) > 0 {
+			f.tableFilterWriter.addKey(f.blockPrefixes[:f.blockPrefixEnds[0]])
+		}
+		f.partitions = append(f.partitions, f.writer.Finish(nil))
+		f.count = 0
+	}
+	start := 0
+	for _, end := range f.blockPrefixEnds {
+		f.tableFilterWriter.addKey(f.blockPrefixes[start:end])
+		start = end
+	}
+	f.blockPrefixes = f.blockPrefixes[:0]
+	f.blockPrefixEnds = f.blockPrefixEnds[:0]
+}
+
+// partitioned returns true if the filter has more than one partition.
+func (f *partitionedFilterWriter) partitioned() bool {
+	return len(f.partitions) > 0
+}
+
+// finishPartitions finishes the final partition, and returns all the
+// partitions.
+func (f *partitionedFilterWriter) finishPartitions() [][]byte {
+	f.finishDataBlock(false /* cutPartition */)
+	return append(f.partitions, f.writer.Finish(nil))
+}
+
+func (f *partitionedFilterWriter) finish() ([]byte, error) {
+	f.finishDataBlock(false /* cutPartition */)
+	return f.tableFilterWriter.finish()
+}
+
+func (f *partitionedFilterWriter) metaName() string {
+	if f.partitioned() {
+		return "partitionedfilter." + f.policy.Name()
+	}
+	return f.tableFilterWriter.metaName()
+}
@@ -166,11 +166,31 @@ type WriterOptions struct {
 	// The default value (DefaultCompression) uses snappy compression.
 	Compression Compression
 
+	// DataBlockHashIndex enables a hash index in each data block, mapping the
+	// prefixes of the block's keys (as extracted by Comparer.Split, or the
+	// whole user keys if Split is nil) to the restart intervals holding them.
+	// Point lookups through SeekPrefixGE use it to skip the binary search
+	// over the block's restart points, at the cost of roughly 1.33 bytes per
+	// distinct prefix in the block. Blocks with more than 254 restart points
+	// are written without a hash index. Requires TableFormatPebblev3 or later.
+	DataBlockHashIndex bool
+
+	// PartitionedFilters splits the table filter of tables with a two-level
+	// index into partitions aligned with the lower-level index blocks, so
+	// that a lookup only needs to load the partition covering its key rather
+	// than the whole filter. Tables with a single-level index are written
+	// with an unpartitioned table filter. Has no effect unless FilterPolicy
+	// is set and FilterType is TableFilter. Requires TableFormatPebblev4 or
+	// later.
+	PartitionedFilters bool
+
 	// FilterPolicy defines a filter algorithm (such as a Bloom filter) that can
 	// reduce disk reads for Get calls.
 	//
 	// One such implementation is bloom.FilterPolicy(10) from the pebble/bloom
-	// package.
+	// package. Another is ribbon.FilterPolicy(10) from the pebble/ribbon
+	// package, which has the same false positive rate using ~30% less space,
+	// but is slower to build.
 	//
 	// The default value means to use no filter.
 	FilterPolicy FilterPolicy
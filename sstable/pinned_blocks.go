@@ -0,0 +1,122 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package sstable
+
+import (
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/cockroachdb/pebble/internal/cache"
+)
+
+// PinnedBlocks holds references to the data blocks read by the iterators it is
+// set on through Iterator.SetPinnedBlocks. A data block is normally released
+// when an iterator moves off of it, invalidating the keys and values returned
+// from it. A pinned data block is instead kept in memory until Release is
+// called, so that the keys and values returned from it remain valid.
+//
+// A PinnedBlocks must not be used concurrently. The zero value is ready to
+// use.
+type PinnedBlocks struct {
+	handles []cache.Handle
+	// loaded holds the blocks currently loaded by the iterators. They are
+	// pinned once the iterators move off of them.
+	loaded [][]byte
+	// pinned holds the start of the buffer of every pinned block. A block that
+	// is loaded again is pinned once.
+	pinned map[*byte]struct{}
+	size   uint64
+	// counter, if non-nil, is atomically adjusted by the size of the blocks
+	// pinned and released.
+	counter *int64
+}
+
+// Init initializes the PinnedBlocks. If counter is non-nil, the size of the
+// pinned blocks is atomically added to it as blocks are pinned and subtracted
+// from it on Release.
+func (p *PinnedBlocks) Init(counter *int64) {
+	*p = PinnedBlocks{counter: counter}
+}
+
+// load records that an iterator loaded the block b, which it will pin once
+// it moves off of it.
+func (p *PinnedBlocks) load(b []byte) {
+	if len(b) > 0 {
+		p.loaded = append(p.loaded, b)
+	}
+}
+
+// pin takes ownership of the reference of h.
+func (p *PinnedBlocks) pin(h cache.Handle) {
+	b := h.Get()
+	if len(b) == 0 {
+		h.Release()
+		return
+	}
+	for i := range p.loaded {
+		if &p.loaded[i][0] == &b[0] {
+			n := len(p.loaded) - 1
+			p.loaded[i] = p.loaded[n]
+			p.loaded[n] = nil
+			p.loaded = p.loaded[:n]
+			break
+		}
+	}
+	if _, ok := p.pinned[&b[0]]; ok {
+		// The block is already pinned through another reference.
+		h.Release()
+		return
+	}
+	if p.pinned == nil {
+		p.pinned = make(map[*byte]struct{})
+	}
+	p.pinned[&b[0]] = struct{}{}
+	p.handles = append(p.handles, h)
+	p.size += uint64(len(b))
+	if p.counter != nil {
+		atomic.AddInt64(p.counter, int64(len(b)))
+	}
+}
+
+// Contains returns true if b lies within a block currently loaded by one of
+// the iterators. Such a block is pinned when the iterator moves off of it, so
+// b remains valid until Release is called. An iterator returns keys that lie
+// within its block when they are stored without prefix compression, and keys
+// assembled in a buffer it reuses otherwise.
+func (p *PinnedBlocks) Contains(b []byte) bool {
+	if len(b) == 0 {
+		return false
+	}
+	start := uintptr(unsafe.Pointer(&b[0]))
+	end := start + uintptr(len(b))
+	for _, l := range p.loaded {
+		lstart := uintptr(unsafe.Pointer(&l[0]))
+		if start >= lstart && end <= lstart+uintptr(len(l)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Size returns the total size of the pinned blocks.
+func (p *PinnedBlocks) Size() uint64 {
+	return p.size
+}
+
+// Release releases all the pinned blocks, invalidating the values returned
+// from them.
+func (p *PinnedBlocks) Release() {
+	for i := range p.handles {
+		p.handles[i].Release()
+		p.handles[i] = cache.Handle{}
+	}
+	if p.counter != nil {
+		atomic.AddInt64(p.counter, -int64(p.size))
+	}
+	p.handles = p.handles[:0]
+	p.loaded = nil
+	p.pinned = nil
+	p.size = 0
+}
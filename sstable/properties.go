@@ -82,7 +82,10 @@ type Properties struct {
 	// The name of the filter policy used in this table. Empty if no filter
 	// policy is used.
 	FilterPolicyName string `prop:"rocksdb.filter.policy"`
-	// The size of filter block.
+	// The number of filter partitions if the filter is partitioned.
+	FilterPartitions uint64 `prop:"pebble.filter.partitions"`
+	// The size of filter block, or of the filter partitions and their top-level
+	// index if the filter is partitioned.
 	FilterSize uint64 `prop:"rocksdb.filter.size"`
 	// If 0, key is variable length. Otherwise number of bytes for each key.
 	FixedKeyLen uint64 `prop:"rocksdb.fixed.key.length"`
@@ -314,6 +317,9 @@ func (p *Properties) save(w *rawBlockWriter) {
 	if p.FilterPolicyName != "" {
 		p.saveString(m, unsafe.Offsetof(p.FilterPolicyName), p.FilterPolicyName)
 	}
+	if p.FilterPartitions != 0 {
+		p.saveUvarint(m, unsafe.Offsetof(p.FilterPartitions), p.FilterPartitions)
+	}
 	p.saveUvarint(m, unsafe.Offsetof(p.FilterSize), p.FilterSize)
 	p.saveUvarint(m, unsafe.Offsetof(p.FixedKeyLen), p.FixedKeyLen)
 	p.saveUvarint(m, unsafe.Offsetof(p.FormatVersion), p.FormatVersion)
@@ -6,6 +6,7 @@ package sstable
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"fmt"
 	"io"
@@ -26,7 +27,8 @@ import (
 	"github.com/cockroachdb/pebble/vfs"
 )
 
-// DBUniqueID is injected by pebble during Open()
+// DBUniqueID is the unique ID of the reading DB assumed by Readers that are
+// not given a DBUniqueIDOpt.
 var DBUniqueID uint32 = 0
 
 var errCorruptIndexEntry = base.CorruptionErrorf("pebble/table: corrupt index entry")
@@ -122,6 +124,17 @@ type Iterator interface {
 
 	SetLevel(level int)
 	GetLevel() int
+
+	// SetContext sets a context that is checked before each block the
+	// iterator reads. Once the context is done, the iterator fails with the
+	// context's error.
+	SetContext(ctx context.Context)
+
+	// SetPinnedBlocks sets the PinnedBlocks taking ownership of the data
+	// blocks the iterator moves off of, so that the values returned from them
+	// remain valid until the PinnedBlocks is released. It must be called
+	// before the iterator is positioned.
+	SetPinnedBlocks(p *PinnedBlocks)
 }
 
 // singleLevelIterator iterates over an entire table of data. To seek for a given
@@ -246,6 +259,9 @@ type singleLevelIterator struct {
 
 	level    int
 	levelSet bool
+
+	// ctx, if non-nil, is checked before each block read. See SetContext.
+	ctx context.Context
 }
 
 // singleLevelIterator implements the base.InternalIterator interface.
@@ -435,6 +451,11 @@ func (i *singleLevelIterator) loadBlock() loadBlockResult {
 func (i *singleLevelIterator) readBlockWithStats(
 	bh BlockHandle, raState *readaheadState,
 ) (cache.Handle, error) {
+	if i.ctx != nil {
+		if err := i.ctx.Err(); err != nil {
+			return cache.Handle{}, err
+		}
+	}
 	block, cacheHit, err := i.reader.readBlock(bh, nil /* transform */, raState)
 	if err == nil {
 		n := bh.Length
@@ -551,12 +572,14 @@ func (i *singleLevelIterator) SeekGE(key []byte, flags base.SeekGEFlags) (*Inter
 	// Seek optimization only applies until iterator is first positioned after SetBounds.
 	i.boundsCmp = 0
 	i.positionedUsingLatestBounds = true
-	return i.seekGEHelper(key, boundsCmp, flags)
+	return i.seekGEHelper(nil /* prefix */, key, boundsCmp, flags)
 }
 
 // seekGEHelper contains the common functionality for SeekGE and SeekPrefixGE.
+// A non-nil prefix, the prefix of key, allows seeking within a data block
+// using the block's hash index.
 func (i *singleLevelIterator) seekGEHelper(
-	key []byte, boundsCmp int, flags base.SeekGEFlags,
+	prefix, key []byte, boundsCmp int, flags base.SeekGEFlags,
 ) (*InternalKey, []byte) {
 	// Invariant: trySeekUsingNext => !i.data.isDataInvalidated() && i.exhaustedBounds != +1
 
@@ -658,7 +681,18 @@ func (i *singleLevelIterator) seekGEHelper(
 		}
 	}
 	if !dontSeekWithinBlock {
-		if ikey, val := i.data.SeekGE(key, flags.DisableTrySeekUsingNext()); ikey != nil {
+		var ikey *InternalKey
+		var val []byte
+		if prefix != nil {
+			var hashed bool
+			ikey, val, hashed = i.data.seekPrefixGE(i.reader.Split, prefix, key)
+			if hashed {
+				i.stats.HashIndexSeeks++
+			}
+		} else {
+			ikey, val = i.data.SeekGE(key, flags.DisableTrySeekUsingNext())
+		}
+		if ikey != nil {
 			if i.blockUpper != nil && i.cmp(ikey.UserKey, i.blockUpper) >= 0 {
 				i.exhaustedBounds = +1
 				return nil, nil
@@ -691,14 +725,12 @@ func (i *singleLevelIterator) seekPrefixGE(
 		}
 		i.lastBloomFilterMatched = false
 		// Check prefix bloom filter.
-		var dataH cache.Handle
-		dataH, i.err = i.reader.readFilter()
+		var mayContain bool
+		mayContain, i.err = i.reader.filterMayContain(prefix, key)
 		if i.err != nil {
 			i.data.invalidate()
 			return nil, nil
 		}
-		mayContain := i.reader.tableFilter.mayContain(dataH.Get(), prefix)
-		dataH.Release()
 		if !mayContain {
 			// This invalidation may not be necessary for correctness, and may
 			// be a place to optimize later by reusing the already loaded
@@ -724,7 +756,7 @@ func (i *singleLevelIterator) seekPrefixGE(
 	// Seek optimization only applies until iterator is first positioned after SetBounds.
 	i.boundsCmp = 0
 	i.positionedUsingLatestBounds = true
-	k, value = i.seekGEHelper(key, boundsCmp, flags)
+	k, value = i.seekGEHelper(prefix, key, boundsCmp, flags)
 	return k, value
 }
 
@@ -1100,6 +1132,16 @@ func (i *singleLevelIterator) SetLevel(level int) {
 	i.level = level
 }
 
+// SetContext implements Iterator.SetContext.
+func (i *singleLevelIterator) SetContext(ctx context.Context) {
+	i.ctx = ctx
+}
+
+// SetPinnedBlocks implements Iterator.SetPinnedBlocks.
+func (i *singleLevelIterator) SetPinnedBlocks(p *PinnedBlocks) {
+	i.data.pinned = p
+}
+
 // GetLevel implements Iterator.GetLevel()
 func (i *singleLevelIterator) GetLevel() int {
 	if !i.levelSet {
@@ -1439,14 +1481,12 @@ func (i *twoLevelIterator) SeekPrefixGE(
 			flags = flags.DisableTrySeekUsingNext()
 		}
 		i.lastBloomFilterMatched = false
-		var dataH cache.Handle
-		dataH, i.err = i.reader.readFilter()
+		var mayContain bool
+		mayContain, i.err = i.reader.filterMayContain(prefix, key)
 		if i.err != nil {
 			i.data.invalidate()
 			return nil, nil
 		}
-		mayContain := i.reader.tableFilter.mayContain(dataH.Get(), prefix)
-		dataH.Release()
 		if !mayContain {
 			// This invalidation may not be necessary for correctness, and may
 			// be a place to optimize later by reusing the already loaded
@@ -2163,6 +2203,35 @@ func (f FileReopenOpt) readerApply(r *Reader) {
 	}
 }
 
+// BlockCacheKeyOpt overrides the (ID, FileNum) pair that keys the reader's
+// blocks in the block cache. Tables backed by a shared object use it to key
+// their blocks by the object rather than by the local file number, so that
+// every virtual table backed by the object shares the cached blocks.
+type BlockCacheKeyOpt struct {
+	ID      uint64
+	FileNum base.FileNum
+}
+
+// Marker function to indicate the option should be applied before reading the
+// sstable properties.
+func (BlockCacheKeyOpt) preApply() {}
+
+func (o BlockCacheKeyOpt) readerApply(r *Reader) {
+	r.cacheID = o.ID
+	r.cacheFileNum = o.FileNum
+}
+
+// DBUniqueIDOpt specifies the unique ID of the DB reading the sstable. Shared
+// sstables created by a DB with a different unique ID are read as foreign
+// tables. See tableIterator.
+type DBUniqueIDOpt struct {
+	ID uint32
+}
+
+func (o DBUniqueIDOpt) readerApply(r *Reader) {
+	r.dbUniqueID = o.ID
+}
+
 // PersistentCacheOpt specifies the cache options
 type PersistentCacheOpt struct {
 	PsCache PersistentCache
@@ -2208,6 +2277,7 @@ type Reader struct {
 	filename          string
 	cacheID           uint64
 	fileNum           base.FileNum
+	cacheFileNum      base.FileNum
 	rawTombstones     bool
 	err               error
 	indexBH           BlockHandle
@@ -2223,12 +2293,14 @@ type Reader struct {
 	FormatKey         base.FormatKey
 	Split             Split
 	mergerOK          bool
+	filterPartitioned bool
 	checksumType      ChecksumType
 	tableFilter       *tableFilterReader
 	tableFormat       TableFormat
 	Properties        Properties
 	psCache           PersistentCache
 	meta              *manifest.FileMetadata
+	dbUniqueID        uint32
 }
 
 // Close implements DB.Close, as documented in the pebble package.
@@ -2276,7 +2348,7 @@ func (r *Reader) NewIterWithBlockPropertyFilters(
 			}
 			return nil, err
 		}
-		return &tableIterator{i, rangeDelIter}, nil
+		return &tableIterator{Iterator: i, rangeDelIter: rangeDelIter}, nil
 	}
 
 	i := singleLevelIterPool.Get().(*singleLevelIterator)
@@ -2291,7 +2363,7 @@ func (r *Reader) NewIterWithBlockPropertyFilters(
 		}
 		return nil, err
 	}
-	return &tableIterator{i, rangeDelIter}, nil
+	return &tableIterator{Iterator: i, rangeDelIter: rangeDelIter}, nil
 }
 
 // NewIter returns an iterator for the contents of the table. If an error
@@ -2319,7 +2391,7 @@ func (r *Reader) NewCompactionIter(bytesIterated *uint64) (Iterator, error) {
 			return nil, err
 		}
 		return &twoLevelCompactionIterator{
-			tableIterator: &tableIterator{i, rangeDelIter},
+			tableIterator: &tableIterator{Iterator: i, rangeDelIter: rangeDelIter},
 			bytesIterated: bytesIterated,
 		}, nil
 	}
@@ -2337,7 +2409,7 @@ func (r *Reader) NewCompactionIter(bytesIterated *uint64) (Iterator, error) {
 		return nil, err
 	}
 	return &compactionIterator{
-		tableIterator: &tableIterator{i, rangeDelIter},
+		tableIterator: &tableIterator{Iterator: i, rangeDelIter: rangeDelIter},
 		bytesIterated: bytesIterated,
 	}, nil
 }
@@ -2378,6 +2450,77 @@ func (r *Reader) NewRawRangeKeyIter() (keyspan.FragmentIterator, error) {
 	return i, nil
 }
 
+// MayContain sets mayContain[i] to whether the table's filter may contain
+// prefixes[i], reading the filter block once for all of the prefixes. If the
+// table has no filter, every prefix may be contained.
+func (r *Reader) MayContain(prefixes [][]byte, mayContain []bool) error {
+	if r.tableFilter == nil {
+		for i := range prefixes {
+			mayContain[i] = true
+		}
+		return nil
+	}
+	if r.filterPartitioned {
+		// Each prefix may be covered by a different partition. A bare prefix
+		// sorts before all the keys with that prefix, so seeking the filter
+		// index with it finds the partition covering the prefix.
+		for i := range prefixes {
+			var err error
+			if mayContain[i], err = r.filterMayContain(prefixes[i], prefixes[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	h, err := r.readFilter()
+	if err != nil {
+		return err
+	}
+	defer h.Release()
+	for i := range prefixes {
+		mayContain[i] = r.tableFilter.mayContain(h.Get(), prefixes[i])
+	}
+	return nil
+}
+
+// filterMayContain returns whether the table's filter may contain prefix. key
+// is the user key being sought, which has the given prefix, and is used to
+// find the partition covering prefix in a partitioned filter.
+func (r *Reader) filterMayContain(prefix, key []byte) (bool, error) {
+	h, err := r.readFilter()
+	if err != nil {
+		return false, err
+	}
+	defer h.Release()
+	if !r.filterPartitioned {
+		return r.tableFilter.mayContain(h.Get(), prefix), nil
+	}
+
+	var iter blockIter
+	if err := iter.init(r.Compare, h.Get(), 0 /* globalSeqNum */); err != nil {
+		return false, err
+	}
+	ikey, value := iter.SeekGE(key, base.SeekGEFlagsNone)
+	if ikey == nil {
+		// The key is past the end of the table.
+		return false, iter.Close()
+	}
+	bh, n := decodeBlockHandle(value)
+	if n == 0 || n != len(value) {
+		_ = iter.Close()
+		return false, base.CorruptionErrorf("pebble/table: invalid table (bad filter partition handle)")
+	}
+	if err := iter.Close(); err != nil {
+		return false, err
+	}
+	partitionH, _, err := r.readBlock(bh, nil /* transform */, nil /* readaheadState */)
+	if err != nil {
+		return false, err
+	}
+	defer partitionH.Release()
+	return r.tableFilter.mayContain(partitionH.Get(), prefix), nil
+}
+
 func (r *Reader) readIndex() (cache.Handle, error) {
 	h, _, err :=
 		r.readBlock(r.indexBH, nil /* transform */, nil /* readaheadState */)
@@ -2432,7 +2575,7 @@ func (r *Reader) readBlock(
 	if r.meta != nil {
 		usesSharedFS = r.meta.IsShared
 	}
-	if h := r.opts.Cache.Get(r.cacheID, r.fileNum, bh.Offset, usesSharedFS); h.Get() != nil {
+	if h := r.opts.Cache.Get(r.cacheID, r.cacheFileNum, bh.Offset, usesSharedFS); h.Get() != nil {
 		if raState != nil {
 			raState.recordCacheHit(int64(bh.Offset), int64(bh.Length+blockTrailerLen))
 		}
@@ -2525,7 +2668,7 @@ func (r *Reader) readBlock(
 		v = newV
 	}
 
-	h := r.opts.Cache.Set(r.cacheID, r.fileNum, bh.Offset, v, usesSharedFS)
+	h := r.opts.Cache.Set(r.cacheID, r.cacheFileNum, bh.Offset, v, usesSharedFS)
 	if r.psCache != nil {
 		r.psCache.MaybeCache(r.meta, int64(bh.Length))
 	}
@@ -2633,15 +2776,18 @@ func (r *Reader) readMetaindex(metaindexBH BlockHandle) error {
 
 	for name, fp := range r.opts.Filters {
 		types := []struct {
-			ftype  FilterType
-			prefix string
+			ftype       FilterType
+			prefix      string
+			partitioned bool
 		}{
-			{TableFilter, "fullfilter."},
+			{TableFilter, "fullfilter.", false},
+			{TableFilter, "partitionedfilter.", true},
 		}
 		var done bool
 		for _, t := range types {
 			if bh, ok := meta[t.prefix+name]; ok {
 				r.filterBH = bh
+				r.filterPartitioned = t.partitioned
 
 				switch t.ftype {
 				case TableFilter:
@@ -2669,7 +2815,6 @@ func (r *Reader) Layout() (*Layout, error) {
 
 	l := &Layout{
 		Data:       make([]BlockHandleWithProperties, 0, r.Properties.NumDataBlocks),
-		Filter:     r.filterBH,
 		RangeDel:   r.rangeDelBH,
 		RangeKey:   r.rangeKeyBH,
 		Properties: r.propertiesBH,
@@ -2677,6 +2822,26 @@ func (r *Reader) Layout() (*Layout, error) {
 		Footer:     r.footerBH,
 	}
 
+	if !r.filterPartitioned {
+		l.Filter = r.filterBH
+	} else {
+		l.TopFilter = r.filterBH
+		filterH, err := r.readFilter()
+		if err != nil {
+			return nil, err
+		}
+		iter, _ := newBlockIter(r.Compare, filterH.Get())
+		for key, value := iter.First(); key != nil; key, value = iter.Next() {
+			bh, n := decodeBlockHandle(value)
+			if n == 0 {
+				filterH.Release()
+				return nil, errCorruptIndexEntry
+			}
+			l.FilterPartitions = append(l.FilterPartitions, bh)
+		}
+		filterH.Release()
+	}
+
 	indexH, err := r.readIndex()
 	if err != nil {
 		return nil, err
@@ -2760,7 +2925,8 @@ func (r *Reader) ValidateBlockChecksums() error {
 		blocks[i] = l.Data[i].BlockHandle
 	}
 	blocks = append(blocks, l.Index...)
-	blocks = append(blocks, l.TopIndex, l.Filter, l.RangeDel, l.RangeKey, l.Properties, l.MetaIndex)
+	blocks = append(blocks, l.FilterPartitions...)
+	blocks = append(blocks, l.TopIndex, l.Filter, l.TopFilter, l.RangeDel, l.RangeKey, l.Properties, l.MetaIndex)
 
 	// Sorting by offset ensures we are performing a sequential scan of the
 	// file.
@@ -2790,6 +2956,95 @@ func (r *Reader) ValidateBlockChecksums() error {
 	return nil
 }
 
+// LoadMetadataBlocks reads the index (including the index partitions of a
+// two-level index), filter (including the partitions of a partitioned
+// filter), range deletion and range key blocks of the table
+// into the block cache, so that subsequent reads of the table only need to
+// read data blocks. It returns the number of bytes of blocks that were not
+// already cached.
+func (r *Reader) LoadMetadataBlocks() (uint64, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+
+	var loaded uint64
+	load := func(bh BlockHandle, transform blockTransform) (cache.Handle, error) {
+		h, cacheHit, err := r.readBlock(bh, transform, nil /* readaheadState */)
+		if err == nil && !cacheHit {
+			loaded += bh.Length + blockTrailerLen
+		}
+		return h, err
+	}
+
+	indexH, err := load(r.indexBH, nil /* transform */)
+	if err != nil {
+		return loaded, err
+	}
+	if r.Properties.IndexPartitions > 0 {
+		topIter, err := newBlockIter(r.Compare, indexH.Get())
+		if err != nil {
+			indexH.Release()
+			return loaded, err
+		}
+		for key, value := topIter.First(); key != nil; key, value = topIter.Next() {
+			bh, err := decodeBlockHandleWithProperties(value)
+			if err != nil {
+				indexH.Release()
+				return loaded, errCorruptIndexEntry
+			}
+			h, err := load(bh.BlockHandle, nil /* transform */)
+			if err != nil {
+				indexH.Release()
+				return loaded, err
+			}
+			h.Release()
+		}
+	}
+	indexH.Release()
+
+	for _, b := range []struct {
+		bh        BlockHandle
+		transform blockTransform
+	}{
+		{r.filterBH, nil},
+		{r.rangeDelBH, r.rangeDelTransform},
+		{r.rangeKeyBH, nil},
+	} {
+		if b.bh.Length == 0 {
+			continue
+		}
+		h, err := load(b.bh, b.transform)
+		if err != nil {
+			return loaded, err
+		}
+		h.Release()
+	}
+
+	if r.filterPartitioned {
+		filterH, err := load(r.filterBH, nil /* transform */)
+		if err != nil {
+			return loaded, err
+		}
+		defer filterH.Release()
+		iter, err := newBlockIter(r.Compare, filterH.Get())
+		if err != nil {
+			return loaded, err
+		}
+		for key, value := iter.First(); key != nil; key, value = iter.Next() {
+			bh, n := decodeBlockHandle(value)
+			if n == 0 {
+				return loaded, errCorruptIndexEntry
+			}
+			h, err := load(bh, nil /* transform */)
+			if err != nil {
+				return loaded, err
+			}
+			h.Release()
+		}
+	}
+	return loaded, nil
+}
+
 // EstimateDiskUsage returns the total size of data blocks overlapping the range
 // `[start, end]`. Even if a data block partially overlaps, or we cannot
 // determine overlap due to abbreviated index keys, the full data block size is
@@ -2927,8 +3182,9 @@ type ReadableFile interface {
 func NewReader(f ReadableFile, o ReaderOptions, extraOpts ...ReaderOption) (*Reader, error) {
 	o = o.ensureDefaults()
 	r := &Reader{
-		file: f,
-		opts: o,
+		file:       f,
+		opts:       o,
+		dbUniqueID: DBUniqueID,
 	}
 	if r.opts.Cache == nil {
 		r.opts.Cache = cache.New(0)
@@ -2953,6 +3209,9 @@ func NewReader(f ReadableFile, o ReaderOptions, extraOpts ...ReaderOption) (*Rea
 	if r.cacheID == 0 {
 		r.cacheID = r.opts.Cache.NewID()
 	}
+	if r.cacheFileNum == 0 {
+		r.cacheFileNum = r.fileNum
+	}
 
 	footer, err := readFooter(f)
 	if err != nil {
@@ -3010,15 +3269,17 @@ type Layout struct {
 	// ValidateBlockChecksums, which validates a static list of BlockHandles
 	// referenced in this struct.
 
-	Data       []BlockHandleWithProperties
-	Index      []BlockHandle
-	TopIndex   BlockHandle
-	Filter     BlockHandle
-	RangeDel   BlockHandle
-	RangeKey   BlockHandle
-	Properties BlockHandle
-	MetaIndex  BlockHandle
-	Footer     BlockHandle
+	Data             []BlockHandleWithProperties
+	Index            []BlockHandle
+	TopIndex         BlockHandle
+	Filter           BlockHandle
+	FilterPartitions []BlockHandle
+	TopFilter        BlockHandle
+	RangeDel         BlockHandle
+	RangeKey         BlockHandle
+	Properties       BlockHandle
+	MetaIndex        BlockHandle
+	Footer           BlockHandle
 }
 
 // Describe returns a description of the layout. If the verbose parameter is
@@ -3044,6 +3305,12 @@ func (l *Layout) Describe(
 	if l.Filter.Length != 0 {
 		blocks = append(blocks, block{l.Filter, "filter"})
 	}
+	for i := range l.FilterPartitions {
+		blocks = append(blocks, block{l.FilterPartitions[i], "filter"})
+	}
+	if l.TopFilter.Length != 0 {
+		blocks = append(blocks, block{l.TopFilter, "top-filter"})
+	}
 	if l.RangeDel.Length != 0 {
 		blocks = append(blocks, block{l.RangeDel, "range-del"})
 	}
@@ -3150,6 +3417,27 @@ func (l *Layout) Describe(
 			}
 		}
 
+		// formatHashIndex prints the buckets of the hash index of a data block,
+		// which follow its restart points.
+		formatHashIndex := func(iter *blockIter) {
+			if iter.hashBuckets == nil {
+				return
+			}
+			offset := b.Offset + uint64(iter.restarts+4*iter.numRestarts)
+			for i, bucket := range iter.hashBuckets {
+				switch bucket {
+				case hashIndexEmpty:
+					fmt.Fprintf(w, "%10d    [hash bucket %d: empty]\n", offset+uint64(i), i)
+				case hashIndexCollision:
+					fmt.Fprintf(w, "%10d    [hash bucket %d: collision]\n", offset+uint64(i), i)
+				default:
+					fmt.Fprintf(w, "%10d    [hash bucket %d: restart %d]\n", offset+uint64(i), i, bucket)
+				}
+			}
+			fmt.Fprintf(w, "%10d    [hash buckets %d]\n",
+				offset+uint64(len(iter.hashBuckets)), len(iter.hashBuckets))
+		}
+
 		formatTrailer := func() {
 			trailer := make([]byte, blockTrailerLen)
 			offset := int64(b.Offset + b.Length)
@@ -3196,8 +3484,9 @@ func (l *Layout) Describe(
 				lastKey.UserKey = append(lastKey.UserKey[:0], key.UserKey...)
 			}
 			formatRestarts(iter.data, iter.restarts, iter.numRestarts)
+			formatHashIndex(iter)
 			formatTrailer()
-		case "index", "top-index":
+		case "index", "top-index", "top-filter":
 			iter, _ := newBlockIter(r.Compare, h.Get())
 			for key, value := iter.First(); key != nil; key, value = iter.Next() {
 				bh, err := decodeBlockHandleWithProperties(value)
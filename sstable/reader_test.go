@@ -25,41 +25,30 @@ import (
 	"github.com/cockroachdb/pebble/internal/cache"
 	"github.com/cockroachdb/pebble/internal/datadriven"
 	"github.com/cockroachdb/pebble/internal/errorfs"
+	"github.com/cockroachdb/pebble/internal/testkeys"
 	"github.com/cockroachdb/pebble/vfs"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/exp/rand"
 )
 
-// get is a testing helper that simulates a read and helps verify bloom filters
-// until they are available through iterators.
+// get is a testing helper that simulates a point lookup. It seeks using
+// SeekPrefixGE, which consults the bloom filter and the data block hash
+// indexes, if any.
 func (r *Reader) get(key []byte) (value []byte, err error) {
 	if r.err != nil {
 		return nil, r.err
 	}
 
-	if r.tableFilter != nil {
-		dataH, err := r.readFilter()
-		if err != nil {
-			return nil, err
-		}
-		var lookupKey []byte
-		if r.Split != nil {
-			lookupKey = key[:r.Split(key)]
-		} else {
-			lookupKey = key
-		}
-		mayContain := r.tableFilter.mayContain(dataH.Get(), lookupKey)
-		dataH.Release()
-		if !mayContain {
-			return nil, base.ErrNotFound
-		}
+	prefix := key
+	if r.Split != nil {
+		prefix = key[:r.Split(key)]
 	}
 
 	i, err := r.NewIter(nil /* lower */, nil /* upper */)
 	if err != nil {
 		return nil, err
 	}
-	ikey, value := i.SeekGE(key, base.SeekGEFlagsNone)
+	ikey, value := i.SeekPrefixGE(prefix, key, base.SeekGEFlagsNone)
 
 	if ikey == nil || r.Compare(key, ikey.UserKey) != 0 {
 		err := i.Close()
@@ -700,6 +689,56 @@ func TestReaderChecksumErrors(t *testing.T) {
 	}
 }
 
+func TestReaderLoadMetadataBlocks(t *testing.T) {
+	for _, file := range []string{
+		"testdata/h.sst",
+		"testdata/h.no-compression.two_level_index.sst",
+		"testdata/h.table-bloom.sst",
+	} {
+		t.Run(file, func(t *testing.T) {
+			filter := bloom.FilterPolicy(10)
+			c := cache.New(1 << 20)
+			defer c.Unref()
+			open := func() *Reader {
+				f, err := os.Open(filepath.FromSlash(file))
+				require.NoError(t, err)
+				r, err := NewReader(f, ReaderOptions{
+					Cache: c,
+					Filters: map[string]FilterPolicy{
+						filter.Name(): filter,
+					},
+				})
+				require.NoError(t, err)
+				return r
+			}
+
+			// Compute the expected size of the metadata blocks using a separate
+			// Reader, as computing the layout loads the index blocks. Every
+			// Reader is assigned its own cache ID.
+			r := open()
+			layout, err := r.Layout()
+			require.NoError(t, err)
+			require.NoError(t, r.Close())
+			var expected uint64
+			for _, bh := range append(layout.Index, layout.TopIndex, layout.Filter, layout.RangeDel, layout.RangeKey) {
+				if bh.Length > 0 {
+					expected += bh.Length + blockTrailerLen
+				}
+			}
+
+			r = open()
+			defer func() { require.NoError(t, r.Close()) }()
+			loaded, err := r.LoadMetadataBlocks()
+			require.NoError(t, err)
+			require.Equal(t, expected, loaded)
+			// The blocks are now cached.
+			loaded, err = r.LoadMetadataBlocks()
+			require.NoError(t, err)
+			require.Equal(t, uint64(0), loaded)
+		})
+	}
+}
+
 func TestValidateBlockChecksums(t *testing.T) {
 	seed := uint64(time.Now().UnixNano())
 	rng := rand.New(rand.NewSource(seed))
@@ -965,6 +1004,212 @@ func buildTestTable(
 	return r
 }
 
+func TestReaderBlockCacheKey(t *testing.T) {
+	mem := vfs.NewMem()
+	f0, err := mem.Create("test")
+	require.NoError(t, err)
+	w := NewWriter(f0, WriterOptions{BlockSize: 256})
+	for i := 0; i < 1000; i++ {
+		require.NoError(t, w.Set([]byte(fmt.Sprintf("%05d", i)), []byte("value")))
+	}
+	require.NoError(t, w.Close())
+
+	c := cache.New(128 << 20)
+	defer c.Unref()
+
+	// Two readers of the same object, opened with distinct per-DB cache keys but
+	// the same block cache key, must share cached blocks.
+	sharedKey := BlockCacheKeyOpt{ID: cache.SharedObjectID(7), FileNum: 3}
+	open := func(cacheID uint64, fileNum base.FileNum) *Reader {
+		f, err := mem.Open("test")
+		require.NoError(t, err)
+		r, err := NewReader(f, ReaderOptions{Cache: c}, &cacheOpts{cacheID, fileNum}, sharedKey)
+		require.NoError(t, err)
+		return r
+	}
+	scan := func(r *Reader) {
+		iter, err := r.NewIter(nil /* lower */, nil /* upper */)
+		require.NoError(t, err)
+		for k, _ := iter.First(); k != nil; k, _ = iter.Next() {
+		}
+		require.NoError(t, iter.Close())
+	}
+
+	r1 := open(1, 10)
+	defer r1.Close()
+	scan(r1)
+	misses := c.Metrics().Misses
+
+	r2 := open(2, 20)
+	defer r2.Close()
+	scan(r2)
+	require.Equal(t, misses, c.Metrics().Misses)
+}
+
+func TestReaderMayContain(t *testing.T) {
+	mem := vfs.NewMem()
+	build := func(name string, policy FilterPolicy) *Reader {
+		f, err := mem.Create(name)
+		require.NoError(t, err)
+		w := NewWriter(f, WriterOptions{FilterPolicy: policy})
+		for i := 0; i < 1000; i += 2 {
+			require.NoError(t, w.Set([]byte(fmt.Sprintf("%05d", i)), []byte("value")))
+		}
+		require.NoError(t, w.Close())
+		f, err = mem.Open(name)
+		require.NoError(t, err)
+		ro := ReaderOptions{}
+		if policy != nil {
+			ro.Filters = map[string]FilterPolicy{policy.Name(): policy}
+		}
+		r, err := NewReader(f, ro)
+		require.NoError(t, err)
+		return r
+	}
+
+	var prefixes [][]byte
+	for i := 0; i < 1000; i++ {
+		prefixes = append(prefixes, []byte(fmt.Sprintf("%05d", i)))
+	}
+	mayContain := make([]bool, len(prefixes))
+
+	// Every key written to the table may be contained, and most of the keys
+	// that were not are excluded by the filter.
+	r := build("bloom", bloom.FilterPolicy(10))
+	require.NoError(t, r.MayContain(prefixes, mayContain))
+	var falsePositives int
+	for i := range prefixes {
+		if i%2 == 0 {
+			require.True(t, mayContain[i], "%s", prefixes[i])
+		} else if mayContain[i] {
+			falsePositives++
+		}
+	}
+	require.Less(t, falsePositives, len(prefixes)/20)
+	require.NoError(t, r.Close())
+
+	// Without a filter, every key may be contained.
+	r = build("none", nil)
+	require.NoError(t, r.MayContain(prefixes, mayContain))
+	for i := range prefixes {
+		require.True(t, mayContain[i])
+	}
+	require.NoError(t, r.Close())
+}
+
+func TestReaderDataBlockHashIndex(t *testing.T) {
+	mem := vfs.NewMem()
+	f, err := mem.Create("test")
+	require.NoError(t, err)
+	w := NewWriter(f, WriterOptions{
+		BlockSize:          1024,
+		Comparer:           testkeys.Comparer,
+		DataBlockHashIndex: true,
+		TableFormat:        TableFormatPebblev3,
+	})
+	// Write three versions of every other prefix of the keyspace.
+	ks := testkeys.Alpha(2)
+	for i := 0; i < ks.Count(); i += 2 {
+		for ts := 3; ts >= 1; ts-- {
+			k := testkeys.KeyAt(ks, i, ts)
+			require.NoError(t, w.Set(k, k))
+		}
+	}
+	require.NoError(t, w.Close())
+	f, err = mem.Open("test")
+	require.NoError(t, err)
+	r, err := NewReader(f, ReaderOptions{Comparer: testkeys.Comparer})
+	require.NoError(t, err)
+	defer r.Close()
+
+	for i := 0; i < ks.Count(); i++ {
+		for ts := 1; ts <= 3; ts++ {
+			k := testkeys.KeyAt(ks, i, ts)
+			v, err := r.get(k)
+			if i%2 == 0 {
+				require.NoError(t, err, "get %q", k)
+				require.Equal(t, string(k), string(v))
+			} else {
+				require.Equal(t, base.ErrNotFound, err, "get %q", k)
+			}
+		}
+	}
+
+	// SeekPrefixGE positions the iterator at the first version of a prefix
+	// at or below the sought version, and the data blocks have hash indexes.
+	iter, err := r.NewIter(nil /* lower */, nil /* upper */)
+	require.NoError(t, err)
+	defer iter.Close()
+	key, _ := iter.SeekPrefixGE(testkeys.Key(ks, 100), testkeys.KeyAt(ks, 100, 5), base.SeekGEFlagsNone)
+	require.NotNil(t, key)
+	require.Equal(t, string(testkeys.KeyAt(ks, 100, 3)), string(key.UserKey))
+	require.NotNil(t, iter.(*tableIterator).Iterator.(*singleLevelIterator).data.hashBuckets)
+}
+
+func TestReaderPartitionedFilters(t *testing.T) {
+	mem := vfs.NewMem()
+	f, err := mem.Create("test")
+	require.NoError(t, err)
+	w := NewWriter(f, WriterOptions{
+		BlockSize:          256,
+		IndexBlockSize:     256,
+		Comparer:           testkeys.Comparer,
+		FilterPolicy:       bloom.FilterPolicy(10),
+		PartitionedFilters: true,
+		TableFormat:        TableFormatPebblev4,
+	})
+	// Write three versions of every other prefix of the keyspace, so that
+	// some prefixes straddle the boundaries of the filter partitions.
+	ks := testkeys.Alpha(2)
+	for i := 0; i < ks.Count(); i += 2 {
+		for ts := 3; ts >= 1; ts-- {
+			k := testkeys.KeyAt(ks, i, ts)
+			require.NoError(t, w.Set(k, k))
+		}
+	}
+	require.NoError(t, w.Close())
+	f, err = mem.Open("test")
+	require.NoError(t, err)
+	r, err := NewReader(f, ReaderOptions{
+		Comparer: testkeys.Comparer,
+		Filters:  map[string]base.FilterPolicy{bloom.FilterPolicy(10).Name(): bloom.FilterPolicy(10)},
+	})
+	require.NoError(t, err)
+	defer r.Close()
+
+	require.Less(t, uint64(1), r.Properties.FilterPartitions)
+	require.Equal(t, r.Properties.IndexPartitions, r.Properties.FilterPartitions)
+	l, err := r.Layout()
+	require.NoError(t, err)
+	require.Equal(t, 0, int(l.Filter.Length))
+	require.NotEqual(t, 0, int(l.TopFilter.Length))
+	require.Equal(t, int(r.Properties.FilterPartitions), len(l.FilterPartitions))
+	require.NoError(t, r.ValidateBlockChecksums())
+
+	var filtered int
+	for i := 0; i < ks.Count(); i++ {
+		prefix := testkeys.Key(ks, i)
+		mayContain := []bool{false}
+		require.NoError(t, r.MayContain([][]byte{prefix}, mayContain))
+		for ts := 1; ts <= 3; ts++ {
+			k := testkeys.KeyAt(ks, i, ts)
+			v, err := r.get(k)
+			if i%2 == 0 {
+				require.True(t, mayContain[0], "prefix %q", prefix)
+				require.NoError(t, err, "get %q", k)
+				require.Equal(t, string(k), string(v))
+			} else {
+				require.Equal(t, base.ErrNotFound, err, "get %q", k)
+			}
+		}
+		if !mayContain[0] {
+			filtered++
+		}
+	}
+	// Nearly all of the absent prefixes are filtered out.
+	require.Less(t, ks.Count()/2-ks.Count()/20, filtered)
+}
+
 func buildBenchmarkTable(b *testing.B, options WriterOptions) (*Reader, [][]byte) {
 	mem := vfs.NewMem()
 	f0, err := mem.Create("bench")
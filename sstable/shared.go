@@ -5,6 +5,7 @@
 package sstable
 
 import (
+	"github.com/cockroachdb/errors"
 	"github.com/cockroachdb/pebble/internal/base"
 	"github.com/cockroachdb/pebble/internal/keyspan"
 )
@@ -22,6 +23,12 @@ const (
 type tableIterator struct {
 	Iterator
 	rangeDelIter keyspan.FragmentIterator
+	// keyBuf holds the copy of the current user key returned by
+	// getCurrUserKey.
+	keyBuf []byte
+	// err is set when the latest version of a user key in a foreign table is a
+	// MERGE, which cannot be exposed without its older versions.
+	err error
 }
 
 // NOTE: The physical layout of user keys follows the descending order of freshness
@@ -86,16 +93,30 @@ func (i *tableIterator) cmpSharedBound(key []byte) int {
 // So for now I will just leave these two flags as is..
 
 func (i *tableIterator) isLocallyCreated() bool {
-	var r *Reader
+	r := i.getReader()
+	return i.isShared() && r.meta.CreatorUniqueID == r.dbUniqueID
+}
+
+// useSharedPath returns true if the table is shared and was created by another
+// DB, in which case its keys are restricted to its virtual bounds and only the
+// latest version of each user key is exposed. Tables created by this DB are
+// read like regular tables, as their virtual bounds are the file bounds.
+func (i *tableIterator) useSharedPath() bool {
+	return i.isShared() && !i.isLocallyCreated()
+}
+
+// getBounds returns the iteration bounds of the underlying iterator.
+func (i *tableIterator) getBounds() (lower, upper []byte) {
 	switch i.Iterator.(type) {
 	case *twoLevelIterator:
-		r = i.Iterator.(*twoLevelIterator).reader
+		it := i.Iterator.(*twoLevelIterator)
+		return it.lower, it.upper
 	case *singleLevelIterator:
-		r = i.Iterator.(*singleLevelIterator).reader
+		it := i.Iterator.(*singleLevelIterator)
+		return it.lower, it.upper
 	default:
 		panic("tableIterator: i.Iterator is not singleLevelIterator or twoLevelIterator")
 	}
-	return i.isShared() && r.meta.CreatorUniqueID == DBUniqueID
 }
 
 func (i *tableIterator) setExhaustedBounds(e int8) {
@@ -119,13 +140,15 @@ func (i *tableIterator) getCurrUserKey() InternalKey {
 	default:
 		panic("tableIterator: i.Iterator is not singleLevelIterator or twoLevelIterator")
 	}
+	// The user key may point into the block iterator's buffers, which are
+	// overwritten once the iterator is repositioned.
+	i.keyBuf = append(i.keyBuf[:0], k.UserKey...)
+	k.UserKey = i.keyBuf
 	return k
 }
 
+// isKeyDeleted returns true if the latest version of a user key is a deletion.
 func (i *tableIterator) isKeyDeleted(k *InternalKey) bool {
-	if k.Kind() == InternalKeyKindMerge {
-		panic("tableIterator: found InternalKeyKindMerge when evaluating key deletion")
-	}
 	if k.Kind() == InternalKeyKindDelete {
 		return true
 	}
@@ -140,6 +163,21 @@ func (i *tableIterator) isKeyDeleted(k *InternalKey) bool {
 	return false
 }
 
+// isUnmergedKey returns true if the latest version of a user key within the
+// virtual bounds is a MERGE, in which case the iterator fails with an error.
+// The operands in the table are not merged with each other, nor with the older
+// versions below it, so exposing the key would return a wrong value.
+// DB.ExportSharedSSTs refuses to export such keys.
+func (i *tableIterator) isUnmergedKey(k *InternalKey) bool {
+	if k.Kind() != InternalKeyKindMerge || i.cmpSharedBound(k.UserKey) != 0 {
+		return false
+	}
+	r := i.getReader()
+	i.err = errors.Errorf("pebble: MERGE key %s in foreign shared table %s",
+		k.Pretty(r.FormatKey), r.meta.FileNum)
+	return true
+}
+
 func setKeySeqNum(key *InternalKey, level int) {
 	if level == 5 {
 		key.SetSeqNum(seqNumL5PointKey)
@@ -182,6 +220,9 @@ func (i *tableIterator) seekGEShared(
 		// if the latest key is a tombstone, omit the current key
 		// Note that we don't need to set the SeqNum in this case because the key
 		// returned from the last level is either nil or has its SeqNum set correctly
+		if i.isUnmergedKey(k) {
+			return nil, nil
+		}
 		if i.isKeyDeleted(k) {
 			k, v = i.nextShared()
 		} else {
@@ -198,7 +239,8 @@ func (i *tableIterator) seekGEShared(
 
 func (i *tableIterator) SeekGE(key []byte, flags base.SeekGEFlags) (*InternalKey, []byte) {
 	// shared path
-	if i.isShared() {
+	if i.useSharedPath() {
+		i.err = nil
 		return i.seekGEShared(nil, key, flags)
 	}
 	// non-shared path
@@ -208,7 +250,8 @@ func (i *tableIterator) SeekGE(key []byte, flags base.SeekGEFlags) (*InternalKey
 func (i *tableIterator) SeekPrefixGE(
 	prefix, key []byte, flags base.SeekGEFlags,
 ) (*InternalKey, []byte) {
-	if i.isShared() {
+	if i.useSharedPath() {
+		i.err = nil
 		return i.seekGEShared(prefix, key, flags)
 	}
 	// non-shared path
@@ -221,10 +264,22 @@ func (i *tableIterator) seekLTShared(key []byte, flags base.SeekLTFlags) (*Inter
 	if ib < 0 {
 		i.setExhaustedBounds(-1)
 		return nil, nil
-	} else if ib > 0 {
-		key = r.meta.Largest.UserKey
 	}
-	k, v := i.Iterator.SeekLT(key, flags)
+	var k *InternalKey
+	var v []byte
+	if key == nil {
+		k, v = i.Iterator.Last()
+	} else {
+		k, v = i.Iterator.SeekLT(key, flags)
+	}
+	if key == nil || ib > 0 {
+		// The search key overflows. SeekLT is exclusive, so it cannot be
+		// substituted with the upper shared bound. Instead, step back over the
+		// keys beyond it.
+		for k != nil && cmp(k.UserKey, r.meta.Largest.UserKey) > 0 {
+			k, v = i.Iterator.Prev()
+		}
+	}
 	if k == nil {
 		i.setExhaustedBounds(-1)
 		return nil, nil
@@ -240,6 +295,9 @@ func (i *tableIterator) seekLTShared(key []byte, flags base.SeekLTFlags) (*Inter
 		// now, either k == nil or k < ik, so k is just one slot over
 		k, v = i.Iterator.Next()
 		// if the latest key is a tombstone, omit the current key
+		if i.isUnmergedKey(k) {
+			return nil, nil
+		}
 		if i.isKeyDeleted(k) {
 			k, v = i.prevShared()
 		} else {
@@ -247,7 +305,7 @@ func (i *tableIterator) seekLTShared(key []byte, flags base.SeekLTFlags) (*Inter
 		}
 	}
 	// check lower bound
-	if i.cmpSharedBound(k.UserKey) < 0 {
+	if k == nil || i.cmpSharedBound(k.UserKey) < 0 {
 		i.setExhaustedBounds(-1)
 		return nil, nil
 	}
@@ -256,7 +314,8 @@ func (i *tableIterator) seekLTShared(key []byte, flags base.SeekLTFlags) (*Inter
 
 func (i *tableIterator) SeekLT(key []byte, flags base.SeekLTFlags) (*InternalKey, []byte) {
 	// shared path
-	if i.isShared() {
+	if i.useSharedPath() {
+		i.err = nil
 		return i.seekLTShared(key, flags)
 	}
 	return i.Iterator.SeekLT(key, flags)
@@ -265,17 +324,25 @@ func (i *tableIterator) SeekLT(key []byte, flags base.SeekLTFlags) (*InternalKey
 // First() and Last() are just two synonyms of SeekGE and SeekLT
 
 func (i *tableIterator) First() (*InternalKey, []byte) {
-	if i.isShared() {
+	if i.useSharedPath() {
 		// in this case the table must have a smallest key
-		return i.seekGEShared(nil, i.getReader().meta.Smallest.UserKey, base.SeekGEFlagsNone)
+		key := i.getReader().meta.Smallest.UserKey
+		if lower, _ := i.getBounds(); lower != nil && i.getCmp()(lower, key) > 0 {
+			key = lower
+		}
+		i.err = nil
+		return i.seekGEShared(nil, key, base.SeekGEFlagsNone)
 	}
 	return i.Iterator.First()
 }
 
 func (i *tableIterator) Last() (*InternalKey, []byte) {
-	if i.isShared() {
-		// in this case the table must have a smallest key
-		return i.seekLTShared(i.getReader().meta.Largest.UserKey, base.SeekLTFlagsNone)
+	if i.useSharedPath() {
+		// seekLTShared handles an upper bound beyond the largest key (or no
+		// upper bound at all)
+		_, upper := i.getBounds()
+		i.err = nil
+		return i.seekLTShared(upper, base.SeekLTFlagsNone)
 	}
 	return i.Iterator.Last()
 }
@@ -307,6 +374,9 @@ func (i *tableIterator) nextShared() (*InternalKey, []byte) {
 			return nil, nil
 		}
 		// if the latest key is a tombstone, omit the current key
+		if i.isUnmergedKey(k) {
+			return nil, nil
+		}
 		if i.isKeyDeleted(k) {
 			k, v = i.nextShared()
 		} else {
@@ -322,7 +392,7 @@ func (i *tableIterator) nextShared() (*InternalKey, []byte) {
 }
 
 func (i *tableIterator) Next() (*InternalKey, []byte) {
-	if i.isShared() {
+	if i.useSharedPath() {
 		return i.nextShared()
 	}
 	return i.Iterator.Next()
@@ -349,6 +419,9 @@ func (i *tableIterator) prevShared() (*InternalKey, []byte) {
 		}
 		// At the current moment, either k < ik, or k == nil. So we rewind iter once.
 		k, v = i.Iterator.Next()
+		if i.isUnmergedKey(k) {
+			return nil, nil
+		}
 		if i.isKeyDeleted(k) {
 			k, v = i.prevShared()
 		} else {
@@ -356,7 +429,7 @@ func (i *tableIterator) prevShared() (*InternalKey, []byte) {
 		}
 	}
 	// check lower bound
-	if k == nil || i.cmpSharedBound(k.UserKey) > 0 {
+	if k == nil || i.cmpSharedBound(k.UserKey) < 0 {
 		i.setExhaustedBounds(-1)
 		return nil, nil
 	}
@@ -364,12 +437,19 @@ func (i *tableIterator) prevShared() (*InternalKey, []byte) {
 }
 
 func (i *tableIterator) Prev() (*InternalKey, []byte) {
-	if i.isShared() {
+	if i.useSharedPath() {
 		return i.prevShared()
 	}
 	return i.Iterator.Prev()
 }
 
+func (i *tableIterator) Error() error {
+	if i.err != nil {
+		return i.err
+	}
+	return i.Iterator.Error()
+}
+
 func (i *tableIterator) Close() error {
 	if i.rangeDelIter != nil {
 		err := i.rangeDelIter.Close()
@@ -459,7 +539,7 @@ func (i *rangeDelIter) isShared() bool {
 
 func (i *rangeDelIter) isLocallyCreated() bool {
 	r := i.reader
-	return i.isShared() && r.meta.CreatorUniqueID == DBUniqueID
+	return i.isShared() && r.meta.CreatorUniqueID == r.dbUniqueID
 }
 
 func setSpanSeqNum(s *keyspan.Span, seqnum uint64) {
@@ -470,8 +550,13 @@ func setSpanSeqNum(s *keyspan.Span, seqnum uint64) {
 }
 
 func (i *rangeDelIter) filterSpan(s *keyspan.Span) *keyspan.Span {
-	if i.isShared() && !i.isLocallyCreated() {
+	if s != nil && i.isShared() && !i.isLocallyCreated() {
 		level := i.GetLevel()
+		if level == -1 {
+			// The iterator is not used to read from the LSM (e.g. it is used to
+			// compute table stats), so the real sequence numbers are exposed.
+			return s
+		}
 		if level == 5 {
 			setSpanSeqNum(s, seqNumL5RangeDel)
 		} else if level == 6 {
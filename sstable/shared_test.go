@@ -167,5 +167,137 @@ func TestSharedSST(t *testing.T) {
 	}
 	require.NoError(t, rDelIter.Close())
 
+	// Restrict the foreign table to the virtual bounds [b, d]. Reverse
+	// iteration must include the largest key, and First must respect the
+	// iterator's lower bound.
+	r.meta.Smallest = InternalKey{UserKey: []byte("b"), Trailer: 0}
+	r.meta.Largest = InternalKey{UserKey: []byte("d"), Trailer: 0}
+	iter, err = r.NewIter(nil, nil)
+	require.NoError(t, err)
+	iter.SetLevel(5)
+	var keys []string
+	for k, _ := iter.Last(); k != nil; k, _ = iter.Prev() {
+		keys = append(keys, string(k.UserKey))
+	}
+	require.Equal(t, []string{"d", "c", "b"}, keys)
+	k, _ := iter.SeekLT([]byte("z"), base.SeekLTFlagsNone)
+	require.Equal(t, []byte("d"), k.UserKey)
+	require.NoError(t, iter.Close())
+
+	iter, err = r.NewIter([]byte("c"), nil)
+	require.NoError(t, err)
+	iter.SetLevel(5)
+	k, _ = iter.First()
+	require.Equal(t, []byte("c"), k.UserKey)
+	require.NoError(t, iter.Close())
+
+	require.NoError(t, r.Close())
+}
+
+func TestSharedSSTPrefixCompressedKeys(t *testing.T) {
+	mem := vfs.NewMem()
+	f0, err := mem.Create("test")
+	require.NoError(t, err)
+
+	// Every key but the first is prefix compressed, so the iterator decodes
+	// the user keys into a reused buffer.
+	w := NewWriter(f0, WriterOptions{})
+	for _, k := range []InternalKey{
+		base.MakeInternalKey([]byte("aa"), 1, InternalKeyKindSet),
+		base.MakeInternalKey([]byte("ab"), 2, InternalKeyKindSet),
+		base.MakeInternalKey([]byte("ab"), 1, InternalKeyKindSet),
+		base.MakeInternalKey([]byte("ac"), 1, InternalKeyKindSet),
+		base.MakeInternalKey([]byte("ad"), 2, InternalKeyKindSet),
+		base.MakeInternalKey([]byte("ad"), 1, InternalKeyKindSet),
+	} {
+		require.NoError(t, w.Add(k, nil))
+	}
+	require.NoError(t, w.Close())
+
+	f1, err := mem.Open("test")
+	require.NoError(t, err)
+	r, err := NewReader(f1, ReaderOptions{}, DBUniqueIDOpt{ID: 2})
+	require.NoError(t, err)
+	r.meta = &manifest.FileMetadata{
+		IsShared:        true,
+		CreatorUniqueID: 1,
+		Smallest:        InternalKey{UserKey: []byte("aa"), Trailer: 0},
+		Largest:         InternalKey{UserKey: []byte("ad"), Trailer: 0},
+	}
+
+	iter, err := r.NewIter(nil, nil)
+	require.NoError(t, err)
+	iter.SetLevel(6)
+	var keys []string
+	for k, _ := iter.First(); k != nil; k, _ = iter.Next() {
+		keys = append(keys, string(k.UserKey))
+	}
+	require.Equal(t, []string{"aa", "ab", "ac", "ad"}, keys)
+	keys = keys[:0]
+	for k, _ := iter.Last(); k != nil; k, _ = iter.Prev() {
+		keys = append(keys, string(k.UserKey))
+	}
+	require.Equal(t, []string{"ad", "ac", "ab", "aa"}, keys)
+	require.NoError(t, iter.Close())
+	require.NoError(t, r.Close())
+}
+
+func TestSharedSSTMergeKey(t *testing.T) {
+	mem := vfs.NewMem()
+	f0, err := mem.Create("test")
+	require.NoError(t, err)
+
+	w := NewWriter(f0, WriterOptions{})
+	for _, k := range []InternalKey{
+		base.MakeInternalKey([]byte("a"), 1, InternalKeyKindSet),
+		base.MakeInternalKey([]byte("b"), 2, InternalKeyKindMerge),
+		base.MakeInternalKey([]byte("b"), 1, InternalKeyKindSet),
+		base.MakeInternalKey([]byte("c"), 1, InternalKeyKindSet),
+	} {
+		require.NoError(t, w.Add(k, []byte("v")))
+	}
+	require.NoError(t, w.Close())
+
+	f1, err := mem.Open("test")
+	require.NoError(t, err)
+	r, err := NewReader(f1, ReaderOptions{}, DBUniqueIDOpt{ID: 2})
+	require.NoError(t, err)
+	r.meta = &manifest.FileMetadata{
+		IsShared:        true,
+		CreatorUniqueID: 1,
+		Smallest:        InternalKey{UserKey: []byte("a"), Trailer: 0},
+		Largest:         InternalKey{UserKey: []byte("c"), Trailer: 0},
+	}
+
+	// The MERGE key fails the read in both directions.
+	iter, err := r.NewIter(nil, nil)
+	require.NoError(t, err)
+	iter.SetLevel(6)
+	k, _ := iter.First()
+	require.Equal(t, []byte("a"), k.UserKey)
+	k, _ = iter.Next()
+	require.Nil(t, k)
+	require.Error(t, iter.Error())
+	k, _ = iter.SeekLT([]byte("c"), base.SeekLTFlagsNone)
+	require.Nil(t, k)
+	require.Error(t, iter.Error())
+	// Repositioning the iterator clears the error.
+	k, _ = iter.SeekGE([]byte("c"), base.SeekGEFlagsNone)
+	require.Equal(t, []byte("c"), k.UserKey)
+	require.NoError(t, iter.Error())
+	require.NoError(t, iter.Close())
+
+	// A MERGE key outside the virtual bounds is not an error.
+	r.meta.Largest = InternalKey{UserKey: []byte("a"), Trailer: 0}
+	iter, err = r.NewIter(nil, nil)
+	require.NoError(t, err)
+	iter.SetLevel(6)
+	var keys []string
+	for k, _ := iter.First(); k != nil; k, _ = iter.Next() {
+		keys = append(keys, string(k.UserKey))
+	}
+	require.Equal(t, []string{"a"}, keys)
+	require.NoError(t, iter.Error())
+	require.NoError(t, iter.Close())
 	require.NoError(t, r.Close())
 }
@@ -84,6 +84,9 @@ func rewriteKeySuffixesInBlocks(
 	// Copy over the filter block if it exists (rewriteDataBlocksToWriter will
 	// already have ensured this is valid if it exists).
 	if w.filter != nil {
+		if len(l.FilterPartitions) > 0 {
+			return nil, errors.New("input table has partitioned filters")
+		}
 		if l.Filter.Length == 0 {
 			return nil, errors.New("input table has no filter")
 		}
@@ -114,6 +117,7 @@ func rewriteBlocks(
 	r *Reader,
 	restartInterval int,
 	checksumType ChecksumType,
+	hashIndex bool,
 	compression Compression,
 	input []BlockHandleWithProperties,
 	output []blockWithSpan,
@@ -123,6 +127,7 @@ func rewriteBlocks(
 ) error {
 	bw := blockWriter{
 		restartInterval: restartInterval,
+		hashIndex:       blockHashIndexBuilder{enabled: hashIndex, split: split},
 	}
 	buf := blockBuf{checksummer: checksummer{checksumType: checksumType}}
 	if checksumType == ChecksumTypeXXHash {
@@ -235,6 +240,7 @@ func rewriteDataBlocksToWriter(
 				r,
 				w.dataBlockBuf.dataBlock.restartInterval,
 				w.blockBuf.checksummer.checksumType,
+				w.dataBlockHashIndex,
 				w.compression,
 				data,
 				blocks,
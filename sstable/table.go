@@ -131,6 +131,16 @@ uint32 values are the block offsets of each restart point. The final uint32
 value is P itself. Thus, when seeking for a particular key, one can use binary
 search to find the largest restart point whose key is <= the key sought.
 
+Starting with TableFormatPebblev3, a data block may also have a hash index,
+which maps the prefixes of its keys to restart points, placed between the
+restart points and P. The hash index is a sequence of B one-byte buckets,
+followed by B as a little-endian uint16, and the high bit of P is set to
+indicate its presence. Each bucket holds the index of the restart point whose
+interval contains the first key with a prefix hashing to the bucket, or a
+marker for an empty bucket, or for a collision between prefixes in different
+restart intervals. A point lookup whose prefix hashes to a bucket holding a
+restart point can skip the binary search, and scan from that restart point.
+
 An index block is a block with N key/value entries. The i'th value is the
 encoded block handle of the i'th data block. The i'th key is a separator for
 i < N-1, and a successor for i == N-1. The separator between blocks i and i+1
@@ -151,6 +161,16 @@ sequence of lower-level index blocks with block handles for data blocks
 followed by a single top-level index block with block handles for the
 lower-level index blocks.
 
+Starting with TableFormatPebblev4, an sstable with a two-level index may have a
+partitioned filter instead of a single filter block. There is one filter
+partition per lower-level index block, covering the prefixes of the keys in
+the data blocks it indexes, followed by a top-level filter index with the same
+keys as the top-level index block, and block handles for the filter
+partitions as values. The metaindex names the top-level filter index
+"partitionedfilter.<policy>". A filter partition also covers the first prefix
+of the following partition, so a lookup for a prefix straddling two partitions
+is answered by the partition found by seeking the top-level filter index.
+
 The metaindex block also contains block handles as values, with keys being
 the names of the meta blocks.
 
@@ -420,7 +440,8 @@ func supportsTwoLevelIndex(format TableFormat) bool {
 	switch format {
 	case TableFormatLevelDB:
 		return false
-	case TableFormatRocksDBv2, TableFormatPebblev1, TableFormatPebblev2:
+	case TableFormatRocksDBv2, TableFormatPebblev1, TableFormatPebblev2, TableFormatPebblev3,
+		TableFormatPebblev4:
 		return true
 	default:
 		panic("sstable: unspecified table format version")
@@ -145,6 +145,8 @@ type Writer struct {
 	cache                   *cache.Cache
 	restartInterval         int
 	checksumType            ChecksumType
+	// dataBlockHashIndex is set if data blocks are written with a hash index.
+	dataBlockHashIndex bool
 	// disableKeyOrderChecks disables the checks that keys are added to an
 	// sstable in order. It is intended for internal use only in the construction
 	// of invalid sstables for testing. See tool/make_test_sstables.go.
@@ -577,9 +579,13 @@ var dataBlockBufPool = sync.Pool{
 	},
 }
 
-func newDataBlockBuf(restartInterval int, checksumType ChecksumType) *dataBlockBuf {
+func newDataBlockBuf(
+	restartInterval int, checksumType ChecksumType, hashIndex bool, split Split,
+) *dataBlockBuf {
 	d := dataBlockBufPool.Get().(*dataBlockBuf)
 	d.dataBlock.restartInterval = restartInterval
+	d.dataBlock.hashIndex.enabled = hashIndex
+	d.dataBlock.hashIndex.split = split
 	d.checksummer.checksumType = checksumType
 	return d
 }
@@ -1081,6 +1087,14 @@ func (w *Writer) maybeAddToFilter(key []byte) {
 	}
 }
 
+// finishFilterDataBlock informs a partitioned filter that a data block has
+// been finished, and whether it starts a new index partition.
+func (w *Writer) finishFilterDataBlock(cutPartition bool) {
+	if f, ok := w.filter.(*partitionedFilterWriter); ok {
+		f.finishDataBlock(cutPartition)
+	}
+}
+
 func (w *Writer) flush(key InternalKey) error {
 	estimatedUncompressedSize := w.dataBlockBuf.dataBlock.estimatedSize()
 	w.coordination.sizeEstimate.addInflightDataBlock(estimatedUncompressedSize)
@@ -1115,6 +1129,8 @@ func (w *Writer) flush(key InternalKey) error {
 		sep, encodedBHPEstimatedSize, w.indexBlockSize, w.indexBlockSizeThreshold,
 	)
 
+	w.finishFilterDataBlock(shouldFlushIndexBlock)
+
 	var indexProps []byte
 	var flushableIndexBlock *indexBlockBuf
 	if shouldFlushIndexBlock {
@@ -1156,7 +1172,7 @@ func (w *Writer) flush(key InternalKey) error {
 	} else {
 		err = w.coordination.writeQueue.addSync(writeTask)
 	}
-	w.dataBlockBuf = newDataBlockBuf(w.restartInterval, w.checksumType)
+	w.dataBlockBuf = newDataBlockBuf(w.restartInterval, w.checksumType, w.dataBlockHashIndex, w.split)
 
 	return err
 }
@@ -1293,6 +1309,8 @@ func (w *Writer) addIndexEntrySync(
 		w.tableFormat) && w.indexBlock.shouldFlush(
 		sep, encodedBHPEstimatedSize, w.indexBlockSize, w.indexBlockSizeThreshold,
 	)
+	w.finishFilterDataBlock(shouldFlush)
+
 	var flushableIndexBlock *indexBlockBuf
 	var props []byte
 	var err error
@@ -1448,6 +1466,45 @@ func (w *Writer) writeTwoLevelIndex() (BlockHandle, error) {
 	return w.writeBlock(w.topLevelIndexBlock.finish(), w.compression, &w.blockBuf)
 }
 
+// writePartitionedFilter writes the partitions of a partitioned filter,
+// followed by their top-level index, and returns the handle of the top-level
+// index. The filter partitions are aligned with the index partitions, so the
+// top-level filter index uses the separators of the index partitions. It must
+// be called after all the index entries have been added.
+func (w *Writer) writePartitionedFilter(f *partitionedFilterWriter) (BlockHandle, error) {
+	partitions := f.finishPartitions()
+	// The final index partition has not been finished yet, and is still
+	// accumulating in w.indexBlock.
+	if len(partitions) != len(w.indexPartitions)+1 {
+		return BlockHandle{}, errors.Errorf(
+			"pebble: %d filter partitions do not match %d index partitions",
+			errors.Safe(len(partitions)), errors.Safe(len(w.indexPartitions)+1))
+	}
+	indexBlock := blockWriter{restartInterval: 1}
+	for i, data := range partitions {
+		bh, err := w.writeBlock(data, NoCompression, &w.blockBuf)
+		if err != nil {
+			return BlockHandle{}, err
+		}
+		w.props.FilterSize += bh.Length
+		var sep InternalKey
+		if i < len(w.indexPartitions) {
+			sep = w.indexPartitions[i].sep
+		} else {
+			sep = base.DecodeInternalKey(w.indexBlock.block.curKey)
+		}
+		n := encodeBlockHandle(w.blockBuf.tmp[:], bh)
+		indexBlock.add(sep, w.blockBuf.tmp[:n])
+	}
+	w.props.FilterPartitions = uint64(len(partitions))
+	bh, err := w.writeBlock(indexBlock.finish(), w.compression, &w.blockBuf)
+	if err != nil {
+		return BlockHandle{}, err
+	}
+	w.props.FilterSize += bh.Length
+	return bh, nil
+}
+
 func compressAndChecksum(b []byte, compression Compression, blockBuf *blockBuf) []byte {
 	// Compress the buffer, discarding the result if the improvement isn't at
 	// least 12.5%.
@@ -1522,6 +1579,22 @@ func (w *Writer) assertFormatCompatibility() error {
 		)
 	}
 
+	// PebbleDBv3: data block hash indexes.
+	if w.dataBlockHashIndex && w.tableFormat < TableFormatPebblev3 {
+		return errors.Newf(
+			"table format version %s is less than the minimum required version %s for data block hash indexes",
+			w.tableFormat, TableFormatPebblev3,
+		)
+	}
+
+	// PebbleDBv4: partitioned filters.
+	if _, ok := w.filter.(*partitionedFilterWriter); ok && w.tableFormat < TableFormatPebblev4 {
+		return errors.Newf(
+			"table format version %s is less than the minimum required version %s for partitioned filters",
+			w.tableFormat, TableFormatPebblev4,
+		)
+	}
+
 	return nil
 }
 
@@ -1588,7 +1661,16 @@ func (w *Writer) Close() (err error) {
 	// Write the filter block.
 	var metaindex rawBlockWriter
 	metaindex.restartInterval = 1
-	if w.filter != nil {
+	if f, ok := w.filter.(*partitionedFilterWriter); ok && f.partitioned() {
+		bh, err := w.writePartitionedFilter(f)
+		if err != nil {
+			w.err = err
+			return w.err
+		}
+		n := encodeBlockHandle(w.blockBuf.tmp[:], bh)
+		metaindex.add(InternalKey{UserKey: []byte(w.filter.metaName())}, w.blockBuf.tmp[:n])
+		w.props.FilterPolicyName = w.filter.policyName()
+	} else if w.filter != nil {
 		b, err := w.filter.finish()
 		if err != nil {
 			w.err = err
@@ -1907,6 +1989,7 @@ func NewWriter(f writeCloseSyncer, o WriterOptions, extraOpts ...WriterOption) *
 		cache:                   o.Cache,
 		restartInterval:         o.BlockRestartInterval,
 		checksumType:            o.Checksum,
+		dataBlockHashIndex:      o.DataBlockHashIndex,
 		indexBlock:              newIndexBlockBuf(o.Parallelism),
 		rangeDelBlock: blockWriter{
 			restartInterval: 1,
@@ -1923,7 +2006,7 @@ func NewWriter(f writeCloseSyncer, o WriterOptions, extraOpts ...WriterOption) *
 		},
 	}
 
-	w.dataBlockBuf = newDataBlockBuf(w.restartInterval, w.checksumType)
+	w.dataBlockBuf = newDataBlockBuf(w.restartInterval, w.checksumType, w.dataBlockHashIndex, w.split)
 
 	w.blockBuf = blockBuf{
 		checksummer: checksummer{checksumType: o.Checksum},
@@ -1950,7 +2033,11 @@ func NewWriter(f writeCloseSyncer, o WriterOptions, extraOpts ...WriterOption) *
 	if o.FilterPolicy != nil {
 		switch o.FilterType {
 		case TableFilter:
-			w.filter = newTableFilterWriter(o.FilterPolicy)
+			if o.PartitionedFilters {
+				w.filter = newPartitionedFilterWriter(o.FilterPolicy)
+			} else {
+				w.filter = newTableFilterWriter(o.FilterPolicy)
+			}
 			if w.split != nil {
 				w.props.PrefixExtractorName = o.Comparer.Name
 				w.props.PrefixFiltering = true
@@ -203,7 +203,7 @@ func TestBlockBufClear(t *testing.T) {
 }
 
 func TestClearDataBlockBuf(t *testing.T) {
-	d := newDataBlockBuf(1, ChecksumTypeCRC32c)
+	d := newDataBlockBuf(1, ChecksumTypeCRC32c, false /* hashIndex */, nil /* split */)
 	d.blockBuf.compressedBuf = make([]byte, 1)
 	d.dataBlock.add(ikey("apple"), nil)
 	d.dataBlock.add(ikey("banana"), nil)
@@ -588,6 +588,21 @@ func TestWriter_TableFormatCompatibility(t *testing.T) {
 				return w.RangeKeyDelete([]byte("a"), []byte("b"))
 			},
 		},
+		{
+			name:      "data block hash index",
+			minFormat: TableFormatPebblev3,
+			configureFn: func(opts *WriterOptions) {
+				opts.DataBlockHashIndex = true
+			},
+		},
+		{
+			name:      "partitioned filters",
+			minFormat: TableFormatPebblev4,
+			configureFn: func(opts *WriterOptions) {
+				opts.FilterPolicy = bloom.FilterPolicy(10)
+				opts.PartitionedFilters = true
+			},
+		},
 	}
 
 	for _, tc := range testCases {
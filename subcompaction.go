@@ -0,0 +1,275 @@
+// Copyright 2022 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/manifest"
+)
+
+// splitCompactionLocked splits the compaction c into key-range
+// sub-compactions that may be run in parallel. The number of sub-compactions
+// is limited by Options.MaxSubcompactions and by the number of compaction
+// slots that are not in use. Manual compactions are not split, as DB.Compact
+// already runs the compactions of the requested span in parallel. If the
+// compaction cannot be split, a slice containing only c is returned.
+//
+// Sub-compactions are bounded at user keys that no input file spans, so that
+// every input file belongs to exactly one sub-compaction and the outputs of
+// the sub-compactions are disjoint. Every sub-compaction includes at least
+// one file of the start level. The candidate boundaries are the smallest keys
+// of the grandparent files, which the outputs are split at anyways, and of
+// the input files, which includes the L0 sublevel boundaries.
+//
+// d.mu must be held when calling this.
+func (d *DB) splitCompactionLocked(c *compaction) []*compaction {
+	if c.kind != compactionKindDefault || c.isManual || len(c.flushing) != 0 || c.outputLevel.level == 0 {
+		return []*compaction{c}
+	}
+	n := d.opts.MaxSubcompactions
+	if free := d.opts.MaxConcurrentCompactions - d.mu.compact.compactingCount + 1; n > free {
+		n = free
+	}
+	var inputSize uint64
+	for _, cl := range c.inputs {
+		inputSize += cl.files.SizeSum()
+	}
+	// Avoid sub-compactions that would write less than a single output file.
+	if c.maxOutputFileSize > 0 {
+		if m := inputSize / c.maxOutputFileSize; uint64(n) > m {
+			n = int(m)
+		}
+	}
+	if n <= 1 {
+		return []*compaction{c}
+	}
+
+	bounds := c.subcompactionBounds(n, inputSize)
+	if len(bounds) == 0 {
+		return []*compaction{c}
+	}
+	subcompactions := make([]*compaction, 0, len(bounds)+1)
+	var lower []byte
+	for _, upper := range bounds {
+		subcompactions = append(subcompactions, c.subcompaction(lower, upper))
+		lower = upper
+	}
+	subcompactions = append(subcompactions, c.subcompaction(lower, nil))
+	return subcompactions
+}
+
+// subcompactionBounds returns up to n-1 user keys at which the compaction may
+// be split, such that the input bytes are divided evenly between the
+// resulting sub-compactions. Each returned key is the smallest user key of
+// the sub-compaction it begins.
+func (c *compaction) subcompactionBounds(n int, inputSize uint64) [][]byte {
+	var files []*fileMetadata
+	var candidates [][]byte
+	isStart := make(map[*fileMetadata]bool)
+	for i, cl := range c.inputs {
+		iter := cl.files.Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			files = append(files, f)
+			candidates = append(candidates, f.Smallest.UserKey)
+			isStart[f] = i == 0
+		}
+	}
+	numStart := c.startLevel.files.Len()
+	iter := c.grandparents.Iter()
+	for f := iter.First(); f != nil; f = iter.Next() {
+		candidates = append(candidates, f.Smallest.UserKey)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return c.cmp(candidates[i], candidates[j]) < 0
+	})
+	sort.Slice(files, func(i, j int) bool {
+		return c.cmp(files[i].Smallest.UserKey, files[j].Smallest.UserKey) < 0
+	})
+
+	// Walk the candidates and the input files, sorted by their smallest key, in
+	// tandem. A candidate is a valid boundary if every file that begins before
+	// it also ends before it, and if files of the start level begin both
+	// before and after it.
+	type boundary struct {
+		key         []byte
+		sizeBefore  uint64
+		startBefore int
+	}
+	var valid []boundary
+	var sizeBefore uint64
+	var startBefore int
+	var maxLargest []byte
+	var j int
+	for _, k := range candidates {
+		for ; j < len(files) && c.cmp(files[j].Smallest.UserKey, k) < 0; j++ {
+			sizeBefore += files[j].Size
+			if isStart[files[j]] {
+				startBefore++
+			}
+			if maxLargest == nil || c.cmp(files[j].Largest.UserKey, maxLargest) > 0 {
+				maxLargest = files[j].Largest.UserKey
+			}
+		}
+		if startBefore == 0 || startBefore == numStart || c.cmp(maxLargest, k) >= 0 {
+			continue
+		}
+		if len(valid) > 0 && valid[len(valid)-1].sizeBefore == sizeBefore {
+			continue
+		}
+		valid = append(valid, boundary{key: k, sizeBefore: sizeBefore, startBefore: startBefore})
+	}
+
+	// Choose the valid boundary closest to each multiple of inputSize/n.
+	var bounds [][]byte
+	for i := 1; i < n && len(valid) > 0; i++ {
+		target := inputSize * uint64(i) / uint64(n)
+		best := 0
+		for best+1 < len(valid) && valid[best+1].sizeBefore <= target {
+			best++
+		}
+		if best+1 < len(valid) && valid[best+1].sizeBefore-target < absDiff(valid[best].sizeBefore, target) {
+			best++
+		}
+		chosen := valid[best]
+		bounds = append(bounds, chosen.key)
+		valid = valid[best+1:]
+		// Skip the boundaries that would leave the next sub-compaction without
+		// any files of the start level.
+		for len(valid) > 0 && valid[0].startBefore == chosen.startBefore {
+			valid = valid[1:]
+		}
+	}
+	return bounds
+}
+
+func absDiff(a, b uint64) uint64 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
+// subcompaction returns a compaction over the input files of c whose smallest
+// user key is within [lower, upper). A nil lower or upper bound is unbounded.
+func (c *compaction) subcompaction(lower, upper []byte) *compaction {
+	sub := &compaction{
+		kind:               c.kind,
+		cmp:                c.cmp,
+		equal:              c.equal,
+		formatKey:          c.formatKey,
+		logger:             c.logger,
+		version:            c.version,
+		score:              c.score,
+		maxOutputFileSize:  c.maxOutputFileSize,
+		maxOverlapBytes:    c.maxOverlapBytes,
+		disableSpanElision: c.disableSpanElision,
+		cancel:             c.cancel,
+		grandparents:       c.grandparents,
+		inuseKeyRanges:     c.inuseKeyRanges,
+		inputs:             make([]compactionLevel, len(c.inputs)),
+	}
+	within := func(key []byte) bool {
+		return (lower == nil || c.cmp(key, lower) >= 0) && (upper == nil || c.cmp(key, upper) < 0)
+	}
+	iters := make([]manifest.LevelIterator, 0, len(c.inputs))
+	for i, cl := range c.inputs {
+		var files []*fileMetadata
+		iter := cl.files.Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			if within(f.Smallest.UserKey) {
+				files = append(files, f)
+			}
+		}
+		sub.inputs[i].level = cl.level
+		if cl.level == 0 {
+			sub.inputs[i].files = manifest.NewLevelSliceSeqSorted(files)
+		} else {
+			sub.inputs[i].files = manifest.NewLevelSliceKeySorted(c.cmp, files)
+		}
+		iters = append(iters, sub.inputs[i].files.Iter())
+	}
+	sub.startLevel = &sub.inputs[0]
+	sub.outputLevel = &sub.inputs[len(sub.inputs)-1]
+	for i := 1; i < len(sub.inputs)-1; i++ {
+		sub.extraLevels = append(sub.extraLevels, &sub.inputs[i])
+	}
+	if sub.startLevel.level == 0 {
+		sub.l0SublevelInfo = generateSublevelInfo(c.cmp, sub.startLevel.files)
+	}
+	sub.smallest, sub.largest = manifest.KeyRange(c.cmp, iters...)
+	return sub
+}
+
+// runSubcompactions runs the sub-compactions of the compaction c in parallel,
+// and combines their outputs into a single version edit. The bytes iterated
+// and written by the sub-compactions are accumulated into c, and the tables
+// they write are added to outputMetrics. If any sub-compaction fails, the
+// tables written by all of them are removed.
+//
+// d.mu must not be held when calling this.
+func (d *DB) runSubcompactions(
+	jobID int,
+	c *compaction,
+	subcompactions []*compaction,
+	snapshots []uint64,
+	formatVers FormatMajorVersion,
+	outputMetrics *LevelMetrics,
+) (*versionEdit, []*fileMetadata, error) {
+	type result struct {
+		ve             *versionEdit
+		pendingOutputs []*fileMetadata
+		metrics        LevelMetrics
+		err            error
+	}
+	results := make([]result, len(subcompactions))
+	var wg sync.WaitGroup
+	for i := range subcompactions {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			r := &results[i]
+			r.ve, r.pendingOutputs, r.err = d.writeCompactionOutputs(
+				jobID, subcompactions[i], snapshots, formatVers, &r.metrics)
+		}(i)
+	}
+	wg.Wait()
+
+	ve := &versionEdit{}
+	var pendingOutputs []*fileMetadata
+	var err error
+	for i, sub := range subcompactions {
+		r := &results[i]
+		c.bytesIterated += sub.bytesIterated
+		c.bytesWritten += sub.bytesWritten
+		c.allowedZeroSeqNum = c.allowedZeroSeqNum || sub.allowedZeroSeqNum
+		pendingOutputs = append(pendingOutputs, r.pendingOutputs...)
+		if r.err != nil {
+			err = firstError(err, r.err)
+			continue
+		}
+		ve.NewFiles = append(ve.NewFiles, r.ve.NewFiles...)
+		outputMetrics.Add(&r.metrics)
+	}
+	if err != nil {
+		// The failed sub-compactions have removed their own outputs.
+		for i := range results {
+			if results[i].err != nil {
+				continue
+			}
+			for _, e := range results[i].ve.NewFiles {
+				if e.Meta.IsShared {
+					d.removeSharedOutput(e.Meta)
+					continue
+				}
+				d.opts.FS.Remove(base.MakeFilepath(d.opts.FS, d.dirname, fileTypeTable, e.Meta.FileNum))
+			}
+		}
+		return nil, pendingOutputs, err
+	}
+	return ve, pendingOutputs, nil
+}
@@ -17,6 +17,7 @@ import (
 
 	"github.com/cockroachdb/errors"
 	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/cache"
 	"github.com/cockroachdb/pebble/internal/invariants"
 	"github.com/cockroachdb/pebble/internal/keyspan"
 	"github.com/cockroachdb/pebble/internal/manifest"
@@ -438,6 +439,12 @@ func (c *tableCacheShard) newIters(
 	}
 	// Set the level here for internal use by sstable package (now only for shared sst)
 	iter.SetLevel(level)
+	if opts != nil && opts.Context != nil {
+		iter.SetContext(opts.Context)
+	}
+	if opts != nil && opts.pinnedBlocks != nil {
+		iter.SetPinnedBlocks(opts.pinnedBlocks)
+	}
 
 	// NB: v.closeHook takes responsibility for calling unrefValue(v) here. Take
 	// care to avoid introduceingan allocation here by adding a closure.
@@ -782,6 +789,11 @@ func (c *tableCacheShard) evict(fileNum FileNum, dbOpts *tableCacheOpts, allowLe
 	key := tableCacheKey{dbOpts.cacheID, fileNum}
 	n := c.mu.nodes[key]
 	var v *tableCacheValue
+	// The blocks of a shared table are keyed by the backing object rather than
+	// by fileNum, and may still be in use by other virtual tables backed by the
+	// same object, possibly in other DBs sharing the block cache. Leave them to
+	// be evicted by the cache's replacement policy.
+	evictBlocks := n == nil || !n.meta.IsShared
 	if n != nil {
 		// NB: This is equivalent to tableCacheShard.releaseNode(), but we perform
 		// the tableCacheNode.release() call synchronously below to ensure the
@@ -806,7 +818,9 @@ func (c *tableCacheShard) evict(fileNum FileNum, dbOpts *tableCacheOpts, allowLe
 		v.release(c)
 	}
 
-	dbOpts.opts.Cache.EvictFile(dbOpts.cacheID, fileNum)
+	if evictBlocks {
+		dbOpts.opts.Cache.EvictFile(dbOpts.cacheID, fileNum)
+	}
 }
 
 // removeDB evicts any nodes which have a reference to the DB
@@ -912,18 +926,24 @@ func (v *tableCacheValue) load(meta *fileMetadata, c *tableCacheShard, dbOpts *t
 	f, v.err = fs.Open(v.filename, vfs.RandomReadsOption)
 	if v.err == nil {
 		cacheOpts := private.SSTableCacheOpts(dbOpts.cacheID, meta.FileNum).(sstable.ReaderOption)
-		extraOpts := []sstable.ReaderOption{cacheOpts, dbOpts.filterMetrics}
+		extraOpts := []sstable.ReaderOption{
+			cacheOpts, dbOpts.filterMetrics, sstable.DBUniqueIDOpt{ID: dbOpts.uniqueID},
+		}
 		if !meta.IsShared {
 			extraOpts = append(extraOpts, sstable.FileReopenOpt{FS: dbOpts.fs, Filename: v.filename})
 		} else {
 			extraOpts = append(extraOpts, sstable.PersistentCacheOpt{PsCache: dbOpts.psCache})
+			extraOpts = append(extraOpts, sharedBlockCacheKey(meta))
 		}
 		// No matter what file type it is, attach metadata here
 		extraOpts = append(extraOpts, sstable.FileMetadataOpt{Meta: meta})
 		v.reader, v.err = sstable.NewReader(f, dbOpts.opts, extraOpts...)
 	}
 	if v.err == nil {
-		if meta.SmallestSeqNum == meta.LargestSeqNum {
+		// Foreign shared tables keep the sequence numbers their range
+		// deletions are compared against. They are remapped when read.
+		foreign := meta.IsShared && meta.CreatorUniqueID != dbOpts.uniqueID
+		if meta.SmallestSeqNum == meta.LargestSeqNum && !foreign {
 			v.reader.Properties.GlobalSeqNum = meta.LargestSeqNum
 		}
 	}
@@ -941,6 +961,16 @@ func (v *tableCacheValue) load(meta *fileMetadata, c *tableCacheShard, dbOpts *t
 	close(v.loaded)
 }
 
+// sharedBlockCacheKey returns the reader option keying the blocks of the shared
+// table described by meta by its backing object, (CreatorUniqueID,
+// PhysicalFileNum), instead of by the DB's cache ID and meta.FileNum.
+func sharedBlockCacheKey(meta *fileMetadata) sstable.BlockCacheKeyOpt {
+	return sstable.BlockCacheKeyOpt{
+		ID:      cache.SharedObjectID(meta.CreatorUniqueID),
+		FileNum: meta.PhysicalFileNum,
+	}
+}
+
 func (v *tableCacheValue) release(c *tableCacheShard) {
 	<-v.loaded
 	// Nothing to be done about an error at this point. Close the reader if it is
@@ -0,0 +1,129 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import "sync/atomic"
+
+// tailingState holds the state of an Iterator configured with
+// IterOptions.Tailing.
+type tailingState struct {
+	// key is a copy of the last key the iterator was positioned at. After
+	// observing new writes, iteration resumes after key.
+	key []byte
+	// positioned is true if key is set.
+	positioned bool
+	// memtables is the number of memtables in the iterator's stack, and spans
+	// the number of range deletions and range keys in the last of them when
+	// the stack was constructed.
+	memtables int
+	spans     uint32
+}
+
+// visibleMemtables returns the memtables that contain keys visible at seqNum.
+func visibleMemtables(memtables flushableList, seqNum uint64) flushableList {
+	for len(memtables) > 0 {
+		n := len(memtables)
+		if memtables[n-1].logSeqNum < seqNum {
+			break
+		}
+		memtables = memtables[:n-1]
+	}
+	return memtables
+}
+
+// memtableSpans returns the number of range deletions and range keys in the
+// last of the memtables, which is the only one that may still be written to.
+func memtableSpans(memtables flushableList) uint32 {
+	if len(memtables) == 0 {
+		return 0
+	}
+	m, ok := memtables[len(memtables)-1].flushable.(*memTable)
+	if !ok {
+		return 0
+	}
+	return atomic.LoadUint32(&m.tombstones.atomicCount) + atomic.LoadUint32(&m.rangeKeys.atomicCount)
+}
+
+// tailingNext implements Next for a tailing iterator. If the iterator is
+// exhausted, it refreshes its view of the DB and resumes after the last key it
+// was positioned at.
+func (i *Iterator) tailingNext() bool {
+	if i.iterValidityState == IterValid && !i.requiresReposition {
+		i.tailing.key = append(i.tailing.key[:0], i.Key()...)
+		i.tailing.positioned = true
+	}
+	if i.NextWithLimit(nil) == IterValid {
+		return true
+	}
+	if i.err != nil || i.hasPrefix || !i.refreshTailing() {
+		return false
+	}
+	if !i.tailing.positioned {
+		return i.First()
+	}
+	if !i.SeekGE(i.tailing.key) {
+		return false
+	}
+	if i.equal(i.Key(), i.tailing.key) {
+		return i.NextWithLimit(nil) == IterValid
+	}
+	return true
+}
+
+// refreshTailing updates the iterator to read the current state of the DB,
+// returning false if nothing was committed since the iterator was constructed
+// or last refreshed. The iterator must be repositioned afterwards.
+//
+// If no memtable was rotated or flushed and no table was ingested or
+// compacted, the memtable iterators in the existing stack already observe the
+// newly committed keys, and only the sequence number the iterator reads at is
+// advanced. Otherwise the iterator stack is reconstructed.
+func (i *Iterator) refreshTailing() bool {
+	if i.readState == nil {
+		return false
+	}
+	d := i.readState.db
+	readState := d.loadReadState()
+	seqNum := atomic.LoadUint64(&d.mu.versions.atomic.visibleSeqNum)
+	if readState == i.readState {
+		readState.unref()
+		if seqNum == i.seqNum {
+			return false
+		}
+		// New range deletions and range keys are not observed by the
+		// fragmented spans of an existing memtable iterator.
+		memtables := visibleMemtables(readState.memtables, seqNum)
+		if m, ok := i.pointIter.(*mergingIter); ok &&
+			len(memtables) == i.tailing.memtables && memtableSpans(memtables) == i.tailing.spans {
+			i.seqNum = seqNum
+			m.snapshot = seqNum
+			i.invalidate()
+			return true
+		}
+	}
+	i.invalidate()
+	var err error
+	if i.pointIter != nil {
+		err = i.pointIter.Close()
+		i.pointIter = nil
+	}
+	if readState != i.readState {
+		// The old stack must be closed before its readState is released. An
+		// iterator pinning keys and values retains it until it is closed.
+		if i.pinned != nil {
+			i.pinned.readStates = append(i.pinned.readStates, i.readState)
+		} else {
+			i.readState.unref()
+		}
+		i.readState = readState
+	}
+	i.seqNum = seqNum
+	finishInitializingIter(i.alloc)
+	if err != nil {
+		i.err = err
+		return false
+	}
+	return true
+}
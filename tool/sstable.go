@@ -308,6 +308,9 @@ func (s *sstableT) runProperties(cmd *cobra.Command, args []string) {
 		fmt.Fprintf(tw, "    blocks\t%d\n", 1+r.Properties.IndexPartitions)
 		fmt.Fprintf(tw, "    top-level\t%s\n", humanize.Uint64(r.Properties.TopLevelIndexSize))
 		fmt.Fprintf(tw, "  filter\t%s\n", humanize.Uint64(r.Properties.FilterSize))
+		if r.Properties.FilterPartitions > 0 {
+			fmt.Fprintf(tw, "    partitions\t%d\n", r.Properties.FilterPartitions)
+		}
 		fmt.Fprintf(tw, "  raw-key\t%s\n", humanize.Uint64(r.Properties.RawKeySize))
 		fmt.Fprintf(tw, "  raw-value\t%s\n", humanize.Uint64(r.Properties.RawValueSize))
 		fmt.Fprintf(tw, "records\t%d\n", r.Properties.NumEntries)
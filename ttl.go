@@ -0,0 +1,178 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"time"
+
+	"github.com/cockroachdb/pebble/internal/manifest"
+)
+
+// oldestCreationTimeAnnotator implements the manifest.Annotator interface,
+// annotating B-Tree nodes with the *fileMetadata of the file with the oldest
+// creation time. Files without a creation time are ignored, since they never
+// expire.
+type oldestCreationTimeAnnotator struct{}
+
+var _ manifest.Annotator = oldestCreationTimeAnnotator{}
+
+func (a oldestCreationTimeAnnotator) Zero(interface{}) interface{} {
+	return nil
+}
+
+func (a oldestCreationTimeAnnotator) Accumulate(
+	f *fileMetadata, dst interface{},
+) (interface{}, bool) {
+	if f.CreationTime == 0 {
+		return dst, true
+	}
+	return oldestCreationTimeMergeHelper(f, dst), true
+}
+
+func (a oldestCreationTimeAnnotator) Merge(v interface{}, accum interface{}) interface{} {
+	if v == nil {
+		return accum
+	}
+	return oldestCreationTimeMergeHelper(v.(*fileMetadata), accum)
+}
+
+// REQUIRES: f is non-nil, and f.CreationTime is non-zero.
+func oldestCreationTimeMergeHelper(f *fileMetadata, dst interface{}) interface{} {
+	if dst == nil || dst.(*fileMetadata).CreationTime > f.CreationTime {
+		return f
+	}
+	return dst
+}
+
+// checkExpiredTables returns the files of v that were created before cutoff,
+// in seconds since the epoch, and that may be dropped by a delete-only
+// compaction. A file is only dropped if it is not being compacted, if no
+// snapshot can read any of its keys, and if every older file overlapping it
+// is dropped as well. Otherwise, dropping a file that holds a tombstone or a
+// newer version of a key would expose the older versions below it.
+func checkExpiredTables(cmp Compare, v *version, cutoff int64, snapshots []uint64) []compactionLevel {
+	// Decide the files from the bottom up, so that the files below a file
+	// are decided before it. Files in L0 are visited from oldest to newest.
+	dropped := make(map[*fileMetadata]bool)
+	var expired [numLevels][]*fileMetadata
+	for level := numLevels - 1; level >= 0; level-- {
+		oldest := v.Levels[level].Annotation(oldestCreationTimeAnnotator{})
+		if oldest == nil || oldest.(*fileMetadata).CreationTime >= cutoff {
+			continue
+		}
+		iter := v.Levels[level].Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			if f.Compacting || f.CreationTime == 0 || f.CreationTime >= cutoff {
+				continue
+			}
+			if i, _ := snapshotIndex(f.SmallestSeqNum, snapshots); i != len(snapshots) {
+				continue
+			}
+			if keepsOlderOverlap(cmp, v, level, f, dropped) {
+				continue
+			}
+			dropped[f] = true
+			expired[level] = append(expired[level], f)
+		}
+	}
+
+	var inputs []compactionLevel
+	for level, files := range expired {
+		if len(files) == 0 {
+			continue
+		}
+		cl := compactionLevel{level: level}
+		if level == 0 {
+			cl.files = manifest.NewLevelSliceSeqSorted(files)
+		} else {
+			cl.files = manifest.NewLevelSliceKeySorted(cmp, files)
+		}
+		inputs = append(inputs, cl)
+	}
+	return inputs
+}
+
+// keepsOlderOverlap returns true if a file of v that is older than f, the
+// file in the given level, overlaps f and is not in dropped. The older files
+// are the files below f, and the files of L0 that are not newer than f.
+func keepsOlderOverlap(
+	cmp Compare, v *version, level int, f *fileMetadata, dropped map[*fileMetadata]bool,
+) bool {
+	for l := level; l < numLevels; l++ {
+		overlaps := v.Overlaps(l, cmp, f.Smallest.UserKey, f.Largest.UserKey,
+			f.Largest.IsExclusiveSentinel())
+		iter := overlaps.Iter()
+		for g := iter.First(); g != nil; g = iter.Next() {
+			if g == f || dropped[g] {
+				continue
+			}
+			if l == 0 {
+				// The overlapping files of L0 are expanded transitively, so
+				// they do not necessarily overlap f.
+				if level == 0 && g.SmallestSeqNum > f.LargestSeqNum {
+					continue
+				}
+				if cmp(g.Smallest.UserKey, f.Largest.UserKey) > 0 ||
+					cmp(g.Largest.UserKey, f.Smallest.UserKey) < 0 {
+					continue
+				}
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// ttlCutoff returns the creation time before which sstables and keys are
+// expired according to Options.TTL.
+func (d *DB) ttlCutoff() time.Time {
+	return d.timeNow().Add(-d.opts.TTL)
+}
+
+// newTTLCompactionFilter returns the compaction filter that removes the keys
+// that have expired according to Options.KeyExpired, or nil if keys do not
+// expire.
+func (d *DB) newTTLCompactionFilter() CompactionFilter {
+	if d.opts.TTL == 0 || d.opts.KeyExpired == nil {
+		return nil
+	}
+	cutoff := d.ttlCutoff()
+	return CompactionFilterFunc(func(
+		key, value []byte, info CompactionFilterKeyInfo,
+	) (CompactionFilterDecision, []byte) {
+		if info.Kind == InternalKeyKindSet && !info.VisibleToSnapshot() &&
+			d.opts.KeyExpired(key, value, cutoff) {
+			return CompactionFilterRemove, nil
+		}
+		return CompactionFilterKeep, nil
+	})
+}
+
+// ageCheckInterval returns the interval at which the DB checks for sstables
+// that are older than age in the absence of other compaction activity.
+func ageCheckInterval(age time.Duration) time.Duration {
+	if interval := age / 10; interval < time.Minute {
+		return interval
+	}
+	return time.Minute
+}
+
+// runAgeTicker periodically schedules compactions until the DB is closed, so
+// that sstables expire, and stale sstables are compacted (see
+// Options.PeriodicCompactionPeriod), even if nothing is written to the DB.
+func (d *DB) runAgeTicker(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.closedCh:
+			return
+		case <-ticker.C:
+			d.mu.Lock()
+			d.maybeScheduleCompaction()
+			d.mu.Unlock()
+		}
+	}
+}
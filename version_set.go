@@ -92,6 +92,12 @@ type versionSet struct {
 	// still referenced by an inuse iterator.
 	zombieTables map[FileNum]uint64 // filenum -> size
 
+	// blobRefs counts the sstables referencing each blob file, from the time
+	// a table is added to a version until it becomes obsolete. A blob file is
+	// added to obsoleteBlobs when its count falls to zero.
+	blobRefs      map[FileNum]int
+	obsoleteBlobs []fileInfo
+
 	// minUnflushedLogNum is the smallest WAL log file number corresponding to
 	// mutations that have not been flushed to an sstable.
 	minUnflushedLogNum FileNum
@@ -130,6 +136,7 @@ func (vs *versionSet) init(
 	vs.versions.Init(mu)
 	vs.obsoleteFn = vs.addObsoleteLocked
 	vs.zombieTables = make(map[FileNum]uint64)
+	vs.blobRefs = make(map[FileNum]int)
 	vs.nextFileNum = 1
 	vs.manifestMarker = marker
 	vs.setCurrent = setCurrent
@@ -281,6 +288,12 @@ func (vs *versionSet) load(
 	}
 	newVersion.L0Sublevels.InitCompactingFileInfo(nil /* in-progress compactions */)
 	vs.append(newVersion)
+	for _, lm := range newVersion.Levels {
+		iter := lm.Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			vs.refBlobFilesLocked(f)
+		}
+	}
 
 	for i := range vs.metrics.Levels {
 		l := &vs.metrics.Levels[i]
@@ -404,6 +417,8 @@ func (vs *versionSet) logAndApply(
 	// to be called.
 	minUnflushedLogNum := vs.minUnflushedLogNum
 	nextFileNum := vs.nextFileNum
+	// ReadCompactionRate may be changed by DB.SetOptions.
+	readCompactionRate := vs.opts.Experimental.ReadCompactionRate
 
 	var zombies map[FileNum]uint64
 	if err := func() error {
@@ -416,7 +431,7 @@ func (vs *versionSet) logAndApply(
 		}
 
 		var err error
-		newVersion, zombies, err = bve.Apply(currentVersion, vs.cmp, vs.opts.Comparer.FormatKey, vs.opts.FlushSplitBytes, vs.opts.Experimental.ReadCompactionRate)
+		newVersion, zombies, err = bve.Apply(currentVersion, vs.cmp, vs.opts.Comparer.FormatKey, vs.opts.FlushSplitBytes, readCompactionRate)
 		if err != nil {
 			return errors.Wrap(err, "MANIFEST apply failed")
 		}
@@ -486,6 +501,21 @@ func (vs *versionSet) logAndApply(
 	for fileNum, size := range zombies {
 		vs.zombieTables[fileNum] = size
 	}
+	// Reference the blob files of the new tables before installing the new
+	// version too, as the tables they replace may reference the same blob
+	// files. A table that is moved between levels is already referenced.
+	for _, nf := range ve.NewFiles {
+		moved := false
+		for df := range ve.DeletedFiles {
+			if df.FileNum == nf.Meta.FileNum {
+				moved = true
+				break
+			}
+		}
+		if !moved {
+			vs.refBlobFilesLocked(nf.Meta)
+		}
+	}
 
 	// Install the new version.
 	vs.append(newVersion)
@@ -694,6 +724,30 @@ func (vs *versionSet) addObsoleteLocked(obsolete []*manifest.FileMetadata) {
 	}
 	vs.obsoleteTables = append(vs.obsoleteTables, obsolete...)
 	vs.incrementObsoleteTablesLocked(obsolete)
+	for _, fileMeta := range obsolete {
+		vs.unrefBlobFilesLocked(fileMeta)
+	}
+}
+
+// refBlobFilesLocked adds a reference to each blob file referenced by the
+// table.
+func (vs *versionSet) refBlobFilesLocked(f *manifest.FileMetadata) {
+	for _, fileNum := range f.BlobFiles {
+		vs.blobRefs[fileNum]++
+	}
+}
+
+// unrefBlobFilesLocked removes a reference from each blob file referenced by
+// the obsolete table, adding the blob files that are no longer referenced to
+// the obsolete blob files.
+func (vs *versionSet) unrefBlobFilesLocked(f *manifest.FileMetadata) {
+	for _, fileNum := range f.BlobFiles {
+		vs.blobRefs[fileNum]--
+		if vs.blobRefs[fileNum] <= 0 {
+			delete(vs.blobRefs, fileNum)
+			vs.obsoleteBlobs = append(vs.obsoleteBlobs, fileInfo{fileNum: fileNum})
+		}
+	}
 }
 
 func (vs *versionSet) incrementObsoleteTablesLocked(obsolete []*manifest.FileMetadata) {